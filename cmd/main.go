@@ -10,11 +10,14 @@ import (
 
 	"ququchat/internal/api"
 	"ququchat/internal/config"
+	serverauth "ququchat/internal/server/auth"
 	cachepkg "ququchat/internal/server/cache"
 	database "ququchat/internal/server/db"
+	"ququchat/internal/server/notify"
 	"ququchat/internal/server/storage"
 	taskservice "ququchat/internal/service"
 	tasksvc "ququchat/internal/service/task"
+	webhooksvc "ququchat/internal/service/webhook"
 )
 
 func main() {
@@ -42,6 +45,9 @@ func main() {
 		log.Fatalf("数据库迁移失败: %v", err)
 	}
 	log.Println("数据库迁移完成")
+	if err := database.EnsureSearchIndexes(db, cfg.Database.Driver); err != nil {
+		log.Fatalf("消息全文索引创建失败: %v", err)
+	}
 
 	affected, err := database.ResetAllUsersOffline(db)
 	if err != nil {
@@ -70,6 +76,21 @@ func main() {
 	}
 
 	authCfg := cfg.Auth.ToSettings()
+	keySet, err := serverauth.NewKeySet(authCfg.SigningKeys, authCfg.ActiveKID, serverauth.LegacyHS256{
+		Secret: authCfg.JWTSecret,
+		Accept: authCfg.AcceptLegacyHS256,
+		Until:  authCfg.LegacyHS256Until,
+	})
+	if err != nil {
+		log.Fatalf("加载签名密钥失败: %v", err)
+	}
+	// 仅当 HS256 仍用于签发或兼容旧令牌时才要求配置真实的 JWT 密钥
+	if authCfg.UsingDevSecret && !authCfg.DevMode && keySet.UsesSharedSecret() {
+		log.Fatalf("拒绝启动: 未配置 JWT 密钥，开发默认密钥仅允许在 auth.dev_mode 或 QUQUCHAT_DEV_MODE=1 下使用")
+	}
+	if authCfg.UsingDevTOTPKey && !authCfg.DevMode {
+		log.Fatalf("拒绝启动: 未配置 auth.totp_encryption_key，不能使用开发默认密钥加密 TOTP 密钥")
+	}
 	commandPriorityRules := make([]taskservice.CommandPriorityRule, 0)
 	for _, item := range cfg.TaskPriority.NormalizedRules() {
 		commandPriorityRules = append(commandPriorityRules, taskservice.CommandPriorityRule{
@@ -121,7 +142,27 @@ func main() {
 		}()
 	}
 
-	r := api.SetupRouter(db, authCfg, cfg.Chat, cfg.File, cfg.Avatar, objStorage, bucket, redisClient, taskService, cfg.WS.NodeID)
+	notifier, err := notify.New(cfg.Notify)
+	if err != nil {
+		log.Fatalf("初始化通知通道失败: %v", err)
+	}
+
+	var webhooks *webhooksvc.Dispatcher
+	if cfg.Webhook.EnabledOrDefault() {
+		webhooks = webhooksvc.NewDispatcher(webhooksvc.Options{
+			DB:                  db,
+			RabbitMQURL:         cfg.Webhook.RabbitMQURLOr(cfg.Task.DoneEventMQURLOrDefault()),
+			QueueName:           cfg.Webhook.QueueNameOrDefault(),
+			MaxAttempts:         cfg.Webhook.MaxAttemptsOrDefault(),
+			Timeout:             cfg.Webhook.TimeoutOrDefault(),
+			BackoffBase:         cfg.Webhook.BackoffBaseOrDefault(),
+			BackoffMax:          cfg.Webhook.BackoffMaxOrDefault(),
+			AllowPrivateTargets: cfg.Webhook.AllowPrivateTargets,
+		})
+		webhooks.Start(context.Background())
+	}
+
+	r := api.SetupRouter(db, cfg.Database.Driver, authCfg, keySet, cfg.Chat, cfg.RateLimit, cfg.File, cfg.Avatar, objStorage, bucket, redisClient, notifier, webhooks, taskService, cfg.WS)
 
 	// 简单首页/健康检查（便于开发验证）
 	r.GET("/", func(c *gin.Context) {
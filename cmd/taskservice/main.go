@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os/signal"
 	"strings"
@@ -13,6 +14,7 @@ import (
 	filesvc "ququchat/internal/service/file"
 	taskservice "ququchat/internal/taskservice"
 	tasksvc "ququchat/internal/taskservice/task"
+	"ququchat/internal/taskservice/task/accounthandler"
 	"ququchat/internal/taskservice/task/aigcmq"
 	"ququchat/internal/taskservice/task/embeddingmq"
 	"ququchat/internal/taskservice/task/llmmq"
@@ -46,27 +48,19 @@ func main() {
 		log.Fatalf("数据库迁移失败: %v", err)
 	}
 
-	var aigcAttachmentSaver aigcmq.AttachmentSaver
-	if strings.EqualFold(cfg.AIGC.TransportOrDefault(), "rabbitmq") {
-		provider := cfg.Storage.ProviderOrDefault()
-		var objStorage storage.ObjectStorage
-		var bucket string
-		switch provider {
-		case "minio":
-			objStorage, err = storage.InitMinioStorage(cfg.Minio)
-			if err != nil {
-				log.Fatalf("初始化 MinIO 失败: %v", err)
-			}
-			bucket = cfg.Minio.Bucket
-		case "oss":
-			objStorage, err = storage.InitOSSStorage(cfg.OSS)
-			if err != nil {
-				log.Fatalf("初始化 OSS 失败: %v", err)
-			}
-			bucket = cfg.OSS.Bucket
-		default:
-			log.Fatalf("不支持的对象存储 provider: %s", provider)
+	// AIGC worker 依赖对象存储保存生成的图片，启用时初始化失败直接退出；
+	// 否则仅在配置了对象存储时初始化，供数据导出与注销清理使用，未配置时清理任务跳过附件
+	aigcNeedsStorage := strings.EqualFold(cfg.AIGC.TransportOrDefault(), "rabbitmq")
+	objStorage, bucket, err := initObjectStorage(cfg, aigcNeedsStorage)
+	if err != nil {
+		if aigcNeedsStorage {
+			log.Fatalf("%v", err)
 		}
+		log.Printf("%v，数据导出与注销清理将跳过对象存储", err)
+	}
+	var aigcAttachmentSaver aigcmq.AttachmentSaver
+	var attachmentDeleter accounthandler.AttachmentDeleter
+	if objStorage != nil {
 		thumb := filesvc.ThumbnailOptions{
 			MaxDimension:   cfg.File.Thumbnail.MaxDimensionOrDefault(),
 			JPEGQuality:    cfg.File.Thumbnail.JPEGQualityOrDefault(),
@@ -74,7 +68,11 @@ func main() {
 			RetryDelay:     cfg.File.Thumbnail.RetryDelayDuration(),
 			MaxSourceBytes: cfg.File.Thumbnail.MaxSourceBytesOrDefault(),
 		}
-		aigcAttachmentSaver = filesvc.NewService(db, objStorage, bucket, cfg.File.MaxSizeBytes, cfg.File.RetentionDuration(), thumb)
+		fileService := filesvc.NewService(db, objStorage, bucket, cfg.File.MaxSizeBytes, cfg.File.RetentionDuration(), thumb)
+		attachmentDeleter = fileService
+		if aigcNeedsStorage {
+			aigcAttachmentSaver = fileService
+		}
 	}
 
 	var llmWorkerPool *llmmq.Pool
@@ -307,6 +305,13 @@ func main() {
 		RAGRerankTimeout:                 cfg.Rerank.TimeoutOrDefault(),
 		RAGRerankRecallTopN:              cfg.Rerank.RecallTopNOrDefault(),
 		MCPMultiClient:                   mcpMultiClient,
+		AccountHandler: accounthandler.New(accounthandler.Options{
+			DB:          db,
+			Storage:     objStorage,
+			Bucket:      bucket,
+			Attachments: attachmentDeleter,
+			VectorStore: ragVectorStore,
+		}),
 	})
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
@@ -371,3 +376,30 @@ func main() {
 	<-ctx.Done()
 	log.Printf("独立 Task Service 正在退出")
 }
+
+// initObjectStorage 按配置初始化对象存储；required 为 false 且未配置 endpoint 时返回 nil
+func initObjectStorage(cfg *config.Config, required bool) (storage.ObjectStorage, string, error) {
+	provider := cfg.Storage.ProviderOrDefault()
+	switch provider {
+	case "minio":
+		if !required && strings.TrimSpace(cfg.Minio.Endpoint) == "" {
+			return nil, "", nil
+		}
+		objStorage, err := storage.InitMinioStorage(cfg.Minio)
+		if err != nil {
+			return nil, "", fmt.Errorf("初始化 MinIO 失败: %w", err)
+		}
+		return objStorage, cfg.Minio.Bucket, nil
+	case "oss":
+		if !required && strings.TrimSpace(cfg.OSS.Endpoint) == "" && strings.TrimSpace(cfg.OSS.Region) == "" {
+			return nil, "", nil
+		}
+		objStorage, err := storage.InitOSSStorage(cfg.OSS)
+		if err != nil {
+			return nil, "", fmt.Errorf("初始化 OSS 失败: %w", err)
+		}
+		return objStorage, cfg.OSS.Bucket, nil
+	default:
+		return nil, "", fmt.Errorf("不支持的对象存储 provider: %s", provider)
+	}
+}
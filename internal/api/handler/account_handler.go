@@ -0,0 +1,364 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"ququchat/internal/models"
+	"ququchat/internal/server/auth"
+	serverstorage "ququchat/internal/server/storage"
+	taskservice "ququchat/internal/service"
+)
+
+// dataExportStaleAfter 超过该时长仍未完成的导出视为失效，允许重新发起
+const dataExportStaleAfter = time.Hour
+
+const (
+	// purgeSweepInterval 重新提交注销清理任务的清扫间隔
+	purgeSweepInterval = 30 * time.Second
+	purgeSweepBatch    = 100
+	// purgeSubmitLease 提交进行中的租约，超时仍未清空标记的由清扫任务重新提交
+	purgeSubmitLease = 2 * time.Minute
+)
+
+// AccountHandler 个人数据导出与注销账号
+type AccountHandler struct {
+	db          *gorm.DB
+	auth        *AuthHandler
+	taskService *taskservice.MainService
+	storage     serverstorage.ObjectStorage
+	bucket      string
+}
+
+func NewAccountHandler(db *gorm.DB, authHandler *AuthHandler, taskService *taskservice.MainService, objStorage serverstorage.ObjectStorage, bucket string) *AccountHandler {
+	return &AccountHandler{
+		db:          db,
+		auth:        authHandler,
+		taskService: taskService,
+		storage:     objStorage,
+		bucket:      bucket,
+	}
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+	// 已启用二次验证时需提供验证码或恢复码
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// RequestDataExport 发起个人数据导出，由任务服务异步打包；同一时间仅允许一个进行中的导出
+func (h *AccountHandler) RequestDataExport(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	if h.taskService == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "任务服务不可用"})
+		return
+	}
+	var running models.DataExport
+	err := h.db.Where("user_id = ? AND status IN ? AND created_at > ?", userID,
+		[]string{models.DataExportStatusPending, models.DataExportStatusRunning}, time.Now().Add(-dataExportStaleAfter)).
+		Order("created_at DESC").First(&running).Error
+	if err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "已有导出任务进行中", "export_id": running.ID})
+		return
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询导出记录失败"})
+		return
+	}
+
+	export := models.DataExport{
+		ID:     uuid.NewString(),
+		UserID: userID,
+		Status: models.DataExportStatusPending,
+	}
+	if err := h.db.Create(&export).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建导出记录失败"})
+		return
+	}
+	taskID, err := h.taskService.SubmitDataExport(userID, export.ID)
+	if err != nil {
+		log.Printf("submit data export failed user=%s export=%s err=%v", userID, export.ID, err)
+		h.db.Model(&export).Updates(map[string]interface{}{
+			"status":        models.DataExportStatusFailed,
+			"error_message": "提交任务失败",
+		})
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "提交导出任务失败"})
+		return
+	}
+	h.db.Model(&export).Update("task_id", taskID)
+	export.TaskID = taskID
+	c.JSON(http.StatusAccepted, gin.H{"export": export})
+}
+
+// ListDataExports 列出最近的导出记录
+func (h *AccountHandler) ListDataExports(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var exports []models.DataExport
+	if err := h.db.Where("user_id = ?", userID).Order("created_at DESC").Limit(20).Find(&exports).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询导出记录失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"exports": exports})
+}
+
+// GetDataExport 查询导出状态，已完成且未过期时返回临时下载链接
+func (h *AccountHandler) GetDataExport(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var export models.DataExport
+	if err := h.db.Where("id = ? AND user_id = ?", c.Param("export_id"), userID).First(&export).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "导出记录不存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询导出记录失败"})
+		return
+	}
+	resp := gin.H{"export": export}
+	if export.Status == models.DataExportStatusSucceeded && export.ObjectKey != "" {
+		if export.ExpiresAt != nil && time.Now().After(*export.ExpiresAt) {
+			c.JSON(http.StatusGone, gin.H{"error": "导出文件已过期，请重新发起导出", "export": export})
+			return
+		}
+		if h.storage == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "对象存储不可用"})
+			return
+		}
+		url, err := h.storage.PresignGetObject(c.Request.Context(), h.bucket, export.ObjectKey, 15*time.Minute)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "生成下载链接失败"})
+			return
+		}
+		resp["download_url"] = url
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// DeleteAccount 注销账号：校验密码（及二次验证）后匿名化资料、解除社交关系、吊销全部会话与 API 令牌，
+// 消息发送者匿名化、附件与向量索引清理由任务服务异步完成
+func (h *AccountHandler) DeleteAccount(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var req DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: 缺少 password"})
+		return
+	}
+	var u models.User
+	if err := h.db.Where("id = ?", userID).First(&u).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(req.Password)) != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "密码错误"})
+		return
+	}
+	var tf models.UserTwoFactor
+	err := h.db.Where("user_id = ? AND enabled_at IS NOT NULL", userID).First(&tf).Error
+	if err == nil {
+		if !h.auth.verifySecondFactor(&tf, req.Code, req.RecoveryCode) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "验证码错误"})
+			return
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询二次验证状态失败"})
+		return
+	}
+
+	// 群主须先转让或解散群，避免群失去管理者
+	var owned int64
+	if err := h.db.Model(&models.RoomMember{}).
+		Joins("JOIN rooms ON rooms.id = room_members.room_id").
+		Where("room_members.user_id = ? AND room_members.role = ? AND room_members.left_at IS NULL", userID, models.MemberRoleOwner).
+		Where("rooms.room_type = ? AND rooms.deleted_at IS NULL", models.RoomTypeGroup).
+		Count(&owned).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询群信息失败"})
+		return
+	}
+	if owned > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "请先转让或解散你创建的群", "owned_groups": owned})
+		return
+	}
+
+	randomPassword, err := auth.GenerateRefreshToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "注销账号失败"})
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(randomPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "注销账号失败"})
+		return
+	}
+	var tokens []models.APIToken
+	if err := h.db.Where("(user_id = ? OR created_by = ?) AND revoked_at IS NULL", userID, userID).Find(&tokens).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询令牌失败"})
+		return
+	}
+	var sessionIDs []string
+	if err := h.db.Model(&models.AuthSession{}).Where("user_id = ? AND revoked_at IS NULL", userID).Pluck("id", &sessionIDs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询会话失败"})
+		return
+	}
+
+	now := time.Now()
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"username":             "deleted_" + strings.ReplaceAll(userID, "-", ""),
+			"email":                nil,
+			"phone":                nil,
+			"password_hash":        string(hash),
+			"status":               "offline",
+			"display_name":         nil,
+			"avatar_attachment_id": nil,
+			"bio":                  nil,
+			"email_verified_at":    nil,
+			"phone_verified_at":    nil,
+			"status_text":          nil,
+			"status_emoji":         nil,
+			"purge_pending_at":     &now,
+			"updated_at":           now,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.RoomMember{}).Where("user_id = ? AND left_at IS NULL", userID).Update("left_at", &now).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id_a = ? OR user_id_b = ?", userID, userID).Delete(&models.Friendship{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("from_user_id = ? OR to_user_id = ?", userID, userID).Delete(&models.FriendRequest{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ? OR blocked_user_id = ?", userID, userID).Delete(&models.Block{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.StarredMessage{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.UserIdentity{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.VerificationToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.TwoFactorRecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.UserTwoFactor{}).Error; err != nil {
+			return err
+		}
+		// 按用户吊销，覆盖查询之后新建的会话；Redis 吊销与断开连接在事务提交后按会话 ID 执行
+		if err := tx.Model(&models.AuthSession{}).Where("user_id = ? AND revoked_at IS NULL", userID).Update("revoked_at", &now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.APIToken{}).Where("(user_id = ? OR created_by = ?) AND revoked_at IS NULL", userID, userID).Update("revoked_at", &now).Error
+	})
+	if err != nil {
+		log.Printf("delete account failed user=%s err=%v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "注销账号失败"})
+		return
+	}
+
+	if err := h.auth.revokeSessions(userID, sessionIDs); err != nil {
+		log.Printf("revoke sessions on account deletion failed user=%s err=%v", userID, err)
+	}
+	h.disconnectTokens(tokens)
+
+	// 清理标记已随注销事务写入，这里提交失败时由清扫任务重试
+	purgeTaskID := h.submitAccountPurge(userID)
+
+	c.SetCookie("refresh_token", "", -1, "/", "", false, true)
+	c.JSON(http.StatusOK, gin.H{"message": "账号已注销", "purge_task_id": purgeTaskID})
+}
+
+// StartPurgeSweeper 定期重新提交入队失败或提交中断的注销清理任务
+func (h *AccountHandler) StartPurgeSweeper(ctx context.Context) {
+	if h.taskService == nil {
+		return
+	}
+	ticker := time.NewTicker(purgeSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var userIDs []string
+			if err := h.db.Model(&models.User{}).Where("purge_pending_at <= ?", time.Now()).
+				Order("purge_pending_at ASC").Limit(purgeSweepBatch).Pluck("id", &userIDs).Error; err != nil {
+				log.Printf("account purge sweep query failed err=%v", err)
+				continue
+			}
+			for _, uid := range userIDs {
+				h.submitAccountPurge(uid)
+			}
+		}
+	}
+}
+
+// submitAccountPurge 认领到期的清理标记并提交任务，成功后清空标记；认领通过条件更新实现，多节点只有一个成功
+func (h *AccountHandler) submitAccountPurge(userID string) string {
+	if h.taskService == nil {
+		return ""
+	}
+	now := time.Now()
+	res := h.db.Model(&models.User{}).Where("id = ? AND purge_pending_at <= ?", userID, now).
+		Update("purge_pending_at", now.Add(purgeSubmitLease))
+	if res.Error != nil || res.RowsAffected != 1 {
+		return ""
+	}
+	taskID, err := h.taskService.SubmitAccountPurge(userID, now)
+	if err != nil {
+		log.Printf("submit account purge failed user=%s err=%v", userID, err)
+		return ""
+	}
+	if err := h.db.Model(&models.User{}).Where("id = ?", userID).Update("purge_pending_at", nil).Error; err != nil {
+		log.Printf("clear account purge mark failed user=%s err=%v", userID, err)
+	}
+	return taskID
+}
+
+// disconnectTokens 断开使用已吊销 API 令牌建立的 WebSocket 连接（以令牌 ID 作为会话 ID）
+func (h *AccountHandler) disconnectTokens(tokens []models.APIToken) {
+	if len(tokens) == 0 {
+		return
+	}
+	byUser := make(map[string][]string)
+	for _, t := range tokens {
+		byUser[t.UserID] = append(byUser[t.UserID], t.ID)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	for uid, ids := range byUser {
+		if h.auth.router != nil {
+			h.auth.router.DisconnectSessions(ctx, uid, ids)
+		} else if h.auth.hub != nil {
+			h.auth.hub.disconnectSessions(SessionDisconnect{UserID: uid, SessionIDs: ids})
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package handler
 
 import (
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,23 +16,40 @@ import (
 	"ququchat/internal/config"
 	"ququchat/internal/models"
 	"ququchat/internal/server/auth"
+	"ququchat/internal/server/notify"
 )
 
 type AuthHandler struct {
 	db           *gorm.DB
-	jwtSecret    string
+	keys         *auth.KeySet
 	accessTTL    time.Duration
 	refreshTTL   time.Duration
 	refreshBytes int
+	revocations  *auth.SessionRevocations
+	loginGuard   *auth.LoginGuard
+	hub          *Hub
+	router       *HubRouter
+	totpIssuer   string
+	totpKey      []byte
+	oidc         map[string]*oidcProvider
+	notifier     notify.Notifier
 }
 
-func NewAuthHandler(db *gorm.DB, settings config.AuthSettings) *AuthHandler {
+func NewAuthHandler(db *gorm.DB, settings config.AuthSettings, keys *auth.KeySet, revocations *auth.SessionRevocations, loginGuard *auth.LoginGuard, notifier notify.Notifier, hub *Hub, router *HubRouter) *AuthHandler {
 	return &AuthHandler{
 		db:           db,
-		jwtSecret:    settings.JWTSecret,
+		keys:         keys,
 		accessTTL:    settings.AccessTTL,
 		refreshTTL:   settings.RefreshTTL,
 		refreshBytes: settings.RefreshTokenBytes,
+		revocations:  revocations,
+		loginGuard:   loginGuard,
+		hub:          hub,
+		router:       router,
+		totpIssuer:   settings.TOTPIssuer,
+		totpKey:      auth.DeriveEncryptionKey(settings.TOTPEncryptionKey),
+		oidc:         newOIDCProviders(settings.OIDCProviders),
+		notifier:     notifier,
 	}
 }
 
@@ -88,9 +107,8 @@ func (h *AuthHandler) Logout(c *gin.Context) {
 		return
 	}
 
-	// 吊销会话
-	now := time.Now()
-	if err := h.db.Model(&session).Update("revoked_at", &now).Error; err != nil {
+	// 吊销会话并断开该设备的实时连接
+	if err := h.revokeSessions(currentUserID, []string{session.ID}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "吊销会话失败"})
 		return
 	}
@@ -183,9 +201,16 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	// 连续失败被锁定期间直接拒绝，不再校验密码
+	if lockedFor := h.loginGuard.LockedFor(c.Request.Context(), req.Username); lockedFor > 0 {
+		h.respondLocked(c, lockedFor)
+		return
+	}
+
 	var u models.User
 	if err := h.db.Where("username = ?", req.Username).First(&u).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
+			h.loginGuard.RecordFailure(c.Request.Context(), req.Username)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
 			return
 		}
@@ -193,13 +218,52 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	// 机器人账号只能通过 API 令牌访问
+	if u.IsBot {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
+		return
+	}
 	if err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(req.Password)); err != nil {
+		if lockedFor := h.loginGuard.RecordFailure(c.Request.Context(), req.Username); lockedFor > 0 {
+			h.respondLocked(c, lockedFor)
+			return
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
 		return
 	}
 
-	// 登录成功：签发访问令牌 + 刷新令牌（使用配置的 TTL）
-	accessToken, _, err := auth.SignAccessToken(u.ID, u.Username, h.accessTTL, h.jwtSecret)
+	h.completeLogin(c, &u)
+}
+
+// completeLogin 第一因素校验通过后：已启用二次验证则仅返回挑战令牌，待 /auth/login/2fa 校验验证码后再签发令牌
+func (h *AuthHandler) completeLogin(c *gin.Context, u *models.User) {
+	enabled, err := h.twoFactorEnabled(u.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询二次验证状态失败"})
+		return
+	}
+	if enabled {
+		challenge, exp, err := auth.SignChallengeToken(h.keys, u.ID, auth.DefaultChallengeTTL)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "签发挑战令牌失败"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"twoFactorRequired": true,
+			"challengeToken":    challenge,
+			"expiresAt":         exp.Unix(),
+		})
+		return
+	}
+
+	h.issueLoginTokens(c, u)
+}
+
+// issueLoginTokens 登录成功：签发访问令牌 + 刷新令牌（使用配置的 TTL），访问令牌携带会话 ID
+func (h *AuthHandler) issueLoginTokens(c *gin.Context, u *models.User) {
+	h.loginGuard.Reset(c.Request.Context(), u.Username)
+	sessionID := uuid.NewString()
+	accessToken, _, err := auth.SignAccessToken(h.keys, u.ID, u.Username, sessionID, h.accessTTL)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "签发令牌失败"})
 		return
@@ -215,11 +279,13 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	// 记录登录会话（刷新令牌）
 	ip := c.ClientIP()
 	ua := c.GetHeader("User-Agent")
+	now := time.Now()
 	session := models.AuthSession{
-		ID:           uuid.NewString(),
+		ID:           sessionID,
 		UserID:       u.ID,
-		ExpiresAt:    time.Now().Add(refreshTTL),
-		CreatedAt:    time.Now(),
+		ExpiresAt:    now.Add(refreshTTL),
+		CreatedAt:    now,
+		LastActiveAt: &now,
 		RefreshToken: &refreshToken,
 	}
 	if ip != "" {
@@ -287,15 +353,8 @@ func (h *AuthHandler) Refresh(c *gin.Context) {
 		return
 	}
 
-	// 轮换：吊销旧刷新令牌
-	now := time.Now()
-	if err := h.db.Model(&sess).Update("revoked_at", &now).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "吊销旧令牌失败"})
-		return
-	}
-
-	// 生成新的访问令牌与刷新令牌（使用配置的 TTL）
-	accessToken, _, err := auth.SignAccessToken(u.ID, u.Username, h.accessTTL, h.jwtSecret)
+	// 生成新的访问令牌与刷新令牌（使用配置的 TTL），会话 ID 保持不变以便按设备管理
+	accessToken, _, err := auth.SignAccessToken(h.keys, u.ID, u.Username, sess.ID, h.accessTTL)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "签发新访问令牌失败"})
 		return
@@ -307,25 +366,30 @@ func (h *AuthHandler) Refresh(c *gin.Context) {
 		return
 	}
 
-	ip := c.ClientIP()
-	ua := c.GetHeader("User-Agent")
-	newSess := models.AuthSession{
-		ID:           uuid.NewString(),
-		UserID:       u.ID,
-		ExpiresAt:    time.Now().Add(refreshTTL),
-		CreatedAt:    time.Now(),
-		RefreshToken: &newRefresh,
+	// 轮换：原会话行替换刷新令牌，旧刷新令牌随即失效；条件更新防止并发重复使用
+	now := time.Now()
+	updates := map[string]interface{}{
+		"refresh_token":  newRefresh,
+		"expires_at":     now.Add(refreshTTL),
+		"last_active_at": now,
 	}
-	if ip != "" {
-		newSess.IP = &ip
+	if ip := c.ClientIP(); ip != "" {
+		updates["ip"] = ip
 	}
-	if ua != "" {
-		newSess.UserAgent = &ua
+	if ua := c.GetHeader("User-Agent"); ua != "" {
+		updates["user_agent"] = ua
 	}
-	if err := h.db.Create(&newSess).Error; err != nil {
+	result := h.db.Model(&models.AuthSession{}).
+		Where("id = ? AND refresh_token = ? AND revoked_at IS NULL", sess.ID, token).
+		Updates(updates)
+	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存新刷新令牌失败"})
 		return
 	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "刷新令牌已失效"})
+		return
+	}
 
 	// 设置新的 Cookie
 	c.SetCookie("refresh_token", newRefresh, int(refreshTTL.Seconds()), "/", "", false, true)
@@ -336,6 +400,22 @@ func (h *AuthHandler) Refresh(c *gin.Context) {
 	})
 }
 
+// respondLocked 账号因连续登录失败被临时锁定
+func (h *AuthHandler) respondLocked(c *gin.Context, lockedFor time.Duration) {
+	seconds := int(math.Ceil(lockedFor.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       "登录失败次数过多，账号已临时锁定",
+		"retry_after": seconds,
+	})
+}
+
+// JWKS 公开验签公钥（/.well-known/jwks.json），供其他服务校验本服务签发的访问令牌
+func (h *AuthHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": h.keys.JWKS()})
+}
+
 func isDuplicateKeyErr(err error) bool {
 	// 优先使用 MySQLError 类型，回退到字符串匹配
 	if me, ok := err.(*mysqlerr.MySQLError); ok {
@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"ququchat/internal/models"
+)
+
+type BlockUserRequest struct {
+	TargetUserCode int64 `json:"target_user_code"`
+}
+
+// isBlockedBetween 判断两个用户之间是否存在任一方向的拉黑关系
+func isBlockedBetween(db *gorm.DB, a, b string) (bool, error) {
+	if a == "" || b == "" || a == b {
+		return false, nil
+	}
+	var count int64
+	err := db.Model(&models.Block{}).
+		Where("(user_id = ? AND blocked_user_id = ?) OR (user_id = ? AND blocked_user_id = ?)", a, b, b, a).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// listBlockerIDs 返回 candidates 中已将 userID 拉黑的用户
+func listBlockerIDs(db *gorm.DB, userID string, candidates []string) ([]string, error) {
+	if userID == "" || len(candidates) == 0 {
+		return nil, nil
+	}
+	var blockerIDs []string
+	err := db.Model(&models.Block{}).
+		Where("blocked_user_id = ? AND user_id IN ?", userID, candidates).
+		Pluck("user_id", &blockerIDs).Error
+	return blockerIDs, err
+}
+
+func (h *UserHandler) findUserByCode(c *gin.Context, userCode int64) (*models.User, bool) {
+	var target models.User
+	if err := h.db.Where("user_code = ?", userCode).First(&target).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询用户失败"})
+		return nil, false
+	}
+	return &target, true
+}
+
+// BlockUser 拉黑用户：被拉黑者无法再发起好友请求或发送私聊消息
+func (h *UserHandler) BlockUser(c *gin.Context) {
+	currentUserID := c.GetString("user_id")
+	if currentUserID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+
+	var req BlockUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.TargetUserCode <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求体格式错误或目标用户无效"})
+		return
+	}
+
+	target, ok := h.findUserByCode(c, req.TargetUserCode)
+	if !ok {
+		return
+	}
+	if target.ID == currentUserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不能拉黑自己"})
+		return
+	}
+
+	block := models.Block{
+		ID:            uuid.NewString(),
+		UserID:        currentUserID,
+		BlockedUserID: target.ID,
+		CreatedAt:     time.Now(),
+	}
+	if err := h.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&block).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "拉黑失败"})
+		return
+	}
+	h.invalidateFriendshipCaches(currentUserID, target.ID)
+
+	if h.hub != nil {
+		h.hub.SendSystemEventToUser(currentUserID, "block_list_updated")
+		h.hub.SendSystemEventToUser(currentUserID, "friend_list_updated")
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "已拉黑",
+		"blocked": gin.H{
+			"id":                   target.ID,
+			"user_code":            target.UserCode,
+			"username":             target.Username,
+			"avatar_attachment_id": target.AvatarAttachmentID,
+		},
+	})
+}
+
+func (h *UserHandler) UnblockUser(c *gin.Context) {
+	currentUserID := c.GetString("user_id")
+	if currentUserID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+
+	var req BlockUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.TargetUserCode <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求体格式错误或目标用户无效"})
+		return
+	}
+
+	target, ok := h.findUserByCode(c, req.TargetUserCode)
+	if !ok {
+		return
+	}
+
+	if err := h.db.Where("user_id = ? AND blocked_user_id = ?", currentUserID, target.ID).Delete(&models.Block{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "取消拉黑失败"})
+		return
+	}
+	h.invalidateFriendshipCaches(currentUserID, target.ID)
+
+	if h.hub != nil {
+		h.hub.SendSystemEventToUser(currentUserID, "block_list_updated")
+		h.hub.SendSystemEventToUser(currentUserID, "friend_list_updated")
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "已取消拉黑"})
+}
+
+func (h *UserHandler) ListBlocks(c *gin.Context) {
+	currentUserID := c.GetString("user_id")
+	if currentUserID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+
+	var blocks []models.Block
+	if err := h.db.Preload("BlockedUser").
+		Where("user_id = ?", currentUserID).
+		Order("created_at DESC").
+		Find(&blocks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询黑名单失败"})
+		return
+	}
+
+	resp := make([]gin.H, 0, len(blocks))
+	for _, b := range blocks {
+		if b.BlockedUser == nil {
+			continue
+		}
+		resp = append(resp, gin.H{
+			"id":                   b.BlockedUser.ID,
+			"user_code":            b.BlockedUser.UserCode,
+			"username":             b.BlockedUser.Username,
+			"avatar_attachment_id": b.BlockedUser.AvatarAttachmentID,
+			"blocked_at":           b.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"blocks": resp})
+}
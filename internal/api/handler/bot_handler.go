@@ -0,0 +1,387 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"ququchat/internal/models"
+	"ququchat/internal/server/auth"
+)
+
+// apiTokenTouchInterval 令牌最近使用时间的写入间隔，避免每次请求都更新数据库
+const apiTokenTouchInterval = time.Minute
+
+// BotHandler 机器人账号与 API 令牌管理，同时作为 API 令牌的校验器
+type BotHandler struct {
+	db     *gorm.DB
+	hub    *Hub
+	router *HubRouter
+}
+
+func NewBotHandler(db *gorm.DB, hub *Hub, router *HubRouter) *BotHandler {
+	return &BotHandler{db: db, hub: hub, router: router}
+}
+
+type CreateBotRequest struct {
+	Username    string  `json:"username" binding:"required"`
+	DisplayName *string `json:"display_name,omitempty"`
+}
+
+type CreateAPITokenRequest struct {
+	Name   string   `json:"name" binding:"required"`
+	Scopes []string `json:"scopes" binding:"required"`
+	// BotID 为空时创建用户本人的令牌
+	BotID         string   `json:"bot_id,omitempty"`
+	RoomIDs       []string `json:"room_ids,omitempty"`
+	ExpiresInDays int      `json:"expires_in_days,omitempty"`
+}
+
+type RevokeAPITokenRequest struct {
+	TokenID string `json:"token_id" binding:"required"`
+}
+
+type APITokenDTO struct {
+	ID         string   `json:"id"`
+	UserID     string   `json:"user_id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	RoomIDs    []string `json:"room_ids"`
+	ExpiresAt  *int64   `json:"expires_at,omitempty"`
+	LastUsedAt *int64   `json:"last_used_at,omitempty"`
+	CreatedAt  int64    `json:"created_at"`
+}
+
+func unixPtr(t *time.Time) *int64 {
+	if t == nil {
+		return nil
+	}
+	v := t.Unix()
+	return &v
+}
+
+func toAPITokenDTO(t models.APIToken) APITokenDTO {
+	return APITokenDTO{
+		ID:         t.ID,
+		UserID:     t.UserID,
+		Name:       t.Name,
+		Prefix:     t.Prefix,
+		Scopes:     strings.Fields(t.Scopes),
+		RoomIDs:    strings.Fields(t.RoomIDs),
+		ExpiresAt:  unixPtr(t.ExpiresAt),
+		LastUsedAt: unixPtr(t.LastUsedAt),
+		CreatedAt:  t.CreatedAt.Unix(),
+	}
+}
+
+// CreateBot 创建当前用户名下的机器人账号；机器人没有可用密码，只能通过 API 令牌访问
+func (h *BotHandler) CreateBot(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var req CreateBotRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Username) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: 缺少 username"})
+		return
+	}
+	var owner models.User
+	if err := h.db.Select("id", "is_bot").Where("id = ?", userID).First(&owner).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询用户失败"})
+		return
+	}
+	if owner.IsBot {
+		c.JSON(http.StatusForbidden, gin.H{"error": "机器人不能创建机器人"})
+		return
+	}
+	randomPassword, err := auth.GenerateRefreshToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成机器人凭据失败"})
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(randomPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成机器人凭据失败"})
+		return
+	}
+	bot := models.User{
+		ID:           uuid.NewString(),
+		Username:     strings.TrimSpace(req.Username),
+		PasswordHash: string(hash),
+		Status:       "offline",
+		DisplayName:  req.DisplayName,
+		IsBot:        true,
+		BotOwnerID:   &userID,
+	}
+	if err := h.db.Create(&bot).Error; err != nil {
+		if isDuplicateKeyErr(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "用户名已存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建机器人失败"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"bot": gin.H{
+		"id":           bot.ID,
+		"user_code":    bot.UserCode,
+		"username":     bot.Username,
+		"display_name": bot.DisplayName,
+	}})
+}
+
+// ListBots 列出当前用户创建的机器人
+func (h *BotHandler) ListBots(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var bots []models.User
+	if err := h.db.Where("bot_owner_id = ? AND is_bot = ?", userID, true).Order("created_at DESC").Find(&bots).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询机器人失败"})
+		return
+	}
+	list := make([]gin.H, 0, len(bots))
+	for _, b := range bots {
+		list = append(list, gin.H{
+			"id":           b.ID,
+			"user_code":    b.UserCode,
+			"username":     b.Username,
+			"display_name": b.DisplayName,
+			"status":       b.Status,
+			"created_at":   b.CreatedAt.Unix(),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"bots": list})
+}
+
+// ownsBot 校验 botID 是当前用户创建的机器人
+func (h *BotHandler) ownsBot(ownerID, botID string) (bool, error) {
+	var count int64
+	err := h.db.Model(&models.User{}).
+		Where("id = ? AND is_bot = ? AND bot_owner_id = ?", botID, true, ownerID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// DeleteBot 删除名下机器人：吊销其全部 API 令牌并断开连接、退出所在群，账号匿名化保留以便历史消息展示发送者
+// 仍被群接入回调使用的机器人须先删除回调
+func (h *BotHandler) DeleteBot(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	botID := c.Param("bot_id")
+	owned, err := h.ownsBot(userID, botID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询机器人失败"})
+		return
+	}
+	if !owned {
+		c.JSON(http.StatusNotFound, gin.H{"error": "机器人不存在"})
+		return
+	}
+	var hooks int64
+	if err := h.db.Model(&models.RoomIncomingWebhook{}).Where("bot_user_id = ?", botID).Count(&hooks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询群接入回调失败"})
+		return
+	}
+	if hooks > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "请先删除使用该机器人的群接入回调"})
+		return
+	}
+	var tokenIDs []string
+	if err := h.db.Model(&models.APIToken{}).Where("user_id = ? AND revoked_at IS NULL", botID).Pluck("id", &tokenIDs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询令牌失败"})
+		return
+	}
+	now := time.Now()
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", botID).Updates(map[string]interface{}{
+			"username":     "deleted_" + strings.ReplaceAll(botID, "-", ""),
+			"status":       "offline",
+			"display_name": nil,
+			"bot_owner_id": nil,
+			"updated_at":   now,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.RoomMember{}).Where("user_id = ? AND left_at IS NULL", botID).Update("left_at", &now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.APIToken{}).Where("user_id = ? AND revoked_at IS NULL", botID).Update("revoked_at", &now).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除机器人失败"})
+		return
+	}
+	if len(tokenIDs) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		if h.router != nil {
+			h.router.DisconnectSessions(ctx, botID, tokenIDs)
+		} else if h.hub != nil {
+			h.hub.disconnectSessions(SessionDisconnect{UserID: botID, SessionIDs: tokenIDs})
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "机器人已删除"})
+}
+
+// CreateAPIToken 为本人或名下机器人创建 API 令牌，明文只在创建时返回一次
+func (h *BotHandler) CreateAPIToken(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var req CreateAPITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: 缺少 name 或 scopes"})
+		return
+	}
+	scopes, err := auth.NormalizeScopes(req.Scopes)
+	if err != nil || len(scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "权限范围无效，可选 messages:read、messages:write、events:read"})
+		return
+	}
+	tokenUserID := userID
+	if botID := strings.TrimSpace(req.BotID); botID != "" {
+		owned, err := h.ownsBot(userID, botID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询机器人失败"})
+			return
+		}
+		if !owned {
+			c.JSON(http.StatusNotFound, gin.H{"error": "机器人不存在"})
+			return
+		}
+		tokenUserID = botID
+	}
+	roomIDs := make([]string, 0, len(req.RoomIDs))
+	for _, id := range req.RoomIDs {
+		if id = strings.TrimSpace(id); id != "" && !strings.ContainsAny(id, " \t\n") {
+			roomIDs = append(roomIDs, id)
+		}
+	}
+
+	raw, prefix, err := auth.GenerateAPIToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成令牌失败"})
+		return
+	}
+	now := time.Now()
+	token := models.APIToken{
+		ID:        uuid.NewString(),
+		UserID:    tokenUserID,
+		CreatedBy: userID,
+		Name:      strings.TrimSpace(req.Name),
+		Prefix:    prefix,
+		TokenHash: auth.HashVerificationToken(raw),
+		Scopes:    strings.Join(scopes, " "),
+		RoomIDs:   strings.Join(roomIDs, " "),
+		CreatedAt: now,
+	}
+	if req.ExpiresInDays > 0 {
+		exp := now.AddDate(0, 0, req.ExpiresInDays)
+		token.ExpiresAt = &exp
+	}
+	if err := h.db.Create(&token).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存令牌失败"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"token": raw, "api_token": toAPITokenDTO(token)})
+}
+
+// ListAPITokens 列出本人及名下机器人的有效令牌
+func (h *BotHandler) ListAPITokens(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var tokens []models.APIToken
+	if err := h.db.Where("created_by = ? AND revoked_at IS NULL", userID).Order("created_at DESC").Find(&tokens).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询令牌失败"})
+		return
+	}
+	list := make([]APITokenDTO, 0, len(tokens))
+	for _, t := range tokens {
+		list = append(list, toAPITokenDTO(t))
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": list})
+}
+
+// RevokeAPIToken 吊销令牌并断开使用该令牌建立的 WebSocket 连接
+func (h *BotHandler) RevokeAPIToken(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var req RevokeAPITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: 缺少 token_id"})
+		return
+	}
+	var token models.APIToken
+	if err := h.db.Where("id = ? AND created_by = ? AND revoked_at IS NULL", req.TokenID, userID).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "令牌不存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询令牌失败"})
+		return
+	}
+	now := time.Now()
+	if err := h.db.Model(&token).Update("revoked_at", &now).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "吊销令牌失败"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if h.router != nil {
+		h.router.DisconnectSessions(ctx, token.UserID, []string{token.ID})
+	} else if h.hub != nil {
+		h.hub.disconnectSessions(SessionDisconnect{UserID: token.UserID, SessionIDs: []string{token.ID}})
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "令牌已吊销"})
+}
+
+// ResolveAPIToken 实现 auth.APITokenResolver：按哈希查找未吊销、未过期的令牌
+func (h *BotHandler) ResolveAPIToken(ctx context.Context, raw string) (*auth.APITokenPrincipal, error) {
+	var token models.APIToken
+	err := h.db.WithContext(ctx).
+		Where("token_hash = ? AND revoked_at IS NULL", auth.HashVerificationToken(raw)).
+		First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if token.ExpiresAt != nil && !token.ExpiresAt.After(now) {
+		return nil, errors.New("api token expired")
+	}
+	var u models.User
+	if err := h.db.WithContext(ctx).Select("id", "username").Where("id = ?", token.UserID).First(&u).Error; err != nil {
+		return nil, err
+	}
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > apiTokenTouchInterval {
+		h.db.Model(&models.APIToken{}).Where("id = ?", token.ID).Update("last_used_at", &now)
+	}
+	return &auth.APITokenPrincipal{
+		TokenID:  token.ID,
+		UserID:   u.ID,
+		Username: u.Username,
+		Scopes:   strings.Fields(token.Scopes),
+		RoomIDs:  strings.Fields(token.RoomIDs),
+	}, nil
+}
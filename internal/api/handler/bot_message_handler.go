@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"ququchat/internal/middleware"
+	"ququchat/internal/models"
+)
+
+// messageMaxRunes 单条文本消息长度上限，WebSocket 与 REST 发送共用
+const messageMaxRunes = 4000
+
+type PostMessageRequest struct {
+	RoomID           string                 `json:"room_id" binding:"required"`
+	Content          string                 `json:"content" binding:"required"`
+	Payload          map[string]interface{} `json:"payload,omitempty"`
+	ParentMessageID  string                 `json:"parent_message_id,omitempty"`
+	ParentSequenceID *int64                 `json:"parent_sequence_id,omitempty"`
+}
+
+// PostMessage 通过 REST 向群发送文本消息，供机器人与脚本使用；需 messages:write 权限且为群成员
+func (h *WsHandler) PostMessage(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var req PostMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: 缺少 room_id 或 content"})
+		return
+	}
+	text := strings.TrimSpace(req.Content)
+	if text == "" || utf8.RuneCountInString(text) > messageMaxRunes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "消息内容为空或过长"})
+		return
+	}
+	if p, ok := middleware.APITokenFromContext(c); ok && !p.AllowsRoom(req.RoomID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API 令牌无权访问该房间"})
+		return
+	}
+	var room models.Room
+	if err := h.db.Select("id", "room_type").Where("id = ? AND room_type = ?", req.RoomID, models.RoomTypeGroup).First(&room).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "群不存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询群信息失败"})
+		return
+	}
+	if err := h.checkGroupPostingPermission(room.ID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "不是群成员或已被禁言"})
+		return
+	}
+	savedMsg, err := h.postGroupTextMessage(room.ID, userID, text, req.Payload, req.ParentMessageID, req.ParentSequenceID)
+	if err != nil && savedMsg == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "发送消息失败"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"id":          savedMsg.ID,
+		"room_id":     room.ID,
+		"sequence_id": savedMsg.SequenceID,
+		"timestamp":   savedMsg.CreatedAt.Unix(),
+	})
+}
@@ -0,0 +1,261 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"ququchat/internal/models"
+	cachepkg "ququchat/internal/server/cache"
+)
+
+type ConversationHandler struct {
+	db    *gorm.DB
+	cache *cachepkg.RedisClient
+}
+
+func NewConversationHandler(db *gorm.DB, cache *cachepkg.RedisClient) *ConversationHandler {
+	return &ConversationHandler{db: db, cache: cache}
+}
+
+type ConversationDTO struct {
+	RoomID             string      `json:"room_id"`
+	RoomType           string      `json:"room_type"`
+	Name               string      `json:"name"`
+	PeerUserID         string      `json:"peer_user_id,omitempty"`
+	AvatarAttachmentID *string     `json:"avatar_attachment_id,omitempty"`
+	LastMessage        *MessageDTO `json:"last_message,omitempty"`
+	UnreadCount        int64       `json:"unread_count"`
+	Muted              bool        `json:"muted"`
+	Pinned             bool        `json:"pinned"`
+	Archived           bool        `json:"archived"`
+	UpdatedAt          int64       `json:"updated_at"`
+}
+
+type UpdateConversationSettingsRequest struct {
+	RoomID   string `json:"room_id" binding:"required"`
+	Muted    *bool  `json:"muted"`
+	Pinned   *bool  `json:"pinned"`
+	Archived *bool  `json:"archived"`
+}
+
+// invalidateConversationLists 清除指定用户的会话列表缓存
+func invalidateConversationLists(cache *cachepkg.RedisClient, userIDs ...string) {
+	if cache == nil || len(userIDs) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(userIDs))
+	for _, uid := range userIDs {
+		if strings.TrimSpace(uid) == "" {
+			continue
+		}
+		keys = append(keys, cache.BuildKey(cachepkg.ConversationListKey(uid)...))
+	}
+	if len(keys) == 0 {
+		return
+	}
+	cacheCtx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	_ = cache.Del(cacheCtx, keys...)
+	cancel()
+}
+
+// buildConversations 汇总当前用户所在的私聊与群聊，按置顶、最近活跃排序
+func (h *ConversationHandler) buildConversations(userID string) ([]ConversationDTO, error) {
+	var memberships []models.RoomMember
+	if err := h.db.Where("user_id = ? AND left_at IS NULL", userID).Find(&memberships).Error; err != nil {
+		return nil, err
+	}
+	result := make([]ConversationDTO, 0, len(memberships))
+	if len(memberships) == 0 {
+		return result, nil
+	}
+	roomIDs := make([]string, 0, len(memberships))
+	memberByRoom := make(map[string]models.RoomMember, len(memberships))
+	for _, m := range memberships {
+		roomIDs = append(roomIDs, m.RoomID)
+		memberByRoom[m.RoomID] = m
+	}
+
+	var rooms []models.Room
+	if err := h.db.Where("id IN ?", roomIDs).Find(&rooms).Error; err != nil {
+		return nil, err
+	}
+
+	// 私聊对端用户信息
+	peerByRoom := make(map[string]string)
+	peerIDs := make([]string, 0)
+	for _, r := range rooms {
+		if r.RoomType != models.RoomTypeDirect {
+			continue
+		}
+		if peerID, ok := directRoomPeer(r.Name, userID); ok {
+			peerByRoom[r.ID] = peerID
+			peerIDs = append(peerIDs, peerID)
+		}
+	}
+	peers := make(map[string]models.User, len(peerIDs))
+	if len(peerIDs) > 0 {
+		var users []models.User
+		if err := h.db.Select("id", "username", "display_name", "avatar_attachment_id").Where("id IN ?", peerIDs).Find(&users).Error; err != nil {
+			return nil, err
+		}
+		for _, u := range users {
+			peers[u.ID] = u
+		}
+	}
+
+	// 每个房间的最后一条消息（含撤回墓碑）
+	var lastMessages []models.Message
+	if err := h.db.Unscoped().
+		Where("(room_id, sequence_id) IN ?",
+			h.db.Unscoped().Model(&models.Message{}).
+				Select("room_id, MAX(sequence_id)").
+				Where("room_id IN ?", roomIDs).
+				Group("room_id")).
+		Find(&lastMessages).Error; err != nil {
+		return nil, err
+	}
+	lastByRoom := make(map[string]models.Message, len(lastMessages))
+	for _, m := range lastMessages {
+		lastByRoom[m.RoomID] = m
+	}
+
+	unreadByRoom, err := loadUnreadCounts(h.db, userID, roomIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range rooms {
+		member := memberByRoom[r.ID]
+		conv := ConversationDTO{
+			RoomID:      r.ID,
+			RoomType:    string(r.RoomType),
+			Name:        r.Name,
+			UnreadCount: unreadByRoom[r.ID],
+			Muted:       member.NotifyMuted,
+			Pinned:      member.PinnedAt != nil,
+			Archived:    member.Archived,
+			UpdatedAt:   member.JoinedAt.Unix(),
+		}
+		if peerID, ok := peerByRoom[r.ID]; ok {
+			conv.PeerUserID = peerID
+			conv.Name = ""
+			if peer, ok := peers[peerID]; ok {
+				conv.Name = peer.Username
+				if peer.DisplayName != nil && strings.TrimSpace(*peer.DisplayName) != "" {
+					conv.Name = strings.TrimSpace(*peer.DisplayName)
+				}
+				conv.AvatarAttachmentID = peer.AvatarAttachmentID
+			}
+		}
+		if last, ok := lastByRoom[r.ID]; ok {
+			dto := toMessageDTO(last)
+			conv.LastMessage = &dto
+			conv.UpdatedAt = last.CreatedAt.Unix()
+		}
+		result = append(result, conv)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		pi := memberByRoom[result[i].RoomID].PinnedAt
+		pj := memberByRoom[result[j].RoomID].PinnedAt
+		if (pi != nil) != (pj != nil) {
+			return pi != nil
+		}
+		if pi != nil && pj != nil && !pi.Equal(*pj) {
+			return pi.After(*pj)
+		}
+		return result[i].UpdatedAt > result[j].UpdatedAt
+	})
+	return result, nil
+}
+
+// ListConversations 返回会话列表：最后一条消息、未读数与个人设置，结果按用户缓存
+func (h *ConversationHandler) ListConversations(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+
+	if h.cache != nil {
+		cacheKey := h.cache.BuildKey(cachepkg.ConversationListKey(userID)...)
+		cacheCtx, cancel := context.WithTimeout(context.Background(), 120*time.Millisecond)
+		var cached []ConversationDTO
+		ok, err := h.cache.GetJSON(cacheCtx, cacheKey, &cached)
+		cancel()
+		if err == nil && ok {
+			c.JSON(http.StatusOK, gin.H{"conversations": cached})
+			return
+		}
+	}
+
+	conversations, err := h.buildConversations(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询会话列表失败"})
+		return
+	}
+	if h.cache != nil {
+		cacheKey := h.cache.BuildKey(cachepkg.ConversationListKey(userID)...)
+		cacheCtx, cancel := context.WithTimeout(context.Background(), 120*time.Millisecond)
+		_ = h.cache.SetJSON(cacheCtx, cacheKey, conversations, cachepkg.ConversationListTTL)
+		cancel()
+	}
+	c.JSON(http.StatusOK, gin.H{"conversations": conversations})
+}
+
+// UpdateConversationSettings 修改会话的免打扰/置顶/归档设置，仅影响当前用户
+func (h *ConversationHandler) UpdateConversationSettings(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var req UpdateConversationSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+	var member models.RoomMember
+	if err := h.db.Where("room_id = ? AND user_id = ? AND left_at IS NULL", req.RoomID, userID).First(&member).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "您不是该房间成员"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询成员关系失败"})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if req.Muted != nil {
+		updates["notify_muted"] = *req.Muted
+	}
+	if req.Archived != nil {
+		updates["archived"] = *req.Archived
+	}
+	if req.Pinned != nil {
+		if *req.Pinned {
+			if member.PinnedAt == nil {
+				updates["pinned_at"] = time.Now()
+			}
+		} else {
+			updates["pinned_at"] = nil
+		}
+	}
+	if len(updates) > 0 {
+		if err := h.db.Model(&models.RoomMember{}).
+			Where("room_id = ? AND user_id = ?", req.RoomID, userID).
+			Updates(updates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新会话设置失败"})
+			return
+		}
+		invalidateConversationLists(h.cache, userID)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "操作成功"})
+}
@@ -0,0 +1,312 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"ququchat/internal/models"
+)
+
+const (
+	groupNameMaxRunes     = 128
+	groupNicknameMaxRunes = 64
+)
+
+var errGroupOwnerChanged = errors.New("group owner changed")
+
+type RemoveAdminsRequest struct {
+	UserIDs []string `json:"user_ids" binding:"required"`
+}
+
+type TransferOwnerRequest struct {
+	UserID string `json:"user_id" binding:"required"`
+}
+
+type RenameGroupRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type SetNicknameRequest struct {
+	Nickname string `json:"nickname"`
+}
+
+// memberDisplayName 群内展示名：群昵称 > 用户昵称 > 用户名
+func (h *GroupHandler) memberDisplayName(roomID, userID string) string {
+	var member models.RoomMember
+	if err := h.db.Preload("User").Where("room_id = ? AND user_id = ?", roomID, userID).First(&member).Error; err != nil {
+		return userID
+	}
+	if member.NicknameInRoom != nil && strings.TrimSpace(*member.NicknameInRoom) != "" {
+		return strings.TrimSpace(*member.NicknameInRoom)
+	}
+	if member.User != nil {
+		if member.User.DisplayName != nil && strings.TrimSpace(*member.User.DisplayName) != "" {
+			return strings.TrimSpace(*member.User.DisplayName)
+		}
+		return member.User.Username
+	}
+	return userID
+}
+
+// writeSystemMessage 向群内写入一条系统消息并推送给在线成员
+func (h *GroupHandler) writeSystemMessage(roomID, text string) {
+	m, _, err := saveRoomMessage(h.db, models.Message{
+		RoomID:      roomID,
+		ContentType: models.ContentTypeSystem,
+		ContentText: &text,
+	}, "", nil)
+	if err != nil {
+		log.Printf("save group system message failed room=%s err=%v", roomID, err)
+		return
+	}
+	memberIDs, err := h.activeMemberIDs(roomID)
+	if err != nil || len(memberIDs) == 0 {
+		return
+	}
+	invalidateConversationLists(h.cache, memberIDs...)
+	b, err := newFrame(OutgoingMessage{
+		ID:         m.ID,
+		Type:       "system_message",
+		RoomID:     roomID,
+		Content:    text,
+		Timestamp:  m.CreatedAt.Unix(),
+		SequenceID: m.SequenceID,
+	})
+	if err != nil {
+		return
+	}
+	broadcastToRoomMembers(h.hub, h.router, roomID, memberIDs, b)
+}
+
+// RemoveAdmins 批量取消管理员（仅群主可用）
+func (h *GroupHandler) RemoveAdmins(c *gin.Context) {
+	currentUserID := c.GetString("user_id")
+	if currentUserID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	groupID := c.Param("group_id")
+	if groupID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少群ID"})
+		return
+	}
+	var req RemoveAdminsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+	if len(req.UserIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "未指定用户"})
+		return
+	}
+
+	room, operator, ok := h.loadGroupOperator(c, groupID, currentUserID)
+	if !ok {
+		return
+	}
+	if operator.Role != models.MemberRoleOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只有群主可以取消管理员"})
+		return
+	}
+
+	var demotedIDs []string
+	if err := h.db.Model(&models.RoomMember{}).
+		Where("room_id = ? AND user_id IN ? AND role = ? AND left_at IS NULL", room.ID, req.UserIDs, models.MemberRoleAdmin).
+		Pluck("user_id", &demotedIDs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询群成员失败"})
+		return
+	}
+	if len(demotedIDs) == 0 {
+		c.JSON(http.StatusOK, gin.H{"message": "操作成功", "updated_count": 0})
+		return
+	}
+	if err := h.db.Model(&models.RoomMember{}).
+		Where("room_id = ? AND user_id IN ?", room.ID, demotedIDs).
+		Update("role", models.MemberRoleMember).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "取消管理员失败"})
+		return
+	}
+	h.invalidateGroupPostingPermission(room.ID, demotedIDs...)
+	h.invalidateGroupMemberIDs(room.ID)
+
+	names := make([]string, 0, len(demotedIDs))
+	for _, uid := range demotedIDs {
+		names = append(names, h.memberDisplayName(room.ID, uid))
+	}
+	h.writeSystemMessage(room.ID, fmt.Sprintf("%s 已被取消管理员", strings.Join(names, "、")))
+	h.notifyGroupMembers(room.ID, GroupEvent{Event: "group_member_updated", OperatorID: currentUserID})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "操作成功",
+		"updated_count": len(demotedIDs),
+	})
+}
+
+// TransferOwner 转让群主，原群主降为管理员
+func (h *GroupHandler) TransferOwner(c *gin.Context) {
+	currentUserID := c.GetString("user_id")
+	if currentUserID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	groupID := c.Param("group_id")
+	if groupID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少群ID"})
+		return
+	}
+	var req TransferOwnerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	room, operator, ok := h.loadGroupOperator(c, groupID, currentUserID)
+	if !ok {
+		return
+	}
+	if operator.Role != models.MemberRoleOwner || room.OwnerUserID != currentUserID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只有群主可以转让群"})
+		return
+	}
+	if req.UserID == currentUserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不能转让给自己"})
+		return
+	}
+	var target models.RoomMember
+	if err := h.db.Where("room_id = ? AND user_id = ? AND left_at IS NULL", room.ID, req.UserID).First(&target).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "目标用户不在群内"})
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.Room{}).Where("id = ? AND owner_user_id = ?", room.ID, currentUserID).
+			Update("owner_user_id", target.UserID)
+		if res.Error != nil {
+			return res.Error
+		}
+		// 并发转让时只有一个请求能匹配到原群主
+		if res.RowsAffected != 1 {
+			return errGroupOwnerChanged
+		}
+		if err := tx.Model(&models.RoomMember{}).Where("room_id = ? AND user_id = ?", room.ID, target.UserID).
+			Update("role", models.MemberRoleOwner).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.RoomMember{}).Where("room_id = ? AND user_id = ?", room.ID, currentUserID).
+			Update("role", models.MemberRoleAdmin).Error
+	})
+	if errors.Is(err, errGroupOwnerChanged) {
+		c.JSON(http.StatusConflict, gin.H{"error": "群主已变更，请刷新后重试"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "转让群主失败"})
+		return
+	}
+	h.invalidateGroupPostingPermission(room.ID, currentUserID, target.UserID)
+	h.invalidateGroupMemberIDs(room.ID)
+
+	h.writeSystemMessage(room.ID, fmt.Sprintf("%s 已将群主转让给 %s",
+		h.memberDisplayName(room.ID, currentUserID), h.memberDisplayName(room.ID, target.UserID)))
+	h.notifyGroupMembers(room.ID, GroupEvent{Event: "group_member_updated", UserID: target.UserID, OperatorID: currentUserID})
+
+	c.JSON(http.StatusOK, gin.H{"message": "转让成功", "owner_id": target.UserID})
+}
+
+// RenameGroup 修改群名称（群主/管理员可用）
+func (h *GroupHandler) RenameGroup(c *gin.Context) {
+	currentUserID := c.GetString("user_id")
+	if currentUserID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	groupID := c.Param("group_id")
+	if groupID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少群ID"})
+		return
+	}
+	var req RenameGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || utf8.RuneCountInString(name) > groupNameMaxRunes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "群名称不能为空且不超过128个字符"})
+		return
+	}
+
+	room, _, ok := h.loadGroupOperator(c, groupID, currentUserID)
+	if !ok {
+		return
+	}
+	if room.Name == name {
+		c.JSON(http.StatusOK, gin.H{"message": "操作成功", "name": name})
+		return
+	}
+	if err := h.db.Model(&models.Room{}).Where("id = ?", room.ID).Update("name", name).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "修改群名称失败"})
+		return
+	}
+
+	h.writeSystemMessage(room.ID, fmt.Sprintf("%s 将群名称修改为「%s」", h.memberDisplayName(room.ID, currentUserID), name))
+	h.notifyGroupMembers(room.ID, GroupEvent{Event: "group_updated", OperatorID: currentUserID})
+
+	c.JSON(http.StatusOK, gin.H{"message": "操作成功", "name": name})
+}
+
+// SetMyNickname 设置自己在群内的昵称，传空字符串恢复默认
+func (h *GroupHandler) SetMyNickname(c *gin.Context) {
+	currentUserID := c.GetString("user_id")
+	if currentUserID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	groupID := c.Param("group_id")
+	if groupID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少群ID"})
+		return
+	}
+	var req SetNicknameRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+	nickname := strings.TrimSpace(req.Nickname)
+	if utf8.RuneCountInString(nickname) > groupNicknameMaxRunes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "群昵称不超过64个字符"})
+		return
+	}
+
+	var member models.RoomMember
+	if err := h.db.Where("room_id = ? AND user_id = ? AND left_at IS NULL", groupID, currentUserID).First(&member).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "您不是该群成员"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询群成员失败"})
+		return
+	}
+
+	var value interface{}
+	if nickname != "" {
+		value = nickname
+	}
+	if err := h.db.Model(&models.RoomMember{}).
+		Where("room_id = ? AND user_id = ?", groupID, currentUserID).
+		Update("nickname_in_room", value).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "设置群昵称失败"})
+		return
+	}
+	h.invalidateGroupMemberIDs(groupID)
+	h.notifyGroupMembers(groupID, GroupEvent{Event: "group_member_updated", UserID: currentUserID, OperatorID: currentUserID})
+
+	c.JSON(http.StatusOK, gin.H{"message": "操作成功", "nickname": nickname})
+}
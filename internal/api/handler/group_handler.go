@@ -12,17 +12,19 @@ import (
 
 	"ququchat/internal/models"
 	cachepkg "ququchat/internal/server/cache"
+	webhooksvc "ququchat/internal/service/webhook"
 )
 
 type GroupHandler struct {
-	db     *gorm.DB
-	hub    *Hub
-	router *HubRouter
-	cache  *cachepkg.RedisClient
+	db       *gorm.DB
+	hub      *Hub
+	router   *HubRouter
+	cache    *cachepkg.RedisClient
+	webhooks *webhooksvc.Dispatcher
 }
 
-func NewGroupHandler(db *gorm.DB, hub *Hub, cache *cachepkg.RedisClient, router *HubRouter) *GroupHandler {
-	return &GroupHandler{db: db, hub: hub, router: router, cache: cache}
+func NewGroupHandler(db *gorm.DB, hub *Hub, cache *cachepkg.RedisClient, router *HubRouter, webhooks *webhooksvc.Dispatcher) *GroupHandler {
+	return &GroupHandler{db: db, hub: hub, router: router, cache: cache, webhooks: webhooks}
 }
 
 func (h *GroupHandler) invalidateGroupPostingPermission(roomID string, userIDs ...string) {
@@ -145,6 +147,7 @@ func (h *GroupHandler) CreateGroup(c *gin.Context) {
 			}
 		}
 	}
+	invalidateConversationLists(h.cache, append([]string{currentUserID}, req.MemberIDs...)...)
 	var memberCount int64
 	if err := h.db.Model(&models.RoomMember{}).Where("room_id = ?", room.ID).Count(&memberCount).Error; err != nil {
 		memberCount = 1
@@ -200,6 +203,7 @@ func (h *GroupHandler) GetGroupDetail(c *gin.Context) {
 			"owner_id":     room.OwnerUserID,
 			"member_count": memberCount,
 			"my_role":      member.Role,
+			"mute_all":     room.MuteAll,
 			"created_at":   room.CreatedAt,
 			"updated_at":   room.UpdatedAt,
 		},
@@ -305,6 +309,7 @@ func (h *GroupHandler) DismissGroup(c *gin.Context) {
 	}
 	h.invalidateGroupPostingPermissionByRoom(room.ID)
 	h.invalidateGroupMemberIDs(room.ID)
+	invalidateConversationLists(h.cache, memberIDs...)
 
 	if h.router != nil && h.cache != nil {
 		roomNodesKey := h.cache.BuildKey(cachepkg.WSRoomNodesKey(room.ID)...)
@@ -404,8 +409,12 @@ func (h *GroupHandler) AddMembers(c *gin.Context) {
 		}
 	}
 	h.invalidateGroupPostingPermission(groupID, addedUserIDs...)
+	for _, uid := range addedUserIDs {
+		h.webhooks.Enqueue(groupID, webhooksvc.EventMemberJoined, gin.H{"user_id": uid, "added_by": currentUserID})
+	}
 	if len(addedUserIDs) > 0 {
 		h.invalidateGroupMemberIDs(groupID)
+		invalidateConversationLists(h.cache, addedUserIDs...)
 	}
 
 	if h.router != nil && h.cache != nil && len(addedUserIDs) > 0 {
@@ -418,6 +427,10 @@ func (h *GroupHandler) AddMembers(c *gin.Context) {
 			if err == nil && len(nodes) > 0 {
 				ctx2, cancel2 := context.WithTimeout(context.Background(), 200*time.Millisecond)
 				_ = h.cache.SAdd(ctx2, roomNodesKey, nodes...)
+				// 同步节点反向索引，节点失效时一并清理
+				for _, n := range nodes {
+					_ = h.cache.SAdd(ctx2, h.cache.BuildKey(cachepkg.WSNodeRoomsKey(n)...), groupID)
+				}
 				cancel2()
 			}
 		}
@@ -493,6 +506,8 @@ func (h *GroupHandler) RemoveMember(c *gin.Context) {
 	}
 	h.invalidateGroupPostingPermission(groupID, req.UserID)
 	h.invalidateGroupMemberIDs(groupID)
+	invalidateConversationLists(h.cache, req.UserID)
+	h.webhooks.Enqueue(groupID, webhooksvc.EventMemberLeft, gin.H{"user_id": req.UserID, "reason": "removed", "removed_by": currentUserID})
 
 	if h.hub != nil {
 		memberIDs, err := h.activeMemberIDs(groupID)
@@ -554,6 +569,8 @@ func (h *GroupHandler) LeaveGroup(c *gin.Context) {
 	}
 	h.invalidateGroupPostingPermission(groupID, currentUserID)
 	h.invalidateGroupMemberIDs(groupID)
+	invalidateConversationLists(h.cache, currentUserID)
+	h.webhooks.Enqueue(groupID, webhooksvc.EventMemberLeft, gin.H{"user_id": currentUserID, "reason": "left"})
 
 	if h.hub != nil {
 		memberIDs, err := h.activeMemberIDs(groupID)
@@ -623,6 +640,7 @@ func (h *GroupHandler) ListGroupMembers(c *gin.Context) {
 		AvatarAttachmentID *string           `json:"avatar_attachment_id,omitempty"`
 		Role               models.MemberRole `json:"role"`
 		JoinedAt           time.Time         `json:"joined_at"`
+		MuteUntil          *time.Time        `json:"mute_until,omitempty"`
 	}
 
 	resp := make([]MemberResp, 0, len(members))
@@ -652,6 +670,12 @@ func (h *GroupHandler) ListGroupMembers(c *gin.Context) {
 			AvatarAttachmentID: avatarAttachmentID,
 			Role:               m.Role,
 			JoinedAt:           m.JoinedAt,
+			MuteUntil: func() *time.Time {
+				if m.MuteUntil == nil || !m.MuteUntil.After(time.Now()) {
+					return nil
+				}
+				return m.MuteUntil
+			}(),
 		})
 	}
 
@@ -712,6 +736,8 @@ func (h *GroupHandler) AddAdmins(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "设置管理员失败"})
 		return
 	}
+	// 角色变化影响全员禁言下的发言权限
+	h.invalidateGroupPostingPermission(groupID, req.UserIDs...)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":       "操作成功",
@@ -0,0 +1,232 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"ququchat/internal/models"
+)
+
+// 单次禁言最长 30 天
+const maxGroupMuteDuration = 30 * 24 * time.Hour
+
+type MuteMemberRequest struct {
+	UserID          string `json:"user_id" binding:"required"`
+	DurationSeconds int64  `json:"duration_seconds" binding:"required"`
+}
+
+type UnmuteMemberRequest struct {
+	UserID string `json:"user_id" binding:"required"`
+}
+
+type SetMuteAllRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// GroupEvent 群设置、成员或禁言状态变化，推送给群内全部成员（跨节点）
+type GroupEvent struct {
+	Type       string `json:"type"`
+	Event      string `json:"event"`
+	RoomID     string `json:"room_id"`
+	UserID     string `json:"user_id,omitempty"`
+	OperatorID string `json:"operator_id"`
+	MuteUntil  int64  `json:"mute_until,omitempty"`
+	MuteAll    *bool  `json:"mute_all,omitempty"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
+// loadGroupOperator 校验群存在且当前用户为群主或管理员
+func (h *GroupHandler) loadGroupOperator(c *gin.Context, groupID, userID string) (*models.Room, *models.RoomMember, bool) {
+	var room models.Room
+	if err := h.db.Where("id = ? AND room_type = ?", groupID, models.RoomTypeGroup).First(&room).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "群不存在"})
+			return nil, nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询群信息失败"})
+		return nil, nil, false
+	}
+	var operator models.RoomMember
+	if err := h.db.Where("room_id = ? AND user_id = ? AND left_at IS NULL", groupID, userID).First(&operator).Error; err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "您不是群成员"})
+		return nil, nil, false
+	}
+	if operator.Role != models.MemberRoleOwner && operator.Role != models.MemberRoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "权限不足"})
+		return nil, nil, false
+	}
+	return &room, &operator, true
+}
+
+// loadMuteTarget 查找被禁言成员；群主可操作任何人，管理员只能操作普通成员
+func (h *GroupHandler) loadMuteTarget(c *gin.Context, groupID string, operator *models.RoomMember, targetUserID string) (*models.RoomMember, bool) {
+	if targetUserID == operator.UserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不能对自己操作"})
+		return nil, false
+	}
+	var target models.RoomMember
+	if err := h.db.Where("room_id = ? AND user_id = ? AND left_at IS NULL", groupID, targetUserID).First(&target).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "目标用户不在群内"})
+		return nil, false
+	}
+	if target.Role == models.MemberRoleOwner ||
+		(operator.Role == models.MemberRoleAdmin && target.Role != models.MemberRoleMember) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "权限不足"})
+		return nil, false
+	}
+	return &target, true
+}
+
+func (h *GroupHandler) notifyGroupMembers(groupID string, ev GroupEvent) {
+	memberIDs, err := h.activeMemberIDs(groupID)
+	if err != nil || len(memberIDs) == 0 {
+		return
+	}
+	ev.Type = "system_event"
+	ev.RoomID = groupID
+	ev.Timestamp = time.Now().Unix()
+	b, err := newFrame(ev)
+	if err != nil {
+		return
+	}
+	broadcastToRoomMembers(h.hub, h.router, groupID, memberIDs, b)
+}
+
+// MuteMember 禁言成员指定时长（群主/管理员可用）
+func (h *GroupHandler) MuteMember(c *gin.Context) {
+	currentUserID := c.GetString("user_id")
+	if currentUserID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	groupID := c.Param("group_id")
+	if groupID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少群ID"})
+		return
+	}
+	var req MuteMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.DurationSeconds <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+	duration := time.Duration(req.DurationSeconds) * time.Second
+	if duration > maxGroupMuteDuration {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "禁言时长不能超过30天"})
+		return
+	}
+
+	_, operator, ok := h.loadGroupOperator(c, groupID, currentUserID)
+	if !ok {
+		return
+	}
+	target, ok := h.loadMuteTarget(c, groupID, operator, req.UserID)
+	if !ok {
+		return
+	}
+
+	muteUntil := time.Now().Add(duration)
+	if err := h.db.Model(&models.RoomMember{}).
+		Where("room_id = ? AND user_id = ?", groupID, target.UserID).
+		Update("mute_until", muteUntil).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "禁言失败"})
+		return
+	}
+	h.invalidateGroupPostingPermission(groupID, target.UserID)
+	h.notifyGroupMembers(groupID, GroupEvent{
+		Event:      "group_member_muted",
+		UserID:     target.UserID,
+		OperatorID: currentUserID,
+		MuteUntil:  muteUntil.Unix(),
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "已禁言",
+		"user_id":    target.UserID,
+		"mute_until": muteUntil,
+	})
+}
+
+// UnmuteMember 解除成员禁言（群主/管理员可用）
+func (h *GroupHandler) UnmuteMember(c *gin.Context) {
+	currentUserID := c.GetString("user_id")
+	if currentUserID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	groupID := c.Param("group_id")
+	if groupID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少群ID"})
+		return
+	}
+	var req UnmuteMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	_, operator, ok := h.loadGroupOperator(c, groupID, currentUserID)
+	if !ok {
+		return
+	}
+	target, ok := h.loadMuteTarget(c, groupID, operator, req.UserID)
+	if !ok {
+		return
+	}
+
+	if err := h.db.Model(&models.RoomMember{}).
+		Where("room_id = ? AND user_id = ?", groupID, target.UserID).
+		Update("mute_until", nil).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "解除禁言失败"})
+		return
+	}
+	h.invalidateGroupPostingPermission(groupID, target.UserID)
+	h.notifyGroupMembers(groupID, GroupEvent{
+		Event:      "group_member_unmuted",
+		UserID:     target.UserID,
+		OperatorID: currentUserID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "已解除禁言", "user_id": target.UserID})
+}
+
+// SetMuteAll 开启/关闭全员禁言，群主和管理员不受影响
+func (h *GroupHandler) SetMuteAll(c *gin.Context) {
+	currentUserID := c.GetString("user_id")
+	if currentUserID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	groupID := c.Param("group_id")
+	if groupID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少群ID"})
+		return
+	}
+	var req SetMuteAllRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	room, _, ok := h.loadGroupOperator(c, groupID, currentUserID)
+	if !ok {
+		return
+	}
+	if room.MuteAll != req.Enabled {
+		if err := h.db.Model(&models.Room{}).Where("id = ?", room.ID).Update("mute_all", req.Enabled).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "设置全员禁言失败"})
+			return
+		}
+		h.invalidateGroupPostingPermissionByRoom(room.ID)
+		muteAll := req.Enabled
+		h.notifyGroupMembers(room.ID, GroupEvent{
+			Event:      "group_mute_all_updated",
+			OperatorID: currentUserID,
+			MuteAll:    &muteAll,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "操作成功", "mute_all": req.Enabled})
+}
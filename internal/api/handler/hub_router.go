@@ -4,16 +4,30 @@ import (
 	"context"
 	"encoding/json"
 	"log"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/redis/go-redis/v9"
+
 	cachepkg "ququchat/internal/server/cache"
 )
 
+const (
+	// wsStreamGroup 节点消费自身消息流的消费组；流按节点划分，每个组只有一个消费者
+	wsStreamGroup     = "hub"
+	wsStreamBatchSize = 100
+	wsStreamBlock     = 5 * time.Second
+)
+
+// HubRouter 跨节点路由：消息写入目标节点的 Redis Stream，由目标节点以消费组方式读取并确认，
+// 节点重启或与 Redis 短暂断开后可补收 replayWindow 内的消息
 type HubRouter struct {
-	nodeID string
-	hub    *Hub
-	redis  *cachepkg.RedisClient
+	nodeID       string
+	hub          *Hub
+	redis        *cachepkg.RedisClient
+	replayWindow time.Duration
 
 	mu                   sync.Mutex
 	localUserConnCount   map[string]int
@@ -28,14 +42,37 @@ type routerPayload struct {
 	ToUserID     string   `json:"to_user_id,omitempty"`
 	RoomID       string   `json:"room_id,omitempty"`
 	UserIDs      []string `json:"user_ids,omitempty"`
+	SessionIDs   []string `json:"session_ids,omitempty"`
 	Data         []byte   `json:"data"`
+	// FrameClass/CoalesceKey 帧的投递元信息，目标节点无需重新解析帧；缺省时按消息帧处理
+	FrameClass  *int   `json:"frame_class,omitempty"`
+	CoalesceKey string `json:"coalesce_key,omitempty"`
+}
+
+// withFrame 写入帧内容与投递元信息
+func (p routerPayload) withFrame(f *wsFrame) routerPayload {
+	class := f.meta.class
+	p.Data = f.data
+	p.FrameClass = &class
+	p.CoalesceKey = f.meta.coalesceKey
+	return p
+}
+
+// frame 还原帧
+func (p routerPayload) frame() *wsFrame {
+	meta := frameMeta{class: frameClassCritical}
+	if p.FrameClass != nil {
+		meta = frameMeta{class: *p.FrameClass, coalesceKey: p.CoalesceKey}
+	}
+	return &wsFrame{data: p.Data, meta: meta}
 }
 
-func NewHubRouter(nodeID string, hub *Hub, redis *cachepkg.RedisClient) *HubRouter {
+func NewHubRouter(nodeID string, hub *Hub, redis *cachepkg.RedisClient, replayWindow time.Duration) *HubRouter {
 	return &HubRouter{
 		nodeID:               nodeID,
 		hub:                  hub,
 		redis:                redis,
+		replayWindow:         replayWindow,
 		localUserConnCount:   make(map[string]int),
 		localRoomMemberCount: make(map[string]int),
 		localUserRooms:       make(map[string]map[string]bool),
@@ -59,11 +96,47 @@ func (r *HubRouter) OnConnect(ctx context.Context, userID, connID string, roomID
 	connKey := r.redis.BuildKey(cachepkg.WSUserConnsKey(userID)...)
 	_ = r.redis.SAdd(ctx, connKey, connID)
 	connHubKey := r.redis.BuildKey(cachepkg.WSConnHubKey(connID)...)
-	_ = r.redis.SetString(ctx, connHubKey, r.nodeID, 24*time.Hour)
+	_ = r.redis.SetString(ctx, connHubKey, r.nodeID, cachepkg.WSPresenceTTL)
+	_ = r.redis.Expire(ctx, key, cachepkg.WSPresenceTTL)
+	_ = r.redis.Expire(ctx, connKey, cachepkg.WSPresenceTTL)
+	_ = r.redis.SAdd(ctx, r.redis.BuildKey(cachepkg.WSNodeUsersKey(r.nodeID)...), userID)
 	for _, rid := range roomIDs {
 		roomKey := r.redis.BuildKey(cachepkg.WSRoomNodesKey(rid)...)
 		_ = r.redis.SAdd(ctx, roomKey, r.nodeID)
 	}
+	if len(roomIDs) > 0 {
+		_ = r.redis.SAdd(ctx, r.redis.BuildKey(cachepkg.WSNodeRoomsKey(r.nodeID)...), roomIDs...)
+	}
+}
+
+// Heartbeat 续期连接心跳及用户的节点、连接集合
+func (r *HubRouter) Heartbeat(ctx context.Context, userID, connID string) {
+	connHubKey := r.redis.BuildKey(cachepkg.WSConnHubKey(connID)...)
+	_ = r.redis.SetString(ctx, connHubKey, r.nodeID, cachepkg.WSPresenceTTL)
+	_ = r.redis.Expire(ctx, r.redis.BuildKey(cachepkg.WSUserNodesKey(userID)...), cachepkg.WSPresenceTTL)
+	_ = r.redis.Expire(ctx, r.redis.BuildKey(cachepkg.WSUserConnsKey(userID)...), cachepkg.WSPresenceTTL)
+}
+
+// LiveConnCount 统计用户在所有节点上心跳未过期的连接数，顺带清理已失效的连接
+func (r *HubRouter) LiveConnCount(ctx context.Context, userID string) (int, error) {
+	connKey := r.redis.BuildKey(cachepkg.WSUserConnsKey(userID)...)
+	connIDs, err := r.redis.SMembers(ctx, connKey)
+	if err != nil {
+		return 0, err
+	}
+	live := 0
+	for _, id := range connIDs {
+		_, ok, err := r.redis.GetString(ctx, r.redis.BuildKey(cachepkg.WSConnHubKey(id)...))
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			live++
+			continue
+		}
+		_ = r.redis.SRem(ctx, connKey, id)
+	}
+	return live, nil
 }
 
 func (r *HubRouter) OnDisconnect(ctx context.Context, userID, connID string) {
@@ -93,101 +166,283 @@ func (r *HubRouter) OnDisconnect(ctx context.Context, userID, connID string) {
 	if userGone {
 		userNodesKey := r.redis.BuildKey(cachepkg.WSUserNodesKey(userID)...)
 		_ = r.redis.SRem(ctx, userNodesKey, r.nodeID)
+		_ = r.redis.SRem(ctx, r.redis.BuildKey(cachepkg.WSNodeUsersKey(r.nodeID)...), userID)
 	}
 	for _, rid := range goneRooms {
 		roomKey := r.redis.BuildKey(cachepkg.WSRoomNodesKey(rid)...)
 		_ = r.redis.SRem(ctx, roomKey, r.nodeID)
 	}
+	if len(goneRooms) > 0 {
+		_ = r.redis.SRem(ctx, r.redis.BuildKey(cachepkg.WSNodeRoomsKey(r.nodeID)...), goneRooms...)
+	}
 }
 
-func (r *HubRouter) RouteDirectMessage(ctx context.Context, fromUserID, toUserID string, data []byte) {
+func (r *HubRouter) RouteDirectMessage(ctx context.Context, fromUserID, toUserID string, f *wsFrame) {
 	key := r.redis.BuildKey(cachepkg.WSUserNodesKey(toUserID)...)
 	nodes, err := r.redis.SMembers(ctx, key)
 	if err != nil || len(nodes) == 0 {
-		r.hub.direct <- DirectMessage{FromUserID: fromUserID, ToUserID: toUserID, Data: data}
+		r.hub.sendDirect(DirectMessage{FromUserID: fromUserID, ToUserID: toUserID, Frame: f})
 		return
 	}
 	localDelivered := false
 	for _, n := range nodes {
 		if n == r.nodeID {
-			r.hub.direct <- DirectMessage{FromUserID: fromUserID, ToUserID: toUserID, Data: data}
+			r.hub.sendDirect(DirectMessage{FromUserID: fromUserID, ToUserID: toUserID, Frame: f})
 			localDelivered = true
 		} else {
 			r.publish(ctx, n, routerPayload{
 				Type: "direct", OriginNodeID: r.nodeID,
-				FromUserID: fromUserID, ToUserID: toUserID, Data: data,
-			})
+				FromUserID: fromUserID, ToUserID: toUserID,
+			}.withFrame(f))
 		}
 	}
 	if !localDelivered {
 		// also echo to sender on this node if present
-		r.hub.direct <- DirectMessage{FromUserID: fromUserID, ToUserID: toUserID, Data: data}
+		r.hub.sendDirect(DirectMessage{FromUserID: fromUserID, ToUserID: toUserID, Frame: f})
 	}
 }
 
-func (r *HubRouter) RouteBroadcast(ctx context.Context, roomID string, userIDs []string, data []byte) {
+func (r *HubRouter) RouteBroadcast(ctx context.Context, roomID string, userIDs []string, f *wsFrame) {
 	key := r.redis.BuildKey(cachepkg.WSRoomNodesKey(roomID)...)
 	nodes, err := r.redis.SMembers(ctx, key)
 	if err != nil || len(nodes) == 0 {
-		r.hub.broadcast <- GroupMessage{RoomID: roomID, UserIDs: userIDs, Data: data}
+		r.hub.sendBroadcast(GroupMessage{RoomID: roomID, UserIDs: userIDs, Frame: f})
 		return
 	}
 	localSent := false
 	for _, n := range nodes {
 		if n == r.nodeID {
-			r.hub.broadcast <- GroupMessage{RoomID: roomID, UserIDs: userIDs, Data: data}
+			r.hub.sendBroadcast(GroupMessage{RoomID: roomID, UserIDs: userIDs, Frame: f})
 			localSent = true
 		} else {
 			r.publish(ctx, n, routerPayload{
 				Type: "broadcast", OriginNodeID: r.nodeID,
-				RoomID: roomID, UserIDs: userIDs, Data: data,
-			})
+				RoomID: roomID, UserIDs: userIDs,
+			}.withFrame(f))
 		}
 	}
 	if !localSent {
-		r.hub.broadcast <- GroupMessage{RoomID: roomID, UserIDs: userIDs, Data: data}
+		r.hub.sendBroadcast(GroupMessage{RoomID: roomID, UserIDs: userIDs, Frame: f})
 	}
 }
 
+// RouteToUsers 向用户所在的各节点投递数据，不限定房间
+func (r *HubRouter) RouteToUsers(ctx context.Context, userIDs []string, f *wsFrame) {
+	byNode := make(map[string][]string)
+	for _, uid := range userIDs {
+		nodes, err := r.redis.SMembers(ctx, r.redis.BuildKey(cachepkg.WSUserNodesKey(uid)...))
+		if err != nil {
+			byNode[r.nodeID] = append(byNode[r.nodeID], uid)
+			continue
+		}
+		for _, n := range nodes {
+			byNode[n] = append(byNode[n], uid)
+		}
+	}
+	for n, ids := range byNode {
+		if n == r.nodeID {
+			r.hub.sendBroadcast(GroupMessage{UserIDs: ids, Frame: f})
+			continue
+		}
+		r.publish(ctx, n, routerPayload{
+			Type: "broadcast", OriginNodeID: r.nodeID,
+			UserIDs: ids,
+		}.withFrame(f))
+	}
+}
+
+// DisconnectSessions 断开用户指定会话在所有节点上的连接
+func (r *HubRouter) DisconnectSessions(ctx context.Context, userID string, sessionIDs []string) {
+	r.hub.disconnectSessions(SessionDisconnect{UserID: userID, SessionIDs: sessionIDs})
+	key := r.redis.BuildKey(cachepkg.WSUserNodesKey(userID)...)
+	nodes, err := r.redis.SMembers(ctx, key)
+	if err != nil {
+		return
+	}
+	for _, n := range nodes {
+		if n == r.nodeID {
+			continue
+		}
+		r.publish(ctx, n, routerPayload{
+			Type: "disconnect_sessions", OriginNodeID: r.nodeID,
+			ToUserID: userID, SessionIDs: sessionIDs,
+		})
+	}
+}
+
+// publish 将消息追加到目标节点的消息流；目标节点暂时离线时消息保留在流中，恢复后补收
 func (r *HubRouter) publish(ctx context.Context, targetNode string, p routerPayload) {
 	b, err := json.Marshal(p)
 	if err != nil {
 		return
 	}
-	channel := cachepkg.WSNodeChannel(r.redis.KeyPrefix(), targetNode)
-	if err := r.redis.Publish(ctx, channel, string(b)); err != nil {
+	stream := r.redis.BuildKey(cachepkg.WSNodeStreamKey(targetNode)...)
+	if _, err := r.redis.XAdd(ctx, stream, map[string]interface{}{"payload": string(b)}, r.replayWindow); err != nil {
 		log.Printf("hub_router publish to node=%s err=%v", targetNode, err)
 	}
 }
 
+// StartSubscriber 注册本节点，启动存活续期、失效节点清理与消息流消费
 func (r *HubRouter) StartSubscriber(ctx context.Context) {
-	channel := cachepkg.WSNodeChannel(r.redis.KeyPrefix(), r.nodeID)
-	pubsub := r.redis.Subscribe(ctx, channel)
-	go func() {
-		defer pubsub.Close()
-		ch := pubsub.Channel()
-		for {
-			select {
-			case <-ctx.Done():
+	r.register(ctx)
+	go r.keepAlive(ctx)
+	go r.consume(ctx)
+}
+
+// register 写入存活标记并加入节点集合；被其他节点判定失效后再次调用时，补回本节点的路由集合
+func (r *HubRouter) register(ctx context.Context) {
+	_ = r.redis.SetString(ctx, r.redis.BuildKey(cachepkg.WSNodeAliveKey(r.nodeID)...), strconv.FormatInt(time.Now().Unix(), 10), cachepkg.WSNodeAliveTTL)
+	_ = r.redis.SAdd(ctx, r.redis.BuildKey(cachepkg.WSNodesKey()...), r.nodeID)
+	stream := r.redis.BuildKey(cachepkg.WSNodeStreamKey(r.nodeID)...)
+	// 从流的起点建组，首次启动前其他节点已写入的消息也按回放窗口补收
+	if err := r.redis.XGroupEnsure(ctx, stream, wsStreamGroup, "0"); err != nil {
+		log.Printf("hub_router create stream group node=%s err=%v", r.nodeID, err)
+	}
+	_ = r.redis.Persist(ctx, stream)
+
+	r.mu.Lock()
+	userIDs := make([]string, 0, len(r.localUserConnCount))
+	for uid := range r.localUserConnCount {
+		userIDs = append(userIDs, uid)
+	}
+	roomIDs := make([]string, 0, len(r.localRoomMemberCount))
+	for rid := range r.localRoomMemberCount {
+		roomIDs = append(roomIDs, rid)
+	}
+	r.mu.Unlock()
+	for _, uid := range userIDs {
+		_ = r.redis.SAdd(ctx, r.redis.BuildKey(cachepkg.WSUserNodesKey(uid)...), r.nodeID)
+	}
+	for _, rid := range roomIDs {
+		_ = r.redis.SAdd(ctx, r.redis.BuildKey(cachepkg.WSRoomNodesKey(rid)...), r.nodeID)
+	}
+	if len(userIDs) > 0 {
+		_ = r.redis.SAdd(ctx, r.redis.BuildKey(cachepkg.WSNodeUsersKey(r.nodeID)...), userIDs...)
+	}
+	if len(roomIDs) > 0 {
+		_ = r.redis.SAdd(ctx, r.redis.BuildKey(cachepkg.WSNodeRoomsKey(r.nodeID)...), roomIDs...)
+	}
+}
+
+// keepAlive 定期续期存活标记，并清理存活标记已过期的节点
+func (r *HubRouter) keepAlive(ctx context.Context) {
+	ticker := time.NewTicker(cachepkg.WSNodeAliveTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		tickCtx, cancel := context.WithTimeout(ctx, cachepkg.WSNodeAliveTTL/3)
+		member, err := r.redis.SIsMember(tickCtx, r.redis.BuildKey(cachepkg.WSNodesKey()...), r.nodeID)
+		if err == nil && !member {
+			log.Printf("hub_router node=%s was swept, re-registering", r.nodeID)
+			r.register(tickCtx)
+		} else {
+			_ = r.redis.SetString(tickCtx, r.redis.BuildKey(cachepkg.WSNodeAliveKey(r.nodeID)...), strconv.FormatInt(time.Now().Unix(), 10), cachepkg.WSNodeAliveTTL)
+		}
+		r.sweepDeadNodes(tickCtx)
+		cancel()
+	}
+}
+
+// sweepDeadNodes 将存活标记已过期的节点从 ws:user_nodes/ws:room_nodes 中移除，其消息流在回放窗口后过期
+func (r *HubRouter) sweepDeadNodes(ctx context.Context) {
+	nodesKey := r.redis.BuildKey(cachepkg.WSNodesKey()...)
+	nodes, err := r.redis.SMembers(ctx, nodesKey)
+	if err != nil {
+		return
+	}
+	for _, n := range nodes {
+		if n == r.nodeID {
+			continue
+		}
+		_, alive, err := r.redis.GetString(ctx, r.redis.BuildKey(cachepkg.WSNodeAliveKey(n)...))
+		if err != nil || alive {
+			continue
+		}
+		usersKey := r.redis.BuildKey(cachepkg.WSNodeUsersKey(n)...)
+		roomsKey := r.redis.BuildKey(cachepkg.WSNodeRoomsKey(n)...)
+		userIDs, err := r.redis.SMembers(ctx, usersKey)
+		if err != nil {
+			continue
+		}
+		roomIDs, err := r.redis.SMembers(ctx, roomsKey)
+		if err != nil {
+			continue
+		}
+		for _, uid := range userIDs {
+			_ = r.redis.SRem(ctx, r.redis.BuildKey(cachepkg.WSUserNodesKey(uid)...), n)
+		}
+		for _, rid := range roomIDs {
+			_ = r.redis.SRem(ctx, r.redis.BuildKey(cachepkg.WSRoomNodesKey(rid)...), n)
+		}
+		_ = r.redis.Del(ctx, usersKey, roomsKey)
+		_ = r.redis.Expire(ctx, r.redis.BuildKey(cachepkg.WSNodeStreamKey(n)...), r.replayWindow)
+		_ = r.redis.SRem(ctx, nodesKey, n)
+		log.Printf("hub_router swept dead node=%s users=%d rooms=%d", n, len(userIDs), len(roomIDs))
+	}
+}
+
+// consume 先处理上次退出前已读取未确认的消息，再持续读取新消息
+func (r *HubRouter) consume(ctx context.Context) {
+	stream := r.redis.BuildKey(cachepkg.WSNodeStreamKey(r.nodeID)...)
+	pending := true
+	for ctx.Err() == nil {
+		id, block := ">", wsStreamBlock
+		if pending {
+			id, block = "0", 0
+		}
+		msgs, err := r.redis.XReadGroup(ctx, stream, wsStreamGroup, r.nodeID, id, wsStreamBatchSize, block)
+		if err != nil {
+			if ctx.Err() != nil {
 				return
-			case msg, ok := <-ch:
-				if !ok {
-					return
-				}
-				var p routerPayload
-				if err := json.Unmarshal([]byte(msg.Payload), &p); err != nil {
-					continue
-				}
-				if p.OriginNodeID == r.nodeID {
-					continue
-				}
-				switch p.Type {
-				case "direct":
-					r.hub.direct <- DirectMessage{FromUserID: p.FromUserID, ToUserID: p.ToUserID, Data: p.Data}
-				case "broadcast":
-					r.hub.broadcast <- GroupMessage{RoomID: p.RoomID, UserIDs: p.UserIDs, Data: p.Data}
-				}
 			}
+			if cachepkg.IsNoGroupErr(err) {
+				_ = r.redis.XGroupEnsure(ctx, stream, wsStreamGroup, "0")
+			} else {
+				log.Printf("hub_router read stream node=%s err=%v", r.nodeID, err)
+			}
+			time.Sleep(time.Second)
+			continue
+		}
+		if pending && len(msgs) == 0 {
+			pending = false
+			continue
+		}
+		ids := make([]string, 0, len(msgs))
+		for _, m := range msgs {
+			r.handleStreamMessage(m)
+			ids = append(ids, m.ID)
 		}
-	}()
+		if err := r.redis.XAck(ctx, stream, wsStreamGroup, ids...); err != nil {
+			log.Printf("hub_router ack stream node=%s err=%v", r.nodeID, err)
+		}
+	}
+}
+
+// handleStreamMessage 投递一条流消息；超出回放窗口的旧消息直接丢弃（客户端重连后通过 since 补拉）
+func (r *HubRouter) handleStreamMessage(m redis.XMessage) {
+	if ms, _, ok := strings.Cut(m.ID, "-"); ok && r.replayWindow > 0 {
+		if ts, err := strconv.ParseInt(ms, 10, 64); err == nil && time.Since(time.UnixMilli(ts)) > r.replayWindow {
+			return
+		}
+	}
+	raw, _ := m.Values["payload"].(string)
+	var p routerPayload
+	if err := json.Unmarshal([]byte(raw), &p); err != nil {
+		return
+	}
+	if p.OriginNodeID == r.nodeID {
+		return
+	}
+	switch p.Type {
+	case "direct":
+		r.hub.sendDirect(DirectMessage{FromUserID: p.FromUserID, ToUserID: p.ToUserID, Frame: p.frame()})
+	case "broadcast":
+		r.hub.sendBroadcast(GroupMessage{RoomID: p.RoomID, UserIDs: p.UserIDs, Frame: p.frame()})
+	case "disconnect_sessions":
+		r.hub.disconnectSessions(SessionDisconnect{UserID: p.ToUserID, SessionIDs: p.SessionIDs})
+	}
 }
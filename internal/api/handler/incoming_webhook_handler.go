@@ -0,0 +1,300 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"ququchat/internal/models"
+	"ququchat/internal/server/auth"
+	webhooksvc "ququchat/internal/service/webhook"
+)
+
+// incomingWebhookPath 接入回调地址前缀，完整地址为前缀 + 令牌
+const incomingWebhookPath = "/api/hooks/incoming/"
+
+const (
+	maxIncomingAttachments    = 10
+	maxIncomingAttachmentText = 2000
+	maxIncomingFields         = 20
+)
+
+var attachmentColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+type CreateIncomingWebhookRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type IncomingWebhookDTO struct {
+	ID         string `json:"id"`
+	RoomID     string `json:"room_id"`
+	Name       string `json:"name"`
+	Prefix     string `json:"prefix"`
+	BotUserID  string `json:"bot_user_id"`
+	Active     bool   `json:"active"`
+	CreatedBy  string `json:"created_by"`
+	LastUsedAt *int64 `json:"last_used_at,omitempty"`
+	CreatedAt  int64  `json:"created_at"`
+}
+
+func toIncomingWebhookDTO(w models.RoomIncomingWebhook) IncomingWebhookDTO {
+	return IncomingWebhookDTO{
+		ID:         w.ID,
+		RoomID:     w.RoomID,
+		Name:       w.Name,
+		Prefix:     w.Prefix,
+		BotUserID:  w.BotUserID,
+		Active:     w.Active,
+		CreatedBy:  w.CreatedBy,
+		LastUsedAt: unixPtr(w.LastUsedAt),
+		CreatedAt:  w.CreatedAt.Unix(),
+	}
+}
+
+// CreateIncomingWebhook 创建接入回调及其发送者机器人账号，令牌仅在创建时返回一次
+func (h *WebhookHandler) CreateIncomingWebhook(c *gin.Context) {
+	roomID, ok := h.requireGroupOwner(c)
+	if !ok {
+		return
+	}
+	var req CreateIncomingWebhookRequest
+	name := ""
+	if err := c.ShouldBindJSON(&req); err == nil {
+		name = strings.TrimSpace(req.Name)
+	}
+	if name == "" || utf8.RuneCountInString(name) > 64 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: name 为空或过长"})
+		return
+	}
+	var count int64
+	if err := h.db.Model(&models.RoomIncomingWebhook{}).Where("room_id = ?", roomID).Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询回调失败"})
+		return
+	}
+	if count >= maxWebhooksPerRoom {
+		c.JSON(http.StatusConflict, gin.H{"error": "回调数量已达上限"})
+		return
+	}
+	raw, prefix, err := webhooksvc.GenerateIncomingToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成令牌失败"})
+		return
+	}
+	// 机器人账号没有可用密码，仅作为消息发送者展示
+	randomPassword, err := auth.GenerateRefreshToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成机器人凭据失败"})
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(randomPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成机器人凭据失败"})
+		return
+	}
+	userID := c.GetString("user_id")
+	now := time.Now()
+	bot := models.User{
+		ID:           uuid.NewString(),
+		Username:     "hook_" + strings.ReplaceAll(uuid.NewString(), "-", "")[:12],
+		PasswordHash: string(hash),
+		Status:       "offline",
+		DisplayName:  &name,
+		IsBot:        true,
+		BotOwnerID:   &userID,
+	}
+	hook := models.RoomIncomingWebhook{
+		ID:        uuid.NewString(),
+		RoomID:    roomID,
+		CreatedBy: userID,
+		BotUserID: bot.ID,
+		Name:      name,
+		Prefix:    prefix,
+		TokenHash: auth.HashVerificationToken(raw),
+		Active:    true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&bot).Error; err != nil {
+			return err
+		}
+		return tx.Create(&hook).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建回调失败"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"webhook": toIncomingWebhookDTO(hook),
+		"token":   raw,
+		"url":     incomingWebhookPath + raw,
+	})
+}
+
+// ListIncomingWebhooks 列出群内的接入回调
+func (h *WebhookHandler) ListIncomingWebhooks(c *gin.Context) {
+	roomID, ok := h.requireGroupOwner(c)
+	if !ok {
+		return
+	}
+	var hooks []models.RoomIncomingWebhook
+	if err := h.db.Where("room_id = ?", roomID).Order("created_at ASC").Find(&hooks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询回调失败"})
+		return
+	}
+	out := make([]IncomingWebhookDTO, 0, len(hooks))
+	for _, w := range hooks {
+		out = append(out, toIncomingWebhookDTO(w))
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": out})
+}
+
+// DeleteIncomingWebhook 删除接入回调，令牌立即失效；机器人账号保留以便历史消息展示发送者
+func (h *WebhookHandler) DeleteIncomingWebhook(c *gin.Context) {
+	roomID, ok := h.requireGroupOwner(c)
+	if !ok {
+		return
+	}
+	res := h.db.Where("id = ? AND room_id = ?", c.Param("webhook_id"), roomID).Delete(&models.RoomIncomingWebhook{})
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除回调失败"})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "回调不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "回调已删除"})
+}
+
+type IncomingAttachmentField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short,omitempty"`
+}
+
+// IncomingAttachment 消息卡片附件，随消息保存在 payload_json.attachments 中
+type IncomingAttachment struct {
+	Title     string                    `json:"title,omitempty"`
+	TitleLink string                    `json:"title_link,omitempty"`
+	Text      string                    `json:"text,omitempty"`
+	ImageURL  string                    `json:"image_url,omitempty"`
+	Color     string                    `json:"color,omitempty"`
+	Fields    []IncomingAttachmentField `json:"fields,omitempty"`
+}
+
+// IncomingWebhookRequest text 与 markdown 二选一，markdown 优先
+type IncomingWebhookRequest struct {
+	Text        string               `json:"text"`
+	Markdown    string               `json:"markdown"`
+	Attachments []IncomingAttachment `json:"attachments,omitempty"`
+}
+
+func validateIncomingAttachments(items []IncomingAttachment) error {
+	if len(items) > maxIncomingAttachments {
+		return errors.New("附件数量过多")
+	}
+	for _, a := range items {
+		if utf8.RuneCountInString(a.Title) > 256 || utf8.RuneCountInString(a.Text) > maxIncomingAttachmentText {
+			return errors.New("附件内容过长")
+		}
+		for _, link := range []string{a.TitleLink, a.ImageURL} {
+			if link == "" {
+				continue
+			}
+			u, err := url.Parse(link)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return errors.New("附件链接无效")
+			}
+		}
+		if a.Color != "" && !attachmentColorPattern.MatchString(a.Color) {
+			return errors.New("附件颜色无效")
+		}
+		if len(a.Fields) > maxIncomingFields {
+			return errors.New("附件字段过多")
+		}
+		for _, f := range a.Fields {
+			if utf8.RuneCountInString(f.Title) > 256 || utf8.RuneCountInString(f.Value) > maxIncomingAttachmentText {
+				return errors.New("附件字段过长")
+			}
+		}
+	}
+	return nil
+}
+
+// PostIncomingWebhook 接入回调发送消息：令牌即凭据，无需登录；消息以回调的机器人账号发送到所属群
+func (h *WsHandler) PostIncomingWebhook(c *gin.Context) {
+	raw := strings.TrimSpace(c.Param("token"))
+	if !strings.HasPrefix(raw, webhooksvc.IncomingTokenPrefix) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "回调不存在"})
+		return
+	}
+	var hook models.RoomIncomingWebhook
+	if err := h.db.Where("token_hash = ? AND active = ?", auth.HashVerificationToken(raw), true).First(&hook).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "回调不存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询回调失败"})
+		return
+	}
+	var req IncomingWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求体必须为 JSON"})
+		return
+	}
+	text := strings.TrimSpace(req.Text)
+	format := "text"
+	if md := strings.TrimSpace(req.Markdown); md != "" {
+		text = md
+		format = "markdown"
+	}
+	if text == "" || utf8.RuneCountInString(text) > messageMaxRunes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "消息内容为空或过长"})
+		return
+	}
+	if err := validateIncomingAttachments(req.Attachments); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var room models.Room
+	if err := h.db.Select("id").Where("id = ? AND room_type = ?", hook.RoomID, models.RoomTypeGroup).First(&room).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusGone, gin.H{"error": "群已解散"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询群信息失败"})
+		return
+	}
+
+	payload := map[string]interface{}{
+		"format":           format,
+		"incoming_webhook": gin.H{"id": hook.ID, "name": hook.Name},
+	}
+	if len(req.Attachments) > 0 {
+		payload["attachments"] = req.Attachments
+	}
+	savedMsg, err := h.postGroupTextMessage(room.ID, hook.BotUserID, text, payload, "", nil)
+	if err != nil && savedMsg == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "发送消息失败"})
+		return
+	}
+	if now := time.Now(); hook.LastUsedAt == nil || now.Sub(*hook.LastUsedAt) > apiTokenTouchInterval {
+		h.db.Model(&models.RoomIncomingWebhook{}).Where("id = ?", hook.ID).Update("last_used_at", now)
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"id":          savedMsg.ID,
+		"room_id":     room.ID,
+		"sequence_id": savedMsg.SequenceID,
+		"timestamp":   savedMsg.CreatedAt.Unix(),
+	})
+}
@@ -0,0 +1,273 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+
+	"ququchat/internal/models"
+)
+
+type MessageUpdatedEvent struct {
+	Type       string `json:"type"`
+	ID         string `json:"id"`
+	RoomID     string `json:"room_id"`
+	SequenceID int64  `json:"sequence_id"`
+	FromUser   string `json:"from_user_id"`
+	Content    string `json:"content"`
+	EditedAt   int64  `json:"edited_at"`
+}
+
+// 编辑/撤回失败时 error 帧的错误码
+const (
+	msgOpCodeNotFound      = "message_not_found"
+	msgOpCodeForbidden     = "forbidden"
+	msgOpCodeWindowExpired = "recall_window_expired"
+)
+
+type MessageRecalledEvent struct {
+	Type       string `json:"type"`
+	ID         string `json:"id"`
+	RoomID     string `json:"room_id"`
+	SequenceID int64  `json:"sequence_id"`
+	FromUser   string `json:"from_user_id,omitempty"`
+	RecalledBy string `json:"recalled_by"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
+// rejectMessageOp 编辑/撤回/表情操作失败时回复 error 帧，附带目标消息 ID
+func (c *Client) rejectMessageOp(messageID, code, message string) {
+	b, err := newFrame(WsErrorFrame{
+		Type:      "error",
+		Code:      code,
+		Message:   message,
+		MessageID: messageID,
+	})
+	if err != nil {
+		return
+	}
+	c.enqueue(b)
+}
+
+// loadMessageForOp 加载编辑/撤回/表情操作的目标消息，失败时已回复 error 帧
+func (h *WsHandler) loadMessageForOp(c *Client, messageID string) (*models.Message, bool) {
+	var msg models.Message
+	if err := h.db.Where("id = ?", messageID).First(&msg).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.rejectMessageOp(messageID, msgOpCodeNotFound, "消息不存在或已撤回")
+		} else {
+			log.Printf("ws load message failed user=%s message=%s err=%v", c.userID, messageID, err)
+			c.rejectMessageOp(messageID, ackCodeInternal, "查询消息失败")
+		}
+		return nil, false
+	}
+	return &msg, true
+}
+
+// handleEditMessage 处理 edit_message 帧：仅发送者可编辑自己的文本消息
+func (h *WsHandler) handleEditMessage(c *Client, messageID, content string) {
+	msg, ok := h.loadMessageForOp(c, messageID)
+	if !ok {
+		return
+	}
+	if msg.SenderID == nil || *msg.SenderID != c.userID || msg.ContentType != models.ContentTypeText {
+		c.rejectMessageOp(msg.ID, msgOpCodeForbidden, "只能编辑自己发送的文本消息")
+		return
+	}
+	// 指令消息已触发任务，编辑无意义
+	if strings.HasPrefix(strings.TrimSpace(content), "\\") {
+		c.rejectMessageOp(msg.ID, ackCodeInvalidRequest, "不能编辑为指令消息")
+		return
+	}
+	if utf8.RuneCountInString(content) > messageMaxRunes {
+		c.rejectMessageOp(msg.ID, ackCodeTooLarge, "消息内容过长")
+		return
+	}
+	room, peerID, ok := h.loadRoomForSender(c.userID, msg.RoomID)
+	if !ok {
+		c.rejectMessageOp(msg.ID, msgOpCodeForbidden, "当前无法在该会话中编辑消息")
+		return
+	}
+	now := time.Now()
+	if err := h.db.Model(&models.Message{}).Where("id = ?", msg.ID).Updates(map[string]interface{}{
+		"content_text": content,
+		"edited_at":    now,
+		"updated_at":   now,
+	}).Error; err != nil {
+		log.Printf("ws edit message failed user=%s message=%s err=%v", c.userID, msg.ID, err)
+		c.rejectMessageOp(msg.ID, ackCodeInternal, "编辑消息失败")
+		return
+	}
+	h.refreshSegments(msg.RoomID, msg.SequenceID)
+	h.invalidateRoomConversations(msg.RoomID)
+
+	out := MessageUpdatedEvent{
+		Type:       "message_updated",
+		ID:         msg.ID,
+		RoomID:     msg.RoomID,
+		SequenceID: msg.SequenceID,
+		FromUser:   c.userID,
+		Content:    content,
+		EditedAt:   now.Unix(),
+	}
+	b, err := newFrame(out)
+	if err != nil {
+		return
+	}
+	h.routeRoomEvent(c, room, peerID, b)
+}
+
+// handleRecallMessage 处理 recall_message 帧：
+// 发送者可在撤回窗口内撤回自己的消息；群主/管理员可随时撤回群内权限低于自己的成员消息
+func (h *WsHandler) handleRecallMessage(c *Client, messageID string) {
+	msg, ok := h.loadMessageForOp(c, messageID)
+	if !ok {
+		return
+	}
+	var room models.Room
+	if err := h.db.Select("id", "room_type", "name").Where("id = ?", msg.RoomID).First(&room).Error; err != nil {
+		c.rejectMessageOp(msg.ID, ackCodeInternal, "查询会话失败")
+		return
+	}
+	senderID := ""
+	if msg.SenderID != nil {
+		senderID = *msg.SenderID
+	}
+	peerID := ""
+	isSender := senderID == c.userID
+	switch room.RoomType {
+	case models.RoomTypeDirect:
+		var ok bool
+		peerID, ok = directRoomPeer(room.Name, c.userID)
+		if !ok || !isSender {
+			c.rejectMessageOp(msg.ID, msgOpCodeForbidden, "只能撤回自己发送的消息")
+			return
+		}
+		if time.Since(msg.CreatedAt) > h.recallWindow {
+			c.rejectMessageOp(msg.ID, msgOpCodeWindowExpired, "消息已超过可撤回时间")
+			return
+		}
+	case models.RoomTypeGroup:
+		var operator models.RoomMember
+		if err := h.db.Where("room_id = ? AND user_id = ? AND left_at IS NULL", room.ID, c.userID).First(&operator).Error; err != nil {
+			c.rejectMessageOp(msg.ID, ackCodeNotMember, "不是群成员")
+			return
+		}
+		if !(isSender && time.Since(msg.CreatedAt) <= h.recallWindow) && !h.canModerateMessage(room.ID, operator.Role, senderID) {
+			if isSender {
+				c.rejectMessageOp(msg.ID, msgOpCodeWindowExpired, "消息已超过可撤回时间")
+			} else {
+				c.rejectMessageOp(msg.ID, msgOpCodeForbidden, "无权撤回该消息")
+			}
+			return
+		}
+	default:
+		c.rejectMessageOp(msg.ID, msgOpCodeForbidden, "无权撤回该消息")
+		return
+	}
+
+	// 撤回的消息同时取消置顶
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&models.Message{}, "id = ?", msg.ID).Error; err != nil {
+			return err
+		}
+		return tx.Where("message_id = ?", msg.ID).Delete(&models.PinnedMessage{}).Error
+	}); err != nil {
+		log.Printf("ws recall message failed user=%s message=%s err=%v", c.userID, msg.ID, err)
+		c.rejectMessageOp(msg.ID, ackCodeInternal, "撤回消息失败")
+		return
+	}
+	h.refreshSegments(msg.RoomID, msg.SequenceID)
+	h.invalidateRoomConversations(msg.RoomID)
+
+	out := MessageRecalledEvent{
+		Type:       "message_recalled",
+		ID:         msg.ID,
+		RoomID:     msg.RoomID,
+		SequenceID: msg.SequenceID,
+		FromUser:   senderID,
+		RecalledBy: c.userID,
+		Timestamp:  time.Now().Unix(),
+	}
+	b, err := newFrame(out)
+	if err != nil {
+		return
+	}
+	h.routeRoomEvent(c, &room, peerID, b)
+}
+
+// canModerateMessage 群主可撤回任意消息，管理员只能撤回普通成员及机器人的消息
+func (h *WsHandler) canModerateMessage(roomID string, operatorRole models.MemberRole, senderID string) bool {
+	switch operatorRole {
+	case models.MemberRoleOwner:
+		return true
+	case models.MemberRoleAdmin:
+		if senderID == "" {
+			return true
+		}
+		var sender models.RoomMember
+		if err := h.db.Select("role").Where("room_id = ? AND user_id = ?", roomID, senderID).First(&sender).Error; err != nil {
+			return err == gorm.ErrRecordNotFound
+		}
+		return sender.Role == models.MemberRoleMember
+	default:
+		return false
+	}
+}
+
+// loadRoomForSender 校验用户当前仍可在房间内发言，私聊时同时返回对端用户 ID
+func (h *WsHandler) loadRoomForSender(userID, roomID string) (*models.Room, string, bool) {
+	var room models.Room
+	if err := h.db.Select("id", "room_type", "name").Where("id = ?", roomID).First(&room).Error; err != nil {
+		return nil, "", false
+	}
+	switch room.RoomType {
+	case models.RoomTypeGroup:
+		if err := h.checkGroupPostingPermission(room.ID, userID); err != nil {
+			return nil, "", false
+		}
+		return &room, "", true
+	case models.RoomTypeDirect:
+		peerID, ok := directRoomPeer(room.Name, userID)
+		if !ok || !h.areFriends(userID, peerID) {
+			return nil, "", false
+		}
+		return &room, peerID, true
+	default:
+		return nil, "", false
+	}
+}
+
+// routeRoomEvent 将房间事件投递给私聊双方或群内全部成员
+func (h *WsHandler) routeRoomEvent(c *Client, room *models.Room, peerID string, f *wsFrame) {
+	if room.RoomType == models.RoomTypeDirect {
+		c.routeDirect(c.userID, peerID, f)
+		return
+	}
+	memberIDs, err := h.getGroupMemberIDs(room.ID)
+	if err != nil {
+		return
+	}
+	c.routeBroadcast(room.ID, memberIDs, f)
+}
+
+// refreshSegments 将覆盖该消息的检索分段标记为过期（召回时跳过旧内容），并提交索引任务按当前内容重建
+func (h *WsHandler) refreshSegments(roomID string, seq int64) {
+	res := h.db.Model(&models.ChatSegment{}).
+		Where("room_id = ? AND start_seq <= ? AND end_seq >= ?", roomID, seq, seq).
+		Update("stale", true)
+	if res.Error != nil {
+		log.Printf("mark chat segments stale failed room=%s seq=%d err=%v", roomID, seq, res.Error)
+		return
+	}
+	if res.RowsAffected == 0 || h.taskService == nil {
+		return
+	}
+	if _, err := h.taskService.SubmitRAGRefresh(roomID); err != nil {
+		log.Printf("submit rag refresh failed room=%s seq=%d err=%v", roomID, seq, err)
+	}
+}
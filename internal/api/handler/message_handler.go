@@ -16,15 +16,17 @@ import (
 type MessageHandler struct {
 	db           *gorm.DB
 	historyLimit int
+	dbDriver     string
 }
 
-func NewMessageHandler(db *gorm.DB, historyLimit int) *MessageHandler {
+func NewMessageHandler(db *gorm.DB, historyLimit int, dbDriver string) *MessageHandler {
 	if historyLimit <= 0 {
 		historyLimit = 50
 	}
 	return &MessageHandler{
 		db:           db,
 		historyLimit: historyLimit,
+		dbDriver:     dbDriver,
 	}
 }
 
@@ -34,17 +36,57 @@ type HistoryRequest struct {
 }
 
 type MessageDTO struct {
-	ID               string          `json:"id"`
-	RoomID           string          `json:"room_id"`
-	SequenceID       int64           `json:"sequence_id"`
-	SenderID         string          `json:"sender_id,omitempty"`
-	ContentType      string          `json:"content_type"`
-	ContentText      string          `json:"content_text,omitempty"`
-	AttachmentID     string          `json:"attachment_id,omitempty"`
-	ParentMessageID  string          `json:"parent_message_id,omitempty"`
-	ParentSequenceID *int64          `json:"parent_sequence_id,omitempty"`
-	PayloadJSON      json.RawMessage `json:"payload_json,omitempty"`
-	CreatedAt        int64           `json:"created_at"`
+	ID               string             `json:"id"`
+	RoomID           string             `json:"room_id"`
+	SequenceID       int64              `json:"sequence_id"`
+	SenderID         string             `json:"sender_id,omitempty"`
+	ContentType      string             `json:"content_type"`
+	ContentText      string             `json:"content_text,omitempty"`
+	AttachmentID     string             `json:"attachment_id,omitempty"`
+	ParentMessageID  string             `json:"parent_message_id,omitempty"`
+	ParentSequenceID *int64             `json:"parent_sequence_id,omitempty"`
+	PayloadJSON      json.RawMessage    `json:"payload_json,omitempty"`
+	Reactions        []ReactionCountDTO `json:"reactions,omitempty"`
+	Recalled         bool               `json:"recalled,omitempty"`
+	RecalledAt       int64              `json:"recalled_at,omitempty"`
+	EditedAt         int64              `json:"edited_at,omitempty"`
+	CreatedAt        int64              `json:"created_at"`
+}
+
+// toMessageDTO 转换消息；已撤回的消息以墓碑形式返回，不携带内容
+func toMessageDTO(m models.Message) MessageDTO {
+	dto := MessageDTO{
+		ID:          m.ID,
+		RoomID:      m.RoomID,
+		SequenceID:  m.SequenceID,
+		ContentType: string(m.ContentType),
+		CreatedAt:   m.CreatedAt.Unix(),
+	}
+	if m.SenderID != nil {
+		dto.SenderID = *m.SenderID
+	}
+	if m.ParentMessageID != nil {
+		dto.ParentMessageID = *m.ParentMessageID
+	}
+	dto.ParentSequenceID = m.ParentSequenceID
+	if m.DeletedAt.Valid {
+		dto.Recalled = true
+		dto.RecalledAt = m.DeletedAt.Time.Unix()
+		return dto
+	}
+	if m.ContentText != nil {
+		dto.ContentText = *m.ContentText
+	}
+	if m.AttachmentID != nil {
+		dto.AttachmentID = *m.AttachmentID
+	}
+	if len(m.PayloadJSON) > 0 {
+		dto.PayloadJSON = json.RawMessage(m.PayloadJSON)
+	}
+	if m.EditedAt != nil {
+		dto.EditedAt = m.EditedAt.Unix()
+	}
+	return dto
 }
 
 func (h *MessageHandler) GetHistoryBefore(c *gin.Context) {
@@ -126,7 +168,7 @@ func (h *MessageHandler) GetHistoryBefore(c *gin.Context) {
 
 	// 3. 加载参照消息 (Cursor)
 	var target models.Message
-	if err := h.db.Where("id = ?", req.MessageID).First(&target).Error; err != nil {
+	if err := h.db.Unscoped().Where("id = ?", req.MessageID).First(&target).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "参照消息不存在"})
 			return
@@ -143,7 +185,7 @@ func (h *MessageHandler) GetHistoryBefore(c *gin.Context) {
 
 	// 5. 执行查询
 	// 优化：改用 sequence_id 进行范围查询，避免时间戳重复或回拨导致的问题
-	query := h.db.Where("room_id = ? AND sequence_id < ?", room.ID, target.SequenceID)
+	query := h.db.Unscoped().Where("room_id = ? AND sequence_id < ?", room.ID, target.SequenceID)
 	if memberLeftAt != nil {
 		query = query.Where("created_at < ?", memberLeftAt)
 	}
@@ -166,31 +208,9 @@ func (h *MessageHandler) GetHistoryBefore(c *gin.Context) {
 	})
 	result := make([]MessageDTO, 0, len(list))
 	for _, m := range list {
-		dto := MessageDTO{
-			ID:          m.ID,
-			RoomID:      m.RoomID,
-			SequenceID:  m.SequenceID,
-			ContentType: string(m.ContentType),
-			CreatedAt:   m.CreatedAt.Unix(),
-		}
-		if m.SenderID != nil {
-			dto.SenderID = *m.SenderID
-		}
-		if m.ContentText != nil {
-			dto.ContentText = *m.ContentText
-		}
-		if m.AttachmentID != nil {
-			dto.AttachmentID = *m.AttachmentID
-		}
-		if m.ParentMessageID != nil {
-			dto.ParentMessageID = *m.ParentMessageID
-		}
-		dto.ParentSequenceID = m.ParentSequenceID
-		if len(m.PayloadJSON) > 0 {
-			dto.PayloadJSON = json.RawMessage(m.PayloadJSON)
-		}
-		result = append(result, dto)
+		result = append(result, toMessageDTO(m))
 	}
+	h.attachReactions(userID, result)
 	c.JSON(http.StatusOK, gin.H{"messages": result})
 }
 
@@ -249,7 +269,7 @@ func (h *MessageHandler) GetHistoryAfter(c *gin.Context) {
 	}
 
 	// 3. 执行查询
-	query := h.db.Where("room_id = ? AND sequence_id > ?", room.ID, req.AfterSequenceID)
+	query := h.db.Unscoped().Where("room_id = ? AND sequence_id > ?", room.ID, req.AfterSequenceID)
 	if memberLeftAt != nil {
 		query = query.Where("created_at < ?", memberLeftAt)
 	}
@@ -270,31 +290,9 @@ func (h *MessageHandler) GetHistoryAfter(c *gin.Context) {
 
 	result := make([]MessageDTO, 0, len(list))
 	for _, m := range list {
-		dto := MessageDTO{
-			ID:          m.ID,
-			RoomID:      m.RoomID,
-			SequenceID:  m.SequenceID,
-			ContentType: string(m.ContentType),
-			CreatedAt:   m.CreatedAt.Unix(),
-		}
-		if m.SenderID != nil {
-			dto.SenderID = *m.SenderID
-		}
-		if m.ContentText != nil {
-			dto.ContentText = *m.ContentText
-		}
-		if m.AttachmentID != nil {
-			dto.AttachmentID = *m.AttachmentID
-		}
-		if m.ParentMessageID != nil {
-			dto.ParentMessageID = *m.ParentMessageID
-		}
-		dto.ParentSequenceID = m.ParentSequenceID
-		if len(m.PayloadJSON) > 0 {
-			dto.PayloadJSON = json.RawMessage(m.PayloadJSON)
-		}
-		result = append(result, dto)
+		result = append(result, toMessageDTO(m))
 	}
+	h.attachReactions(userID, result)
 	c.JSON(http.StatusOK, gin.H{"messages": result})
 }
 
@@ -334,7 +332,7 @@ func (h *MessageHandler) GetLatestByGroup(c *gin.Context) {
 		return
 	}
 
-	query := h.db.Where("room_id = ?", room.ID)
+	query := h.db.Unscoped().Where("room_id = ?", room.ID)
 	if member.LeftAt != nil {
 		query = query.Where("created_at < ?", member.LeftAt)
 	}
@@ -359,32 +357,10 @@ func (h *MessageHandler) GetLatestByGroup(c *gin.Context) {
 
 	result := make([]MessageDTO, 0, len(list))
 	for _, m := range list {
-		dto := MessageDTO{
-			ID:          m.ID,
-			RoomID:      m.RoomID,
-			SequenceID:  m.SequenceID,
-			ContentType: string(m.ContentType),
-			CreatedAt:   m.CreatedAt.Unix(),
-		}
-		if m.SenderID != nil {
-			dto.SenderID = *m.SenderID
-		}
-		if m.ContentText != nil {
-			dto.ContentText = *m.ContentText
-		}
-		if m.AttachmentID != nil {
-			dto.AttachmentID = *m.AttachmentID
-		}
-		if m.ParentMessageID != nil {
-			dto.ParentMessageID = *m.ParentMessageID
-		}
-		dto.ParentSequenceID = m.ParentSequenceID
-		if len(m.PayloadJSON) > 0 {
-			dto.PayloadJSON = json.RawMessage(m.PayloadJSON)
-		}
-		result = append(result, dto)
+		result = append(result, toMessageDTO(m))
 	}
 
+	h.attachReactions(userID, result)
 	c.JSON(http.StatusOK, gin.H{"messages": result})
 }
 
@@ -435,7 +411,7 @@ func (h *MessageHandler) GetLatestByFriend(c *gin.Context) {
 		return
 	}
 	var list []models.Message
-	if err := h.db.
+	if err := h.db.Unscoped().
 		Where("room_id = ?", room.ID).
 		Order("sequence_id desc").
 		Limit(h.historyLimit).
@@ -452,30 +428,8 @@ func (h *MessageHandler) GetLatestByFriend(c *gin.Context) {
 	})
 	result := make([]MessageDTO, 0, len(list))
 	for _, m := range list {
-		dto := MessageDTO{
-			ID:          m.ID,
-			RoomID:      m.RoomID,
-			SequenceID:  m.SequenceID,
-			ContentType: string(m.ContentType),
-			CreatedAt:   m.CreatedAt.Unix(),
-		}
-		if m.SenderID != nil {
-			dto.SenderID = *m.SenderID
-		}
-		if m.ContentText != nil {
-			dto.ContentText = *m.ContentText
-		}
-		if m.AttachmentID != nil {
-			dto.AttachmentID = *m.AttachmentID
-		}
-		if m.ParentMessageID != nil {
-			dto.ParentMessageID = *m.ParentMessageID
-		}
-		dto.ParentSequenceID = m.ParentSequenceID
-		if len(m.PayloadJSON) > 0 {
-			dto.PayloadJSON = json.RawMessage(m.PayloadJSON)
-		}
-		result = append(result, dto)
+		result = append(result, toMessageDTO(m))
 	}
+	h.attachReactions(userID, result)
 	c.JSON(http.StatusOK, gin.H{"messages": result})
 }
@@ -0,0 +1,259 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"ququchat/internal/config"
+	"ququchat/internal/models"
+	"ququchat/internal/server/auth"
+	"ququchat/internal/server/oidc"
+)
+
+// oidcStateCookie 保存 OIDC 登录状态令牌的 Cookie，仅在 /api/auth/oidc 路径下发送
+const (
+	oidcStateCookie     = "oidc_state"
+	oidcStateCookiePath = "/api/auth/oidc"
+)
+
+var usernameUnsafeChars = regexp.MustCompile(`[^A-Za-z0-9_.\-]+`)
+
+type oidcProvider struct {
+	client        *oidc.Provider
+	autoProvision bool
+}
+
+func newOIDCProviders(settings map[string]config.OIDCProviderSettings) map[string]*oidcProvider {
+	out := make(map[string]*oidcProvider, len(settings))
+	for name, s := range settings {
+		out[name] = &oidcProvider{client: oidc.NewProvider(s.OIDC), autoProvision: s.AutoProvision}
+	}
+	return out
+}
+
+// OIDCLogin 发起 OIDC 授权码 + PKCE 登录：state/nonce/code_verifier 写入签名 Cookie 后跳转到 IdP
+// format=json 时返回授权地址而不跳转，便于前端自行打开
+func (h *AuthHandler) OIDCLogin(c *gin.Context) {
+	name := c.Param("provider")
+	p, ok := h.oidc[name]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未配置该登录方式"})
+		return
+	}
+	state, err1 := oidc.RandomString(24)
+	nonce, err2 := oidc.RandomString(24)
+	verifier, err3 := oidc.RandomString(32)
+	if err1 != nil || err2 != nil || err3 != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成登录状态失败"})
+		return
+	}
+	authURL, err := p.client.AuthCodeURL(c.Request.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("oidc discovery failed provider=%s err=%v", name, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "身份提供方不可用"})
+		return
+	}
+	stateToken, err := auth.SignOIDCState(h.keys, name, state, nonce, verifier, auth.DefaultOIDCStateTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "签发登录状态失败"})
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, stateToken, int(auth.DefaultOIDCStateTTL.Seconds()), oidcStateCookiePath, "", false, true)
+
+	if c.Query("format") == "json" {
+		c.JSON(http.StatusOK, gin.H{"authorizationUrl": authURL})
+		return
+	}
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback IdP 回调：校验 state，换取并校验 ID Token，关联或创建本地用户后按密码登录流程签发令牌
+func (h *AuthHandler) OIDCCallback(c *gin.Context) {
+	name := c.Param("provider")
+	p, ok := h.oidc[name]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未配置该登录方式"})
+		return
+	}
+	if e := c.Query("error"); e != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "身份提供方拒绝授权: " + e})
+		return
+	}
+	code := c.Query("code")
+	if code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少授权码"})
+		return
+	}
+
+	// 状态令牌一次性使用
+	raw, err := c.Cookie(oidcStateCookie)
+	c.SetCookie(oidcStateCookie, "", -1, oidcStateCookiePath, "", false, true)
+	if err != nil || raw == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "登录状态已失效，请重新发起登录"})
+		return
+	}
+	st, err := auth.ParseOIDCState(raw, h.keys)
+	if err != nil || st.Provider != name ||
+		subtle.ConstantTimeCompare([]byte(st.State), []byte(c.Query("state"))) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "登录状态校验失败，请重新发起登录"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tok, err := p.client.Exchange(ctx, code, st.CodeVerifier)
+	if err != nil {
+		log.Printf("oidc code exchange failed provider=%s err=%v", name, err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "授权码无效或已过期"})
+		return
+	}
+	claims, err := p.client.VerifyIDToken(ctx, tok.IDToken, st.Nonce)
+	if err != nil {
+		log.Printf("oidc id_token rejected provider=%s err=%v", name, err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "身份令牌校验失败"})
+		return
+	}
+
+	u, err := h.resolveOIDCUser(name, p.autoProvision, claims)
+	if err != nil {
+		if errors.Is(err, errIdentityNotLinked) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "该外部账号未关联本地用户"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "关联外部账号失败"})
+		return
+	}
+	// 本地启用了二次验证时仍需完成挑战
+	h.completeLogin(c, u)
+}
+
+var errIdentityNotLinked = errors.New("identity not linked")
+
+// resolveOIDCUser 按 (provider, sub) 查找已关联用户；未关联且允许自动创建时新建用户与关联记录
+// 不按邮箱自动关联已有账号，避免通过 IdP 上的同名邮箱接管本地账号
+func (h *AuthHandler) resolveOIDCUser(provider string, autoProvision bool, claims *oidc.IDTokenClaims) (*models.User, error) {
+	now := time.Now()
+	var identity models.UserIdentity
+	err := h.db.Where("provider = ? AND subject = ?", provider, claims.Subject).First(&identity).Error
+	if err == nil {
+		var u models.User
+		if err := h.db.Where("id = ?", identity.UserID).First(&u).Error; err != nil {
+			return nil, err
+		}
+		h.db.Model(&identity).Update("last_login_at", &now)
+		return &u, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if !autoProvision {
+		return nil, errIdentityNotLinked
+	}
+
+	// 外部账号无本地密码：写入随机密码哈希，仅能通过 OIDC 登录
+	randomPassword, err := oidc.RandomString(32)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(randomPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	var email *string
+	if e := strings.TrimSpace(claims.Email); e != "" && claims.EmailVerified {
+		var count int64
+		if err := h.db.Model(&models.User{}).Where("email = ?", e).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			email = &e
+		}
+	}
+	var displayName *string
+	if n := strings.TrimSpace(claims.Name); n != "" {
+		if len([]rune(n)) > 64 {
+			n = string([]rune(n)[:64])
+		}
+		displayName = &n
+	}
+	base := oidcUsernameBase(claims)
+
+	var u models.User
+	for attempt := 0; attempt < 5; attempt++ {
+		username := base
+		if attempt > 0 {
+			username = base + "_" + uuid.NewString()[:6]
+		}
+		u = models.User{
+			ID:           uuid.NewString(),
+			Username:     username,
+			Email:        email,
+			PasswordHash: string(hash),
+			Status:       "offline",
+			DisplayName:  displayName,
+		}
+		// IdP 已验证邮箱
+		if email != nil {
+			u.EmailVerifiedAt = &now
+		}
+		identity = models.UserIdentity{
+			ID:          uuid.NewString(),
+			UserID:      u.ID,
+			Provider:    provider,
+			Subject:     claims.Subject,
+			LastLoginAt: &now,
+		}
+		if e := strings.TrimSpace(claims.Email); e != "" {
+			identity.Email = &e
+		}
+		err = h.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&u).Error; err != nil {
+				return err
+			}
+			return tx.Create(&identity).Error
+		})
+		if err == nil {
+			return &u, nil
+		}
+		if !isDuplicateKeyErr(err) {
+			return nil, err
+		}
+		// 并发首次登录已创建关联时直接使用
+		var existing models.UserIdentity
+		if h.db.Where("provider = ? AND subject = ?", provider, claims.Subject).First(&existing).Error == nil {
+			if err := h.db.Where("id = ?", existing.UserID).First(&u).Error; err != nil {
+				return nil, err
+			}
+			return &u, nil
+		}
+	}
+	return nil, err
+}
+
+// oidcUsernameBase 依次取 preferred_username、邮箱前缀作为用户名，冲突时由调用方追加随机后缀
+func oidcUsernameBase(claims *oidc.IDTokenClaims) string {
+	candidate := strings.TrimSpace(claims.PreferredUsername)
+	if candidate == "" {
+		if at := strings.Index(claims.Email, "@"); at > 0 {
+			candidate = claims.Email[:at]
+		}
+	}
+	candidate = usernameUnsafeChars.ReplaceAllString(candidate, "")
+	if candidate == "" {
+		candidate = "user"
+	}
+	if len(candidate) > 48 {
+		candidate = candidate[:48]
+	}
+	return candidate
+}
@@ -0,0 +1,321 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"ququchat/internal/models"
+)
+
+const (
+	// 单个房间最多置顶的消息条数
+	maxPinnedMessagesPerRoom = 50
+	starredListLimit         = 200
+)
+
+type PinHandler struct {
+	db     *gorm.DB
+	hub    *Hub
+	router *HubRouter
+}
+
+func NewPinHandler(db *gorm.DB, hub *Hub, router *HubRouter) *PinHandler {
+	return &PinHandler{db: db, hub: hub, router: router}
+}
+
+type MessageRefRequest struct {
+	MessageID string `json:"message_id" binding:"required"`
+}
+
+type ListPinsRequest struct {
+	RoomID string `form:"room_id" binding:"required"`
+}
+
+type PinEvent struct {
+	Type       string `json:"type"`
+	Action     string `json:"action"`
+	RoomID     string `json:"room_id"`
+	MessageID  string `json:"message_id"`
+	SequenceID int64  `json:"sequence_id"`
+	UserID     string `json:"user_id"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
+// broadcastToRoomMembers 经由跨节点路由（未启用时直接走本地 Hub）向房间内指定用户投递
+func broadcastToRoomMembers(hub *Hub, router *HubRouter, roomID string, userIDs []string, f *wsFrame) {
+	if router != nil {
+		router.RouteBroadcast(context.Background(), roomID, userIDs, f)
+		return
+	}
+	if hub != nil {
+		hub.sendBroadcast(GroupMessage{RoomID: roomID, UserIDs: userIDs, Frame: f})
+	}
+}
+
+// loadVisibleMessage 加载消息并校验当前用户是房间成员且可见该消息（退群后仅可见退群前的消息）
+func (h *PinHandler) loadVisibleMessage(c *gin.Context, userID, messageID string) (*models.Message, *models.RoomMember, bool) {
+	var msg models.Message
+	if err := h.db.Where("id = ?", messageID).First(&msg).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "消息不存在"})
+			return nil, nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询消息失败"})
+		return nil, nil, false
+	}
+	var member models.RoomMember
+	if err := h.db.Where("room_id = ? AND user_id = ?", msg.RoomID, userID).First(&member).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "您不是该房间成员"})
+			return nil, nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询成员关系失败"})
+		return nil, nil, false
+	}
+	if member.LeftAt != nil && !msg.CreatedAt.Before(*member.LeftAt) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权查看该消息"})
+		return nil, nil, false
+	}
+	return &msg, &member, true
+}
+
+// canPin 群聊仅群主/管理员可置顶，私聊双方均可
+func (h *PinHandler) canPin(c *gin.Context, roomID string, member *models.RoomMember) bool {
+	if member.LeftAt != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "您已不在该房间"})
+		return false
+	}
+	var room models.Room
+	if err := h.db.Select("id", "room_type").Where("id = ?", roomID).First(&room).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "房间不存在"})
+		return false
+	}
+	if room.RoomType == models.RoomTypeGroup &&
+		member.Role != models.MemberRoleOwner && member.Role != models.MemberRoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只有群主或管理员可以置顶消息"})
+		return false
+	}
+	return true
+}
+
+func (h *PinHandler) broadcastPinUpdated(action string, msg *models.Message, userID string) {
+	var memberIDs []string
+	if err := h.db.Model(&models.RoomMember{}).
+		Where("room_id = ? AND left_at IS NULL", msg.RoomID).
+		Pluck("user_id", &memberIDs).Error; err != nil || len(memberIDs) == 0 {
+		return
+	}
+	b, err := newFrame(PinEvent{
+		Type:       "pin_updated",
+		Action:     action,
+		RoomID:     msg.RoomID,
+		MessageID:  msg.ID,
+		SequenceID: msg.SequenceID,
+		UserID:     userID,
+		Timestamp:  time.Now().Unix(),
+	})
+	if err != nil {
+		return
+	}
+	broadcastToRoomMembers(h.hub, h.router, msg.RoomID, memberIDs, b)
+}
+
+func (h *PinHandler) PinMessage(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var req MessageRefRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: 缺少 message_id"})
+		return
+	}
+	msg, member, ok := h.loadVisibleMessage(c, userID, req.MessageID)
+	if !ok {
+		return
+	}
+	if !h.canPin(c, msg.RoomID, member) {
+		return
+	}
+	// 已撤回消息的置顶不显示，也不占用上限
+	var count int64
+	if err := h.db.Table("pinned_messages AS p").
+		Joins("JOIN messages m ON m.id = p.message_id AND m.deleted_at IS NULL").
+		Where("p.room_id = ?", msg.RoomID).
+		Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询置顶消息失败"})
+		return
+	}
+	if count >= maxPinnedMessagesPerRoom {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "置顶消息数量已达上限"})
+		return
+	}
+
+	pin := models.PinnedMessage{
+		ID:        uuid.NewString(),
+		RoomID:    msg.RoomID,
+		MessageID: msg.ID,
+		PinnedBy:  userID,
+		CreatedAt: time.Now(),
+	}
+	result := h.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&pin)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "置顶失败"})
+		return
+	}
+	if result.RowsAffected > 0 {
+		h.broadcastPinUpdated("add", msg, userID)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已置顶", "room_id": msg.RoomID, "message_id": msg.ID})
+}
+
+func (h *PinHandler) UnpinMessage(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var req MessageRefRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: 缺少 message_id"})
+		return
+	}
+	msg, member, ok := h.loadVisibleMessage(c, userID, req.MessageID)
+	if !ok {
+		return
+	}
+	if !h.canPin(c, msg.RoomID, member) {
+		return
+	}
+	result := h.db.Where("room_id = ? AND message_id = ?", msg.RoomID, msg.ID).Delete(&models.PinnedMessage{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "取消置顶失败"})
+		return
+	}
+	if result.RowsAffected > 0 {
+		h.broadcastPinUpdated("remove", msg, userID)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已取消置顶"})
+}
+
+func (h *PinHandler) ListPins(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var req ListPinsRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: 缺少 room_id"})
+		return
+	}
+	var member models.RoomMember
+	if err := h.db.Where("room_id = ? AND user_id = ? AND left_at IS NULL", req.RoomID, userID).First(&member).Error; err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "您不是该房间成员"})
+		return
+	}
+
+	var pins []models.PinnedMessage
+	if err := h.db.Preload("Message").
+		Where("room_id = ?", req.RoomID).
+		Order("created_at DESC").
+		Find(&pins).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询置顶消息失败"})
+		return
+	}
+	resp := make([]gin.H, 0, len(pins))
+	for _, p := range pins {
+		// 已撤回的消息不再展示
+		if p.Message == nil {
+			continue
+		}
+		resp = append(resp, gin.H{
+			"message":   toMessageDTO(*p.Message),
+			"pinned_by": p.PinnedBy,
+			"pinned_at": p.CreatedAt.Unix(),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"pins": resp})
+}
+
+func (h *PinHandler) StarMessage(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var req MessageRefRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: 缺少 message_id"})
+		return
+	}
+	msg, _, ok := h.loadVisibleMessage(c, userID, req.MessageID)
+	if !ok {
+		return
+	}
+	star := models.StarredMessage{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		MessageID: msg.ID,
+		CreatedAt: time.Now(),
+	}
+	if err := h.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&star).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "收藏失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已收藏", "message_id": msg.ID})
+}
+
+func (h *PinHandler) UnstarMessage(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var req MessageRefRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: 缺少 message_id"})
+		return
+	}
+	if err := h.db.Where("user_id = ? AND message_id = ?", userID, req.MessageID).Delete(&models.StarredMessage{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "取消收藏失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已取消收藏"})
+}
+
+func (h *PinHandler) ListStars(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var stars []models.StarredMessage
+	if err := h.db.Preload("Message").
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Limit(starredListLimit).
+		Find(&stars).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询收藏失败"})
+		return
+	}
+	resp := make([]gin.H, 0, len(stars))
+	for _, s := range stars {
+		if s.Message == nil {
+			continue
+		}
+		resp = append(resp, gin.H{
+			"message":    toMessageDTO(*s.Message),
+			"starred_at": s.CreatedAt.Unix(),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"stars": resp})
+}
@@ -0,0 +1,369 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"ququchat/internal/models"
+	cachepkg "ququchat/internal/server/cache"
+)
+
+const (
+	presenceStatusTextMaxRunes  = 100
+	presenceStatusEmojiMaxRunes = 16
+	// wsHeartbeatInterval 同一连接续期 Redis 心跳的最小间隔，需小于 cachepkg.WSPresenceTTL
+	wsHeartbeatInterval = 30 * time.Second
+	// wsTypingMinInterval 同一连接转发“正在输入”的最小间隔，过密的帧直接丢弃
+	wsTypingMinInterval = time.Second
+)
+
+// PresenceEvent 在线状态变化，定向推送给未拉黑该用户的好友
+type PresenceEvent struct {
+	Type        string `json:"type"`
+	UserID      string `json:"user_id"`
+	Online      bool   `json:"online"`
+	LastSeenAt  int64  `json:"last_seen_at,omitempty"`
+	StatusText  string `json:"status_text,omitempty"`
+	StatusEmoji string `json:"status_emoji,omitempty"`
+}
+
+// TypingEvent 正在输入提示，只转发不落库
+type TypingEvent struct {
+	Type      string `json:"type"`
+	FromUser  string `json:"from_user_id"`
+	ToUser    string `json:"to_user_id,omitempty"`
+	RoomID    string `json:"room_id,omitempty"`
+	Typing    bool   `json:"typing"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// Presence 计算用户在线状态：启用 Redis 路由时以各节点心跳未过期的连接为准，否则以本节点连接为准
+type Presence struct {
+	db     *gorm.DB
+	hub    *Hub
+	router *HubRouter
+	cache  *cachepkg.RedisClient
+
+	mu    sync.Mutex
+	local map[string]int
+	// transitions 正在执行状态切换的用户；值为 true 表示执行期间又有连接变化，需要再执行一轮
+	transitions map[string]bool
+}
+
+func NewPresence(db *gorm.DB, hub *Hub, router *HubRouter, cache *cachepkg.RedisClient) *Presence {
+	return &Presence{
+		db:          db,
+		hub:         hub,
+		router:      router,
+		cache:       cache,
+		local:       make(map[string]int),
+		transitions: make(map[string]bool),
+	}
+}
+
+// Connected 连接注册后调用；用户在本节点的首个连接上线时通知好友
+func (p *Presence) Connected(userID string) {
+	p.mu.Lock()
+	p.local[userID]++
+	first := p.local[userID] == 1
+	p.mu.Unlock()
+	if first {
+		p.scheduleTransition(userID)
+	}
+}
+
+// Disconnected 连接注销后调用；用户在所有节点均无存活连接时记为离线
+func (p *Presence) Disconnected(userID string) {
+	p.mu.Lock()
+	p.local[userID]--
+	remaining := p.local[userID]
+	if remaining <= 0 {
+		delete(p.local, userID)
+	}
+	p.mu.Unlock()
+	// 启用 Redis 路由时其他节点可能仍有连接，由 transition 统计全部存活连接后决定
+	if p.router != nil || remaining <= 0 {
+		p.scheduleTransition(userID)
+	}
+}
+
+// scheduleTransition 同一用户的状态切换串行执行：已有切换进行中时只标记需要重算，避免快速断开重连时离线覆盖上线
+func (p *Presence) scheduleTransition(userID string) {
+	p.mu.Lock()
+	if _, running := p.transitions[userID]; running {
+		p.transitions[userID] = true
+		p.mu.Unlock()
+		return
+	}
+	p.transitions[userID] = false
+	p.mu.Unlock()
+	go func() {
+		for {
+			p.transition(userID)
+			p.mu.Lock()
+			if p.transitions[userID] {
+				p.transitions[userID] = false
+				p.mu.Unlock()
+				continue
+			}
+			delete(p.transitions, userID)
+			p.mu.Unlock()
+			return
+		}
+	}()
+}
+
+// IsOnline 用户当前是否在线；Redis 不可用时退回数据库中的最后状态
+func (p *Presence) IsOnline(ctx context.Context, u *models.User) bool {
+	if p.router == nil {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.local[u.ID] > 0
+	}
+	n, err := p.router.LiveConnCount(ctx, u.ID)
+	if err != nil {
+		return u.Status == "active"
+	}
+	return n > 0
+}
+
+// transition 按当前存活连接重新计算在线状态，状态有变化时落库并通知好友
+func (p *Presence) transition(userID string) {
+	var online bool
+	if p.router == nil {
+		p.mu.Lock()
+		online = p.local[userID] > 0
+		p.mu.Unlock()
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		n, err := p.router.LiveConnCount(ctx, userID)
+		cancel()
+		if err != nil {
+			log.Printf("ws presence count conns failed user=%s err=%v", userID, err)
+			return
+		}
+		online = n > 0
+	}
+	status := "offline"
+	if online {
+		status = "active"
+	}
+	updates := map[string]interface{}{"status": status, "last_seen_at": time.Now()}
+	res := p.db.Model(&models.User{}).Where("id = ? AND status <> ?", userID, status).Updates(updates)
+	if res.Error != nil {
+		log.Printf("ws update user status failed user=%s status=%s err=%v", userID, status, res.Error)
+		return
+	}
+	if res.RowsAffected == 0 {
+		return
+	}
+	p.notifyFriends(userID, online)
+}
+
+// NotifyFriends 向好友推送用户当前的在线状态与自定义状态
+func (p *Presence) NotifyFriends(userID string) {
+	var u models.User
+	if err := p.db.Select("id", "status").Where("id = ?", userID).First(&u).Error; err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	online := p.IsOnline(ctx, &u)
+	cancel()
+	p.notifyFriends(userID, online)
+}
+
+func (p *Presence) notifyFriends(userID string, online bool) {
+	var u models.User
+	if err := p.db.Select("id", "last_seen_at", "status_text", "status_emoji").Where("id = ?", userID).First(&u).Error; err != nil {
+		log.Printf("ws load presence failed user=%s err=%v", userID, err)
+		return
+	}
+	friendIDs, err := p.listFriendIDs(userID)
+	if err != nil {
+		log.Printf("ws list friends for presence failed user=%s err=%v", userID, err)
+		return
+	}
+	// 拉黑了该用户的好友不接收其状态变化
+	if blockerIDs, err := listBlockerIDs(p.db, userID, friendIDs); err == nil && len(blockerIDs) > 0 {
+		filtered := make([]string, 0, len(friendIDs))
+		for _, id := range friendIDs {
+			if !containsString(blockerIDs, id) {
+				filtered = append(filtered, id)
+			}
+		}
+		friendIDs = filtered
+	}
+	if len(friendIDs) == 0 {
+		return
+	}
+	b, err := newFrame(presenceEventFor(&u, online))
+	if err != nil {
+		return
+	}
+	if p.router != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		p.router.RouteToUsers(ctx, friendIDs, b)
+		cancel()
+		return
+	}
+	p.hub.sendToUsers(friendIDs, b)
+}
+
+func presenceEventFor(u *models.User, online bool) PresenceEvent {
+	ev := PresenceEvent{Type: "presence", UserID: u.ID, Online: online}
+	if !online && u.LastSeenAt != nil {
+		ev.LastSeenAt = u.LastSeenAt.Unix()
+	}
+	if u.StatusText != nil {
+		ev.StatusText = *u.StatusText
+	}
+	if u.StatusEmoji != nil {
+		ev.StatusEmoji = *u.StatusEmoji
+	}
+	return ev
+}
+
+func (p *Presence) listFriendIDs(userID string) ([]string, error) {
+	if p.cache != nil {
+		cacheKey := p.cache.BuildKey(cachepkg.FriendIDsKey(userID)...)
+		cacheCtx, cancel := context.WithTimeout(context.Background(), 120*time.Millisecond)
+		var cached []string
+		ok, err := p.cache.GetJSON(cacheCtx, cacheKey, &cached)
+		cancel()
+		if err == nil && ok {
+			return cached, nil
+		}
+	}
+	var relations []models.Friendship
+	if err := p.db.Where("user_id_a = ? OR user_id_b = ?", userID, userID).Find(&relations).Error; err != nil {
+		return nil, err
+	}
+	friendIDs := make([]string, 0, len(relations))
+	for _, r := range relations {
+		if r.UserIDA == userID {
+			friendIDs = append(friendIDs, r.UserIDB)
+		} else {
+			friendIDs = append(friendIDs, r.UserIDA)
+		}
+	}
+	if p.cache != nil {
+		cacheKey := p.cache.BuildKey(cachepkg.FriendIDsKey(userID)...)
+		cacheCtx, cancel := context.WithTimeout(context.Background(), 120*time.Millisecond)
+		_ = p.cache.SetJSON(cacheCtx, cacheKey, friendIDs, cachepkg.FriendIDsTTL)
+		cancel()
+	}
+	return friendIDs, nil
+}
+
+// heartbeat 按间隔续期当前连接在 Redis 中的心跳
+func (c *Client) heartbeat() {
+	if c.router == nil || time.Since(c.lastHeartbeat) < wsHeartbeatInterval {
+		return
+	}
+	c.lastHeartbeat = time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	c.router.Heartbeat(ctx, c.userID, c.connID)
+	cancel()
+}
+
+// handleTyping 处理 typing 帧：私聊转发给好友，群聊转发给其他成员；不落库，无权限时静默丢弃
+func (h *WsHandler) handleTyping(c *Client, msg *IncomingMessage) {
+	typing := msg.Typing == nil || *msg.Typing
+	now := time.Now()
+	if typing {
+		if now.Sub(c.lastTyping) < wsTypingMinInterval {
+			return
+		}
+		c.lastTyping = now
+	}
+	out := TypingEvent{Type: "typing", FromUser: c.userID, Typing: typing, Timestamp: now.Unix()}
+	var targets []string
+	if msg.ToUser != "" {
+		if !h.areFriends(c.userID, msg.ToUser) {
+			return
+		}
+		out.ToUser = msg.ToUser
+		targets = []string{msg.ToUser}
+	} else if msg.RoomID != "" {
+		if err := h.checkGroupPostingPermission(msg.RoomID, c.userID); err != nil {
+			return
+		}
+		memberIDs, err := h.getGroupMemberIDs(msg.RoomID)
+		if err != nil {
+			return
+		}
+		out.RoomID = msg.RoomID
+		for _, id := range memberIDs {
+			if id != c.userID {
+				targets = append(targets, id)
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return
+	}
+	b, err := newFrame(out)
+	if err != nil {
+		return
+	}
+	if out.RoomID != "" {
+		c.routeBroadcast(out.RoomID, targets, b)
+		return
+	}
+	if c.router != nil {
+		c.router.RouteToUsers(context.Background(), targets, b)
+		return
+	}
+	c.hub.sendToUsers(targets, b)
+}
+
+type SetStatusRequest struct {
+	StatusText  string `json:"status_text"`
+	StatusEmoji string `json:"status_emoji"`
+}
+
+// SetStatus 设置自定义状态文字与表情，均为空表示清除；变化实时推送给好友
+func (h *UserHandler) SetStatus(c *gin.Context) {
+	currentUserID := c.GetString("user_id")
+	if currentUserID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var req SetStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+	text := strings.TrimSpace(req.StatusText)
+	emoji := strings.TrimSpace(req.StatusEmoji)
+	if utf8.RuneCountInString(text) > presenceStatusTextMaxRunes || utf8.RuneCountInString(emoji) > presenceStatusEmojiMaxRunes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "状态文字或表情过长"})
+		return
+	}
+	updates := map[string]interface{}{"status_text": nil, "status_emoji": nil}
+	if text != "" {
+		updates["status_text"] = text
+	}
+	if emoji != "" {
+		updates["status_emoji"] = emoji
+	}
+	if err := h.db.Model(&models.User{}).Where("id = ?", currentUserID).Updates(updates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新状态失败"})
+		return
+	}
+	if h.presence != nil {
+		go h.presence.NotifyFriends(currentUserID)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status_text":  text,
+		"status_emoji": emoji,
+	})
+}
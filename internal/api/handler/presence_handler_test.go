@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"ququchat/internal/models"
+)
+
+// waitTransitions 等待所有状态切换执行完毕
+func waitTransitions(t *testing.T, p *Presence) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		p.mu.Lock()
+		n := len(p.transitions)
+		p.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("presence transitions did not finish")
+}
+
+func TestPresence_InterleavedConnectDisconnect(t *testing.T) {
+	cases := []struct {
+		name   string
+		status string
+		ops    []bool // true 为连接，false 为断开
+		want   string
+	}{
+		{"reconnect right after disconnect stays online", "offline", []bool{true, false, true}, "active"},
+		{"last of several connections closes", "active", []bool{true, true, false, false}, "offline"},
+		{"disconnect while another connection remains", "offline", []bool{true, true, false}, "active"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := syncTestDB(t)
+			if err := db.AutoMigrate(&models.Friendship{}); err != nil {
+				t.Fatalf("migrate: %v", err)
+			}
+			if err := db.Create(&models.User{ID: "u1", Username: "u1", Status: tc.status, CreatedAt: syncT0, UpdatedAt: syncT0}).Error; err != nil {
+				t.Fatalf("create user: %v", err)
+			}
+			p := NewPresence(db, nil, nil, nil)
+
+			// 占住唯一的连接，让所有状态切换在连接变化结束后才落库
+			tx := db.Begin()
+			for _, connect := range tc.ops {
+				if connect {
+					p.Connected("u1")
+				} else {
+					p.Disconnected("u1")
+				}
+			}
+			tx.Rollback()
+			waitTransitions(t, p)
+
+			var u models.User
+			if err := db.Select("status").Where("id = ?", "u1").First(&u).Error; err != nil {
+				t.Fatalf("load user: %v", err)
+			}
+			if u.Status != tc.want {
+				t.Fatalf("status = %q, want %q", u.Status, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm/clause"
+
+	"ququchat/internal/models"
+)
+
+const reactionEmojiMaxRunes = 32
+
+type ReactionEvent struct {
+	Type       string `json:"type"`
+	Action     string `json:"action"`
+	RoomID     string `json:"room_id"`
+	MessageID  string `json:"message_id"`
+	SequenceID int64  `json:"sequence_id"`
+	UserID     string `json:"user_id"`
+	Emoji      string `json:"emoji"`
+	Count      int64  `json:"count"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
+type ReactionCountDTO struct {
+	Emoji       string `json:"emoji"`
+	Count       int64  `json:"count"`
+	ReactedByMe bool   `json:"reacted_by_me,omitempty"`
+}
+
+// handleReaction 处理 add_reaction / remove_reaction 帧，群聊沿用发言权限校验，私聊要求双方仍为好友
+// 失败时回复附带 message_id 的 error 帧，客户端据此回滚本地的表情状态
+func (h *WsHandler) handleReaction(c *Client, add bool, messageID, emoji string) {
+	if utf8.RuneCountInString(emoji) > reactionEmojiMaxRunes {
+		c.rejectMessageOp(messageID, ackCodeTooLarge, "表情过长")
+		return
+	}
+	msg, ok := h.loadMessageForOp(c, messageID)
+	if !ok {
+		return
+	}
+	room, peerID, ok := h.loadRoomForSender(c.userID, msg.RoomID)
+	if !ok {
+		c.rejectMessageOp(msg.ID, msgOpCodeForbidden, "当前无法在该会话中添加表情")
+		return
+	}
+
+	action := "add"
+	if add {
+		reaction := models.MessageReaction{
+			MessageID: msg.ID,
+			UserID:    c.userID,
+			Emoji:     emoji,
+			CreatedAt: time.Now(),
+		}
+		if err := h.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&reaction).Error; err != nil {
+			log.Printf("ws add reaction failed user=%s message=%s err=%v", c.userID, msg.ID, err)
+			c.rejectMessageOp(msg.ID, ackCodeInternal, "添加表情失败")
+			return
+		}
+	} else {
+		action = "remove"
+		if err := h.db.Where("message_id = ? AND user_id = ? AND emoji = ?", msg.ID, c.userID, emoji).
+			Delete(&models.MessageReaction{}).Error; err != nil {
+			log.Printf("ws remove reaction failed user=%s message=%s err=%v", c.userID, msg.ID, err)
+			c.rejectMessageOp(msg.ID, ackCodeInternal, "移除表情失败")
+			return
+		}
+	}
+	var count int64
+	if err := h.db.Model(&models.MessageReaction{}).Where("message_id = ? AND emoji = ?", msg.ID, emoji).Count(&count).Error; err != nil {
+		return
+	}
+
+	out := ReactionEvent{
+		Type:       "reaction_updated",
+		Action:     action,
+		RoomID:     room.ID,
+		MessageID:  msg.ID,
+		SequenceID: msg.SequenceID,
+		UserID:     c.userID,
+		Emoji:      emoji,
+		Count:      count,
+		Timestamp:  time.Now().Unix(),
+	}
+	b, err := newFrame(out)
+	if err != nil {
+		return
+	}
+	h.routeRoomEvent(c, room, peerID, b)
+}
+
+// directRoomPeer 从私聊房间名 "a:b" 中解析出对端用户 ID
+func directRoomPeer(roomName, userID string) (string, bool) {
+	parts := strings.Split(roomName, ":")
+	if len(parts) != 2 {
+		return "", false
+	}
+	if parts[0] == userID {
+		return parts[1], true
+	}
+	if parts[1] == userID {
+		return parts[0], true
+	}
+	return "", false
+}
+
+type reactionCountRow struct {
+	MessageID string
+	Emoji     string
+	Count     int64
+	Mine      int64
+}
+
+// attachReactions 为历史消息补充聚合后的表情计数
+func (h *MessageHandler) attachReactions(userID string, list []MessageDTO) {
+	if len(list) == 0 {
+		return
+	}
+	ids := make([]string, 0, len(list))
+	for _, m := range list {
+		ids = append(ids, m.ID)
+	}
+	var rows []reactionCountRow
+	if err := h.db.Model(&models.MessageReaction{}).
+		Select("message_id, emoji, COUNT(*) AS count, SUM(CASE WHEN user_id = ? THEN 1 ELSE 0 END) AS mine", userID).
+		Where("message_id IN ?", ids).
+		Group("message_id, emoji").
+		Order("message_id, MIN(created_at)").
+		Scan(&rows).Error; err != nil {
+		log.Printf("load message reactions failed err=%v", err)
+		return
+	}
+	byMessage := make(map[string][]ReactionCountDTO, len(rows))
+	for _, r := range rows {
+		byMessage[r.MessageID] = append(byMessage[r.MessageID], ReactionCountDTO{
+			Emoji:       r.Emoji,
+			Count:       r.Count,
+			ReactedByMe: r.Mine > 0,
+		})
+	}
+	for i := range list {
+		list[i].Reactions = byMessage[list[i].ID]
+	}
+}
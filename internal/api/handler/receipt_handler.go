@@ -0,0 +1,287 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"ququchat/internal/models"
+)
+
+// 回执按批补写时每批的消息条数
+const wsReceiptBatchLimit = 500
+
+type ReceiptEvent struct {
+	Type       string `json:"type"`
+	RoomID     string `json:"room_id"`
+	UserID     string `json:"user_id"`
+	SequenceID int64  `json:"sequence_id"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
+// handleReceipt 处理 message_delivered / message_read 帧：
+// 将房间内 sequence_id <= upToSeq 且非本人发送的消息标记为已送达/已读，并通知对应发送者
+func (h *WsHandler) handleReceipt(c *Client, frameType, roomID string, upToSeq int64) {
+	memberIDs, err := h.getGroupMemberIDs(roomID)
+	if err != nil || !containsString(memberIDs, c.userID) {
+		return
+	}
+	read := frameType == "message_read"
+	now := time.Now()
+	senderIDs, maxSeq, err := h.markMessagesUpTo(roomID, c.userID, upToSeq, read, now)
+	if err != nil {
+		log.Printf("ws save receipt failed user=%s room=%s type=%s err=%v", c.userID, roomID, frameType, err)
+		return
+	}
+	if maxSeq == 0 {
+		return
+	}
+	if read {
+		invalidateConversationLists(h.cacheClient, c.userID)
+	}
+	out := ReceiptEvent{
+		Type:       frameType,
+		RoomID:     roomID,
+		UserID:     c.userID,
+		SequenceID: maxSeq,
+		Timestamp:  now.Unix(),
+	}
+	b, err := newFrame(out)
+	if err != nil {
+		return
+	}
+	// 发送者收到回执；读者的其他设备也同步已读位置
+	notifyIDs := append(senderIDs, c.userID)
+	c.routeBroadcast(roomID, notifyIDs, b)
+}
+
+// markMessagesUpTo 按 sequence_id 升序分批补写回执，直到 upToSeq 及之前的消息全部写入
+func (h *WsHandler) markMessagesUpTo(roomID, userID string, upToSeq int64, read bool, now time.Time) ([]string, int64, error) {
+	markedColumn := "delivered_at"
+	if read {
+		markedColumn = "read_at"
+	}
+	onConflict := clause.OnConflict{
+		Columns:   []clause.Column{{Name: "message_id"}, {Name: "user_id"}},
+		DoNothing: true,
+	}
+	if read {
+		// 已存在的送达回执只补写 read_at，保留原 delivered_at
+		onConflict = clause.OnConflict{
+			Columns:   []clause.Column{{Name: "message_id"}, {Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"read_at"}),
+		}
+	}
+	senderSet := make(map[string]struct{})
+	var afterSeq, maxSeq int64
+	for {
+		var list []models.Message
+		if err := h.db.Select("id", "sender_id", "sequence_id").
+			Where("room_id = ? AND sequence_id > ? AND sequence_id <= ? AND (sender_id IS NULL OR sender_id <> ?)", roomID, afterSeq, upToSeq, userID).
+			Where("NOT EXISTS (SELECT 1 FROM message_receipts r WHERE r.message_id = messages.id AND r.user_id = ? AND r."+markedColumn+" IS NOT NULL)", userID).
+			Order("sequence_id asc").
+			Limit(wsReceiptBatchLimit).
+			Find(&list).Error; err != nil {
+			return nil, 0, err
+		}
+		if len(list) == 0 {
+			break
+		}
+		receipts := make([]models.MessageReceipt, 0, len(list))
+		for _, m := range list {
+			r := models.MessageReceipt{
+				MessageID:   m.ID,
+				UserID:      userID,
+				DeliveredAt: &now,
+			}
+			if read {
+				r.ReadAt = &now
+			}
+			receipts = append(receipts, r)
+			if m.SenderID != nil && strings.TrimSpace(*m.SenderID) != "" {
+				senderSet[strings.TrimSpace(*m.SenderID)] = struct{}{}
+			}
+			if m.SequenceID > maxSeq {
+				maxSeq = m.SequenceID
+			}
+		}
+		if err := h.db.Clauses(onConflict).Create(&receipts).Error; err != nil {
+			return nil, 0, err
+		}
+		if len(list) < wsReceiptBatchLimit {
+			break
+		}
+		afterSeq = list[len(list)-1].SequenceID
+	}
+	if maxSeq == 0 {
+		return nil, 0, nil
+	}
+	senderIDs := make([]string, 0, len(senderSet))
+	for id := range senderSet {
+		senderIDs = append(senderIDs, id)
+	}
+	return senderIDs, maxSeq, nil
+}
+
+func containsString(list []string, target string) bool {
+	for _, v := range list {
+		if v == target {
+			return true
+		}
+	}
+	return false
+}
+
+type unreadCountRow struct {
+	RoomID      string
+	UnreadCount int64
+}
+
+type lastReadRow struct {
+	RoomID      string
+	LastReadSeq int64
+}
+
+// loadUnreadCounts 统计各房间已读位置之后他人发送的未撤回消息数
+func loadUnreadCounts(db *gorm.DB, userID string, roomIDs []string) (map[string]int64, error) {
+	var counts []unreadCountRow
+	if err := db.Table("messages AS m").
+		Select("m.room_id AS room_id, COUNT(*) AS unread_count").
+		Where("m.room_id IN ? AND m.deleted_at IS NULL AND (m.sender_id IS NULL OR m.sender_id <> ?)", roomIDs, userID).
+		Where("m.sequence_id > COALESCE((SELECT MAX(m2.sequence_id) FROM messages m2 JOIN message_receipts r ON r.message_id = m2.id WHERE m2.room_id = m.room_id AND r.user_id = ? AND r.read_at IS NOT NULL), 0)", userID).
+		Group("m.room_id").
+		Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+	countByRoom := make(map[string]int64, len(counts))
+	for _, r := range counts {
+		countByRoom[r.RoomID] = r.UnreadCount
+	}
+	return countByRoom, nil
+}
+
+// GetUnreadCounts 返回当前用户所在各房间的未读数（以已读回执中最大的 sequence_id 为已读位置）
+func (h *MessageHandler) GetUnreadCounts(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+
+	var roomIDs []string
+	if err := h.db.Model(&models.RoomMember{}).
+		Joins("JOIN rooms ON rooms.id = room_members.room_id AND rooms.deleted_at IS NULL").
+		Where("room_members.user_id = ? AND room_members.left_at IS NULL", userID).
+		Pluck("room_members.room_id", &roomIDs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询房间失败"})
+		return
+	}
+	if len(roomIDs) == 0 {
+		c.JSON(http.StatusOK, gin.H{"rooms": []gin.H{}, "total": 0})
+		return
+	}
+
+	var lastReads []lastReadRow
+	if err := h.db.Table("messages AS m").
+		Select("m.room_id AS room_id, MAX(m.sequence_id) AS last_read_seq").
+		Joins("JOIN message_receipts r ON r.message_id = m.id").
+		Where("m.room_id IN ? AND r.user_id = ? AND r.read_at IS NOT NULL", roomIDs, userID).
+		Group("m.room_id").
+		Scan(&lastReads).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询已读位置失败"})
+		return
+	}
+	lastReadByRoom := make(map[string]int64, len(lastReads))
+	for _, r := range lastReads {
+		lastReadByRoom[r.RoomID] = r.LastReadSeq
+	}
+
+	countByRoom, err := loadUnreadCounts(h.db, userID, roomIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询未读数失败"})
+		return
+	}
+
+	var total int64
+	resp := make([]gin.H, 0, len(roomIDs))
+	for _, rid := range roomIDs {
+		total += countByRoom[rid]
+		resp = append(resp, gin.H{
+			"room_id":               rid,
+			"unread_count":          countByRoom[rid],
+			"last_read_sequence_id": lastReadByRoom[rid],
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"rooms": resp, "total": total})
+}
+
+type MessageReceiptsRequest struct {
+	MessageID string `form:"message_id" json:"message_id" binding:"required"`
+}
+
+// GetMessageReceipts 返回单条消息的送达/已读用户列表
+func (h *MessageHandler) GetMessageReceipts(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var req MessageReceiptsRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: 缺少 message_id"})
+		return
+	}
+
+	var msg models.Message
+	if err := h.db.Where("id = ?", req.MessageID).First(&msg).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "消息不存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询消息失败"})
+		return
+	}
+
+	var member models.RoomMember
+	if err := h.db.Where("room_id = ? AND user_id = ?", msg.RoomID, userID).First(&member).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusForbidden, gin.H{"error": "您不是该房间成员"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询成员关系失败"})
+		return
+	}
+	if member.LeftAt != nil && !msg.CreatedAt.Before(*member.LeftAt) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权查看该消息"})
+		return
+	}
+
+	var receipts []models.MessageReceipt
+	if err := h.db.Where("message_id = ?", msg.ID).Find(&receipts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询回执失败"})
+		return
+	}
+
+	readBy := make([]gin.H, 0, len(receipts))
+	deliveredTo := make([]gin.H, 0, len(receipts))
+	for _, r := range receipts {
+		if r.ReadAt != nil {
+			readBy = append(readBy, gin.H{"user_id": r.UserID, "read_at": r.ReadAt.Unix()})
+		}
+		if r.DeliveredAt != nil {
+			deliveredTo = append(deliveredTo, gin.H{"user_id": r.UserID, "delivered_at": r.DeliveredAt.Unix()})
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message_id":   msg.ID,
+		"room_id":      msg.RoomID,
+		"sequence_id":  msg.SequenceID,
+		"read_by":      readBy,
+		"delivered_to": deliveredTo,
+	})
+}
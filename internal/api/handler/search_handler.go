@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+
+	"ququchat/internal/models"
+)
+
+const (
+	searchDefaultPageSize = 20
+	searchMaxPageSize     = 50
+	searchKeywordMaxRunes = 100
+	// mysqlErrFulltextIndexMissing ER_FT_MATCHING_KEY_NOT_FOUND：列上没有 FULLTEXT 索引
+	mysqlErrFulltextIndexMissing = 1191
+	// mysqlNgramTokenSize MySQL ngram_token_size 的默认值，短于该长度的词不进入 FULLTEXT 索引
+	mysqlNgramTokenSize = 2
+)
+
+type SearchMessagesRequest struct {
+	Keyword     string `form:"q" binding:"required"`
+	RoomID      string `form:"room_id"`
+	SenderID    string `form:"sender_id"`
+	ContentType string `form:"content_type"`
+	StartTime   int64  `form:"start_time"`
+	EndTime     int64  `form:"end_time"`
+	Page        int    `form:"page"`
+	PageSize    int    `form:"page_size"`
+}
+
+type SearchResultDTO struct {
+	MessageDTO
+	RoomType string `json:"room_type"`
+	RoomName string `json:"room_name"`
+}
+
+type searchResultRow struct {
+	models.Message
+	RoomType string
+	RoomName string
+}
+
+// escapeLike 转义 LIKE 模式中的通配符
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
+
+// isFulltextIndexMissing 全文检索因索引不存在而失败
+func isFulltextIndexMissing(err error) bool {
+	var myErr *mysql.MySQLError
+	return errors.As(err, &myErr) && myErr.Number == mysqlErrFulltextIndexMissing
+}
+
+// fulltextSearchable MySQL 的 ngram 索引无法命中短于分词长度的词（如单个汉字），此类关键词改用 LIKE
+func (h *MessageHandler) fulltextSearchable(keyword string) bool {
+	if h.dbDriver != "mysql" {
+		return true
+	}
+	for _, word := range strings.Fields(keyword) {
+		if utf8.RuneCountInString(word) < mysqlNgramTokenSize {
+			return false
+		}
+	}
+	return true
+}
+
+// buildSearchQuery 构造检索语句：只返回当前用户所在房间的消息，退群成员仅可见退群前的消息
+func (h *MessageHandler) buildSearchQuery(userID string, req SearchMessagesRequest, keyword string, fulltext bool) *gorm.DB {
+	query := h.db.Table("messages").
+		Select("messages.*, rooms.room_type AS room_type, rooms.name AS room_name").
+		Joins("JOIN room_members rm ON rm.room_id = messages.room_id AND rm.user_id = ?", userID).
+		Joins("JOIN rooms ON rooms.id = messages.room_id AND rooms.deleted_at IS NULL").
+		Where("messages.deleted_at IS NULL").
+		Where("(rm.left_at IS NULL OR messages.created_at < rm.left_at)")
+
+	likePattern := "%" + escapeLike(keyword) + "%"
+	switch {
+	case fulltext && h.dbDriver == "mysql":
+		query = query.Where("MATCH(messages.content_text) AGAINST (? IN BOOLEAN MODE)", `"`+strings.ReplaceAll(keyword, `"`, " ")+`"`)
+	case fulltext && h.dbDriver == "postgres":
+		// 由 pg_trgm 索引支持任意位置的子串匹配（含中文）
+		query = query.Where("messages.content_text ILIKE ?", likePattern)
+	default:
+		query = query.Where("messages.content_text LIKE ?", likePattern)
+	}
+
+	if req.RoomID != "" {
+		query = query.Where("messages.room_id = ?", req.RoomID)
+	}
+	if req.SenderID != "" {
+		query = query.Where("messages.sender_id = ?", req.SenderID)
+	}
+	if req.ContentType != "" {
+		query = query.Where("messages.content_type = ?", req.ContentType)
+	}
+	if req.StartTime > 0 {
+		query = query.Where("messages.created_at >= ?", time.Unix(req.StartTime, 0))
+	}
+	if req.EndTime > 0 {
+		query = query.Where("messages.created_at <= ?", time.Unix(req.EndTime, 0))
+	}
+	return query
+}
+
+// SearchMessages 关键词检索当前用户所在房间的消息，支持发送者/房间/时间/类型过滤与分页
+func (h *MessageHandler) SearchMessages(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var req SearchMessagesRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: 缺少关键词 q"})
+		return
+	}
+	keyword := strings.TrimSpace(req.Keyword)
+	if keyword == "" || utf8.RuneCountInString(keyword) > searchKeywordMaxRunes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "关键词不能为空且不超过100个字符"})
+		return
+	}
+	if req.StartTime > 0 && req.EndTime > 0 && req.StartTime > req.EndTime {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "时间范围无效"})
+		return
+	}
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+	if req.PageSize <= 0 {
+		req.PageSize = searchDefaultPageSize
+	}
+	if req.PageSize > searchMaxPageSize {
+		req.PageSize = searchMaxPageSize
+	}
+
+	find := func(fulltext bool) ([]searchResultRow, error) {
+		var rows []searchResultRow
+		err := h.buildSearchQuery(userID, req, keyword, fulltext).
+			Order("messages.created_at desc, messages.sequence_id desc").
+			Offset((req.Page - 1) * req.PageSize).
+			Limit(req.PageSize + 1).
+			Scan(&rows).Error
+		return rows, err
+	}
+	fulltext := h.fulltextSearchable(keyword)
+	rows, err := find(fulltext)
+	if err != nil && fulltext && isFulltextIndexMissing(err) {
+		// 全文索引缺失时退化为 LIKE 匹配，其余错误（超时、语法等）直接返回
+		log.Printf("fulltext index missing, fallback to LIKE driver=%s err=%v", h.dbDriver, err)
+		rows, err = find(false)
+	}
+	if err != nil {
+		log.Printf("message search failed driver=%s err=%v", h.dbDriver, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "搜索消息失败"})
+		return
+	}
+
+	hasMore := len(rows) > req.PageSize
+	if hasMore {
+		rows = rows[:req.PageSize]
+	}
+	list := make([]MessageDTO, 0, len(rows))
+	for _, r := range rows {
+		list = append(list, toMessageDTO(r.Message))
+	}
+	h.attachReactions(userID, list)
+	result := make([]SearchResultDTO, 0, len(rows))
+	for i, r := range rows {
+		result = append(result, SearchResultDTO{
+			MessageDTO: list[i],
+			RoomType:   r.RoomType,
+			RoomName:   r.RoomName,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"messages":  result,
+		"page":      req.Page,
+		"page_size": req.PageSize,
+		"has_more":  hasMore,
+	})
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	gormmysql "gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func dryRunDB(t *testing.T, driver string) *gorm.DB {
+	t.Helper()
+	var dialector gorm.Dialector
+	switch driver {
+	case "mysql":
+		dialector = gormmysql.New(gormmysql.Config{DSN: "u:p@tcp(127.0.0.1:3306)/test", SkipInitializeWithVersion: true})
+	case "postgres":
+		dialector = postgres.New(postgres.Config{DSN: "host=127.0.0.1 user=u dbname=test"})
+	}
+	db, err := gorm.Open(dialector, &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatalf("open dry run db: %v", err)
+	}
+	return db
+}
+
+func searchSQL(t *testing.T, driver string, req SearchMessagesRequest, fulltext bool) string {
+	t.Helper()
+	h := &MessageHandler{db: dryRunDB(t, driver), dbDriver: driver}
+	var rows []searchResultRow
+	stmt := h.buildSearchQuery("u1", req, req.Keyword, fulltext).Scan(&rows).Statement
+	return stmt.SQL.String()
+}
+
+func TestBuildSearchQuery_DriverPredicates(t *testing.T) {
+	cases := []struct {
+		driver   string
+		fulltext bool
+		want     string
+		notWant  string
+	}{
+		{driver: "mysql", fulltext: true, want: "MATCH(messages.content_text) AGAINST", notWant: "LIKE"},
+		{driver: "mysql", fulltext: false, want: "messages.content_text LIKE", notWant: "MATCH("},
+		{driver: "postgres", fulltext: true, want: "messages.content_text ILIKE", notWant: "to_tsvector"},
+	}
+	for _, tc := range cases {
+		sql := searchSQL(t, tc.driver, SearchMessagesRequest{Keyword: "hello"}, tc.fulltext)
+		if !strings.Contains(sql, tc.want) {
+			t.Fatalf("%s fulltext=%v: want %q in %s", tc.driver, tc.fulltext, tc.want, sql)
+		}
+		if strings.Contains(sql, tc.notWant) {
+			t.Fatalf("%s fulltext=%v: unexpected %q in %s", tc.driver, tc.fulltext, tc.notWant, sql)
+		}
+	}
+}
+
+func TestBuildSearchQuery_Filters(t *testing.T) {
+	sql := searchSQL(t, "mysql", SearchMessagesRequest{
+		Keyword:     "hello",
+		RoomID:      "r1",
+		SenderID:    "s1",
+		ContentType: "text",
+		StartTime:   100,
+		EndTime:     200,
+	}, true)
+	for _, want := range []string{
+		"rm.user_id = ?",
+		"messages.deleted_at IS NULL",
+		"messages.created_at < rm.left_at",
+		"messages.room_id = ?",
+		"messages.sender_id = ?",
+		"messages.content_type = ?",
+		"messages.created_at >= ?",
+		"messages.created_at <= ?",
+	} {
+		if !strings.Contains(sql, want) {
+			t.Fatalf("want %q in %s", want, sql)
+		}
+	}
+}
+
+func TestFulltextSearchable_ShortKeywords(t *testing.T) {
+	cases := []struct {
+		driver  string
+		keyword string
+		want    bool
+	}{
+		{driver: "mysql", keyword: "你好", want: true},
+		{driver: "mysql", keyword: "hello world", want: true},
+		{driver: "mysql", keyword: "好", want: false},
+		{driver: "mysql", keyword: "a", want: false},
+		{driver: "mysql", keyword: "你好 a", want: false},
+		{driver: "postgres", keyword: "好", want: true},
+	}
+	for _, tc := range cases {
+		h := &MessageHandler{dbDriver: tc.driver}
+		if got := h.fulltextSearchable(tc.keyword); got != tc.want {
+			t.Fatalf("%s %q: fulltextSearchable = %v, want %v", tc.driver, tc.keyword, got, tc.want)
+		}
+	}
+
+	// 单字关键词在 MySQL 上走 LIKE
+	h := &MessageHandler{dbDriver: "mysql"}
+	sql := searchSQL(t, "mysql", SearchMessagesRequest{Keyword: "好"}, h.fulltextSearchable("好"))
+	if !strings.Contains(sql, "messages.content_text LIKE") || strings.Contains(sql, "MATCH(") {
+		t.Fatalf("single-char keyword should use LIKE: %s", sql)
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if got := escapeLike(`50%_a\b`); got != `50\%\_a\\b` {
+		t.Fatalf("escapeLike = %q", got)
+	}
+}
+
+func TestIsFulltextIndexMissing(t *testing.T) {
+	missing := fmt.Errorf("query: %w", &mysql.MySQLError{Number: mysqlErrFulltextIndexMissing})
+	if !isFulltextIndexMissing(missing) {
+		t.Fatalf("expected 1191 to be treated as missing index")
+	}
+	for _, err := range []error{
+		&mysql.MySQLError{Number: 1064},
+		errors.New("context deadline exceeded"),
+	} {
+		if isFulltextIndexMissing(err) {
+			t.Fatalf("unexpected fallback for %v", err)
+		}
+	}
+}
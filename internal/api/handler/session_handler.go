@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"ququchat/internal/models"
+)
+
+type SessionDTO struct {
+	ID           string  `json:"id"`
+	IP           *string `json:"ip,omitempty"`
+	UserAgent    *string `json:"user_agent,omitempty"`
+	CreatedAt    int64   `json:"created_at"`
+	LastActiveAt int64   `json:"last_active_at"`
+	ExpiresAt    int64   `json:"expires_at"`
+	Current      bool    `json:"current"`
+}
+
+type RevokeSessionRequest struct {
+	SessionID string `json:"session_id" binding:"required"`
+}
+
+// revokeSessions 吊销会话：数据库标记吊销使刷新令牌失效，Redis 吊销集合使访问令牌立即失效，并断开对应 WebSocket 连接
+func (h *AuthHandler) revokeSessions(userID string, sessionIDs []string) error {
+	if len(sessionIDs) == 0 {
+		return nil
+	}
+	now := time.Now()
+	if err := h.db.Model(&models.AuthSession{}).
+		Where("user_id = ? AND id IN ? AND revoked_at IS NULL", userID, sessionIDs).
+		Update("revoked_at", &now).Error; err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if err := h.revocations.Revoke(ctx, userID, sessionIDs...); err != nil {
+		// 刷新令牌已失效，访问令牌最迟在 TTL 到期后失效
+		log.Printf("revoke sessions in redis failed user=%s err=%v", userID, err)
+	}
+	if h.router != nil {
+		h.router.DisconnectSessions(ctx, userID, sessionIDs)
+	} else if h.hub != nil {
+		h.hub.disconnectSessions(SessionDisconnect{UserID: userID, SessionIDs: sessionIDs})
+	}
+	return nil
+}
+
+// ListSessions 列出当前用户仍有效的登录会话（设备）
+func (h *AuthHandler) ListSessions(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var sessions []models.AuthSession
+	if err := h.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("created_at DESC").
+		Find(&sessions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询会话失败"})
+		return
+	}
+	currentID := c.GetString("session_id")
+	list := make([]SessionDTO, 0, len(sessions))
+	for _, s := range sessions {
+		lastActive := s.CreatedAt
+		if s.LastActiveAt != nil {
+			lastActive = *s.LastActiveAt
+		}
+		list = append(list, SessionDTO{
+			ID:           s.ID,
+			IP:           s.IP,
+			UserAgent:    s.UserAgent,
+			CreatedAt:    s.CreatedAt.Unix(),
+			LastActiveAt: lastActive.Unix(),
+			ExpiresAt:    s.ExpiresAt.Unix(),
+			Current:      s.ID == currentID,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": list})
+}
+
+// RevokeSession 注销指定会话（设备），可用于下线丢失的设备
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var req RevokeSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.SessionID) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: 缺少 session_id"})
+		return
+	}
+	var count int64
+	if err := h.db.Model(&models.AuthSession{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", req.SessionID, userID).
+		Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询会话失败"})
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未找到有效会话"})
+		return
+	}
+	if err := h.revokeSessions(userID, []string{req.SessionID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "吊销会话失败"})
+		return
+	}
+	if req.SessionID == c.GetString("session_id") {
+		c.SetCookie("refresh_token", "", -1, "/", "", false, true)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "会话已注销"})
+}
+
+// RevokeOtherSessions 注销除当前设备外的全部会话
+func (h *AuthHandler) RevokeOtherSessions(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	currentID := c.GetString("session_id")
+	if currentID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "当前访问令牌不含会话信息，请重新登录"})
+		return
+	}
+	var sessionIDs []string
+	if err := h.db.Model(&models.AuthSession{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, currentID).
+		Pluck("id", &sessionIDs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询会话失败"})
+		return
+	}
+	if err := h.revokeSessions(userID, sessionIDs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "批量吊销会话失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已注销其他设备", "revoked": len(sessionIDs)})
+}
@@ -0,0 +1,344 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"ququchat/internal/models"
+)
+
+const (
+	// 每个房间单次同步最多返回的消息条数
+	syncPerRoomLimit = 50
+	// 单次同步最多返回的消息总数，超出后其余房间只返回补拉起点
+	syncTotalLimit = 500
+)
+
+type SyncRequest struct {
+	// Rooms 客户端已知的房间及其最后一条消息的 sequence_id
+	Rooms map[string]int64 `json:"rooms"`
+	// SyncedAt 上次同步结果中的 synced_at；此前加入但不在 Rooms 中的房间不算新房间，从该时间起补拉
+	SyncedAt int64 `json:"synced_at"`
+}
+
+type SyncRoomDTO struct {
+	RoomID   string       `json:"room_id"`
+	Messages []MessageDTO `json:"messages"`
+	HasMore  bool         `json:"has_more"`
+	// SinceSequenceID 本次补拉的起点，messages 为空时客户端以此作为该房间的游标继续同步
+	SinceSequenceID  int64 `json:"since_sequence_id"`
+	LatestSequenceID int64 `json:"latest_sequence_id"`
+}
+
+type SyncNewRoomDTO struct {
+	RoomID           string `json:"room_id"`
+	RoomType         string `json:"room_type"`
+	Name             string `json:"name"`
+	LatestSequenceID int64  `json:"latest_sequence_id"`
+}
+
+type SyncPayload struct {
+	Type           string           `json:"type,omitempty"`
+	Rooms          []SyncRoomDTO    `json:"rooms"`
+	NewRooms       []SyncNewRoomDTO `json:"new_rooms"`
+	RemovedRoomIDs []string         `json:"removed_room_ids"`
+	// HasMore 有房间未返回全部缺失消息，客户端应更新游标后调用 POST /api/messages/sync 继续补拉
+	HasMore  bool  `json:"has_more"`
+	SyncedAt int64 `json:"synced_at"`
+}
+
+type roomLatestSeqRow struct {
+	RoomID    string
+	LatestSeq int64
+}
+
+type roomStartRow struct {
+	RoomID    string
+	JoinedAt  time.Time
+	LatestSeq int64
+}
+
+// roomStart 没有游标的房间的补拉起点
+type roomStart struct {
+	seq int64
+	// joined 在上次同步之后加入，作为新房间返回
+	joined bool
+}
+
+// listUserRoomIDs 返回用户当前所在（未退出）的房间
+func listUserRoomIDs(db *gorm.DB, userID string) ([]string, error) {
+	var roomIDs []string
+	err := db.Model(&models.RoomMember{}).
+		Where("user_id = ? AND left_at IS NULL", userID).
+		Order("room_id").
+		Pluck("room_id", &roomIDs).Error
+	return roomIDs, err
+}
+
+// startCursors 计算没有游标的房间的补拉起点：上次同步之后加入的房间从加入时开始，
+// 其余房间从上次同步时开始；lastSyncAt 为零值时全部视为新加入
+func startCursors(db *gorm.DB, userID string, roomIDs []string, lastSyncAt time.Time) (map[string]roomStart, error) {
+	query := db.Table("room_members AS rm").
+		Select("rm.room_id AS room_id, rm.joined_at AS joined_at, COALESCE(MAX(m.sequence_id), 0) AS latest_seq")
+	if lastSyncAt.IsZero() {
+		query = query.Joins("LEFT JOIN messages m ON m.room_id = rm.room_id AND m.created_at < rm.joined_at")
+	} else {
+		query = query.Joins("LEFT JOIN messages m ON m.room_id = rm.room_id AND m.created_at < CASE WHEN rm.joined_at > ? THEN rm.joined_at ELSE ? END", lastSyncAt, lastSyncAt)
+	}
+	var rows []roomStartRow
+	if err := query.
+		Where("rm.user_id = ? AND rm.room_id IN ?", userID, roomIDs).
+		Group("rm.room_id, rm.joined_at").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	starts := make(map[string]roomStart, len(rows))
+	for _, r := range rows {
+		starts[r.RoomID] = roomStart{
+			seq:    r.LatestSeq,
+			joined: lastSyncAt.IsZero() || r.JoinedAt.After(lastSyncAt),
+		}
+	}
+	return starts, nil
+}
+
+// loadSyncRoom 返回房间 lastSeq 之后的至多 limit 条消息
+func loadSyncRoom(db *gorm.DB, roomID string, lastSeq, latest int64, limit int) (*SyncRoomDTO, error) {
+	var list []models.Message
+	if err := db.Unscoped().
+		Where("room_id = ? AND sequence_id > ?", roomID, lastSeq).
+		Order("sequence_id asc").
+		Limit(limit + 1).
+		Find(&list).Error; err != nil {
+		return nil, err
+	}
+	hasMore := len(list) > limit
+	if hasMore {
+		list = list[:limit]
+	}
+	messages := make([]MessageDTO, 0, len(list))
+	for _, m := range list {
+		messages = append(messages, toMessageDTO(m))
+	}
+	return &SyncRoomDTO{
+		RoomID:           roomID,
+		Messages:         messages,
+		HasMore:          hasMore,
+		SinceSequenceID:  lastSeq,
+		LatestSequenceID: latest,
+	}, nil
+}
+
+// buildSyncPayload 按游标计算各房间缺失的消息，每个房间至多 perRoomLimit 条、总数至多 totalLimit 条。
+// 没有游标的房间按 startCursors 确定起点，其中上次同步之后加入的房间同时作为新房间返回元信息
+func buildSyncPayload(db *gorm.DB, userID string, cursors map[string]int64, lastSyncAt time.Time, perRoomLimit, totalLimit int) (*SyncPayload, error) {
+	roomIDs, err := listUserRoomIDs(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	payload := &SyncPayload{
+		Rooms:          make([]SyncRoomDTO, 0),
+		NewRooms:       make([]SyncNewRoomDTO, 0),
+		RemovedRoomIDs: make([]string, 0),
+		SyncedAt:       time.Now().Unix(),
+	}
+	active := make(map[string]struct{}, len(roomIDs))
+	for _, rid := range roomIDs {
+		active[rid] = struct{}{}
+	}
+	for rid := range cursors {
+		if _, ok := active[rid]; !ok {
+			payload.RemovedRoomIDs = append(payload.RemovedRoomIDs, rid)
+		}
+	}
+	sort.Strings(payload.RemovedRoomIDs)
+	if len(roomIDs) == 0 {
+		return payload, nil
+	}
+
+	var latestRows []roomLatestSeqRow
+	if err := db.Unscoped().Model(&models.Message{}).
+		Select("room_id, MAX(sequence_id) AS latest_seq").
+		Where("room_id IN ?", roomIDs).
+		Group("room_id").
+		Scan(&latestRows).Error; err != nil {
+		return nil, err
+	}
+	latestByRoom := make(map[string]int64, len(latestRows))
+	for _, r := range latestRows {
+		latestByRoom[r.RoomID] = r.LatestSeq
+	}
+
+	uncursored := make([]string, 0)
+	for _, rid := range roomIDs {
+		if _, known := cursors[rid]; !known {
+			uncursored = append(uncursored, rid)
+		}
+	}
+	var starts map[string]roomStart
+	if len(uncursored) > 0 {
+		if starts, err = startCursors(db, userID, uncursored, lastSyncAt); err != nil {
+			return nil, err
+		}
+	}
+	budget := totalLimit
+	for _, rid := range roomIDs {
+		lastSeq, known := cursors[rid]
+		if !known {
+			lastSeq = starts[rid].seq
+		}
+		latest := latestByRoom[rid]
+		if latest <= lastSeq {
+			continue
+		}
+		if budget <= 0 {
+			// 总量已满，只告知补拉起点
+			payload.Rooms = append(payload.Rooms, SyncRoomDTO{
+				RoomID:           rid,
+				Messages:         make([]MessageDTO, 0),
+				HasMore:          true,
+				SinceSequenceID:  lastSeq,
+				LatestSequenceID: latest,
+			})
+			payload.HasMore = true
+			continue
+		}
+		limit := perRoomLimit
+		if budget < limit {
+			limit = budget
+		}
+		room, err := loadSyncRoom(db, rid, lastSeq, latest, limit)
+		if err != nil {
+			return nil, err
+		}
+		budget -= len(room.Messages)
+		if room.HasMore {
+			payload.HasMore = true
+		}
+		payload.Rooms = append(payload.Rooms, *room)
+	}
+
+	newRoomIDs := make([]string, 0)
+	for _, rid := range uncursored {
+		if starts[rid].joined {
+			newRoomIDs = append(newRoomIDs, rid)
+		}
+	}
+	if len(newRoomIDs) > 0 {
+		var rooms []models.Room
+		if err := db.Select("id", "room_type", "name").Where("id IN ?", newRoomIDs).Order("id").Find(&rooms).Error; err != nil {
+			return nil, err
+		}
+		for _, r := range rooms {
+			payload.NewRooms = append(payload.NewRooms, SyncNewRoomDTO{
+				RoomID:           r.ID,
+				RoomType:         string(r.RoomType),
+				Name:             r.Name,
+				LatestSequenceID: latestByRoom[r.ID],
+			})
+		}
+	}
+	return payload, nil
+}
+
+// deliveredCursors 以已送达回执中最大的 sequence_id 作为服务端记录的同步游标
+func deliveredCursors(db *gorm.DB, userID string) (map[string]int64, error) {
+	var rows []lastReadRow
+	if err := db.Table("messages AS m").
+		Select("m.room_id AS room_id, MAX(m.sequence_id) AS last_read_seq").
+		Joins("JOIN message_receipts r ON r.message_id = m.id").
+		Joins("JOIN room_members rm ON rm.room_id = m.room_id AND rm.user_id = r.user_id AND rm.left_at IS NULL").
+		Where("r.user_id = ? AND r.delivered_at IS NOT NULL", userID).
+		Group("m.room_id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	cursors := make(map[string]int64, len(rows))
+	for _, r := range rows {
+		cursors[r.RoomID] = r.LastReadSeq
+	}
+	return cursors, nil
+}
+
+// Sync 离线补拉：根据客户端提交的各房间游标一次性返回缺失消息
+func (h *MessageHandler) Sync(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var req SyncRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求体格式错误"})
+		return
+	}
+	limit := h.historyLimit
+	if limit > syncPerRoomLimit {
+		limit = syncPerRoomLimit
+	}
+	var lastSyncAt time.Time
+	if req.SyncedAt > 0 {
+		lastSyncAt = time.Unix(req.SyncedAt, 0)
+	}
+	payload, err := buildSyncPayload(h.db, userID, req.Rooms, lastSyncAt, limit, syncTotalLimit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "同步消息失败"})
+		return
+	}
+	for i := range payload.Rooms {
+		h.attachReactions(userID, payload.Rooms[i].Messages)
+	}
+	c.JSON(http.StatusOK, payload)
+}
+
+// parseSyncCursors 解析建连时 since 参数（JSON：room_id -> sequence_id）；缺省时使用送达回执游标，
+// 并返回服务端记录的上次同步时间，fromServer 表示游标来自服务端
+func (h *WsHandler) parseSyncCursors(userID, raw string) (cursors map[string]int64, lastSyncAt time.Time, fromServer bool) {
+	raw = strings.TrimSpace(raw)
+	if raw != "" {
+		if err := json.Unmarshal([]byte(raw), &cursors); err == nil {
+			return cursors, time.Time{}, false
+		}
+	}
+	cursors, err := deliveredCursors(h.db, userID)
+	if err != nil {
+		log.Printf("ws load sync cursors failed user=%s err=%v", userID, err)
+		return map[string]int64{}, time.Time{}, true
+	}
+	var u models.User
+	if err := h.db.Select("id", "last_synced_at").Where("id = ?", userID).First(&u).Error; err != nil {
+		log.Printf("ws load last sync time failed user=%s err=%v", userID, err)
+	} else if u.LastSyncedAt != nil {
+		lastSyncAt = *u.LastSyncedAt
+	}
+	return cursors, lastSyncAt, true
+}
+
+// pushSyncFrame 在连接注册后推送 sync 帧，帮助客户端断线重连后补齐消息；在独立协程中调用，不阻塞握手
+func (h *WsHandler) pushSyncFrame(c *Client, since string) {
+	cursors, lastSyncAt, fromServer := h.parseSyncCursors(c.userID, since)
+	now := time.Now()
+	payload, err := buildSyncPayload(h.db, c.userID, cursors, lastSyncAt, syncPerRoomLimit, syncTotalLimit)
+	if err != nil {
+		log.Printf("ws build sync frame failed user=%s err=%v", c.userID, err)
+		return
+	}
+	payload.Type = "sync"
+	b, err := newFrame(payload)
+	if err != nil {
+		return
+	}
+	c.enqueue(b)
+	if fromServer {
+		if err := h.db.Model(&models.User{}).Where("id = ?", c.userID).Update("last_synced_at", now).Error; err != nil {
+			log.Printf("ws save last sync time failed user=%s err=%v", c.userID, err)
+		}
+	}
+}
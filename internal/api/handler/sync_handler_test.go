@@ -0,0 +1,233 @@
+package handler
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"ququchat/internal/models"
+)
+
+func TestParseSyncCursors_Since(t *testing.T) {
+	h := &WsHandler{}
+	got, lastSyncAt, fromServer := h.parseSyncCursors("u1", ` {"r1": 12, "r2": 0} `)
+	if len(got) != 2 || got["r1"] != 12 || got["r2"] != 0 {
+		t.Fatalf("unexpected cursors: %v", got)
+	}
+	if fromServer || !lastSyncAt.IsZero() {
+		t.Fatalf("client cursors should not use the server sync time")
+	}
+}
+
+var syncT0 = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func syncTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("sql db: %v", err)
+	}
+	// 内存库每个连接独立，固定单连接
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&models.User{}, &models.Room{}, &models.RoomMember{}, &models.Message{}, &models.MessageReceipt{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+// seedSyncRoom 创建群并让 u1 在 joinedAt 加入，消息 i 在 syncT0 之后 i 分钟发送
+func seedSyncRoom(t *testing.T, db *gorm.DB, roomID string, joinedAt time.Time, messages int) {
+	t.Helper()
+	if err := db.Create(&models.Room{ID: roomID, RoomType: models.RoomTypeGroup, Name: "room " + roomID, OwnerUserID: "u2", CreatedAt: syncT0, UpdatedAt: syncT0}).Error; err != nil {
+		t.Fatalf("create room: %v", err)
+	}
+	if err := db.Create(&models.RoomMember{RoomID: roomID, UserID: "u1", JoinedAt: joinedAt}).Error; err != nil {
+		t.Fatalf("create member: %v", err)
+	}
+	sender := "u2"
+	for i := 1; i <= messages; i++ {
+		if err := db.Create(&models.Message{
+			ID:          fmt.Sprintf("%s-m%d", roomID, i),
+			RoomID:      roomID,
+			SenderID:    &sender,
+			ContentType: models.ContentTypeText,
+			SequenceID:  int64(i),
+			CreatedAt:   syncT0.Add(time.Duration(i) * time.Minute),
+		}).Error; err != nil {
+			t.Fatalf("create message: %v", err)
+		}
+	}
+}
+
+func syncRoomSeqs(r SyncRoomDTO) []int64 {
+	seqs := make([]int64, 0, len(r.Messages))
+	for _, m := range r.Messages {
+		seqs = append(seqs, m.SequenceID)
+	}
+	return seqs
+}
+
+func TestBuildSyncPayload_ResumesFromCursors(t *testing.T) {
+	db := syncTestDB(t)
+	seedSyncRoom(t, db, "r1", syncT0, 5)
+	seedSyncRoom(t, db, "r2", syncT0, 2)
+
+	payload, err := buildSyncPayload(db, "u1", map[string]int64{"r1": 3, "r2": 2, "gone": 7}, time.Time{}, syncPerRoomLimit, syncTotalLimit)
+	if err != nil {
+		t.Fatalf("buildSyncPayload: %v", err)
+	}
+	if len(payload.Rooms) != 1 || payload.Rooms[0].RoomID != "r1" {
+		t.Fatalf("only r1 has new messages: %+v", payload.Rooms)
+	}
+	r1 := payload.Rooms[0]
+	if got := syncRoomSeqs(r1); !reflect.DeepEqual(got, []int64{4, 5}) || r1.SinceSequenceID != 3 || r1.LatestSequenceID != 5 || r1.HasMore {
+		t.Fatalf("unexpected r1: seqs=%v %+v", got, r1)
+	}
+	if len(payload.NewRooms) != 0 || payload.HasMore {
+		t.Fatalf("no new rooms or paging expected: %+v", payload)
+	}
+	if !reflect.DeepEqual(payload.RemovedRoomIDs, []string{"gone"}) {
+		t.Fatalf("removed = %v", payload.RemovedRoomIDs)
+	}
+}
+
+func TestBuildSyncPayload_RoomsWithoutCursor(t *testing.T) {
+	db := syncTestDB(t)
+	// r1 早已加入，上次同步在第 3 条消息之后；r2 在上次同步之后加入，加入前的消息不补拉
+	seedSyncRoom(t, db, "r1", syncT0, 4)
+	lastSyncAt := syncT0.Add(3*time.Minute + 30*time.Second)
+	seedSyncRoom(t, db, "r2", lastSyncAt.Add(time.Minute), 4)
+
+	cases := []struct {
+		name       string
+		lastSyncAt time.Time
+		want       map[string][]int64
+		newRooms   []string
+	}{
+		{
+			name:       "known sync time separates new rooms",
+			lastSyncAt: lastSyncAt,
+			want:       map[string][]int64{"r1": {4}},
+			newRooms:   []string{"r2"},
+		},
+		{
+			name:     "unknown sync time treats every room as new",
+			want:     map[string][]int64{"r1": {1, 2, 3, 4}},
+			newRooms: []string{"r1", "r2"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			payload, err := buildSyncPayload(db, "u1", map[string]int64{}, tc.lastSyncAt, syncPerRoomLimit, syncTotalLimit)
+			if err != nil {
+				t.Fatalf("buildSyncPayload: %v", err)
+			}
+			got := make(map[string][]int64)
+			for _, r := range payload.Rooms {
+				got[r.RoomID] = syncRoomSeqs(r)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("rooms = %v, want %v", got, tc.want)
+			}
+			newRooms := make([]string, 0)
+			for _, r := range payload.NewRooms {
+				newRooms = append(newRooms, r.RoomID)
+			}
+			if !reflect.DeepEqual(newRooms, tc.newRooms) {
+				t.Fatalf("new rooms = %v, want %v", newRooms, tc.newRooms)
+			}
+		})
+	}
+}
+
+func TestBuildSyncPayload_NewRoomBackfillFromJoin(t *testing.T) {
+	db := syncTestDB(t)
+	seedSyncRoom(t, db, "r1", syncT0.Add(2*time.Minute+30*time.Second), 4)
+
+	payload, err := buildSyncPayload(db, "u1", map[string]int64{}, syncT0, syncPerRoomLimit, syncTotalLimit)
+	if err != nil {
+		t.Fatalf("buildSyncPayload: %v", err)
+	}
+	if len(payload.NewRooms) != 1 || payload.NewRooms[0].RoomID != "r1" || payload.NewRooms[0].LatestSequenceID != 4 {
+		t.Fatalf("unexpected new rooms: %+v", payload.NewRooms)
+	}
+	if len(payload.Rooms) != 1 {
+		t.Fatalf("unexpected rooms: %+v", payload.Rooms)
+	}
+	if got := syncRoomSeqs(payload.Rooms[0]); !reflect.DeepEqual(got, []int64{3, 4}) || payload.Rooms[0].SinceSequenceID != 2 {
+		t.Fatalf("new room should back-fill from join: seqs=%v since=%d", got, payload.Rooms[0].SinceSequenceID)
+	}
+}
+
+func TestBuildSyncPayload_Limits(t *testing.T) {
+	db := syncTestDB(t)
+	for _, rid := range []string{"r1", "r2", "r3"} {
+		seedSyncRoom(t, db, rid, syncT0, 5)
+	}
+	cursors := map[string]int64{"r1": 0, "r2": 1, "r3": 2}
+
+	cases := []struct {
+		name         string
+		perRoom      int
+		total        int
+		want         map[string][]int64
+		roomsHasMore map[string]bool
+		hasMore      bool
+	}{
+		{
+			name:         "no truncation",
+			perRoom:      10,
+			total:        100,
+			want:         map[string][]int64{"r1": {1, 2, 3, 4, 5}, "r2": {2, 3, 4, 5}, "r3": {3, 4, 5}},
+			roomsHasMore: map[string]bool{},
+		},
+		{
+			name:         "per-room limit",
+			perRoom:      2,
+			total:        100,
+			want:         map[string][]int64{"r1": {1, 2}, "r2": {2, 3}, "r3": {3, 4}},
+			roomsHasMore: map[string]bool{"r1": true, "r2": true, "r3": true},
+			hasMore:      true,
+		},
+		{
+			name:         "total limit",
+			perRoom:      3,
+			total:        4,
+			want:         map[string][]int64{"r1": {1, 2, 3}, "r2": {2}, "r3": {}},
+			roomsHasMore: map[string]bool{"r1": true, "r2": true, "r3": true},
+			hasMore:      true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			payload, err := buildSyncPayload(db, "u1", cursors, time.Time{}, tc.perRoom, tc.total)
+			if err != nil {
+				t.Fatalf("buildSyncPayload: %v", err)
+			}
+			got := make(map[string][]int64)
+			for _, r := range payload.Rooms {
+				got[r.RoomID] = syncRoomSeqs(r)
+				if r.HasMore != tc.roomsHasMore[r.RoomID] {
+					t.Fatalf("%s has_more = %v", r.RoomID, r.HasMore)
+				}
+				if r.SinceSequenceID != cursors[r.RoomID] {
+					t.Fatalf("%s since = %d, want %d", r.RoomID, r.SinceSequenceID, cursors[r.RoomID])
+				}
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("rooms = %v, want %v", got, tc.want)
+			}
+			if payload.HasMore != tc.hasMore {
+				t.Fatalf("payload has_more = %v, want %v", payload.HasMore, tc.hasMore)
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"ququchat/internal/models"
+	"ququchat/internal/server/auth"
+)
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+	// RecoveryCode 无法使用身份验证器时可改用恢复码
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+}
+
+// twoFactorEnabled 判断用户是否已启用（确认过的）二次验证
+func (h *AuthHandler) twoFactorEnabled(userID string) (bool, error) {
+	var count int64
+	err := h.db.Model(&models.UserTwoFactor{}).
+		Where("user_id = ? AND enabled_at IS NOT NULL", userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// verifyTOTP 校验验证码并推进 last_used_step，同一时间步的验证码只能使用一次
+func (h *AuthHandler) verifyTOTP(tf *models.UserTwoFactor, code string) bool {
+	secret, err := auth.DecryptSecret(h.totpKey, tf.SecretEnc)
+	if err != nil {
+		return false
+	}
+	step, ok := auth.ValidateTOTP(secret, code, time.Now())
+	if !ok || step <= tf.LastUsedStep {
+		return false
+	}
+	result := h.db.Model(&models.UserTwoFactor{}).
+		Where("user_id = ? AND last_used_step < ?", tf.UserID, step).
+		Update("last_used_step", step)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false
+	}
+	tf.LastUsedStep = step
+	return true
+}
+
+// consumeRecoveryCode 使用一次性恢复码，条件更新保证并发下只能成功一次
+func (h *AuthHandler) consumeRecoveryCode(userID, code string) bool {
+	if strings.TrimSpace(code) == "" {
+		return false
+	}
+	result := h.db.Model(&models.TwoFactorRecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, auth.HashRecoveryCode(code)).
+		Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected > 0
+}
+
+// verifySecondFactor 优先校验 TOTP 验证码，未提供时校验恢复码
+func (h *AuthHandler) verifySecondFactor(tf *models.UserTwoFactor, code, recoveryCode string) bool {
+	if strings.TrimSpace(code) != "" {
+		return h.verifyTOTP(tf, code)
+	}
+	return h.consumeRecoveryCode(tf.UserID, recoveryCode)
+}
+
+// replaceRecoveryCodes 作废旧恢复码并生成新的一组，仅返回一次明文
+func replaceRecoveryCodes(tx *gorm.DB, userID string) ([]string, error) {
+	codes, err := auth.GenerateRecoveryCodes(auth.RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&models.TwoFactorRecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	rows := make([]models.TwoFactorRecoveryCode, 0, len(codes))
+	for _, code := range codes {
+		rows = append(rows, models.TwoFactorRecoveryCode{
+			ID:        uuid.NewString(),
+			UserID:    userID,
+			CodeHash:  auth.HashRecoveryCode(code),
+			CreatedAt: now,
+		})
+	}
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// loadEnabledTwoFactor 加载已启用的二次验证配置，未启用时写入错误响应
+func (h *AuthHandler) loadEnabledTwoFactor(c *gin.Context, userID string) (*models.UserTwoFactor, bool) {
+	var tf models.UserTwoFactor
+	if err := h.db.Where("user_id = ? AND enabled_at IS NOT NULL", userID).First(&tf).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "未启用二次验证"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询二次验证状态失败"})
+		return nil, false
+	}
+	return &tf, true
+}
+
+// EnrollTwoFactor 生成新的 TOTP 密钥（未确认前不生效），返回密钥与 otpauth 链接
+func (h *AuthHandler) EnrollTwoFactor(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	enabled, err := h.twoFactorEnabled(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询二次验证状态失败"})
+		return
+	}
+	if enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "已启用二次验证，请先关闭"})
+		return
+	}
+	var u models.User
+	if err := h.db.Select("id", "username").Where("id = ?", userID).First(&u).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成密钥失败"})
+		return
+	}
+	enc, err := auth.EncryptSecret(h.totpKey, secret)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "加密密钥失败"})
+		return
+	}
+	now := time.Now()
+	tf := models.UserTwoFactor{UserID: userID, SecretEnc: enc, CreatedAt: now, UpdatedAt: now}
+	// 重复登记时覆盖尚未确认的旧密钥
+	if err := h.db.Save(&tf).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存二次验证配置失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": auth.TOTPURI(h.totpIssuer, u.Username, secret),
+	})
+}
+
+// ConfirmTwoFactor 校验首个验证码后启用二次验证，并返回首批恢复码
+func (h *AuthHandler) ConfirmTwoFactor(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Code) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: 缺少验证码"})
+		return
+	}
+	var tf models.UserTwoFactor
+	if err := h.db.Where("user_id = ?", userID).First(&tf).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "请先登记二次验证"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询二次验证状态失败"})
+		return
+	}
+	if tf.EnabledAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "已启用二次验证"})
+		return
+	}
+	if !h.verifyTOTP(&tf, req.Code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "验证码错误"})
+		return
+	}
+
+	var codes []string
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.UserTwoFactor{}).
+			Where("user_id = ?", userID).
+			Update("enabled_at", time.Now()).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "启用二次验证失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已启用二次验证", "recovery_codes": codes})
+}
+
+// DisableTwoFactor 校验验证码或恢复码后关闭二次验证
+func (h *AuthHandler) DisableTwoFactor(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求体格式错误"})
+		return
+	}
+	tf, ok := h.loadEnabledTwoFactor(c, userID)
+	if !ok {
+		return
+	}
+	if !h.verifySecondFactor(tf, req.Code, req.RecoveryCode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "验证码错误"})
+		return
+	}
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.TwoFactorRecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.UserTwoFactor{}).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "关闭二次验证失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已关闭二次验证"})
+}
+
+// RegenerateRecoveryCodes 校验验证码后重新生成恢复码，旧恢复码全部作废
+func (h *AuthHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Code) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: 缺少验证码"})
+		return
+	}
+	tf, ok := h.loadEnabledTwoFactor(c, userID)
+	if !ok {
+		return
+	}
+	if !h.verifyTOTP(tf, req.Code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "验证码错误"})
+		return
+	}
+	var codes []string
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成恢复码失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// LoginTwoFactor 登录第二步：校验挑战令牌与验证码（或恢复码）后签发访问令牌与刷新令牌
+func (h *AuthHandler) LoginTwoFactor(c *gin.Context) {
+	var req TwoFactorLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: 缺少 challenge_token"})
+		return
+	}
+	claims, err := auth.ParseChallengeToken(req.ChallengeToken, h.keys)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "挑战令牌无效或已过期"})
+		return
+	}
+	var u models.User
+	if err := h.db.Where("id = ?", claims.UserID).First(&u).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "关联用户不存在"})
+		return
+	}
+	// 验证码失败与密码失败共用锁定计数，防止持有密码者穷举验证码
+	if lockedFor := h.loginGuard.LockedFor(c.Request.Context(), u.Username); lockedFor > 0 {
+		h.respondLocked(c, lockedFor)
+		return
+	}
+	tf, ok := h.loadEnabledTwoFactor(c, u.ID)
+	if !ok {
+		return
+	}
+	if !h.verifySecondFactor(tf, req.Code, req.RecoveryCode) {
+		if lockedFor := h.loginGuard.RecordFailure(c.Request.Context(), u.Username); lockedFor > 0 {
+			h.respondLocked(c, lockedFor)
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "验证码错误"})
+		return
+	}
+	h.issueLoginTokens(c, &u)
+}
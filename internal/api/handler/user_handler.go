@@ -25,9 +25,10 @@ type UserHandler struct {
 	avatarCfg config.Avatar
 	hub       *Hub
 	cache     *cachepkg.RedisClient
+	presence  *Presence
 }
 
-func NewUserHandler(db *gorm.DB, cfg config.File, avatarCfg config.Avatar, objStorage serverstorage.ObjectStorage, bucket string, hub *Hub, cache *cachepkg.RedisClient) *UserHandler {
+func NewUserHandler(db *gorm.DB, cfg config.File, avatarCfg config.Avatar, objStorage serverstorage.ObjectStorage, bucket string, hub *Hub, cache *cachepkg.RedisClient, presence *Presence) *UserHandler {
 	thumb := filesvc.ThumbnailOptions{
 		MaxDimension:   cfg.Thumbnail.MaxDimensionOrDefault(),
 		JPEGQuality:    cfg.Thumbnail.JPEGQualityOrDefault(),
@@ -41,6 +42,7 @@ func NewUserHandler(db *gorm.DB, cfg config.File, avatarCfg config.Avatar, objSt
 		avatarCfg: avatarCfg,
 		hub:       hub,
 		cache:     cache,
+		presence:  presence,
 	}
 }
 
@@ -343,6 +345,14 @@ func (h *UserHandler) AddFriend(c *gin.Context) {
 		return
 	}
 
+	if blocked, err := isBlockedBetween(h.db, currentUserID, target.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询拉黑关系失败"})
+		return
+	} else if blocked {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无法添加该用户为好友"})
+		return
+	}
+
 	a, b := currentUserID, target.ID
 	if a > b {
 		a, b = b, a
@@ -557,17 +567,45 @@ func (h *UserHandler) ListFriends(c *gin.Context) {
 		userMap[u.ID] = u
 	}
 
+	// 已被当前用户拉黑的好友不展示在线状态
+	var blockedIDs []string
+	h.db.Model(&models.Block{}).Where("user_id = ? AND blocked_user_id IN ?", currentUserID, friendIDs).Pluck("blocked_user_id", &blockedIDs)
+	blockedSet := make(map[string]bool, len(blockedIDs))
+	for _, id := range blockedIDs {
+		blockedSet[id] = true
+	}
+
+	// 在线状态以各节点的连接心跳为准，数据库中的 status 仅为最后一次记录
+	presenceCtx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+	defer cancel()
 	resp := make([]gin.H, 0, len(friendIDs))
 	for _, id := range friendIDs {
 		if u, ok := userMap[id]; ok {
-			resp = append(resp, gin.H{
+			online := u.Status == "active"
+			if h.presence != nil {
+				online = h.presence.IsOnline(presenceCtx, &u)
+			}
+			item := gin.H{
 				"id":                   u.ID,
 				"user_code":            u.UserCode,
 				"username":             u.Username,
-				"status":               u.Status,
+				"status":               "offline",
+				"online":               false,
+				"blocked":              blockedSet[id],
 				"avatar_attachment_id": u.AvatarAttachmentID,
 				"room_id":              roomMap[id],
-			})
+			}
+			if !blockedSet[id] {
+				ev := presenceEventFor(&u, online)
+				if online {
+					item["status"] = "active"
+				}
+				item["online"] = online
+				item["last_seen_at"] = ev.LastSeenAt
+				item["status_text"] = ev.StatusText
+				item["status_emoji"] = ev.StatusEmoji
+			}
+			resp = append(resp, item)
 		}
 	}
 
@@ -663,6 +701,15 @@ func (h *UserHandler) RespondFriendRequest(c *gin.Context) {
 	}
 
 	now := time.Now()
+	if req.Action == "accept" {
+		if blocked, err := isBlockedBetween(h.db, fr.FromUserID, fr.ToUserID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询拉黑关系失败"})
+			return
+		} else if blocked {
+			c.JSON(http.StatusForbidden, gin.H{"error": "无法接受该好友请求"})
+			return
+		}
+	}
 	if req.Action == "reject" {
 		if err := h.db.Model(&fr).Updates(map[string]interface{}{
 			"status":       models.FriendRequestRejected,
@@ -0,0 +1,359 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"ququchat/internal/models"
+	"ququchat/internal/server/auth"
+	"ququchat/internal/server/notify"
+)
+
+type SendVerificationRequest struct {
+	Channel string `json:"channel" binding:"required"`
+}
+
+type ConfirmVerificationRequest struct {
+	Channel string `json:"channel" binding:"required"`
+	Code    string `json:"code" binding:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	Account string `json:"account" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+// verificationTarget 返回通道对应的验证目的与用户当前联系方式
+func verificationTarget(u *models.User, channel string) (models.VerificationPurpose, string, bool) {
+	switch channel {
+	case notify.ChannelEmail:
+		if u.Email != nil && strings.TrimSpace(*u.Email) != "" {
+			return models.VerificationPurposeEmail, *u.Email, true
+		}
+	case notify.ChannelPhone:
+		if u.Phone != nil && strings.TrimSpace(*u.Phone) != "" {
+			return models.VerificationPurposePhone, *u.Phone, true
+		}
+	}
+	return "", "", false
+}
+
+// recentlySent 限制同一用户同一用途的发送频率
+func (h *AuthHandler) recentlySent(userID string, purpose models.VerificationPurpose) (bool, error) {
+	var count int64
+	err := h.db.Model(&models.VerificationToken{}).
+		Where("user_id = ? AND purpose = ? AND created_at > ?", userID, purpose, time.Now().Add(-auth.VerificationResendInterval)).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// issueVerificationToken 作废该用途下未使用的旧令牌并保存新令牌哈希
+func (h *AuthHandler) issueVerificationToken(userID string, purpose models.VerificationPurpose, target, token string, ttl time.Duration) error {
+	now := time.Now()
+	return h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.VerificationToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+			Update("used_at", &now).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.VerificationToken{
+			ID:        uuid.NewString(),
+			UserID:    userID,
+			Purpose:   purpose,
+			Target:    target,
+			TokenHash: auth.HashVerificationToken(token),
+			ExpiresAt: now.Add(ttl),
+			CreatedAt: now,
+		}).Error
+	})
+}
+
+// SendVerificationCode 向当前用户的邮箱或手机号发送验证码
+func (h *AuthHandler) SendVerificationCode(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var req SendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: channel 为 email 或 phone"})
+		return
+	}
+	var u models.User
+	if err := h.db.Where("id = ?", userID).First(&u).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询用户失败"})
+		return
+	}
+	purpose, target, ok := verificationTarget(&u, req.Channel)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "未绑定该联系方式"})
+		return
+	}
+	if (purpose == models.VerificationPurposeEmail && u.EmailVerifiedAt != nil) ||
+		(purpose == models.VerificationPurposePhone && u.PhoneVerifiedAt != nil) {
+		c.JSON(http.StatusConflict, gin.H{"error": "该联系方式已验证"})
+		return
+	}
+	if recent, err := h.recentlySent(userID, purpose); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询验证码失败"})
+		return
+	} else if recent {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "发送过于频繁，请稍后再试"})
+		return
+	}
+
+	code, err := auth.GenerateVerificationCode()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成验证码失败"})
+		return
+	}
+	if err := h.issueVerificationToken(userID, purpose, target, code, auth.VerificationCodeTTL); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存验证码失败"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 15*time.Second)
+	defer cancel()
+	err = h.notifier.Send(ctx, notify.Message{
+		Channel: req.Channel,
+		To:      target,
+		Subject: "QuQuChat 验证码",
+		Body:    fmt.Sprintf("您的验证码为 %s，%d 分钟内有效。如非本人操作请忽略。", code, int(auth.VerificationCodeTTL.Minutes())),
+	})
+	if err != nil {
+		if errors.Is(err, notify.ErrUnsupportedChannel) {
+			c.JSON(http.StatusNotImplemented, gin.H{"error": "暂不支持该通道发送验证码"})
+			return
+		}
+		log.Printf("send verification code failed user=%s channel=%s err=%v", userID, req.Channel, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "验证码发送失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "验证码已发送", "expires_in": int(auth.VerificationCodeTTL.Seconds())})
+}
+
+// ConfirmVerificationCode 校验验证码并标记邮箱/手机号已验证；错误次数超限后验证码作废
+func (h *AuthHandler) ConfirmVerificationCode(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	var req ConfirmVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: 缺少 channel 或 code"})
+		return
+	}
+	var u models.User
+	if err := h.db.Where("id = ?", userID).First(&u).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询用户失败"})
+		return
+	}
+	purpose, target, ok := verificationTarget(&u, req.Channel)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "未绑定该联系方式"})
+		return
+	}
+
+	now := time.Now()
+	var token models.VerificationToken
+	if err := h.db.Where("user_id = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", userID, purpose, now).
+		Order("created_at DESC").
+		First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "验证码无效或已过期"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询验证码失败"})
+		return
+	}
+	// 联系方式在发送后被修改时验证码失效
+	if token.Target != target {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "验证码无效或已过期"})
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(token.TokenHash), []byte(auth.HashVerificationToken(req.Code))) != 1 {
+		updates := map[string]interface{}{"attempts": gorm.Expr("attempts + 1")}
+		if token.Attempts+1 >= auth.VerificationMaxAttempts {
+			updates["used_at"] = &now
+		}
+		h.db.Model(&models.VerificationToken{}).Where("id = ?", token.ID).Updates(updates)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "验证码错误"})
+		return
+	}
+
+	column := "email_verified_at"
+	if purpose == models.VerificationPurposePhone {
+		column = "phone_verified_at"
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.VerificationToken{}).
+			Where("id = ? AND used_at IS NULL", token.ID).
+			Update("used_at", &now)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Model(&models.User{}).Where("id = ?", userID).Update(column, &now).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "验证码无效或已过期"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "验证失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "验证成功", "channel": req.Channel})
+}
+
+// ForgotPassword 向已验证的邮箱（优先）或手机号发送一次性重置令牌
+// 无论账号是否存在都返回相同响应，避免枚举用户
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Account) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: 缺少 account"})
+		return
+	}
+	account := strings.TrimSpace(req.Account)
+	resp := gin.H{"message": "如果账号存在且已验证邮箱或手机号，重置信息已发送"}
+
+	var u models.User
+	if err := h.db.Where("username = ? OR email = ? OR phone = ?", account, account, account).First(&u).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("forgot password lookup failed err=%v", err)
+		}
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	var msg notify.Message
+	switch {
+	case u.Email != nil && u.EmailVerifiedAt != nil:
+		msg = notify.Message{Channel: notify.ChannelEmail, To: *u.Email}
+	case u.Phone != nil && u.PhoneVerifiedAt != nil:
+		msg = notify.Message{Channel: notify.ChannelPhone, To: *u.Phone}
+	default:
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	if recent, err := h.recentlySent(u.ID, models.VerificationPurposePasswordReset); err != nil || recent {
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	token, err := auth.GenerateRefreshToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成重置令牌失败"})
+		return
+	}
+	if err := h.issueVerificationToken(u.ID, models.VerificationPurposePasswordReset, msg.To, token, auth.PasswordResetTTL); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存重置令牌失败"})
+		return
+	}
+	msg.Subject = "QuQuChat 密码重置"
+	msg.Body = fmt.Sprintf("您正在重置 %s 的密码，重置令牌为:\n%s\n%d 分钟内有效且仅可使用一次。如非本人操作请忽略。",
+		u.Username, token, int(auth.PasswordResetTTL.Minutes()))
+	// 异步发送，响应时间不随账号是否存在而变化
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := h.notifier.Send(ctx, msg); err != nil {
+			log.Printf("send password reset failed user=%s channel=%s err=%v", u.ID, msg.Channel, err)
+		}
+	}()
+	c.JSON(http.StatusOK, resp)
+}
+
+// ResetPassword 使用重置令牌设置新密码，并吊销该用户全部登录会话
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: 缺少 token 或 new_password"})
+		return
+	}
+	if len(req.NewPassword) < 6 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "密码至少6位"})
+		return
+	}
+	now := time.Now()
+	var token models.VerificationToken
+	if err := h.db.Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?",
+		auth.HashVerificationToken(req.Token), models.VerificationPurposePasswordReset, now).
+		First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "重置令牌无效或已过期"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询重置令牌失败"})
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "密码哈希失败"})
+		return
+	}
+
+	// 密码变更与会话吊销在同一事务中完成，旧设备的刷新令牌随即失效
+	var u models.User
+	var sessionIDs []string
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.VerificationToken{}).
+			Where("id = ? AND used_at IS NULL", token.ID).
+			Update("used_at", &now)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if err := tx.Model(&models.User{}).Where("id = ?", token.UserID).Update("password_hash", string(hash)).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("id = ?", token.UserID).First(&u).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.AuthSession{}).
+			Where("user_id = ? AND revoked_at IS NULL", u.ID).
+			Pluck("id", &sessionIDs).Error; err != nil {
+			return err
+		}
+		if len(sessionIDs) == 0 {
+			return nil
+		}
+		return tx.Model(&models.AuthSession{}).
+			Where("user_id = ? AND id IN ?", u.ID, sessionIDs).
+			Update("revoked_at", &now).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "重置令牌无效或已过期"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "重置密码失败"})
+		return
+	}
+
+	// 访问令牌立即失效并断开实时连接
+	if err := h.revokeSessions(u.ID, sessionIDs); err != nil {
+		log.Printf("revoke sessions after password reset failed user=%s err=%v", u.ID, err)
+	}
+	h.loginGuard.Reset(c.Request.Context(), u.Username)
+	c.SetCookie("refresh_token", "", -1, "/", "", false, true)
+	c.JSON(http.StatusOK, gin.H{"message": "密码已重置，请重新登录", "revoked_sessions": len(sessionIDs)})
+}
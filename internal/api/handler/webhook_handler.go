@@ -0,0 +1,253 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"ququchat/internal/models"
+	webhooksvc "ququchat/internal/service/webhook"
+)
+
+// maxWebhooksPerRoom 单个群可注册的回调数量上限
+const maxWebhooksPerRoom = 10
+
+// WebhookHandler 群外发回调管理，仅群主可操作
+type WebhookHandler struct {
+	db         *gorm.DB
+	dispatcher *webhooksvc.Dispatcher
+}
+
+func NewWebhookHandler(db *gorm.DB, dispatcher *webhooksvc.Dispatcher) *WebhookHandler {
+	return &WebhookHandler{db: db, dispatcher: dispatcher}
+}
+
+type CreateWebhookRequest struct {
+	URL        string   `json:"url" binding:"required"`
+	EventTypes []string `json:"event_types" binding:"required"`
+}
+
+type WebhookDTO struct {
+	ID         string   `json:"id"`
+	RoomID     string   `json:"room_id"`
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	Active     bool     `json:"active"`
+	CreatedBy  string   `json:"created_by"`
+	CreatedAt  int64    `json:"created_at"`
+}
+
+func toWebhookDTO(w models.RoomWebhook) WebhookDTO {
+	return WebhookDTO{
+		ID:         w.ID,
+		RoomID:     w.RoomID,
+		URL:        w.URL,
+		EventTypes: strings.Fields(w.EventTypes),
+		Active:     w.Active,
+		CreatedBy:  w.CreatedBy,
+		CreatedAt:  w.CreatedAt.Unix(),
+	}
+}
+
+type WebhookDeliveryDTO struct {
+	models.WebhookDelivery
+	AttemptLog []models.WebhookDeliveryAttempt `json:"attempt_log"`
+}
+
+// requireGroupOwner 校验当前用户为群主，失败时已写入响应
+func (h *WebhookHandler) requireGroupOwner(c *gin.Context) (string, bool) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return "", false
+	}
+	groupID := c.Param("group_id")
+	var room models.Room
+	if err := h.db.Select("id", "owner_user_id").Where("id = ? AND room_type = ?", groupID, models.RoomTypeGroup).First(&room).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "群不存在"})
+			return "", false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询群信息失败"})
+		return "", false
+	}
+	if room.OwnerUserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只有群主可以管理回调"})
+		return "", false
+	}
+	return room.ID, true
+}
+
+// CreateWebhook 注册群事件回调，签名密钥仅在创建时返回一次
+func (h *WebhookHandler) CreateWebhook(c *gin.Context) {
+	roomID, ok := h.requireGroupOwner(c)
+	if !ok {
+		return
+	}
+	var req CreateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: 缺少 url 或 event_types"})
+		return
+	}
+	rawURL := strings.TrimSpace(req.URL)
+	if len(rawURL) > 2048 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "回调地址过长"})
+		return
+	}
+	if err := webhooksvc.ValidateURL(rawURL, h.dispatcher.AllowPrivateTargets()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "回调地址无效或不允许访问"})
+		return
+	}
+	eventTypes, err := webhooksvc.NormalizeEventTypes(req.EventTypes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "事件类型无效: " + err.Error()})
+		return
+	}
+	var count int64
+	if err := h.db.Model(&models.RoomWebhook{}).Where("room_id = ?", roomID).Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询回调失败"})
+		return
+	}
+	if count >= maxWebhooksPerRoom {
+		c.JSON(http.StatusConflict, gin.H{"error": "回调数量已达上限"})
+		return
+	}
+	secret, err := webhooksvc.GenerateSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成签名密钥失败"})
+		return
+	}
+	now := time.Now()
+	hook := models.RoomWebhook{
+		ID:         uuid.NewString(),
+		RoomID:     roomID,
+		CreatedBy:  c.GetString("user_id"),
+		URL:        rawURL,
+		Secret:     secret,
+		EventTypes: strings.Join(eventTypes, " "),
+		Active:     true,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := h.db.Create(&hook).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建回调失败"})
+		return
+	}
+	h.dispatcher.Invalidate(roomID)
+	c.JSON(http.StatusCreated, gin.H{"webhook": toWebhookDTO(hook), "secret": secret})
+}
+
+// ListWebhooks 列出群内已注册的回调
+func (h *WebhookHandler) ListWebhooks(c *gin.Context) {
+	roomID, ok := h.requireGroupOwner(c)
+	if !ok {
+		return
+	}
+	var hooks []models.RoomWebhook
+	if err := h.db.Where("room_id = ?", roomID).Order("created_at ASC").Find(&hooks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询回调失败"})
+		return
+	}
+	out := make([]WebhookDTO, 0, len(hooks))
+	for _, w := range hooks {
+		out = append(out, toWebhookDTO(w))
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": out})
+}
+
+// DeleteWebhook 删除回调及其投递记录，未完成的投递不再发送
+func (h *WebhookHandler) DeleteWebhook(c *gin.Context) {
+	roomID, ok := h.requireGroupOwner(c)
+	if !ok {
+		return
+	}
+	webhookID := c.Param("webhook_id")
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("id = ? AND room_id = ?", webhookID, roomID).Delete(&models.RoomWebhook{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		deliveryIDs := tx.Model(&models.WebhookDelivery{}).Select("id").Where("webhook_id = ?", webhookID)
+		if err := tx.Where("delivery_id IN (?)", deliveryIDs).Delete(&models.WebhookDeliveryAttempt{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("webhook_id = ?", webhookID).Delete(&models.WebhookDelivery{}).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "回调不存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除回调失败"})
+		return
+	}
+	h.dispatcher.Invalidate(roomID)
+	c.JSON(http.StatusOK, gin.H{"message": "回调已删除"})
+}
+
+// ListWebhookDeliveries 查询回调的最近投递记录及每次尝试结果，可按 status 过滤
+func (h *WebhookHandler) ListWebhookDeliveries(c *gin.Context) {
+	roomID, ok := h.requireGroupOwner(c)
+	if !ok {
+		return
+	}
+	webhookID := c.Param("webhook_id")
+	var count int64
+	if err := h.db.Model(&models.RoomWebhook{}).Where("id = ? AND room_id = ?", webhookID, roomID).Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询回调失败"})
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "回调不存在"})
+		return
+	}
+	limit := 20
+	if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 {
+		limit = v
+	}
+	if limit > 100 {
+		limit = 100
+	}
+	q := h.db.Where("webhook_id = ?", webhookID)
+	if status := strings.TrimSpace(c.Query("status")); status != "" {
+		q = q.Where("status = ?", status)
+	}
+	var deliveries []models.WebhookDelivery
+	if err := q.Order("created_at DESC").Limit(limit).Find(&deliveries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询投递记录失败"})
+		return
+	}
+	ids := make([]string, 0, len(deliveries))
+	for _, d := range deliveries {
+		ids = append(ids, d.ID)
+	}
+	attemptsByDelivery := make(map[string][]models.WebhookDeliveryAttempt, len(ids))
+	if len(ids) > 0 {
+		var attempts []models.WebhookDeliveryAttempt
+		if err := h.db.Where("delivery_id IN ?", ids).Order("attempt_no ASC").Find(&attempts).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询投递记录失败"})
+			return
+		}
+		for _, a := range attempts {
+			attemptsByDelivery[a.DeliveryID] = append(attemptsByDelivery[a.DeliveryID], a)
+		}
+	}
+	out := make([]WebhookDeliveryDTO, 0, len(deliveries))
+	for _, d := range deliveries {
+		attempts := attemptsByDelivery[d.ID]
+		if attempts == nil {
+			attempts = []models.WebhookDeliveryAttempt{}
+		}
+		out = append(out, WebhookDeliveryDTO{WebhookDelivery: d, AttemptLog: attempts})
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": out})
+}
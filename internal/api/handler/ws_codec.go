@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/gorilla/websocket"
+	"github.com/ugorji/go/codec"
+)
+
+// WebSocket 子协议（Sec-WebSocket-Protocol），未协商子协议时使用 JSON 文本帧
+const (
+	wsSubprotocolMsgpack = "ququchat.v1.msgpack"
+	wsSubprotocolJSON    = "ququchat.v1.json"
+)
+
+// wsCompressMinBytes 协商了 permessage-deflate 时，小于该长度的帧不压缩
+const wsCompressMinBytes = 256
+
+// wsCodec 连接的帧编解码。帧统一以带 json 标签的结构体定义（OutgoingMessage、MessageAck 等），
+// 构造时经 newFrame 编为 JSON 用于跨节点路由与排队，写出时再由连接的 codec 转为线上格式，
+// 每帧每种格式只转换一次；读入时按同一组结构体解码，两种格式的字段名一致
+type wsCodec interface {
+	MessageType() int
+	Decode(data []byte, v interface{}) error
+	EncodeFrame(f *wsFrame) ([]byte, error)
+}
+
+// marshalFrame 将下行帧编为规范 JSON
+func marshalFrame(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) MessageType() int { return websocket.TextMessage }
+
+func (jsonCodec) Decode(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+func (jsonCodec) EncodeFrame(f *wsFrame) ([]byte, error) { return f.data, nil }
+
+// msgpackCodec MessagePack 二进制帧，结构体字段沿用 json 标签
+type msgpackCodec struct {
+	json    *codec.JsonHandle
+	msgpack *codec.MsgpackHandle
+}
+
+func newMsgpackCodec() msgpackCodec {
+	mapType := reflect.TypeOf(map[string]interface{}(nil))
+	jh := &codec.JsonHandle{}
+	jh.MapType = mapType
+	// 整数保持为 int64，避免 sequence_id 等大整数经 float64 丢失精度
+	jh.PreferFloat = false
+	jh.SignedInteger = true
+	mh := &codec.MsgpackHandle{}
+	mh.MapType = mapType
+	mh.RawToString = true
+	mh.WriteExt = true
+	return msgpackCodec{json: jh, msgpack: mh}
+}
+
+func (msgpackCodec) MessageType() int { return websocket.BinaryMessage }
+
+func (m msgpackCodec) Decode(data []byte, v interface{}) error {
+	return codec.NewDecoderBytes(data, m.msgpack).Decode(v)
+}
+
+// EncodeFrame 同一帧投递给多个二进制连接时共享首次转换的结果
+func (m msgpackCodec) EncodeFrame(f *wsFrame) ([]byte, error) {
+	f.msgpackOnce.Do(func() {
+		f.msgpack, f.msgpackErr = m.transcode(f.data)
+	})
+	return f.msgpack, f.msgpackErr
+}
+
+// transcode 将规范 JSON 帧转为 MessagePack
+func (m msgpackCodec) transcode(frame []byte) ([]byte, error) {
+	var v interface{}
+	if err := codec.NewDecoderBytes(frame, m.json).Decode(&v); err != nil {
+		return nil, err
+	}
+	var out []byte
+	if err := codec.NewEncoderBytes(&out, m.msgpack).Encode(v); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+var (
+	wsJSONCodec    wsCodec = jsonCodec{}
+	wsMsgpackCodec wsCodec = newMsgpackCodec()
+)
+
+// codecForSubprotocol 按握手协商出的子协议选择编解码
+func codecForSubprotocol(subprotocol string) wsCodec {
+	if subprotocol == wsSubprotocolMsgpack {
+		return wsMsgpackCodec
+	}
+	return wsJSONCodec
+}
+
+// decodeFrame 解码上行帧；文本帧始终按 JSON 解析，便于二进制连接调试
+func (c *Client) decodeFrame(messageType int, data []byte, v interface{}) error {
+	if messageType == websocket.TextMessage {
+		return wsJSONCodec.Decode(data, v)
+	}
+	return c.codec.Decode(data, v)
+}
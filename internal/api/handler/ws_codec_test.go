@@ -0,0 +1,219 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/ugorji/go/codec"
+)
+
+// wsLargeSeq 超出 float64 精度的序号，经浮点中转会变成 1<<53
+const wsLargeSeq = int64(1)<<53 + 1
+
+func mapKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// encodeBoth 返回帧的 JSON 与 MessagePack 编码
+func encodeBoth(t *testing.T, v interface{}) (*wsFrame, []byte) {
+	t.Helper()
+	f, err := newFrame(v)
+	if err != nil {
+		t.Fatalf("newFrame: %v", err)
+	}
+	out, err := wsMsgpackCodec.EncodeFrame(f)
+	if err != nil {
+		t.Fatalf("EncodeFrame: %v", err)
+	}
+	return f, out
+}
+
+func TestMsgpackCodec_RoundTrip(t *testing.T) {
+	parentSeq := wsLargeSeq - 1
+	size := int64(5 << 30)
+	msg := &OutgoingMessage{
+		ID:               "m1",
+		Type:             "group_message",
+		FromUser:         "u1",
+		RoomID:           "r1",
+		Content:          "你好",
+		Attachment:       &AttachmentPayload{AttachmentID: "a1", SizeBytes: &size, CreatedAt: 1700000000},
+		ParentMessageID:  "m0",
+		ParentSequenceID: &parentSeq,
+		Timestamp:        1700000000,
+		SequenceID:       wsLargeSeq,
+		ClientMsgID:      "c1",
+	}
+	cases := []struct {
+		name string
+		v    interface{}
+		out  interface{}
+	}{
+		{"outgoing message", msg, &OutgoingMessage{}},
+		{"message ack", &MessageAck{Type: "message_ack", ClientMsgID: "c1", OK: true, MessageID: "m1", RoomID: "r1", SequenceID: wsLargeSeq, Timestamp: 1700000000, Duplicate: true, Message: msg}, &MessageAck{}},
+		{"rejection ack", &MessageAck{Type: "message_ack", ClientMsgID: "c2", Code: ackCodeRateLimited, Error: "发送过于频繁", RetryAfterMs: 1500}, &MessageAck{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, bin := encodeBoth(t, tc.v)
+
+			// 字段名与 JSON 帧一致
+			var fromJSON, fromMsgpack map[string]interface{}
+			if err := json.Unmarshal(f.data, &fromJSON); err != nil {
+				t.Fatalf("json decode: %v", err)
+			}
+			mh := &codec.MsgpackHandle{}
+			mh.RawToString = true
+			if err := codec.NewDecoderBytes(bin, mh).Decode(&fromMsgpack); err != nil {
+				t.Fatalf("msgpack decode: %v", err)
+			}
+			if jk, mk := mapKeys(fromJSON), mapKeys(fromMsgpack); !reflect.DeepEqual(jk, mk) {
+				t.Fatalf("field names differ: json=%v msgpack=%v", jk, mk)
+			}
+
+			// 按同一结构体解码后与原值一致，int64 不经浮点
+			if err := wsMsgpackCodec.Decode(bin, tc.out); err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !reflect.DeepEqual(reflect.ValueOf(tc.out).Elem().Interface(), reflect.ValueOf(tc.v).Elem().Interface()) {
+				t.Fatalf("round trip mismatch:\n got %+v\nwant %+v", tc.out, tc.v)
+			}
+		})
+	}
+}
+
+func TestMsgpackCodec_PayloadJSONAsMap(t *testing.T) {
+	_, bin := encodeBoth(t, OutgoingMessage{Type: "bot_message", PayloadJSON: []byte(`{"card":{"id":9007199254740993}}`)})
+	var got struct {
+		PayloadJSON map[string]map[string]int64 `json:"payload_json"`
+	}
+	if err := wsMsgpackCodec.Decode(bin, &got); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if got.PayloadJSON["card"]["id"] != wsLargeSeq {
+		t.Fatalf("payload_json = %v", got.PayloadJSON)
+	}
+}
+
+func TestMsgpackCodec_EncodesOncePerFrame(t *testing.T) {
+	f, first := encodeBoth(t, OutgoingMessage{Type: "group_message", SequenceID: 1})
+	again, err := wsMsgpackCodec.EncodeFrame(f)
+	if err != nil {
+		t.Fatalf("EncodeFrame: %v", err)
+	}
+	if &first[0] != &again[0] {
+		t.Fatalf("second encode should reuse the cached bytes")
+	}
+	if out, _ := wsJSONCodec.EncodeFrame(f); &out[0] != &f.data[0] {
+		t.Fatalf("json codec should write the canonical frame as is")
+	}
+}
+
+func TestDecodeFrame_BinaryAndText(t *testing.T) {
+	parentSeq := wsLargeSeq
+	want := IncomingMessage{Type: "group_message", RoomID: "r1", Content: "hi", ParentSequenceID: &parentSeq, ClientMsgID: "c1"}
+	text, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var bin []byte
+	if err := codec.NewEncoderBytes(&bin, &codec.MsgpackHandle{}).Encode(map[string]interface{}{
+		"type": "group_message", "room_id": "r1", "content": "hi", "parent_sequence_id": wsLargeSeq, "client_msg_id": "c1",
+	}); err != nil {
+		t.Fatalf("msgpack encode: %v", err)
+	}
+
+	cases := []struct {
+		name        string
+		codec       wsCodec
+		messageType int
+		data        []byte
+	}{
+		{"json connection text", wsJSONCodec, websocket.TextMessage, text},
+		{"msgpack connection binary", wsMsgpackCodec, websocket.BinaryMessage, bin},
+		{"msgpack connection text", wsMsgpackCodec, websocket.TextMessage, text},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &Client{codec: tc.codec}
+			var got IncomingMessage
+			if err := c.decodeFrame(tc.messageType, tc.data, &got); err != nil {
+				t.Fatalf("decodeFrame: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestUpgrader_SubprotocolNegotiation(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		cd := codecForSubprotocol(conn.Subprotocol())
+		f, _ := newFrame(MessageAck{Type: "message_ack", OK: true, SequenceID: wsLargeSeq})
+		out, err := cd.EncodeFrame(f)
+		if err != nil {
+			return
+		}
+		_ = conn.WriteMessage(cd.MessageType(), out)
+	}))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	cases := []struct {
+		name    string
+		offered []string
+		want    string
+		msgType int
+	}{
+		{"none offered falls back to json text", nil, "", websocket.TextMessage},
+		{"msgpack", []string{wsSubprotocolMsgpack}, wsSubprotocolMsgpack, websocket.BinaryMessage},
+		{"json", []string{wsSubprotocolJSON}, wsSubprotocolJSON, websocket.TextMessage},
+		{"server prefers msgpack", []string{wsSubprotocolJSON, wsSubprotocolMsgpack}, wsSubprotocolMsgpack, websocket.BinaryMessage},
+		{"unknown only", []string{"ququchat.v2.cbor"}, "", websocket.TextMessage},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dialer := websocket.Dialer{Subprotocols: tc.offered}
+			conn, resp, err := dialer.Dial(url, nil)
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			defer conn.Close()
+			if conn.Subprotocol() != tc.want || resp.Header.Get("Sec-WebSocket-Protocol") != tc.want {
+				t.Fatalf("subprotocol = %q (header %q), want %q", conn.Subprotocol(), resp.Header.Get("Sec-WebSocket-Protocol"), tc.want)
+			}
+			mt, data, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if mt != tc.msgType {
+				t.Fatalf("message type = %d, want %d", mt, tc.msgType)
+			}
+			client := &Client{codec: codecForSubprotocol(tc.want)}
+			var ack MessageAck
+			if err := client.decodeFrame(mt, data, &ack); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if ack.Type != "message_ack" || !ack.OK || ack.SequenceID != wsLargeSeq {
+				t.Fatalf("unexpected ack: %+v", ack)
+			}
+		})
+	}
+}
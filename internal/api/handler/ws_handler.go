@@ -18,10 +18,13 @@ import (
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 
+	"ququchat/internal/config"
+	"ququchat/internal/middleware"
 	"ququchat/internal/models"
 	cachepkg "ququchat/internal/server/cache"
 	taskservice "ququchat/internal/service"
 	tasksvc "ququchat/internal/service/task"
+	webhooksvc "ququchat/internal/service/webhook"
 )
 
 const (
@@ -44,231 +47,64 @@ type WsHandler struct {
 	doneConsumerMu  sync.Mutex
 	doneConsumerUp  bool
 	doneConsumerErr error
+	recallWindow    time.Duration
+	msgRate         int
+	msgBurst        int
+	webhooks        *webhooksvc.Dispatcher
+	presence        *Presence
 }
 
-func NewWsHandler(db *gorm.DB, hub *Hub, cacheClient *cachepkg.RedisClient, taskService *taskservice.MainService, streamHub *taskservice.AgentStreamHub, router *HubRouter) *WsHandler {
+func NewWsHandler(db *gorm.DB, hub *Hub, cacheClient *cachepkg.RedisClient, taskService *taskservice.MainService, streamHub *taskservice.AgentStreamHub, router *HubRouter, chatCfg config.Chat, rateCfg config.RateLimit, webhooks *webhooksvc.Dispatcher, presence *Presence) *WsHandler {
+	msgRate, msgBurst := 0, 0
+	if rateCfg.EnabledOrDefault() {
+		msgRate, msgBurst = rateCfg.WS.MessagesPerSecondOrDefault(), rateCfg.WS.BurstOrDefault()
+	}
 	if hub == nil {
 		hub = NewHub()
 	}
-	if hub.db == nil {
-		hub.db = db
-	}
-	if hub.cacheClient == nil {
-		hub.cacheClient = cacheClient
+	if presence == nil {
+		presence = NewPresence(db, hub, router, cacheClient)
 	}
 	return &WsHandler{
-		db:          db,
-		hub:         hub,
-		router:      router,
-		cacheClient: cacheClient,
-		taskService: taskService,
-		streamHub:   streamHub,
-	}
-}
-
-type Hub struct {
-	clients       map[*Client]bool
-	clientsByUser map[string]map[*Client]bool
-	register      chan *Client
-	unregister    chan *Client
-	direct        chan DirectMessage
-	broadcast     chan GroupMessage
-	db            *gorm.DB
-	cacheClient   *cachepkg.RedisClient
-}
-
-type DirectMessage struct {
-	FromUserID string
-	ToUserID   string
-	Data       []byte
-}
-
-type GroupMessage struct {
-	RoomID  string
-	UserIDs []string
-	Data    []byte
-}
-
-type SystemEvent struct {
-	Type  string `json:"type"`
-	Event string `json:"event"`
-}
-
-func NewHub() *Hub {
-	h := &Hub{
-		clients:       make(map[*Client]bool),
-		clientsByUser: make(map[string]map[*Client]bool),
-		register:      make(chan *Client),
-		unregister:    make(chan *Client),
-		direct:        make(chan DirectMessage),
-		broadcast:     make(chan GroupMessage),
-	}
-	go h.run()
-	return h
-}
-
-func (h *Hub) SendSystemEventToUser(userID string, event string) {
-	if userID == "" {
-		return
-	}
-	h.SendSystemEventToUsers([]string{userID}, event)
-}
-
-func (h *Hub) SendSystemEventToUsers(userIDs []string, event string) {
-	if h == nil || len(userIDs) == 0 || event == "" {
-		return
-	}
-	data, err := json.Marshal(SystemEvent{Type: "system_event", Event: event})
-	if err != nil {
-		log.Printf("failed to marshal system_event: %v", err)
-		return
-	}
-	h.broadcast <- GroupMessage{UserIDs: userIDs, Data: data}
-}
-
-func (h *Hub) SendDataToUser(userID string, data []byte) {
-	if userID == "" {
-		return
-	}
-	h.SendDataToUsers([]string{userID}, data)
-}
-
-func (h *Hub) SendDataToUsers(userIDs []string, data []byte) {
-	if h == nil || len(userIDs) == 0 || len(data) == 0 {
-		return
-	}
-	h.broadcast <- GroupMessage{UserIDs: userIDs, Data: data}
-}
-
-func (h *Hub) run() {
-	for {
-		select {
-		case c := <-h.register:
-			h.handleRegister(c)
-		case c := <-h.unregister:
-			h.removeClient(c)
-		case msg := <-h.direct:
-			if set, ok := h.clientsByUser[msg.ToUserID]; ok {
-				for c := range set {
-					select {
-					case c.send <- msg.Data:
-					default:
-						h.removeClient(c)
-					}
-				}
-			}
-			if set, ok := h.clientsByUser[msg.FromUserID]; ok {
-				for c := range set {
-					select {
-					case c.send <- msg.Data:
-					default:
-						h.removeClient(c)
-					}
-				}
-			}
-		case msg := <-h.broadcast:
-			for _, uid := range msg.UserIDs {
-				if set, ok := h.clientsByUser[uid]; ok {
-					for c := range set {
-						select {
-						case c.send <- msg.Data:
-						default:
-							h.removeClient(c)
-						}
-					}
-				}
-			}
-		}
+		db:           db,
+		hub:          hub,
+		router:       router,
+		cacheClient:  cacheClient,
+		taskService:  taskService,
+		streamHub:    streamHub,
+		recallWindow: chatCfg.RecallWindowDuration(),
+		msgRate:      msgRate,
+		msgBurst:     msgBurst,
+		webhooks:     webhooks,
+		presence:     presence,
 	}
 }
 
-func (h *Hub) handleRegister(c *Client) {
-	h.clients[c] = true
-	set, ok := h.clientsByUser[c.userID]
-	if !ok {
-		set = make(map[*Client]bool)
-		h.clientsByUser[c.userID] = set
-	}
-	set[c] = true
-	if len(set) == 1 {
-		h.updateUserStatus(c.userID, "active")
-	}
-}
-
-func (h *Hub) removeClient(c *Client) {
-	if _, ok := h.clients[c]; !ok {
-		return
-	}
-	delete(h.clients, c)
-	if set, ok := h.clientsByUser[c.userID]; ok {
-		delete(set, c)
-		if len(set) == 0 {
-			delete(h.clientsByUser, c.userID)
-			h.updateUserStatus(c.userID, "offline")
-		}
-	}
-	close(c.send)
-}
-
-func (h *Hub) updateUserStatus(userID, status string) {
-	if h == nil || h.db == nil || userID == "" || status == "" {
-		return
-	}
-	go func() {
-		if err := h.db.Model(&models.User{}).Where("id = ?", userID).Update("status", status).Error; err != nil {
-			log.Printf("ws update user status failed user=%s status=%s err=%v", userID, status, err)
-			return
-		}
-		friendIDs, err := h.listFriendIDs(userID)
-		if err != nil {
-			log.Printf("ws list friends for status update failed user=%s err=%v", userID, err)
-			return
-		}
-		if len(friendIDs) > 0 {
-			h.SendSystemEventToUsers(friendIDs, "friend_list_updated")
-		}
-	}()
+type Client struct {
+	hub       *Hub
+	router    *HubRouter
+	conn      *websocket.Conn
+	codec     wsCodec
+	queue     *sendQueue
+	userID    string
+	sessionID string
+	connID    string
+	// rooms 非空时只接收这些房间的群消息（受房间限制的 API 令牌）
+	rooms map[string]struct{}
+	// receiveOnly API 令牌连接只接收事件，发送消息走 REST 接口
+	receiveOnly bool
+	// lastHeartbeat/lastTyping 仅由读循环访问
+	lastHeartbeat time.Time
+	lastTyping    time.Time
 }
 
-func (h *Hub) listFriendIDs(userID string) ([]string, error) {
-	if h.cacheClient != nil {
-		cacheKey := h.cacheClient.BuildKey(cachepkg.FriendIDsKey(userID)...)
-		cacheCtx, cancel := context.WithTimeout(context.Background(), 120*time.Millisecond)
-		var cached []string
-		ok, err := h.cacheClient.GetJSON(cacheCtx, cacheKey, &cached)
-		cancel()
-		if err == nil && ok {
-			return cached, nil
-		}
-	}
-	var relations []models.Friendship
-	if err := h.db.Where("user_id_a = ? OR user_id_b = ?", userID, userID).Find(&relations).Error; err != nil {
-		return nil, err
-	}
-	friendIDs := make([]string, 0, len(relations))
-	for _, r := range relations {
-		if r.UserIDA == userID {
-			friendIDs = append(friendIDs, r.UserIDB)
-		} else {
-			friendIDs = append(friendIDs, r.UserIDA)
-		}
-	}
-	if h.cacheClient != nil {
-		cacheKey := h.cacheClient.BuildKey(cachepkg.FriendIDsKey(userID)...)
-		cacheCtx, cancel := context.WithTimeout(context.Background(), 120*time.Millisecond)
-		_ = h.cacheClient.SetJSON(cacheCtx, cacheKey, friendIDs, cachepkg.FriendIDsTTL)
-		cancel()
+// acceptsRoom 连接是否接收指定房间的消息；roomID 为空表示私聊
+func (c *Client) acceptsRoom(roomID string) bool {
+	if c.rooms == nil {
+		return true
 	}
-	return friendIDs, nil
-}
-
-type Client struct {
-	hub    *Hub
-	router *HubRouter
-	conn   *websocket.Conn
-	send   chan []byte
-	userID string
-	connID string
+	_, ok := c.rooms[roomID]
+	return roomID != "" && ok
 }
 
 type IncomingMessage struct {
@@ -279,6 +115,13 @@ type IncomingMessage struct {
 	AttachmentID     string `json:"attachment_id,omitempty"`
 	ParentMessageID  string `json:"parent_message_id,omitempty"`
 	ParentSequenceID *int64 `json:"parent_sequence_id,omitempty"`
+	SequenceID       int64  `json:"sequence_id,omitempty"`
+	MessageID        string `json:"message_id,omitempty"`
+	Emoji            string `json:"emoji,omitempty"`
+	// ClientMsgID 客户端生成的消息 ID，重发时携带相同值以去重
+	ClientMsgID string `json:"client_msg_id,omitempty"`
+	// Typing typing 帧的输入状态，缺省为 true
+	Typing *bool `json:"typing,omitempty"`
 }
 
 type OutgoingMessage struct {
@@ -295,6 +138,7 @@ type OutgoingMessage struct {
 	ParentSequenceID *int64             `json:"parent_sequence_id,omitempty"`
 	Timestamp        int64              `json:"timestamp"`
 	SequenceID       int64              `json:"sequence_id"`
+	ClientMsgID      string             `json:"client_msg_id,omitempty"`
 }
 
 type AgentCommandAck struct {
@@ -321,14 +165,22 @@ type AttachmentPayload struct {
 	CreatedAt         int64   `json:"created_at"`
 }
 
+// upgrader 协商 permessage-deflate 压缩；子协议按服务端列表顺序匹配客户端 Sec-WebSocket-Protocol，二进制优先
 var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
+	ReadBufferSize:    1024,
+	WriteBufferSize:   1024,
+	EnableCompression: true,
+	Subprotocols:      []string{wsSubprotocolMsgpack, wsSubprotocolJSON},
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
 }
 
+// Stats 本节点 WebSocket 投递指标：连接数、待发送帧数、各分片积压与丢弃/合并次数
+func (h *WsHandler) Stats(c *gin.Context) {
+	c.JSON(http.StatusOK, h.hub.Stats())
+}
+
 func (h *WsHandler) Handle(c *gin.Context) {
 	_ = h.StartTaskDoneConsumer(context.Background())
 	userID := c.GetString("user_id")
@@ -341,41 +193,62 @@ func (h *WsHandler) Handle(c *gin.Context) {
 		log.Printf("ws upgrade failed user=%s ip=%s err=%v", userID, c.ClientIP(), err)
 		return
 	}
-	log.Printf("ws connected user=%s ip=%s", userID, c.ClientIP())
+	log.Printf("ws connected user=%s ip=%s subprotocol=%q", userID, c.ClientIP(), conn.Subprotocol())
 	connID := uuid.NewString()
 	client := &Client{
-		hub:    h.hub,
-		router: h.router,
-		conn:   conn,
-		send:   make(chan []byte, 256),
-		userID: userID,
-		connID: connID,
-	}
-	client.hub.register <- client
+		hub:       h.hub,
+		router:    h.router,
+		conn:      conn,
+		codec:     codecForSubprotocol(conn.Subprotocol()),
+		queue:     newSendQueue(wsClientQueueSize, &h.hub.stats),
+		userID:    userID,
+		sessionID: c.GetString("session_id"),
+		connID:    connID,
+		// OnConnect 已写入心跳
+		lastHeartbeat: time.Now(),
+	}
+	principal, viaAPIToken := middleware.APITokenFromContext(c)
+	if viaAPIToken {
+		client.receiveOnly = true
+		if len(principal.RoomIDs) > 0 {
+			client.rooms = make(map[string]struct{}, len(principal.RoomIDs))
+			for _, id := range principal.RoomIDs {
+				client.rooms[id] = struct{}{}
+			}
+		}
+	}
+	client.hub.registerClient(client)
 	if h.router != nil {
 		roomIDs, _ := h.getUserRoomIDs(userID)
 		h.router.OnConnect(c.Request.Context(), userID, connID, roomIDs)
 	}
-
+	h.presence.Connected(userID)
 	go client.writeLoop()
 	go client.readLoop(h)
+	// API 令牌连接不推送离线补偿帧，历史消息通过 REST 接口拉取
+	if !viaAPIToken {
+		go h.pushSyncFrame(client, c.Query("since"))
+	}
 }
 
 func (c *Client) readLoop(h *WsHandler) {
 	defer func() {
-		c.hub.unregister <- c
+		c.hub.unregisterClient(c)
 		if c.router != nil {
 			c.router.OnDisconnect(context.Background(), c.userID, c.connID)
 		}
+		h.presence.Disconnected(c.userID)
 		_ = c.conn.Close()
 	}()
+	limiter := newWsMessageLimiter(h.msgRate, h.msgBurst)
 	c.conn.SetReadLimit(wsMaxMsgBytes)
 	_ = c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
 	c.conn.SetPongHandler(func(string) error {
+		c.heartbeat()
 		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
 	})
 	for {
-		_, data, err := c.conn.ReadMessage()
+		mt, data, err := c.conn.ReadMessage()
 		if err != nil {
 			if ce, ok := err.(*websocket.CloseError); ok {
 				log.Printf("ws read close user=%s code=%d text=%s", c.userID, ce.Code, ce.Text)
@@ -385,289 +258,105 @@ func (c *Client) readLoop(h *WsHandler) {
 			break
 		}
 		var msg IncomingMessage
-		if err := json.Unmarshal(data, &msg); err != nil {
+		if err := c.decodeFrame(mt, data, &msg); err != nil {
+			c.sendError("invalid_frame", "消息格式错误", 0)
 			continue
 		}
 		if msg.Type == "ping" {
-			resp, err := json.Marshal(map[string]interface{}{
+			c.heartbeat()
+			resp, err := newFrame(map[string]interface{}{
 				"type": "pong",
 				"ts":   time.Now().Unix(),
 			})
 			if err == nil {
-				select {
-				case c.send <- resp:
-				default:
-				}
+				c.enqueue(resp)
 			}
 			continue
 		}
 		if msg.Type == "pong" {
 			continue
 		}
+		if c.receiveOnly {
+			c.rejectFrame(&msg, "forbidden", "API 令牌连接仅接收事件，请通过 REST 接口发送消息", 0)
+			continue
+		}
+		// typing 帧自带节流，不占用消息发送配额
+		if msg.Type == "typing" {
+			h.handleTyping(c, &msg)
+			continue
+		}
+		if ok, wait := limiter.allow(time.Now()); !ok {
+			c.rejectFrame(&msg, ackCodeRateLimited, "发送过于频繁，请稍后再试", wait)
+			continue
+		}
 		if msg.Type == "friend_message" {
-			if msg.ToUser == "" || msg.Content == "" {
-				continue
-			}
-			if !h.areFriends(c.userID, msg.ToUser) {
-				continue
-			}
-			roomID, err := h.ensureDirectRoom(c.userID, msg.ToUser)
-			if err != nil {
-				continue
-			}
-			savedMsg, err := h.saveDirectMessage(roomID, c.userID, msg.Content, strings.TrimSpace(msg.ParentMessageID), msg.ParentSequenceID)
-			if err != nil {
-				continue
-			}
-			out := OutgoingMessage{
-				ID:       savedMsg.ID,
-				Type:     "friend_message",
-				FromUser: c.userID,
-				ToUser:   msg.ToUser,
-				RoomID:   roomID,
-				Content:  msg.Content,
-				ParentMessageID: func() string {
-					if savedMsg.ParentMessageID == nil {
-						return ""
-					}
-					return strings.TrimSpace(*savedMsg.ParentMessageID)
-				}(),
-				ParentSequenceID: savedMsg.ParentSequenceID,
-				Timestamp:        savedMsg.CreatedAt.Unix(),
-				SequenceID:       savedMsg.SequenceID,
-			}
-			b, err := json.Marshal(out)
-			if err != nil {
-				continue
-			}
-			c.routeDirect(c.userID, msg.ToUser, b)
+			h.handleFriendMessage(c, &msg)
 		} else if msg.Type == "group_message" {
-			if msg.RoomID == "" || msg.Content == "" {
-				continue
-			}
-			if strings.HasPrefix(strings.TrimSpace(msg.Content), "\\") {
-				if err := h.checkGroupPostingPermission(msg.RoomID, c.userID); err != nil {
-					continue
-				}
-				savedMsg, err := h.saveGroupMessage(msg.RoomID, c.userID, msg.Content, strings.TrimSpace(msg.ParentMessageID), msg.ParentSequenceID)
-				if err != nil {
-					continue
-				}
-				memberIDs, err := h.getGroupMemberIDs(msg.RoomID)
-				if err != nil {
-					continue
-				}
-				commandOut := OutgoingMessage{
-					ID:       savedMsg.ID,
-					Type:     "group_message",
-					FromUser: c.userID,
-					RoomID:   msg.RoomID,
-					Content:  msg.Content,
-					ParentMessageID: func() string {
-						if savedMsg.ParentMessageID == nil {
-							return ""
-						}
-						return strings.TrimSpace(*savedMsg.ParentMessageID)
-					}(),
-					ParentSequenceID: savedMsg.ParentSequenceID,
-					Timestamp:        savedMsg.CreatedAt.Unix(),
-					SequenceID:       savedMsg.SequenceID,
-				}
-				commandBroadcast, err := json.Marshal(commandOut)
-				if err == nil {
-					c.routeBroadcast(msg.RoomID, memberIDs, commandBroadcast)
-				}
-				if h.taskService == nil {
-					continue
-				}
-				userID := c.userID
-				roomID := msg.RoomID
-				requestID := taskservice.BuildWSCommandRequestID(userID, roomID, savedMsg.ID, savedMsg.SequenceID)
-				taskID, err := h.taskService.SubmitCommand(taskservice.SubmitCommandRequest{
-					RequestID:        requestID,
-					UserID:           userID,
-					RoomID:           roomID,
-					Content:          msg.Content,
-					ParentMessageID:  savedMsg.ID,
-					ParentSequenceID: savedMsg.SequenceID,
-				})
-				if err != nil {
-					h.publishAgentStreamEvent(taskservice.AgentStreamEvent{
-						EventType:        "agent.error",
-						RequestID:        requestID,
-						RoomID:           roomID,
-						UserID:           userID,
-						Status:           "failed",
-						Error:            err.Error(),
-						ParentMessageID:  savedMsg.ID,
-						ParentSequenceID: savedMsg.SequenceID,
-					})
-					log.Printf("submit command failed user=%s room=%s err=%v", userID, roomID, err)
-					if sendErr := h.sendRobotGroupMessage(roomID, err.Error(), nil, savedMsg.ID, &savedMsg.SequenceID); sendErr != nil {
-						log.Printf("send robot submit-failed message failed room=%s err=%v", roomID, sendErr)
-					}
-					continue
-				}
-				h.publishAgentStreamEvent(taskservice.AgentStreamEvent{
-					EventType:        "agent.start",
-					RequestID:        requestID,
-					RoomID:           roomID,
-					UserID:           userID,
-					Status:           "running",
-					Content:          strings.TrimSpace(msg.Content),
-					ParentMessageID:  savedMsg.ID,
-					ParentSequenceID: savedMsg.SequenceID,
-				})
-				h.sendAgentCommandAck(userID, roomID, requestID, taskID, savedMsg.ID, savedMsg.SequenceID)
-				continue
-			}
-			// Check if user is a member of the group and not muted
-			if err := h.checkGroupPostingPermission(msg.RoomID, c.userID); err != nil {
-				// Optionally send error back to user
-				continue
-			}
-			savedMsg, err := h.saveGroupMessage(msg.RoomID, c.userID, msg.Content, strings.TrimSpace(msg.ParentMessageID), msg.ParentSequenceID)
-			if err != nil {
-				continue
-			}
-
-			// Get all active members to broadcast
-			memberIDs, err := h.getGroupMemberIDs(msg.RoomID)
-			if err != nil {
+			h.handleGroupMessage(c, &msg)
+		} else if msg.Type == "file_message" || msg.Type == "image_message" {
+			h.handleAttachmentMessage(c, &msg)
+		} else if msg.Type == "message_delivered" || msg.Type == "message_read" {
+			if msg.RoomID == "" || msg.SequenceID <= 0 {
 				continue
 			}
-
-			out := OutgoingMessage{
-				ID:       savedMsg.ID,
-				Type:     "group_message",
-				FromUser: c.userID,
-				RoomID:   msg.RoomID,
-				Content:  msg.Content,
-				ParentMessageID: func() string {
-					if savedMsg.ParentMessageID == nil {
-						return ""
-					}
-					return strings.TrimSpace(*savedMsg.ParentMessageID)
-				}(),
-				ParentSequenceID: savedMsg.ParentSequenceID,
-				Timestamp:        savedMsg.CreatedAt.Unix(),
-				SequenceID:       savedMsg.SequenceID,
-			}
-			b, err := json.Marshal(out)
-			if err != nil {
+			h.handleReceipt(c, msg.Type, msg.RoomID, msg.SequenceID)
+		} else if msg.Type == "add_reaction" || msg.Type == "remove_reaction" {
+			if msg.MessageID == "" || strings.TrimSpace(msg.Emoji) == "" {
+				c.rejectMessageOp(strings.TrimSpace(msg.MessageID), ackCodeInvalidRequest, "message_id 和 emoji 不能为空")
 				continue
 			}
-			c.routeBroadcast(msg.RoomID, memberIDs, b)
-		} else if msg.Type == "file_message" || msg.Type == "image_message" {
-			if msg.AttachmentID == "" {
+			h.handleReaction(c, msg.Type == "add_reaction", strings.TrimSpace(msg.MessageID), strings.TrimSpace(msg.Emoji))
+		} else if msg.Type == "edit_message" {
+			if msg.MessageID == "" || strings.TrimSpace(msg.Content) == "" {
+				c.rejectMessageOp(msg.MessageID, ackCodeInvalidRequest, "message_id 和 content 不能为空")
 				continue
 			}
-			attachment, payload, payloadJSON, err := h.loadAttachmentPayload(c.userID, msg.AttachmentID)
-			if err != nil {
+			h.handleEditMessage(c, strings.TrimSpace(msg.MessageID), msg.Content)
+		} else if msg.Type == "recall_message" {
+			if msg.MessageID == "" {
+				c.rejectMessageOp("", ackCodeInvalidRequest, "message_id 不能为空")
 				continue
 			}
-			contentType := models.ContentTypeFile
-			outType := "file_message"
-			if isImageAttachment(attachment) {
-				contentType = models.ContentTypeImage
-				outType = "image_message"
-			}
-			if msg.ToUser != "" {
-				if !h.areFriends(c.userID, msg.ToUser) {
-					continue
-				}
-				roomID, err := h.ensureDirectRoom(c.userID, msg.ToUser)
-				if err != nil {
-					continue
-				}
-				savedMsg, err := h.saveAttachmentMessage(roomID, c.userID, attachment.ID, payloadJSON, contentType, strings.TrimSpace(msg.ParentMessageID), msg.ParentSequenceID)
-				if err != nil {
-					continue
-				}
-				out := OutgoingMessage{
-					ID:           savedMsg.ID,
-					Type:         outType,
-					FromUser:     c.userID,
-					ToUser:       msg.ToUser,
-					RoomID:       roomID,
-					AttachmentID: attachment.ID,
-					Attachment:   payload,
-					ParentMessageID: func() string {
-						if savedMsg.ParentMessageID == nil {
-							return ""
-						}
-						return strings.TrimSpace(*savedMsg.ParentMessageID)
-					}(),
-					ParentSequenceID: savedMsg.ParentSequenceID,
-					Timestamp:        savedMsg.CreatedAt.Unix(),
-					SequenceID:       savedMsg.SequenceID,
-				}
-				b, err := json.Marshal(out)
-				if err != nil {
-					continue
-				}
-				c.routeDirect(c.userID, msg.ToUser, b)
-			} else if msg.RoomID != "" {
-				if err := h.checkGroupPostingPermission(msg.RoomID, c.userID); err != nil {
-					continue
-				}
-				savedMsg, err := h.saveAttachmentMessage(msg.RoomID, c.userID, attachment.ID, payloadJSON, contentType, strings.TrimSpace(msg.ParentMessageID), msg.ParentSequenceID)
-				if err != nil {
-					continue
-				}
-				memberIDs, err := h.getGroupMemberIDs(msg.RoomID)
-				if err != nil {
-					continue
-				}
-				out := OutgoingMessage{
-					ID:           savedMsg.ID,
-					Type:         outType,
-					FromUser:     c.userID,
-					RoomID:       msg.RoomID,
-					AttachmentID: attachment.ID,
-					Attachment:   payload,
-					ParentMessageID: func() string {
-						if savedMsg.ParentMessageID == nil {
-							return ""
-						}
-						return strings.TrimSpace(*savedMsg.ParentMessageID)
-					}(),
-					ParentSequenceID: savedMsg.ParentSequenceID,
-					Timestamp:        savedMsg.CreatedAt.Unix(),
-					SequenceID:       savedMsg.SequenceID,
-				}
-				b, err := json.Marshal(out)
-				if err != nil {
-					continue
-				}
-				c.routeBroadcast(msg.RoomID, memberIDs, b)
-			}
+			h.handleRecallMessage(c, strings.TrimSpace(msg.MessageID))
 		}
 	}
 }
 
 func (c *Client) writeLoop() {
 	defer func() {
+		c.queue.discard()
 		_ = c.conn.Close()
 	}()
 	ticker := time.NewTicker(wsPingPeriod)
 	defer ticker.Stop()
 	for {
 		select {
-		case msg, ok := <-c.send:
-			_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
-			if !ok {
-				_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				log.Printf("ws write closed user=%s", c.userID)
-				return
-			}
-			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
-				if ce, ok := err.(*websocket.CloseError); ok {
-					log.Printf("ws write close user=%s code=%d text=%s", c.userID, ce.Code, ce.Text)
-				} else {
-					log.Printf("ws write error user=%s err=%v", c.userID, err)
+		case <-c.queue.ready:
+			for {
+				msg, ok, closed := c.queue.pop()
+				_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+				if closed {
+					_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+					log.Printf("ws write closed user=%s", c.userID)
+					return
+				}
+				if !ok {
+					break
+				}
+				out, err := c.codec.EncodeFrame(msg)
+				if err != nil {
+					log.Printf("ws encode frame failed user=%s err=%v", c.userID, err)
+					continue
+				}
+				c.conn.EnableWriteCompression(len(out) >= wsCompressMinBytes)
+				if err := c.conn.WriteMessage(c.codec.MessageType(), out); err != nil {
+					if ce, ok := err.(*websocket.CloseError); ok {
+						log.Printf("ws write close user=%s code=%d text=%s", c.userID, ce.Code, ce.Text)
+					} else {
+						log.Printf("ws write error user=%s err=%v", c.userID, err)
+					}
+					return
 				}
-				return
 			}
 		case <-ticker.C:
 			_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
@@ -700,8 +389,19 @@ func (h *WsHandler) areFriends(a, b string) bool {
 	if x > y {
 		x, y = y, x
 	}
+	// 任一方拉黑对方时视为非好友，结果与好友关系一并缓存（拉黑/取消拉黑时失效）
 	var f models.Friendship
-	if err := h.db.Where("user_id_a = ? AND user_id_b = ?", x, y).First(&f).Error; err != nil {
+	err := h.db.Where("user_id_a = ? AND user_id_b = ?", x, y).First(&f).Error
+	if err == nil {
+		blocked, blockErr := isBlockedBetween(h.db, a, b)
+		if blockErr != nil {
+			return false
+		}
+		if blocked {
+			err = gorm.ErrRecordNotFound
+		}
+	}
+	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) && h.cacheClient != nil {
 			cacheKey := h.cacheClient.BuildKey(cachepkg.FriendshipKey(a, b)...)
 			cacheCtx, cancel := context.WithTimeout(context.Background(), 120*time.Millisecond)
@@ -784,9 +484,16 @@ func (h *WsHandler) ensureDirectRoom(a, b string) (string, error) {
 	return room.ID, nil
 }
 
+var (
+	errGroupMemberLeft  = errors.New("user has left the group")
+	errGroupMemberMuted = errors.New("user is muted")
+	errGroupMuted       = errors.New("group is muted")
+)
+
 type groupPostingPermissionCache struct {
 	LeftAtUnix    int64 `json:"left_at_unix"`
 	MuteUntilUnix int64 `json:"mute_until_unix"`
+	MutedByRoom   bool  `json:"muted_by_room"`
 }
 
 func (h *WsHandler) checkGroupPostingPermission(roomID, userID string) error {
@@ -799,10 +506,13 @@ func (h *WsHandler) checkGroupPostingPermission(roomID, userID string) error {
 		cancel()
 		if err == nil && ok {
 			if cached.LeftAtUnix > 0 {
-				return errors.New("user has left the group")
+				return errGroupMemberLeft
 			}
 			if cached.MuteUntilUnix > now.Unix() {
-				return errors.New("user is muted")
+				return errGroupMemberMuted
+			}
+			if cached.MutedByRoom {
+				return errGroupMuted
 			}
 			return nil
 		}
@@ -812,7 +522,13 @@ func (h *WsHandler) checkGroupPostingPermission(roomID, userID string) error {
 	if err != nil {
 		return err
 	}
-	cached := groupPostingPermissionCache{}
+	var room models.Room
+	if err := h.db.Select("id", "mute_all").Where("id = ?", roomID).First(&room).Error; err != nil {
+		return err
+	}
+	cached := groupPostingPermissionCache{
+		MutedByRoom: room.MuteAll && member.Role == models.MemberRoleMember,
+	}
 	if member.LeftAt != nil {
 		cached.LeftAtUnix = member.LeftAt.Unix()
 	}
@@ -826,10 +542,13 @@ func (h *WsHandler) checkGroupPostingPermission(roomID, userID string) error {
 		cancel()
 	}
 	if member.LeftAt != nil {
-		return errors.New("user has left the group")
+		return errGroupMemberLeft
 	}
 	if member.MuteUntil != nil && member.MuteUntil.After(now) {
-		return errors.New("user is muted")
+		return errGroupMemberMuted
+	}
+	if cached.MutedByRoom {
+		return errGroupMuted
 	}
 	return nil
 }
@@ -960,7 +679,18 @@ func (h *WsHandler) StartTaskDoneConsumer(ctx context.Context) error {
 			ParentSequenceID: event.ParentSequenceID,
 		})
 		parentSequenceID := event.ParentSequenceID
-		return h.sendRobotGroupMessage(event.RoomID, replyText, event.Payload, event.ParentMessageID, &parentSequenceID)
+		if err := h.sendRobotGroupMessage(event.RoomID, replyText, event.Payload, event.ParentMessageID, &parentSequenceID); err != nil {
+			return err
+		}
+		h.webhooks.Enqueue(strings.TrimSpace(event.RoomID), webhooksvc.EventAgentTaskDone, gin.H{
+			"task_id":       strings.TrimSpace(event.TaskID),
+			"request_id":    strings.TrimSpace(event.RequestID),
+			"user_id":       strings.TrimSpace(event.UserID),
+			"status":        string(event.Status),
+			"final":         strings.TrimSpace(event.Final),
+			"error_message": strings.TrimSpace(event.ErrorMessage),
+		})
+		return nil
 	})
 	if h.doneConsumerErr == nil {
 		h.doneConsumerUp = true
@@ -976,22 +706,28 @@ func (h *WsHandler) sendRobotGroupMessage(roomID, content string, payload map[st
 	if err := h.ensureRobotUser(); err != nil {
 		return err
 	}
+	_, err := h.postGroupTextMessage(roomID, wsRobotUserID, text, payload, parentMessageID, parentSequenceID)
+	return err
+}
+
+// postGroupTextMessage 以指定发送者保存群文本消息并广播到所有节点，调用方负责发送权限校验
+func (h *WsHandler) postGroupTextMessage(roomID, fromUserID, text string, payload map[string]interface{}, parentMessageID string, parentSequenceID *int64) (*models.Message, error) {
 	payloadJSON, err := toJSONPayload(payload)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	savedMsg, err := h.saveMessage(roomID, wsRobotUserID, models.ContentTypeText, &text, nil, payloadJSON, strings.TrimSpace(parentMessageID), parentSequenceID)
+	savedMsg, err := h.saveMessage(roomID, fromUserID, models.ContentTypeText, &text, nil, payloadJSON, strings.TrimSpace(parentMessageID), parentSequenceID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	memberIDs, err := h.getGroupMemberIDs(roomID)
 	if err != nil {
-		return err
+		return savedMsg, err
 	}
 	out := OutgoingMessage{
 		ID:          savedMsg.ID,
 		Type:        "group_message",
-		FromUser:    wsRobotUserID,
+		FromUser:    fromUserID,
 		RoomID:      roomID,
 		Content:     text,
 		PayloadJSON: payloadJSON,
@@ -1005,16 +741,16 @@ func (h *WsHandler) sendRobotGroupMessage(roomID, content string, payload map[st
 		Timestamp:        savedMsg.CreatedAt.Unix(),
 		SequenceID:       savedMsg.SequenceID,
 	}
-	b, err := json.Marshal(out)
+	b, err := newFrame(out)
 	if err != nil {
-		return err
+		return savedMsg, err
 	}
 	if h.router != nil {
 		h.router.RouteBroadcast(context.Background(), roomID, memberIDs, b)
 	} else {
-		h.hub.broadcast <- GroupMessage{RoomID: roomID, UserIDs: memberIDs, Data: b}
+		h.hub.sendBroadcast(GroupMessage{RoomID: roomID, UserIDs: memberIDs, Frame: b})
 	}
-	return nil
+	return savedMsg, nil
 }
 
 func (h *WsHandler) publishAgentStreamEvent(event taskservice.AgentStreamEvent) {
@@ -1082,14 +818,14 @@ func (h *WsHandler) sendAgentCommandAck(userID, roomID, requestID, taskID, paren
 		ParentMessageID:  strings.TrimSpace(parentMessageID),
 		ParentSequenceID: parentSequenceID,
 	}
-	b, err := json.Marshal(ack)
+	b, err := newFrame(ack)
 	if err != nil {
 		return
 	}
 	if h.router != nil {
 		h.router.RouteDirectMessage(context.Background(), wsRobotUserID, strings.TrimSpace(userID), b)
 	} else {
-		h.hub.direct <- DirectMessage{FromUserID: wsRobotUserID, ToUserID: strings.TrimSpace(userID), Data: b}
+		h.hub.sendDirect(DirectMessage{FromUserID: wsRobotUserID, ToUserID: strings.TrimSpace(userID), Frame: b})
 	}
 }
 
@@ -1104,74 +840,138 @@ func toJSONPayload(payload map[string]interface{}) (datatypes.JSON, error) {
 	return datatypes.JSON(b), nil
 }
 
-func (h *WsHandler) saveGroupMessage(roomID, fromUserID, content string, parentMessageID string, parentSequenceID *int64) (*models.Message, error) {
+func (h *WsHandler) saveTextMessage(roomID, fromUserID, clientMsgID, content string, parentMessageID string, parentSequenceID *int64) (*models.Message, bool, error) {
 	text := content
-	return h.saveMessage(roomID, fromUserID, models.ContentTypeText, &text, nil, nil, parentMessageID, parentSequenceID)
+	return h.saveClientMessage(roomID, fromUserID, clientMsgID, models.ContentTypeText, &text, nil, nil, parentMessageID, parentSequenceID)
 }
 
-func (h *WsHandler) saveDirectMessage(roomID, fromUserID, content string, parentMessageID string, parentSequenceID *int64) (*models.Message, error) {
-	text := content
-	return h.saveMessage(roomID, fromUserID, models.ContentTypeText, &text, nil, nil, parentMessageID, parentSequenceID)
+func (h *WsHandler) saveAttachmentMessage(roomID, fromUserID, clientMsgID, attachmentID string, payload datatypes.JSON, contentType models.ContentType, parentMessageID string, parentSequenceID *int64) (*models.Message, bool, error) {
+	aid := attachmentID
+	return h.saveClientMessage(roomID, fromUserID, clientMsgID, contentType, nil, &aid, payload, parentMessageID, parentSequenceID)
 }
 
-func (h *WsHandler) saveAttachmentMessage(roomID, fromUserID, attachmentID string, payload datatypes.JSON, contentType models.ContentType, parentMessageID string, parentSequenceID *int64) (*models.Message, error) {
-	aid := attachmentID
-	return h.saveMessage(roomID, fromUserID, contentType, nil, &aid, payload, parentMessageID, parentSequenceID)
+// nextSequenceID 在事务内锁定房间最新消息并返回下一个序号
+func nextSequenceID(tx *gorm.DB, roomID string) (int64, error) {
+	var lastMsg models.Message
+	// 尝试锁定最新的一条消息
+	// 注意：如果房间为空，First 会返回 RecordNotFound，此时无法加锁，依赖唯一索引冲突重试
+	// 已撤回（软删除）的消息仍占用序号，需一并计入
+	if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("room_id = ?", roomID).
+		Order("sequence_id desc").
+		First(&lastMsg).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, err
+		}
+		return 1, nil
+	}
+	return lastMsg.SequenceID + 1, nil
 }
 
 func (h *WsHandler) saveMessage(roomID, fromUserID string, contentType models.ContentType, contentText *string, attachmentID *string, payload datatypes.JSON, parentMessageID string, parentSequenceID *int64) (*models.Message, error) {
-	now := time.Now()
+	m, _, err := h.saveClientMessage(roomID, fromUserID, "", contentType, contentText, attachmentID, payload, parentMessageID, parentSequenceID)
+	return m, err
+}
+
+// saveClientMessage 保存消息；clientMsgID 非空时按 (房间, 发送者, clientMsgID) 去重，重复提交返回原消息且 duplicate 为 true
+func (h *WsHandler) saveClientMessage(roomID, fromUserID, clientMsgID string, contentType models.ContentType, contentText *string, attachmentID *string, payload datatypes.JSON, parentMessageID string, parentSequenceID *int64) (*models.Message, bool, error) {
+	m := models.Message{
+		RoomID:       roomID,
+		SenderID:     &fromUserID,
+		ContentType:  contentType,
+		ContentText:  contentText,
+		AttachmentID: attachmentID,
+		PayloadJSON:  payload,
+	}
+	if clientMsgID != "" {
+		m.ClientMsgID = &clientMsgID
+	}
+	saved, duplicate, err := saveRoomMessage(h.db, m, parentMessageID, parentSequenceID)
+	if err != nil || duplicate {
+		return saved, duplicate, err
+	}
+	h.invalidateRoomConversations(roomID)
+	h.webhooks.Enqueue(roomID, webhooksvc.EventMessageCreated, messageWebhookData(saved))
+	return saved, false, nil
+}
+
+// saveRoomMessage 分配房间序号并保存消息，SenderID 为空表示系统消息；
+// ClientMsgID 非空时按 (房间, 发送者, ClientMsgID) 去重，重复提交返回原消息且 duplicate 为 true
+func saveRoomMessage(db *gorm.DB, m models.Message, parentMessageID string, parentSequenceID *int64) (*models.Message, bool, error) {
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = time.Now()
+	}
 	trimmedParentMessageID := strings.TrimSpace(parentMessageID)
+	clientMsgID := ""
+	if m.ClientMsgID != nil && m.SenderID != nil {
+		clientMsgID = *m.ClientMsgID
+		if existing, err := findClientMessage(db, m.RoomID, *m.SenderID, clientMsgID); err == nil {
+			return existing, true, nil
+		}
+	}
 
 	// 重试逻辑：处理高并发下的 SequenceID 冲突（尤其是在 Postgres 无间隙锁的情况下）
 	maxRetries := 3
 	for i := 0; i < maxRetries; i++ {
-		m := models.Message{
-			ID:           uuid.NewString(),
-			RoomID:       roomID,
-			SenderID:     &fromUserID,
-			ContentType:  contentType,
-			ContentText:  contentText,
-			AttachmentID: attachmentID,
-			PayloadJSON:  payload,
-			CreatedAt:    now,
-		}
-
-		err := h.db.Transaction(func(tx *gorm.DB) error {
-			resolvedParentMessageID, resolvedParentSequenceID, resolveErr := h.resolveParentReference(tx, roomID, trimmedParentMessageID, parentSequenceID)
+		m.ID = uuid.NewString()
+		err := db.Transaction(func(tx *gorm.DB) error {
+			resolvedParentMessageID, resolvedParentSequenceID, resolveErr := resolveParentReference(tx, m.RoomID, trimmedParentMessageID, parentSequenceID)
 			if resolveErr != nil {
 				return resolveErr
 			}
 			m.ParentMessageID = resolvedParentMessageID
 			m.ParentSequenceID = resolvedParentSequenceID
-			var lastMsg models.Message
-			// 尝试锁定最新的一条消息
-			// 注意：如果房间为空，First 会返回 RecordNotFound，此时无法加锁，依赖唯一索引冲突重试
-			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
-				Where("room_id = ?", roomID).
-				Order("sequence_id desc").
-				First(&lastMsg).Error; err != nil {
-				if !errors.Is(err, gorm.ErrRecordNotFound) {
-					return err
-				}
-				m.SequenceID = 1
-			} else {
-				m.SequenceID = lastMsg.SequenceID + 1
+			seq, err := nextSequenceID(tx, m.RoomID)
+			if err != nil {
+				return err
 			}
+			m.SequenceID = seq
 			return tx.Create(&m).Error
 		})
 
 		if err == nil {
-			return &m, nil
+			return &m, false, nil
+		}
+		// 并发重发同一 clientMsgID 时唯一索引冲突，返回先写入的消息
+		if clientMsgID != "" {
+			if existing, findErr := findClientMessage(db, m.RoomID, *m.SenderID, clientMsgID); findErr == nil {
+				return existing, true, nil
+			}
 		}
 		// 如果是唯一索引冲突，稍微等待后重试
 		time.Sleep(time.Duration(10*(i+1)) * time.Millisecond)
 	}
 	// TODO: 记录重试失败日志
-	return nil, errors.New("failed to save message after retries")
+	return nil, false, errors.New("failed to save message after retries")
 }
 
-func (h *WsHandler) resolveParentReference(tx *gorm.DB, roomID string, parentMessageID string, parentSequenceID *int64) (*string, *int64, error) {
+// findClientMessage 按客户端消息 ID 查找已保存的消息，包含已撤回的消息
+func findClientMessage(db *gorm.DB, roomID, senderID, clientMsgID string) (*models.Message, error) {
+	var m models.Message
+	if err := db.Unscoped().
+		Where("room_id = ? AND sender_id = ? AND client_msg_id = ?", roomID, senderID, clientMsgID).
+		First(&m).Error; err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// messageWebhookData 回调中的消息字段，不含附件签名地址等仅对客户端有效的信息
+func messageWebhookData(m *models.Message) gin.H {
+	return gin.H{
+		"id":                 m.ID,
+		"sequence_id":        m.SequenceID,
+		"sender_id":          m.SenderID,
+		"content_type":       m.ContentType,
+		"content_text":       m.ContentText,
+		"attachment_id":      m.AttachmentID,
+		"parent_message_id":  m.ParentMessageID,
+		"parent_sequence_id": m.ParentSequenceID,
+		"created_at":         m.CreatedAt.UTC(),
+	}
+}
+
+func resolveParentReference(tx *gorm.DB, roomID string, parentMessageID string, parentSequenceID *int64) (*string, *int64, error) {
 	trimmedParentMessageID := strings.TrimSpace(parentMessageID)
 	hasParentSequence := parentSequenceID != nil && *parentSequenceID > 0
 	if trimmedParentMessageID == "" && !hasParentSequence {
@@ -1255,26 +1055,34 @@ func (h *WsHandler) ensureDirectRoomMembers(roomID, a, b string) {
 	}
 }
 
+// invalidateRoomConversations 房间有新消息后清除成员的会话列表缓存
+func (h *WsHandler) invalidateRoomConversations(roomID string) {
+	if h.cacheClient == nil {
+		return
+	}
+	memberIDs, err := h.getGroupMemberIDs(roomID)
+	if err != nil {
+		return
+	}
+	invalidateConversationLists(h.cacheClient, memberIDs...)
+}
+
 func (h *WsHandler) getUserRoomIDs(userID string) ([]string, error) {
-	var roomIDs []string
-	err := h.db.Model(&models.RoomMember{}).
-		Where("user_id = ? AND left_at IS NULL", userID).
-		Pluck("room_id", &roomIDs).Error
-	return roomIDs, err
+	return listUserRoomIDs(h.db, userID)
 }
 
-func (c *Client) routeDirect(fromUserID, toUserID string, data []byte) {
+func (c *Client) routeDirect(fromUserID, toUserID string, f *wsFrame) {
 	if c.router != nil {
-		c.router.RouteDirectMessage(context.Background(), fromUserID, toUserID, data)
+		c.router.RouteDirectMessage(context.Background(), fromUserID, toUserID, f)
 		return
 	}
-	c.hub.direct <- DirectMessage{FromUserID: fromUserID, ToUserID: toUserID, Data: data}
+	c.hub.sendDirect(DirectMessage{FromUserID: fromUserID, ToUserID: toUserID, Frame: f})
 }
 
-func (c *Client) routeBroadcast(roomID string, userIDs []string, data []byte) {
+func (c *Client) routeBroadcast(roomID string, userIDs []string, f *wsFrame) {
 	if c.router != nil {
-		c.router.RouteBroadcast(context.Background(), roomID, userIDs, data)
+		c.router.RouteBroadcast(context.Background(), roomID, userIDs, f)
 		return
 	}
-	c.hub.broadcast <- GroupMessage{RoomID: roomID, UserIDs: userIDs, Data: data}
+	c.hub.sendBroadcast(GroupMessage{RoomID: roomID, UserIDs: userIDs, Frame: f})
 }
@@ -0,0 +1,248 @@
+package handler
+
+import (
+	"hash/fnv"
+	"log"
+	"sync/atomic"
+)
+
+const (
+	// wsHubShards 投递分片数：用户按 ID 哈希固定落在一个分片，各分片独立运行
+	wsHubShards = 16
+	// wsHubShardBacklog 单个分片待处理的投递请求上限，超出时调用方阻塞等待
+	wsHubShardBacklog = 1024
+)
+
+// Hub 本节点的连接注册表与投递中心；入队不阻塞，慢连接只会丢弃自身队列中的帧
+type Hub struct {
+	shards []*hubShard
+	stats  hubStats
+}
+
+type hubShard struct {
+	clientsByUser map[string]map[*Client]bool
+	register      chan *Client
+	unregister    chan *Client
+	deliver       chan shardDelivery
+	disconnect    chan SessionDisconnect
+	stats         *hubStats
+}
+
+type shardDelivery struct {
+	roomID  string
+	userIDs []string
+	frame   *wsFrame
+}
+
+type hubStats struct {
+	clients   atomic.Int64
+	queued    atomic.Int64
+	dropped   atomic.Int64
+	coalesced atomic.Int64
+	resyncs   atomic.Int64
+}
+
+// HubStats 本节点投递统计
+type HubStats struct {
+	Clients         int64 `json:"clients"`
+	QueuedFrames    int64 `json:"queued_frames"`
+	QueueCapacity   int   `json:"queue_capacity"`
+	ShardBacklog    []int `json:"shard_backlog"`
+	DroppedFrames   int64 `json:"dropped_frames"`
+	CoalescedFrames int64 `json:"coalesced_frames"`
+	ResyncSent      int64 `json:"resync_sent"`
+}
+
+type DirectMessage struct {
+	FromUserID string
+	ToUserID   string
+	Frame      *wsFrame
+}
+
+type GroupMessage struct {
+	RoomID  string
+	UserIDs []string
+	Frame   *wsFrame
+}
+
+// SessionDisconnect 关闭用户指定登录会话上的全部连接
+type SessionDisconnect struct {
+	UserID     string
+	SessionIDs []string
+}
+
+type SystemEvent struct {
+	Type  string `json:"type"`
+	Event string `json:"event"`
+}
+
+func NewHub() *Hub {
+	h := &Hub{shards: make([]*hubShard, wsHubShards)}
+	for i := range h.shards {
+		s := &hubShard{
+			clientsByUser: make(map[string]map[*Client]bool),
+			register:      make(chan *Client),
+			unregister:    make(chan *Client),
+			deliver:       make(chan shardDelivery, wsHubShardBacklog),
+			disconnect:    make(chan SessionDisconnect),
+			stats:         &h.stats,
+		}
+		h.shards[i] = s
+		go s.run()
+	}
+	return h
+}
+
+func (h *Hub) shardFor(userID string) *hubShard {
+	f := fnv.New32a()
+	_, _ = f.Write([]byte(userID))
+	return h.shards[f.Sum32()%uint32(len(h.shards))]
+}
+
+func (h *Hub) SendSystemEventToUser(userID string, event string) {
+	if userID == "" {
+		return
+	}
+	h.SendSystemEventToUsers([]string{userID}, event)
+}
+
+func (h *Hub) SendSystemEventToUsers(userIDs []string, event string) {
+	if h == nil || len(userIDs) == 0 || event == "" {
+		return
+	}
+	f, err := newFrame(SystemEvent{Type: "system_event", Event: event})
+	if err != nil {
+		log.Printf("failed to marshal system_event: %v", err)
+		return
+	}
+	h.sendBroadcast(GroupMessage{UserIDs: userIDs, Frame: f})
+}
+
+// sendToUsers 向用户在本节点的全部连接投递，不限定房间
+func (h *Hub) sendToUsers(userIDs []string, f *wsFrame) {
+	if h == nil || len(userIDs) == 0 || f == nil {
+		return
+	}
+	h.sendBroadcast(GroupMessage{UserIDs: userIDs, Frame: f})
+}
+
+func (h *Hub) registerClient(c *Client) {
+	h.shardFor(c.userID).register <- c
+}
+
+func (h *Hub) unregisterClient(c *Client) {
+	h.shardFor(c.userID).unregister <- c
+}
+
+// sendDirect 私聊投递给接收方，并回显给发送方的其他连接
+func (h *Hub) sendDirect(msg DirectMessage) {
+	userIDs := make([]string, 0, 2)
+	if msg.ToUserID != "" {
+		userIDs = append(userIDs, msg.ToUserID)
+	}
+	if msg.FromUserID != "" && msg.FromUserID != msg.ToUserID {
+		userIDs = append(userIDs, msg.FromUserID)
+	}
+	h.deliver("", userIDs, msg.Frame)
+}
+
+func (h *Hub) sendBroadcast(msg GroupMessage) {
+	h.deliver(msg.RoomID, msg.UserIDs, msg.Frame)
+}
+
+func (h *Hub) disconnectSessions(d SessionDisconnect) {
+	h.shardFor(d.UserID).disconnect <- d
+}
+
+// deliver 按分片拆分接收者，同一帧由所有接收连接共享
+func (h *Hub) deliver(roomID string, userIDs []string, f *wsFrame) {
+	if len(userIDs) == 0 || f == nil {
+		return
+	}
+	byShard := make(map[*hubShard][]string)
+	for _, uid := range userIDs {
+		s := h.shardFor(uid)
+		byShard[s] = append(byShard[s], uid)
+	}
+	for s, ids := range byShard {
+		s.deliver <- shardDelivery{roomID: roomID, userIDs: ids, frame: f}
+	}
+}
+
+// Stats 返回本节点连接数、待发送帧数与丢弃统计
+func (h *Hub) Stats() HubStats {
+	backlog := make([]int, len(h.shards))
+	for i, s := range h.shards {
+		backlog[i] = len(s.deliver)
+	}
+	return HubStats{
+		Clients:         h.stats.clients.Load(),
+		QueuedFrames:    h.stats.queued.Load(),
+		QueueCapacity:   wsClientQueueSize,
+		ShardBacklog:    backlog,
+		DroppedFrames:   h.stats.dropped.Load(),
+		CoalescedFrames: h.stats.coalesced.Load(),
+		ResyncSent:      h.stats.resyncs.Load(),
+	}
+}
+
+func (s *hubShard) run() {
+	for {
+		select {
+		case c := <-s.register:
+			s.addClient(c)
+		case c := <-s.unregister:
+			s.removeClient(c)
+		case d := <-s.disconnect:
+			s.disconnectSessions(d)
+		case m := <-s.deliver:
+			for _, uid := range m.userIDs {
+				for c := range s.clientsByUser[uid] {
+					if !c.acceptsRoom(m.roomID) {
+						continue
+					}
+					c.queue.push(m.frame)
+				}
+			}
+		}
+	}
+}
+
+func (s *hubShard) addClient(c *Client) {
+	set, ok := s.clientsByUser[c.userID]
+	if !ok {
+		set = make(map[*Client]bool)
+		s.clientsByUser[c.userID] = set
+	}
+	set[c] = true
+	s.stats.clients.Add(1)
+}
+
+func (s *hubShard) removeClient(c *Client) {
+	set, ok := s.clientsByUser[c.userID]
+	if !ok || !set[c] {
+		return
+	}
+	delete(set, c)
+	if len(set) == 0 {
+		delete(s.clientsByUser, c.userID)
+	}
+	s.stats.clients.Add(-1)
+	c.queue.close()
+}
+
+// disconnectSessions 通知并断开属于已吊销会话的连接，写循环发完已入队的帧后关闭底层连接
+func (s *hubShard) disconnectSessions(d SessionDisconnect) {
+	set, ok := s.clientsByUser[d.UserID]
+	if !ok {
+		return
+	}
+	f, _ := newFrame(SystemEvent{Type: "system_event", Event: "session_revoked"})
+	for c := range set {
+		if c.sessionID == "" || !containsString(d.SessionIDs, c.sessionID) {
+			continue
+		}
+		c.queue.push(f)
+		s.removeClient(c)
+	}
+}
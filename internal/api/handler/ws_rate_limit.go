@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"time"
+)
+
+// WsErrorFrame 服务端拒绝处理某个上行帧时回复的错误帧
+type WsErrorFrame struct {
+	Type         string `json:"type"`
+	Code         string `json:"code"`
+	Message      string `json:"message"`
+	RetryAfterMs int64  `json:"retry_after_ms,omitempty"`
+	MessageID    string `json:"message_id,omitempty"`
+}
+
+// wsMessageLimiter 单连接令牌桶，仅在该连接的 readLoop 协程内使用，无需加锁
+type wsMessageLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newWsMessageLimiter perSecond <= 0 时返回 nil，表示不限速
+func newWsMessageLimiter(perSecond, burst int) *wsMessageLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	if burst < perSecond {
+		burst = perSecond
+	}
+	return &wsMessageLimiter{
+		rate:   float64(perSecond),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// allow 消耗一个令牌；不足时返回需要等待的时间
+func (l *wsMessageLimiter) allow(now time.Time) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// sendError 非阻塞地向当前连接回复错误帧
+func (c *Client) sendError(code, message string, retryAfter time.Duration) {
+	b, err := newFrame(WsErrorFrame{
+		Type:         "error",
+		Code:         code,
+		Message:      message,
+		RetryAfterMs: retryAfter.Milliseconds(),
+	})
+	if err != nil {
+		return
+	}
+	c.enqueue(b)
+}
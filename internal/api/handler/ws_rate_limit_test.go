@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWsMessageLimiter_Disabled(t *testing.T) {
+	l := newWsMessageLimiter(0, 10)
+	if l != nil {
+		t.Fatalf("expected nil limiter when rate <= 0")
+	}
+	for i := 0; i < 100; i++ {
+		if ok, _ := l.allow(time.Now()); !ok {
+			t.Fatalf("nil limiter must always allow")
+		}
+	}
+}
+
+func TestWsMessageLimiter_BurstThenRefill(t *testing.T) {
+	l := newWsMessageLimiter(2, 4)
+	now := l.last
+	for i := 0; i < 4; i++ {
+		if ok, _ := l.allow(now); !ok {
+			t.Fatalf("frame %d within burst rejected", i)
+		}
+	}
+	ok, wait := l.allow(now)
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("expected rejection with 500ms wait, got ok=%v wait=%s", ok, wait)
+	}
+	if ok, _ := l.allow(now.Add(250 * time.Millisecond)); ok {
+		t.Fatalf("half a token must not be enough")
+	}
+	if ok, _ := l.allow(now.Add(500 * time.Millisecond)); !ok {
+		t.Fatalf("expected a token after refill")
+	}
+	// 长时间空闲后最多恢复到 burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 4; i++ {
+		if ok, _ := l.allow(now); !ok {
+			t.Fatalf("frame %d after idle rejected", i)
+		}
+	}
+	if ok, _ := l.allow(now); ok {
+		t.Fatalf("tokens must be capped at burst")
+	}
+}
+
+func TestWsMessageLimiter_BurstAtLeastRate(t *testing.T) {
+	l := newWsMessageLimiter(5, 1)
+	if l.burst != 5 {
+		t.Fatalf("burst should be raised to rate, got %v", l.burst)
+	}
+}
+
+func TestSendError(t *testing.T) {
+	c := newTestClient()
+	c.sendError(ackCodeRateLimited, "发送过于频繁", 1200*time.Millisecond)
+	var frame WsErrorFrame
+	popFrame(t, c, &frame)
+	if frame.Type != "error" || frame.Code != ackCodeRateLimited || frame.Message != "发送过于频繁" || frame.RetryAfterMs != 1200 {
+		t.Fatalf("unexpected error frame: %+v", frame)
+	}
+}
@@ -0,0 +1,403 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+
+	"ququchat/internal/models"
+	taskservice "ququchat/internal/service"
+)
+
+// clientMsgIDMaxLen 客户端消息 ID 最大长度，与 Message.ClientMsgID 列宽一致
+const clientMsgIDMaxLen = 64
+
+// message_ack 错误码
+const (
+	ackCodeInvalidRequest    = "invalid_request"
+	ackCodeNotFriends        = "not_friends"
+	ackCodeNotMember         = "not_member"
+	ackCodeMuted             = "muted"
+	ackCodeTooLarge          = "too_large"
+	ackCodeAttachmentInvalid = "attachment_invalid"
+	ackCodeRateLimited       = "rate_limited"
+	ackCodeInternal          = "internal_error"
+)
+
+// MessageAck 发送类上行帧（friend_message/group_message/file_message/image_message）的处理结果，仅回复给发送连接
+// 成功时携带分配的序号；Duplicate 为 true 表示 client_msg_id 重复，Message 为首次保存的消息
+type MessageAck struct {
+	Type         string           `json:"type"`
+	ClientMsgID  string           `json:"client_msg_id,omitempty"`
+	OK           bool             `json:"ok"`
+	MessageID    string           `json:"message_id,omitempty"`
+	RoomID       string           `json:"room_id,omitempty"`
+	SequenceID   int64            `json:"sequence_id,omitempty"`
+	Timestamp    int64            `json:"timestamp,omitempty"`
+	Duplicate    bool             `json:"duplicate,omitempty"`
+	Message      *OutgoingMessage `json:"message,omitempty"`
+	Code         string           `json:"code,omitempty"`
+	Error        string           `json:"error,omitempty"`
+	RetryAfterMs int64            `json:"retry_after_ms,omitempty"`
+}
+
+func isSendFrame(frameType string) bool {
+	switch frameType {
+	case "friend_message", "group_message", "file_message", "image_message":
+		return true
+	}
+	return false
+}
+
+// sendAck 非阻塞地向当前连接回复 message_ack
+func (c *Client) sendAck(ack MessageAck) {
+	ack.Type = "message_ack"
+	b, err := newFrame(ack)
+	if err != nil {
+		return
+	}
+	c.enqueue(b)
+}
+
+func (c *Client) ackError(msg *IncomingMessage, code, message string) {
+	c.sendAck(MessageAck{ClientMsgID: strings.TrimSpace(msg.ClientMsgID), Code: code, Error: message})
+}
+
+// ackSaved 回复已保存（或重复提交）的消息；重复时附带原消息供客户端替换本地草稿
+func (c *Client) ackSaved(msg *IncomingMessage, saved *models.Message, duplicate bool, toUser string) {
+	ack := MessageAck{
+		ClientMsgID: strings.TrimSpace(msg.ClientMsgID),
+		OK:          true,
+		MessageID:   saved.ID,
+		RoomID:      saved.RoomID,
+		SequenceID:  saved.SequenceID,
+		Timestamp:   saved.CreatedAt.Unix(),
+		Duplicate:   duplicate,
+	}
+	if duplicate {
+		ack.Message = outgoingFromMessage(saved, toUser)
+	}
+	c.sendAck(ack)
+}
+
+// rejectFrame 拒绝上行帧：发送类帧回复 message_ack，其余回复 error 帧
+func (c *Client) rejectFrame(msg *IncomingMessage, code, message string, retryAfter time.Duration) {
+	if !isSendFrame(msg.Type) {
+		c.sendError(code, message, retryAfter)
+		return
+	}
+	c.sendAck(MessageAck{
+		ClientMsgID:  strings.TrimSpace(msg.ClientMsgID),
+		Code:         code,
+		Error:        message,
+		RetryAfterMs: retryAfter.Milliseconds(),
+	})
+}
+
+// validateClientMsgID 校验 client_msg_id 长度，不合法时已回复错误
+func (c *Client) validateClientMsgID(msg *IncomingMessage) (string, bool) {
+	id := strings.TrimSpace(msg.ClientMsgID)
+	if len(id) > clientMsgIDMaxLen {
+		c.ackError(msg, ackCodeInvalidRequest, "client_msg_id 过长")
+		return "", false
+	}
+	return id, true
+}
+
+// postingErrorCode 将群发言权限校验错误映射为 ack 错误码
+func postingErrorCode(err error) (string, string) {
+	switch {
+	case errors.Is(err, errGroupMemberMuted), errors.Is(err, errGroupMuted):
+		return ackCodeMuted, "已被禁言"
+	case errors.Is(err, errGroupMemberLeft), errors.Is(err, gorm.ErrRecordNotFound):
+		return ackCodeNotMember, "不是群成员"
+	default:
+		return ackCodeInternal, "校验发言权限失败"
+	}
+}
+
+func savedParentMessageID(m *models.Message) string {
+	if m.ParentMessageID == nil {
+		return ""
+	}
+	return strings.TrimSpace(*m.ParentMessageID)
+}
+
+// outgoingFromMessage 由已保存的消息还原下行消息；已撤回的消息不再返回内容
+func outgoingFromMessage(m *models.Message, toUser string) *OutgoingMessage {
+	if m == nil || m.DeletedAt.Valid {
+		return nil
+	}
+	out := &OutgoingMessage{
+		ID:               m.ID,
+		ToUser:           toUser,
+		RoomID:           m.RoomID,
+		ParentMessageID:  savedParentMessageID(m),
+		ParentSequenceID: m.ParentSequenceID,
+		Timestamp:        m.CreatedAt.Unix(),
+		SequenceID:       m.SequenceID,
+	}
+	if m.SenderID != nil {
+		out.FromUser = *m.SenderID
+	}
+	if m.ClientMsgID != nil {
+		out.ClientMsgID = *m.ClientMsgID
+	}
+	switch m.ContentType {
+	case models.ContentTypeImage, models.ContentTypeFile:
+		out.Type = "file_message"
+		if m.ContentType == models.ContentTypeImage {
+			out.Type = "image_message"
+		}
+		if m.AttachmentID != nil {
+			out.AttachmentID = *m.AttachmentID
+		}
+		var payload AttachmentPayload
+		if len(m.PayloadJSON) > 0 && json.Unmarshal(m.PayloadJSON, &payload) == nil {
+			out.Attachment = &payload
+		}
+	default:
+		out.Type = "group_message"
+		if toUser != "" {
+			out.Type = "friend_message"
+		}
+		if m.ContentText != nil {
+			out.Content = *m.ContentText
+		}
+		out.PayloadJSON = m.PayloadJSON
+	}
+	return out
+}
+
+// handleFriendMessage 处理 friend_message 帧：保存私聊文本消息并投递给双方
+func (h *WsHandler) handleFriendMessage(c *Client, msg *IncomingMessage) {
+	clientMsgID, ok := c.validateClientMsgID(msg)
+	if !ok {
+		return
+	}
+	if msg.ToUser == "" || msg.Content == "" {
+		c.ackError(msg, ackCodeInvalidRequest, "缺少 to_user_id 或 content")
+		return
+	}
+	if utf8.RuneCountInString(msg.Content) > messageMaxRunes {
+		c.ackError(msg, ackCodeTooLarge, "消息内容过长")
+		return
+	}
+	if !h.areFriends(c.userID, msg.ToUser) {
+		c.ackError(msg, ackCodeNotFriends, "对方不是你的好友")
+		return
+	}
+	roomID, err := h.ensureDirectRoom(c.userID, msg.ToUser)
+	if err != nil {
+		c.ackError(msg, ackCodeInternal, "创建会话失败")
+		return
+	}
+	savedMsg, duplicate, err := h.saveTextMessage(roomID, c.userID, clientMsgID, msg.Content, strings.TrimSpace(msg.ParentMessageID), msg.ParentSequenceID)
+	if err != nil {
+		c.ackError(msg, ackCodeInternal, "保存消息失败")
+		return
+	}
+	c.ackSaved(msg, savedMsg, duplicate, msg.ToUser)
+	if duplicate {
+		return
+	}
+	out := OutgoingMessage{
+		ID:               savedMsg.ID,
+		Type:             "friend_message",
+		FromUser:         c.userID,
+		ToUser:           msg.ToUser,
+		RoomID:           roomID,
+		Content:          msg.Content,
+		ParentMessageID:  savedParentMessageID(savedMsg),
+		ParentSequenceID: savedMsg.ParentSequenceID,
+		Timestamp:        savedMsg.CreatedAt.Unix(),
+		SequenceID:       savedMsg.SequenceID,
+		ClientMsgID:      clientMsgID,
+	}
+	b, err := newFrame(out)
+	if err != nil {
+		return
+	}
+	c.routeDirect(c.userID, msg.ToUser, b)
+}
+
+// handleGroupMessage 处理 group_message 帧：保存群文本消息并广播；以 \ 开头的消息同时提交为任务指令
+func (h *WsHandler) handleGroupMessage(c *Client, msg *IncomingMessage) {
+	clientMsgID, ok := c.validateClientMsgID(msg)
+	if !ok {
+		return
+	}
+	if msg.RoomID == "" || msg.Content == "" {
+		c.ackError(msg, ackCodeInvalidRequest, "缺少 room_id 或 content")
+		return
+	}
+	if utf8.RuneCountInString(msg.Content) > messageMaxRunes {
+		c.ackError(msg, ackCodeTooLarge, "消息内容过长")
+		return
+	}
+	// Check if user is a member of the group and not muted
+	if err := h.checkGroupPostingPermission(msg.RoomID, c.userID); err != nil {
+		code, text := postingErrorCode(err)
+		c.ackError(msg, code, text)
+		return
+	}
+	savedMsg, duplicate, err := h.saveTextMessage(msg.RoomID, c.userID, clientMsgID, msg.Content, strings.TrimSpace(msg.ParentMessageID), msg.ParentSequenceID)
+	if err != nil {
+		c.ackError(msg, ackCodeInternal, "保存消息失败")
+		return
+	}
+	c.ackSaved(msg, savedMsg, duplicate, "")
+	// 重复提交不再广播，也不重复提交指令
+	if duplicate {
+		return
+	}
+
+	// Get all active members to broadcast
+	memberIDs, err := h.getGroupMemberIDs(msg.RoomID)
+	if err != nil {
+		log.Printf("ws load group members failed room=%s err=%v", msg.RoomID, err)
+		return
+	}
+	out := OutgoingMessage{
+		ID:               savedMsg.ID,
+		Type:             "group_message",
+		FromUser:         c.userID,
+		RoomID:           msg.RoomID,
+		Content:          msg.Content,
+		ParentMessageID:  savedParentMessageID(savedMsg),
+		ParentSequenceID: savedMsg.ParentSequenceID,
+		Timestamp:        savedMsg.CreatedAt.Unix(),
+		SequenceID:       savedMsg.SequenceID,
+		ClientMsgID:      clientMsgID,
+	}
+	if b, err := newFrame(out); err == nil {
+		c.routeBroadcast(msg.RoomID, memberIDs, b)
+	}
+	if !strings.HasPrefix(strings.TrimSpace(msg.Content), "\\") || h.taskService == nil {
+		return
+	}
+	h.submitGroupCommand(c.userID, msg.RoomID, msg.Content, savedMsg)
+}
+
+// submitGroupCommand 将指令消息提交到任务服务，提交失败时由机器人在群内回复原因
+func (h *WsHandler) submitGroupCommand(userID, roomID, content string, savedMsg *models.Message) {
+	requestID := taskservice.BuildWSCommandRequestID(userID, roomID, savedMsg.ID, savedMsg.SequenceID)
+	taskID, err := h.taskService.SubmitCommand(taskservice.SubmitCommandRequest{
+		RequestID:        requestID,
+		UserID:           userID,
+		RoomID:           roomID,
+		Content:          content,
+		ParentMessageID:  savedMsg.ID,
+		ParentSequenceID: savedMsg.SequenceID,
+	})
+	if err != nil {
+		h.publishAgentStreamEvent(taskservice.AgentStreamEvent{
+			EventType:        "agent.error",
+			RequestID:        requestID,
+			RoomID:           roomID,
+			UserID:           userID,
+			Status:           "failed",
+			Error:            err.Error(),
+			ParentMessageID:  savedMsg.ID,
+			ParentSequenceID: savedMsg.SequenceID,
+		})
+		log.Printf("submit command failed user=%s room=%s err=%v", userID, roomID, err)
+		if sendErr := h.sendRobotGroupMessage(roomID, err.Error(), nil, savedMsg.ID, &savedMsg.SequenceID); sendErr != nil {
+			log.Printf("send robot submit-failed message failed room=%s err=%v", roomID, sendErr)
+		}
+		return
+	}
+	h.publishAgentStreamEvent(taskservice.AgentStreamEvent{
+		EventType:        "agent.start",
+		RequestID:        requestID,
+		RoomID:           roomID,
+		UserID:           userID,
+		Status:           "running",
+		Content:          strings.TrimSpace(content),
+		ParentMessageID:  savedMsg.ID,
+		ParentSequenceID: savedMsg.SequenceID,
+	})
+	h.sendAgentCommandAck(userID, roomID, requestID, taskID, savedMsg.ID, savedMsg.SequenceID)
+}
+
+// handleAttachmentMessage 处理 file_message/image_message 帧：to_user_id 非空发私聊，否则发到 room_id 群
+func (h *WsHandler) handleAttachmentMessage(c *Client, msg *IncomingMessage) {
+	clientMsgID, ok := c.validateClientMsgID(msg)
+	if !ok {
+		return
+	}
+	if msg.AttachmentID == "" || (msg.ToUser == "" && msg.RoomID == "") {
+		c.ackError(msg, ackCodeInvalidRequest, "缺少 attachment_id 或接收方")
+		return
+	}
+	attachment, payload, payloadJSON, err := h.loadAttachmentPayload(c.userID, msg.AttachmentID)
+	if err != nil {
+		c.ackError(msg, ackCodeAttachmentInvalid, "附件不存在或无权使用")
+		return
+	}
+	contentType := models.ContentTypeFile
+	outType := "file_message"
+	if isImageAttachment(attachment) {
+		contentType = models.ContentTypeImage
+		outType = "image_message"
+	}
+
+	roomID := msg.RoomID
+	if msg.ToUser != "" {
+		if !h.areFriends(c.userID, msg.ToUser) {
+			c.ackError(msg, ackCodeNotFriends, "对方不是你的好友")
+			return
+		}
+		roomID, err = h.ensureDirectRoom(c.userID, msg.ToUser)
+		if err != nil {
+			c.ackError(msg, ackCodeInternal, "创建会话失败")
+			return
+		}
+	} else if err := h.checkGroupPostingPermission(roomID, c.userID); err != nil {
+		code, text := postingErrorCode(err)
+		c.ackError(msg, code, text)
+		return
+	}
+	savedMsg, duplicate, err := h.saveAttachmentMessage(roomID, c.userID, clientMsgID, attachment.ID, payloadJSON, contentType, strings.TrimSpace(msg.ParentMessageID), msg.ParentSequenceID)
+	if err != nil {
+		c.ackError(msg, ackCodeInternal, "保存消息失败")
+		return
+	}
+	c.ackSaved(msg, savedMsg, duplicate, msg.ToUser)
+	if duplicate {
+		return
+	}
+	out := OutgoingMessage{
+		ID:               savedMsg.ID,
+		Type:             outType,
+		FromUser:         c.userID,
+		ToUser:           msg.ToUser,
+		RoomID:           roomID,
+		AttachmentID:     attachment.ID,
+		Attachment:       payload,
+		ParentMessageID:  savedParentMessageID(savedMsg),
+		ParentSequenceID: savedMsg.ParentSequenceID,
+		Timestamp:        savedMsg.CreatedAt.Unix(),
+		SequenceID:       savedMsg.SequenceID,
+		ClientMsgID:      clientMsgID,
+	}
+	b, err := newFrame(out)
+	if err != nil {
+		return
+	}
+	if msg.ToUser != "" {
+		c.routeDirect(c.userID, msg.ToUser, b)
+		return
+	}
+	memberIDs, err := h.getGroupMemberIDs(roomID)
+	if err != nil {
+		log.Printf("ws load group members failed room=%s err=%v", roomID, err)
+		return
+	}
+	c.routeBroadcast(roomID, memberIDs, b)
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"ququchat/internal/models"
+)
+
+func newTestClient() *Client {
+	return &Client{userID: "u1", queue: newSendQueue(wsClientQueueSize, nil)}
+}
+
+func popFrame(t *testing.T, c *Client, v interface{}) {
+	t.Helper()
+	f, ok, _ := c.queue.pop()
+	if !ok {
+		t.Fatalf("expected a queued frame")
+	}
+	if err := json.Unmarshal(f.data, v); err != nil {
+		t.Fatalf("decode frame %s: %v", f.data, err)
+	}
+}
+
+func TestValidateClientMsgID(t *testing.T) {
+	c := newTestClient()
+	id, ok := c.validateClientMsgID(&IncomingMessage{Type: "group_message", ClientMsgID: " " + strings.Repeat("a", clientMsgIDMaxLen) + " "})
+	if !ok || len(id) != clientMsgIDMaxLen {
+		t.Fatalf("64-char id should be accepted after trim: ok=%v len=%d", ok, len(id))
+	}
+	if _, ok, _ := c.queue.pop(); ok {
+		t.Fatalf("valid id must not produce an ack")
+	}
+
+	if _, ok := c.validateClientMsgID(&IncomingMessage{Type: "group_message", ClientMsgID: strings.Repeat("a", clientMsgIDMaxLen+1)}); ok {
+		t.Fatalf("65-char id should be rejected")
+	}
+	var ack MessageAck
+	popFrame(t, c, &ack)
+	if ack.Type != "message_ack" || ack.OK || ack.Code != ackCodeInvalidRequest {
+		t.Fatalf("unexpected ack: %+v", ack)
+	}
+}
+
+func TestRejectFrame_AckForSendFramesErrorOtherwise(t *testing.T) {
+	c := newTestClient()
+	c.rejectFrame(&IncomingMessage{Type: "friend_message", ClientMsgID: "c1"}, ackCodeRateLimited, "发送过于频繁", 1500*time.Millisecond)
+	var ack MessageAck
+	popFrame(t, c, &ack)
+	if ack.Type != "message_ack" || ack.OK || ack.ClientMsgID != "c1" || ack.Code != ackCodeRateLimited || ack.RetryAfterMs != 1500 {
+		t.Fatalf("unexpected ack: %+v", ack)
+	}
+
+	c.rejectFrame(&IncomingMessage{Type: "message_read", ClientMsgID: "c2"}, ackCodeRateLimited, "发送过于频繁", time.Second)
+	var frame WsErrorFrame
+	popFrame(t, c, &frame)
+	if frame.Type != "error" || frame.Code != ackCodeRateLimited || frame.RetryAfterMs != 1000 {
+		t.Fatalf("unexpected error frame: %+v", frame)
+	}
+}
+
+func TestAckSaved_Duplicate(t *testing.T) {
+	sender, clientMsgID := "u1", "c1"
+	saved := &models.Message{
+		ID:          "m1",
+		RoomID:      "r1",
+		SenderID:    &sender,
+		ClientMsgID: &clientMsgID,
+		SequenceID:  1 << 40,
+		CreatedAt:   time.Unix(1698372000, 0),
+	}
+	cases := []struct {
+		name        string
+		duplicate   bool
+		recalled    bool
+		wantMessage bool
+	}{
+		{name: "first save", duplicate: false, wantMessage: false},
+		{name: "duplicate", duplicate: true, wantMessage: true},
+		{name: "duplicate of recalled message", duplicate: true, recalled: true, wantMessage: false},
+	}
+	for _, tc := range cases {
+		m := *saved
+		if tc.recalled {
+			m.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+		}
+		c := newTestClient()
+		c.ackSaved(&IncomingMessage{Type: "group_message", ClientMsgID: clientMsgID}, &m, tc.duplicate, "")
+		var ack MessageAck
+		popFrame(t, c, &ack)
+		if !ack.OK || ack.MessageID != "m1" || ack.SequenceID != 1<<40 || ack.ClientMsgID != clientMsgID || ack.Duplicate != tc.duplicate {
+			t.Fatalf("%s: unexpected ack: %+v", tc.name, ack)
+		}
+		if (ack.Message != nil) != tc.wantMessage {
+			t.Fatalf("%s: message=%+v", tc.name, ack.Message)
+		}
+		if ack.Message != nil && (ack.Message.ClientMsgID != clientMsgID || ack.Message.FromUser != sender) {
+			t.Fatalf("%s: unexpected original message: %+v", tc.name, ack.Message)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package handler
+
+import (
+	"sync"
+	"time"
+)
+
+// wsClientQueueSize 单连接待发送帧上限
+const wsClientQueueSize = 256
+
+// 帧优先级：队列满时依次丢弃最早的 typing 帧、状态类帧，最后才丢弃消息帧
+const (
+	frameClassTyping = iota
+	frameClassState
+	frameClassCritical
+)
+
+type frameMeta struct {
+	class int
+	// coalesceKey 非空时，队列中同 key 的待发送帧被新帧原地替换
+	coalesceKey string
+}
+
+// frameClassifier 需要非默认投递策略的下行帧实现该接口；未实现的帧按消息帧处理，不参与合并
+type frameClassifier interface {
+	deliveryMeta() frameMeta
+}
+
+// wsFrame 构造完成的下行帧：规范 JSON 与投递元信息在构造时确定一次，经 Hub、跨节点路由与发送队列传递
+type wsFrame struct {
+	data []byte
+	meta frameMeta
+
+	// MessagePack 编码结果，由首个二进制连接写出时生成
+	msgpackOnce sync.Once
+	msgpack     []byte
+	msgpackErr  error
+}
+
+// newFrame 编码下行帧并确定其丢弃优先级与合并 key
+func newFrame(v interface{}) (*wsFrame, error) {
+	data, err := marshalFrame(v)
+	if err != nil {
+		return nil, err
+	}
+	meta := frameMeta{class: frameClassCritical}
+	if fc, ok := v.(frameClassifier); ok {
+		meta = fc.deliveryMeta()
+	}
+	return &wsFrame{data: data, meta: meta}, nil
+}
+
+func (e TypingEvent) deliveryMeta() frameMeta {
+	return frameMeta{class: frameClassTyping, coalesceKey: "typing|" + e.FromUser + "|" + e.ToUser + "|" + e.RoomID}
+}
+
+func (e PresenceEvent) deliveryMeta() frameMeta {
+	return frameMeta{class: frameClassState, coalesceKey: "presence|" + e.UserID}
+}
+
+func (e ReceiptEvent) deliveryMeta() frameMeta {
+	return frameMeta{class: frameClassState, coalesceKey: e.Type + "|" + e.RoomID + "|" + e.UserID}
+}
+
+// deliveryMeta 用户级事件只按事件名合并；会话吊销通知不可丢弃
+func (e SystemEvent) deliveryMeta() frameMeta {
+	if e.Event == "session_revoked" {
+		return frameMeta{class: frameClassCritical}
+	}
+	return frameMeta{class: frameClassState, coalesceKey: "system_event|" + e.Event}
+}
+
+// deliveryMeta 群事件按群与被操作成员合并，不同成员的禁言变更互不覆盖
+func (e GroupEvent) deliveryMeta() frameMeta {
+	return frameMeta{class: frameClassState, coalesceKey: "system_event|" + e.Event + "|" + e.RoomID + "|" + e.UserID}
+}
+
+// ResyncRequired 连接的发送队列溢出、有帧被丢弃时先于后续帧发送，客户端应通过 /api/messages/sync 补齐
+type ResyncRequired struct {
+	Type      string `json:"type"`
+	Reason    string `json:"reason"`
+	Dropped   int    `json:"dropped"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// sendQueue 连接的有界发送队列，入队不阻塞：满时丢弃最早的低优先级帧而不是断开慢连接
+type sendQueue struct {
+	mu     sync.Mutex
+	frames []*wsFrame
+	limit  int
+	closed bool
+	// dropped 自上次 resync_required 以来丢弃的非 typing 帧数
+	dropped int
+	ready   chan struct{}
+	stats   *hubStats
+}
+
+func newSendQueue(limit int, stats *hubStats) *sendQueue {
+	if stats == nil {
+		stats = &hubStats{}
+	}
+	return &sendQueue{
+		limit: limit,
+		ready: make(chan struct{}, 1),
+		stats: stats,
+	}
+}
+
+// push 入队；队列已关闭时返回 false
+func (q *sendQueue) push(f *wsFrame) bool {
+	meta := f.meta
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return false
+	}
+	if meta.coalesceKey != "" {
+		for i := range q.frames {
+			if q.frames[i].meta.coalesceKey == meta.coalesceKey {
+				q.frames[i] = f
+				q.mu.Unlock()
+				q.stats.coalesced.Add(1)
+				q.signal()
+				return true
+			}
+		}
+	}
+	if len(q.frames) >= q.limit && !q.evict(meta.class) {
+		// 队列中只有优先级更高的帧，丢弃新帧
+		q.recordDrop(meta.class)
+		q.mu.Unlock()
+		q.signal()
+		return true
+	}
+	q.frames = append(q.frames, f)
+	q.mu.Unlock()
+	q.stats.queued.Add(1)
+	q.signal()
+	return true
+}
+
+// evict 丢弃最早的一帧，按优先级从低到高、且不高于新帧的优先级查找
+func (q *sendQueue) evict(incoming int) bool {
+	for class := frameClassTyping; class <= incoming; class++ {
+		for i := range q.frames {
+			if q.frames[i].meta.class != class {
+				continue
+			}
+			copy(q.frames[i:], q.frames[i+1:])
+			q.frames[len(q.frames)-1] = nil
+			q.frames = q.frames[:len(q.frames)-1]
+			q.stats.queued.Add(-1)
+			q.recordDrop(class)
+			return true
+		}
+	}
+	return false
+}
+
+func (q *sendQueue) recordDrop(class int) {
+	q.stats.dropped.Add(1)
+	if class != frameClassTyping {
+		q.dropped++
+	}
+}
+
+// pop 取出下一帧；有帧被丢弃时先返回 resync_required。队列为空时 ok 为 false，closed 表示已关闭且无剩余帧
+func (q *sendQueue) pop() (f *wsFrame, ok bool, closed bool) {
+	q.mu.Lock()
+	if q.dropped > 0 {
+		n := q.dropped
+		q.dropped = 0
+		q.mu.Unlock()
+		q.stats.resyncs.Add(1)
+		f, _ = newFrame(ResyncRequired{
+			Type:      "resync_required",
+			Reason:    "send_queue_overflow",
+			Dropped:   n,
+			Timestamp: time.Now().Unix(),
+		})
+		return f, true, false
+	}
+	if len(q.frames) == 0 {
+		closed = q.closed
+		q.mu.Unlock()
+		return nil, false, closed
+	}
+	f = q.frames[0]
+	q.frames[0] = nil
+	q.frames = q.frames[1:]
+	q.mu.Unlock()
+	q.stats.queued.Add(-1)
+	return f, true, false
+}
+
+// close 停止接收新帧，已入队的帧仍由写循环发完
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+}
+
+// discard 写循环退出后丢弃未发送的帧
+func (q *sendQueue) discard() {
+	q.mu.Lock()
+	n := len(q.frames)
+	q.frames = nil
+	q.closed = true
+	q.mu.Unlock()
+	q.stats.queued.Add(int64(-n))
+}
+
+func (q *sendQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// enqueue 向当前连接发送一帧
+func (c *Client) enqueue(f *wsFrame) {
+	c.queue.push(f)
+}
@@ -0,0 +1,210 @@
+package handler
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func testFrame(label string, class int, key string) *wsFrame {
+	return &wsFrame{data: []byte(label), meta: frameMeta{class: class, coalesceKey: key}}
+}
+
+// drainQueue 依次取出队列中的帧；遇到 resync_required 时记录其 dropped 数
+func drainQueue(t *testing.T, q *sendQueue) (labels []string, resyncDropped int) {
+	t.Helper()
+	for {
+		f, ok, _ := q.pop()
+		if !ok {
+			return labels, resyncDropped
+		}
+		var r ResyncRequired
+		if json.Unmarshal(f.data, &r) == nil && r.Type == "resync_required" {
+			if len(labels) > 0 || resyncDropped > 0 {
+				t.Fatalf("resync_required must come first and only once")
+			}
+			resyncDropped = r.Dropped
+			continue
+		}
+		labels = append(labels, string(f.data))
+	}
+}
+
+func TestNewFrame_DeliveryMeta(t *testing.T) {
+	cases := []struct {
+		name  string
+		v     interface{}
+		class int
+		key   string
+	}{
+		{"message", OutgoingMessage{Type: "group_message", RoomID: "r1"}, frameClassCritical, ""},
+		{"ack", MessageAck{Type: "message_ack", OK: true}, frameClassCritical, ""},
+		{"typing", TypingEvent{Type: "typing", FromUser: "u1", RoomID: "r1"}, frameClassTyping, "typing|u1||r1"},
+		{"presence", PresenceEvent{Type: "presence", UserID: "u1"}, frameClassState, "presence|u1"},
+		{"receipt", ReceiptEvent{Type: "message_read", RoomID: "r1", UserID: "u1"}, frameClassState, "message_read|r1|u1"},
+		{"system event", SystemEvent{Type: "system_event", Event: "friends_changed"}, frameClassState, "system_event|friends_changed"},
+		{"session revoked", SystemEvent{Type: "system_event", Event: "session_revoked"}, frameClassCritical, ""},
+		{"group event", GroupEvent{Type: "system_event", Event: "group_member_muted", RoomID: "r1", UserID: "u2"}, frameClassState, "system_event|group_member_muted|r1|u2"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := newFrame(tc.v)
+			if err != nil {
+				t.Fatalf("newFrame: %v", err)
+			}
+			if f.meta.class != tc.class || f.meta.coalesceKey != tc.key {
+				t.Fatalf("meta = %+v, want class=%d key=%q", f.meta, tc.class, tc.key)
+			}
+		})
+	}
+}
+
+func TestSendQueue_OverflowAndCoalesce(t *testing.T) {
+	cases := []struct {
+		name   string
+		pushes []*wsFrame
+		want   []string
+		// resync 期望的 resync_required.dropped，0 表示不应发送
+		resync int
+	}{
+		{
+			name:   "evicts typing first without resync",
+			pushes: []*wsFrame{testFrame("s1", frameClassState, ""), testFrame("t1", frameClassTyping, ""), testFrame("c1", frameClassCritical, ""), testFrame("c2", frameClassCritical, "")},
+			want:   []string{"s1", "c1", "c2"},
+		},
+		{
+			name:   "evicts state before critical",
+			pushes: []*wsFrame{testFrame("c1", frameClassCritical, ""), testFrame("s1", frameClassState, ""), testFrame("c2", frameClassCritical, ""), testFrame("c3", frameClassCritical, "")},
+			want:   []string{"c1", "c2", "c3"},
+			resync: 1,
+		},
+		{
+			name:   "evicts oldest within class",
+			pushes: []*wsFrame{testFrame("s1", frameClassState, ""), testFrame("s2", frameClassState, ""), testFrame("c1", frameClassCritical, ""), testFrame("s3", frameClassState, "")},
+			want:   []string{"s2", "c1", "s3"},
+			resync: 1,
+		},
+		{
+			name:   "evicts oldest critical when full of critical",
+			pushes: []*wsFrame{testFrame("c1", frameClassCritical, ""), testFrame("c2", frameClassCritical, ""), testFrame("c3", frameClassCritical, ""), testFrame("c4", frameClassCritical, "")},
+			want:   []string{"c2", "c3", "c4"},
+			resync: 1,
+		},
+		{
+			name:   "drops incoming state when full of critical",
+			pushes: []*wsFrame{testFrame("c1", frameClassCritical, ""), testFrame("c2", frameClassCritical, ""), testFrame("c3", frameClassCritical, ""), testFrame("s1", frameClassState, "")},
+			want:   []string{"c1", "c2", "c3"},
+			resync: 1,
+		},
+		{
+			name:   "drops incoming typing silently",
+			pushes: []*wsFrame{testFrame("s1", frameClassState, ""), testFrame("c1", frameClassCritical, ""), testFrame("c2", frameClassCritical, ""), testFrame("t1", frameClassTyping, "")},
+			want:   []string{"s1", "c1", "c2"},
+		},
+		{
+			name:   "coalesces in place",
+			pushes: []*wsFrame{testFrame("p1", frameClassState, "presence|u1"), testFrame("c1", frameClassCritical, ""), testFrame("p2", frameClassState, "presence|u1")},
+			want:   []string{"p2", "c1"},
+		},
+		{
+			name:   "coalesces on a full queue without eviction",
+			pushes: []*wsFrame{testFrame("p1", frameClassState, "presence|u1"), testFrame("c1", frameClassCritical, ""), testFrame("c2", frameClassCritical, ""), testFrame("p2", frameClassState, "presence|u1")},
+			want:   []string{"p2", "c1", "c2"},
+		},
+		{
+			name:   "different keys are kept",
+			pushes: []*wsFrame{testFrame("p1", frameClassState, "presence|u1"), testFrame("p2", frameClassState, "presence|u2")},
+			want:   []string{"p1", "p2"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := newSendQueue(3, nil)
+			for _, f := range tc.pushes {
+				if !q.push(f) {
+					t.Fatalf("push on open queue returned false")
+				}
+			}
+			got, resync := drainQueue(t, q)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("frames = %v, want %v", got, tc.want)
+			}
+			if resync != tc.resync {
+				t.Fatalf("resync dropped = %d, want %d", resync, tc.resync)
+			}
+			if n := q.stats.queued.Load(); n != 0 {
+				t.Fatalf("queued gauge = %d after drain", n)
+			}
+		})
+	}
+}
+
+func TestSendQueue_GroupEventsCoalescePerRoomAndMember(t *testing.T) {
+	q := newSendQueue(wsClientQueueSize, nil)
+	events := []GroupEvent{
+		{Type: "system_event", Event: "group_member_muted", RoomID: "r1", UserID: "u1", MuteUntil: 1},
+		{Type: "system_event", Event: "group_member_muted", RoomID: "r1", UserID: "u2", MuteUntil: 2},
+		{Type: "system_event", Event: "group_member_muted", RoomID: "r2", UserID: "u1", MuteUntil: 3},
+		{Type: "system_event", Event: "group_member_muted", RoomID: "r1", UserID: "u1", MuteUntil: 4},
+	}
+	for _, e := range events {
+		f, err := newFrame(e)
+		if err != nil {
+			t.Fatalf("newFrame: %v", err)
+		}
+		q.push(f)
+	}
+	var got []int64
+	for {
+		f, ok, _ := q.pop()
+		if !ok {
+			break
+		}
+		var e GroupEvent
+		if err := json.Unmarshal(f.data, &e); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		got = append(got, e.MuteUntil)
+	}
+	if want := []int64{4, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("mute_until order = %v, want %v", got, want)
+	}
+	if n := q.stats.coalesced.Load(); n != 1 {
+		t.Fatalf("coalesced = %d, want 1", n)
+	}
+}
+
+func TestSendQueue_ResyncOnceThenCleared(t *testing.T) {
+	q := newSendQueue(1, nil)
+	q.push(testFrame("s1", frameClassState, ""))
+	q.push(testFrame("s2", frameClassState, ""))
+	q.push(testFrame("s3", frameClassState, ""))
+
+	got, resync := drainQueue(t, q)
+	if resync != 2 || !reflect.DeepEqual(got, []string{"s3"}) {
+		t.Fatalf("got %v resync=%d, want [s3] resync=2", got, resync)
+	}
+	q.push(testFrame("s4", frameClassState, ""))
+	got, resync = drainQueue(t, q)
+	if resync != 0 || !reflect.DeepEqual(got, []string{"s4"}) {
+		t.Fatalf("got %v resync=%d after drain, want [s4] without resync", got, resync)
+	}
+	if n := q.stats.resyncs.Load(); n != 1 {
+		t.Fatalf("resyncs = %d, want 1", n)
+	}
+}
+
+func TestSendQueue_ClosedQueue(t *testing.T) {
+	q := newSendQueue(2, nil)
+	q.push(testFrame("c1", frameClassCritical, ""))
+	q.close()
+	if q.push(testFrame("c2", frameClassCritical, "")) {
+		t.Fatalf("push after close should return false")
+	}
+	if f, ok, closed := q.pop(); !ok || closed || string(f.data) != "c1" {
+		t.Fatalf("queued frame should still be delivered after close")
+	}
+	if _, ok, closed := q.pop(); ok || !closed {
+		t.Fatalf("drained closed queue should report closed")
+	}
+}
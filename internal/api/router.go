@@ -11,13 +11,16 @@ import (
 	"ququchat/internal/api/handler"
 	"ququchat/internal/config"
 	"ququchat/internal/middleware"
+	serverauth "ququchat/internal/server/auth"
 	cachepkg "ququchat/internal/server/cache"
+	"ququchat/internal/server/notify"
 	serverstorage "ququchat/internal/server/storage"
 	taskservice "ququchat/internal/service"
+	webhooksvc "ququchat/internal/service/webhook"
 )
 
 // SetupRouter 初始化 Gin 路由，并将数据库句柄注入到上下文中
-func SetupRouter(db *gorm.DB, authCfg config.AuthSettings, chatCfg config.Chat, fileCfg config.File, avatarCfg config.Avatar, objStorage serverstorage.ObjectStorage, bucket string, redisClient *cachepkg.RedisClient, taskService *taskservice.MainService, wsNodeID string) *gin.Engine {
+func SetupRouter(db *gorm.DB, dbDriver string, authCfg config.AuthSettings, keys *serverauth.KeySet, chatCfg config.Chat, rateCfg config.RateLimit, fileCfg config.File, avatarCfg config.Avatar, objStorage serverstorage.ObjectStorage, bucket string, redisClient *cachepkg.RedisClient, notifier notify.Notifier, webhooks *webhooksvc.Dispatcher, taskService *taskservice.MainService, wsCfg config.WS) *gin.Engine {
 	r := gin.New()
 	r.Use(gin.Logger())
 	r.Use(gin.Recovery())
@@ -34,34 +37,89 @@ func SetupRouter(db *gorm.DB, authCfg config.AuthSettings, chatCfg config.Chat,
 
 	api := r.Group("/api")
 
-	// 认证相关路由（注入认证配置）
-	auth := handler.NewAuthHandler(db, authCfg)
-	api.POST("/auth/register", auth.Register)
-	api.POST("/auth/login", auth.Login)
-	api.POST("/auth/refresh", auth.Refresh)
-	api.POST("/auth/logout", middleware.JWTAuth(authCfg.JWTSecret), auth.Logout)
+	// 限流：匿名认证接口按 IP，已登录接口在 JWT 校验后按 IP 与用户
+	limitCache := redisClient
+	if !rateCfg.EnabledOrDefault() {
+		limitCache = nil
+	}
+	authLimit := middleware.RateLimit(limitCache, "auth", rateCfg.AuthOrDefault())
+	apiLimit := middleware.RateLimit(limitCache, "api", rateCfg.APIOrDefault())
+	lockout := rateCfg.LoginLockout
+	loginGuard := serverauth.NewLoginGuard(limitCache, serverauth.LoginGuardOptions{
+		MaxFailures:   lockout.MaxFailuresOrDefault(),
+		FailureWindow: lockout.FailureWindowDuration(),
+		BaseLockout:   lockout.BaseDurationOrDefault(),
+		MaxLockout:    lockout.MaxDurationOrDefault(),
+	})
 
 	hub := handler.NewHub()
 	var wsRouter *handler.HubRouter
-	if redisClient != nil && wsNodeID != "" {
-		wsRouter = handler.NewHubRouter(wsNodeID, hub, redisClient)
+	if redisClient != nil && wsCfg.NodeID != "" {
+		wsRouter = handler.NewHubRouter(wsCfg.NodeID, hub, redisClient, wsCfg.ReplayWindowDuration())
 		wsRouter.StartSubscriber(context.Background())
 	}
-	userHandler := handler.NewUserHandler(db, fileCfg, avatarCfg, objStorage, bucket, hub, redisClient)
-	friends := api.Group("/friends", middleware.JWTAuth(authCfg.JWTSecret))
+	presence := handler.NewPresence(db, hub, wsRouter, redisClient)
+
+	// 访问令牌校验：携带已吊销会话 ID 的令牌立即失效
+	revocations := serverauth.NewSessionRevocations(redisClient, authCfg.AccessTTL)
+	jwtAuth := middleware.JWTAuth(keys, revocations)
+	// 机器人/程序可访问的路由同时接受 API 令牌，权限由 RequireScope 限定
+	botHandler := handler.NewBotHandler(db, hub, wsRouter)
+	tokenAuth := middleware.TokenAuth(keys, revocations, botHandler)
+
+	// 认证相关路由（注入认证配置）
+	auth := handler.NewAuthHandler(db, authCfg, keys, revocations, loginGuard, notifier, hub, wsRouter)
+	r.GET("/.well-known/jwks.json", auth.JWKS)
+	api.POST("/auth/register", authLimit, auth.Register)
+	api.POST("/auth/login", authLimit, auth.Login)
+	api.POST("/auth/login/2fa", authLimit, auth.LoginTwoFactor)
+	api.GET("/auth/oidc/:provider/login", authLimit, auth.OIDCLogin)
+	api.GET("/auth/oidc/:provider/callback", authLimit, auth.OIDCCallback)
+	api.POST("/auth/refresh", authLimit, auth.Refresh)
+	api.POST("/auth/password/forgot", authLimit, auth.ForgotPassword)
+	api.POST("/auth/password/reset", authLimit, auth.ResetPassword)
+	api.POST("/auth/verify/send", jwtAuth, apiLimit, auth.SendVerificationCode)
+	api.POST("/auth/verify/confirm", jwtAuth, apiLimit, auth.ConfirmVerificationCode)
+	api.POST("/auth/logout", jwtAuth, apiLimit, auth.Logout)
+	api.GET("/auth/sessions", jwtAuth, apiLimit, auth.ListSessions)
+	api.POST("/auth/sessions/revoke", jwtAuth, apiLimit, auth.RevokeSession)
+	api.POST("/auth/sessions/revoke_others", jwtAuth, apiLimit, auth.RevokeOtherSessions)
+	api.POST("/auth/2fa/enroll", jwtAuth, apiLimit, auth.EnrollTwoFactor)
+	api.POST("/auth/2fa/confirm", jwtAuth, apiLimit, auth.ConfirmTwoFactor)
+	api.POST("/auth/2fa/disable", jwtAuth, apiLimit, auth.DisableTwoFactor)
+	api.POST("/auth/2fa/recovery_codes", jwtAuth, apiLimit, auth.RegenerateRecoveryCodes)
+	api.POST("/bots", jwtAuth, apiLimit, botHandler.CreateBot)
+	api.GET("/bots", jwtAuth, apiLimit, botHandler.ListBots)
+	api.POST("/bots/:bot_id/delete", jwtAuth, apiLimit, botHandler.DeleteBot)
+	api.POST("/tokens", jwtAuth, apiLimit, botHandler.CreateAPIToken)
+	api.GET("/tokens", jwtAuth, apiLimit, botHandler.ListAPITokens)
+	api.POST("/tokens/revoke", jwtAuth, apiLimit, botHandler.RevokeAPIToken)
+	// 个人数据导出与注销账号，打包与清理由任务服务异步执行
+	accountHandler := handler.NewAccountHandler(db, auth, taskService, objStorage, bucket)
+	api.POST("/account/exports", jwtAuth, apiLimit, accountHandler.RequestDataExport)
+	api.GET("/account/exports", jwtAuth, apiLimit, accountHandler.ListDataExports)
+	api.GET("/account/exports/:export_id", jwtAuth, apiLimit, accountHandler.GetDataExport)
+	api.POST("/account/delete", jwtAuth, apiLimit, accountHandler.DeleteAccount)
+	go accountHandler.StartPurgeSweeper(context.Background())
+	userHandler := handler.NewUserHandler(db, fileCfg, avatarCfg, objStorage, bucket, hub, redisClient, presence)
+	friends := api.Group("/friends", jwtAuth, apiLimit)
 	friends.POST("/add", userHandler.AddFriend)
 	friends.POST("/remove", userHandler.RemoveFriend)
 	friends.GET("/list", userHandler.ListFriends)
 	friends.GET("/requests/incoming", userHandler.ListIncomingFriendRequests)
 	friends.POST("/requests/respond", userHandler.RespondFriendRequest)
 
-	users := api.Group("/users", middleware.JWTAuth(authCfg.JWTSecret))
+	users := api.Group("/users", jwtAuth, apiLimit)
 	users.POST("/me/avatar", userHandler.UploadAvatar)
+	users.POST("/me/status", userHandler.SetStatus)
 	users.GET("/:user_id/avatar/url", userHandler.GetAvatarURL)
 	users.GET("/:user_id/avatar/thumb/url", userHandler.GetAvatarThumbURL)
+	users.POST("/blocks/add", userHandler.BlockUser)
+	users.POST("/blocks/remove", userHandler.UnblockUser)
+	users.GET("/blocks/list", userHandler.ListBlocks)
 
-	groupHandler := handler.NewGroupHandler(db, hub, redisClient, wsRouter)
-	groups := api.Group("/groups", middleware.JWTAuth(authCfg.JWTSecret))
+	groupHandler := handler.NewGroupHandler(db, hub, redisClient, wsRouter, webhooks)
+	groups := api.Group("/groups", jwtAuth, apiLimit)
 	groups.POST("/create", groupHandler.CreateGroup)
 	groups.GET("/:group_id", groupHandler.GetGroupDetail)
 	groups.GET("/my", groupHandler.ListMyGroups)
@@ -71,18 +129,51 @@ func SetupRouter(db *gorm.DB, authCfg config.AuthSettings, chatCfg config.Chat,
 	groups.POST("/:group_id/leave", groupHandler.LeaveGroup)
 	groups.GET("/:group_id/members", groupHandler.ListGroupMembers)
 	groups.POST("/:group_id/admins/add", groupHandler.AddAdmins)
+	groups.POST("/:group_id/admins/remove", groupHandler.RemoveAdmins)
+	groups.POST("/:group_id/transfer", groupHandler.TransferOwner)
+	groups.POST("/:group_id/rename", groupHandler.RenameGroup)
+	groups.POST("/:group_id/nickname", groupHandler.SetMyNickname)
+	groups.POST("/:group_id/members/mute", groupHandler.MuteMember)
+	groups.POST("/:group_id/members/unmute", groupHandler.UnmuteMember)
+	groups.POST("/:group_id/mute_all", groupHandler.SetMuteAll)
+
+	// 群外发回调，仅群主可管理
+	webhookHandler := handler.NewWebhookHandler(db, webhooks)
+	groups.POST("/:group_id/webhooks", webhookHandler.CreateWebhook)
+	groups.GET("/:group_id/webhooks", webhookHandler.ListWebhooks)
+	groups.POST("/:group_id/webhooks/:webhook_id/delete", webhookHandler.DeleteWebhook)
+	groups.GET("/:group_id/webhooks/:webhook_id/deliveries", webhookHandler.ListWebhookDeliveries)
+	groups.POST("/:group_id/incoming_webhooks", webhookHandler.CreateIncomingWebhook)
+	groups.GET("/:group_id/incoming_webhooks", webhookHandler.ListIncomingWebhooks)
+	groups.POST("/:group_id/incoming_webhooks/:webhook_id/delete", webhookHandler.DeleteIncomingWebhook)
+
+	messageHandler := handler.NewMessageHandler(db, chatCfg.HistoryLimit, dbDriver)
+	api.GET("/messages/history/before", tokenAuth, apiLimit, middleware.RequireScope(serverauth.ScopeMessagesRead), messageHandler.GetHistoryBefore)
+	api.GET("/messages/history/after", tokenAuth, apiLimit, middleware.RequireScope(serverauth.ScopeMessagesRead), messageHandler.GetHistoryAfter)
+	api.GET("/messages/history/latest", jwtAuth, apiLimit, messageHandler.GetLatestByFriend)
+	api.GET("/messages/history/group", tokenAuth, apiLimit, middleware.RequireScope(serverauth.ScopeMessagesRead), messageHandler.GetLatestByGroup)
+	api.GET("/messages/receipts/unread", jwtAuth, apiLimit, messageHandler.GetUnreadCounts)
+	api.GET("/messages/receipts", jwtAuth, apiLimit, messageHandler.GetMessageReceipts)
+	api.GET("/messages/search", jwtAuth, apiLimit, messageHandler.SearchMessages)
+	api.POST("/messages/sync", jwtAuth, apiLimit, messageHandler.Sync)
+
+	conversationHandler := handler.NewConversationHandler(db, redisClient)
+	api.GET("/conversations", jwtAuth, apiLimit, conversationHandler.ListConversations)
+	api.POST("/conversations/settings", jwtAuth, apiLimit, conversationHandler.UpdateConversationSettings)
 
-	messageHandler := handler.NewMessageHandler(db, chatCfg.HistoryLimit)
-	api.GET("/messages/history/before", middleware.JWTAuth(authCfg.JWTSecret), messageHandler.GetHistoryBefore)
-	api.GET("/messages/history/after", middleware.JWTAuth(authCfg.JWTSecret), messageHandler.GetHistoryAfter)
-	api.GET("/messages/history/latest", middleware.JWTAuth(authCfg.JWTSecret), messageHandler.GetLatestByFriend)
-	api.GET("/messages/history/group", middleware.JWTAuth(authCfg.JWTSecret), messageHandler.GetLatestByGroup)
+	pinHandler := handler.NewPinHandler(db, hub, wsRouter)
+	api.GET("/messages/pins", jwtAuth, apiLimit, pinHandler.ListPins)
+	api.POST("/messages/pins/add", jwtAuth, apiLimit, pinHandler.PinMessage)
+	api.POST("/messages/pins/remove", jwtAuth, apiLimit, pinHandler.UnpinMessage)
+	api.GET("/messages/stars", jwtAuth, apiLimit, pinHandler.ListStars)
+	api.POST("/messages/stars/add", jwtAuth, apiLimit, pinHandler.StarMessage)
+	api.POST("/messages/stars/remove", jwtAuth, apiLimit, pinHandler.UnstarMessage)
 	streamHub := taskservice.NewAgentStreamHub()
 	agentStreamHandler := handler.NewAgentStreamHandler(streamHub)
-	api.GET("/agent/stream", middleware.JWTAuth(authCfg.JWTSecret), agentStreamHandler.Stream)
+	api.GET("/agent/stream", jwtAuth, apiLimit, agentStreamHandler.Stream)
 
 	fileHandler := handler.NewFileHandler(db, fileCfg, objStorage, bucket)
-	files := api.Group("/files", middleware.JWTAuth(authCfg.JWTSecret))
+	files := api.Group("/files", jwtAuth, apiLimit)
 	files.POST("/upload", fileHandler.Upload)
 	files.GET("/:attachment_id/url", fileHandler.GetDownloadURL)
 	files.GET("/:attachment_id/thumb/url", fileHandler.GetThumbnailURL)
@@ -92,7 +183,7 @@ func SetupRouter(db *gorm.DB, authCfg config.AuthSettings, chatCfg config.Chat,
 	files.POST("/multipart/complete", fileHandler.CompleteMultipartUpload)
 	files.POST("/multipart/abort", fileHandler.AbortMultipartUpload)
 
-	wsHandler := handler.NewWsHandler(db, hub, redisClient, taskService, streamHub, wsRouter)
+	wsHandler := handler.NewWsHandler(db, hub, redisClient, taskService, streamHub, wsRouter, chatCfg, rateCfg, webhooks, presence)
 	go func() {
 		for {
 			if err := wsHandler.StartTaskDoneConsumer(context.Background()); err != nil {
@@ -104,7 +195,12 @@ func SetupRouter(db *gorm.DB, authCfg config.AuthSettings, chatCfg config.Chat,
 			return
 		}
 	}()
-	r.GET("/ws", middleware.JWTAuthFromHeaderOrQuery(authCfg.JWTSecret), wsHandler.Handle)
+	api.POST("/messages/send", tokenAuth, apiLimit, middleware.RequireScope(serverauth.ScopeMessagesWrite), wsHandler.PostMessage)
+	// 接入回调：路径中的令牌即凭据，仅按 IP 限流
+	api.POST("/hooks/incoming/:token", apiLimit, wsHandler.PostIncomingWebhook)
+	// 节点投递指标仅对运维开放
+	api.GET("/ws/stats", apiLimit, middleware.OpsToken(wsCfg.StatsToken), wsHandler.Stats)
+	r.GET("/ws", middleware.TokenAuthFromHeaderOrQuery(keys, revocations, botHandler), middleware.RequireScope(serverauth.ScopeEventsRead), wsHandler.Handle)
 
 	return r
 }
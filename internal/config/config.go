@@ -3,6 +3,8 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -13,6 +15,9 @@ type Config struct {
 	WS             WS                   `yaml:"ws" json:"ws"`
 	Auth           Auth                 `yaml:"auth" json:"auth"`
 	Chat           Chat                 `yaml:"chat" json:"chat"`
+	RateLimit      RateLimit            `yaml:"rate_limit" json:"rate_limit"`
+	Notify         Notify               `yaml:"notify" json:"notify"`
+	Webhook        Webhook              `yaml:"webhook" json:"webhook"`
 	Task           Task                 `yaml:"task" json:"task"`
 	TaskPriority   TaskPriority         `yaml:"task_priority" json:"task_priority"`
 	LLM            LLM                  `yaml:"llm" json:"llm"`
@@ -45,6 +50,21 @@ type RAGStopPhrasesConfig struct {
 
 type WS struct {
 	NodeID string `yaml:"node_id" json:"node_id"`
+	// ReplayWindow 节点重启或与 Redis 断开后可补收的跨节点消息时间窗口
+	ReplayWindow string `yaml:"replay_window" json:"replay_window"`
+	// StatsToken 运维令牌，请求 /api/ws/stats 时通过 X-Ops-Token 头携带；为空时不开放该接口
+	StatsToken string `yaml:"stats_token" json:"stats_token"`
+}
+
+func (w WS) ReplayWindowDuration() time.Duration {
+	const defaultWindow = 2 * time.Minute
+	if strings.TrimSpace(w.ReplayWindow) == "" {
+		return defaultWindow
+	}
+	if d, err := time.ParseDuration(strings.TrimSpace(w.ReplayWindow)); err == nil && d > 0 {
+		return d
+	}
+	return defaultWindow
 }
 
 type Redis struct {
@@ -7,6 +7,7 @@ import (
     "time"
 
     "ququchat/internal/server/auth"
+    "ququchat/internal/server/oidc"
 )
 
 // Auth 认证相关配置（从 YAML 读取的原始结构）
@@ -15,14 +16,70 @@ type Auth struct {
     AccessTTL         string `yaml:"access_ttl" json:"access_ttl"`
     RefreshTTL        string `yaml:"refresh_ttl" json:"refresh_ttl"`
     RefreshTokenBytes int    `yaml:"refresh_token_bytes" json:"refresh_token_bytes"`
+    // TOTPIssuer 身份验证器中展示的发行方名称
+    TOTPIssuer string `yaml:"totp_issuer" json:"totp_issuer"`
+    // TOTPEncryptionKey 加密存储 TOTP 密钥所用的主密钥
+    TOTPEncryptionKey string `yaml:"totp_encryption_key" json:"totp_encryption_key"`
+    // DevMode 允许使用开发默认 JWT 密钥启动，仅限本地开发
+    DevMode bool `yaml:"dev_mode" json:"dev_mode"`
+    // SigningKeys 非对称签名密钥（RS256/EdDSA），令牌头携带 kid，公钥通过 JWKS 公开
+    SigningKeys []SigningKey `yaml:"signing_keys" json:"signing_keys"`
+    // ActiveKID 当前用于签发的密钥，为空时取第一个配置了私钥的密钥
+    ActiveKID string `yaml:"active_kid" json:"active_kid"`
+    // AcceptLegacyHS256 切换到非对称密钥后仍接受不带 kid 的 HS256 旧令牌，用于平滑迁移
+    AcceptLegacyHS256 bool `yaml:"accept_legacy_hs256" json:"accept_legacy_hs256"`
+    // LegacyHS256Until 接受旧令牌的截止时间（RFC3339），为空表示不设截止
+    LegacyHS256Until string `yaml:"legacy_hs256_until" json:"legacy_hs256_until"`
+    // OIDCProviders 外部 OpenID Connect 登录提供方，键为提供方名称（出现在登录路径中）
+    OIDCProviders map[string]OIDCProvider `yaml:"oidc_providers" json:"oidc_providers"`
 }
 
+// OIDCProvider 单个 OIDC 提供方配置（授权码 + PKCE）
+type OIDCProvider struct {
+    Issuer       string   `yaml:"issuer" json:"issuer"`
+    ClientID     string   `yaml:"client_id" json:"client_id"`
+    ClientSecret string   `yaml:"client_secret" json:"client_secret"`
+    RedirectURL  string   `yaml:"redirect_url" json:"redirect_url"`
+    Scopes       []string `yaml:"scopes" json:"scopes"`
+    // AutoProvision 首次登录时自动创建本地用户，默认开启
+    AutoProvision *bool `yaml:"auto_provision" json:"auto_provision"`
+}
+
+// OIDCProviderSettings 运行时使用的 OIDC 提供方配置
+type OIDCProviderSettings struct {
+    OIDC          oidc.Config
+    AutoProvision bool
+}
+
+// SigningKey 单个签名密钥；仅配置公钥时只用于验签，便于轮换时保留旧密钥
+type SigningKey struct {
+    KID            string `yaml:"kid" json:"kid"`
+    Algorithm      string `yaml:"algorithm" json:"algorithm"`
+    PrivateKeyFile string `yaml:"private_key_file" json:"private_key_file"`
+    PublicKeyFile  string `yaml:"public_key_file" json:"public_key_file"`
+}
+
+// devJWTSecret 开发默认 JWT 密钥，非开发模式下拒绝使用
+const devJWTSecret = "dev-secret-change-me"
+
 // AuthSettings 为运行时使用的认证配置（已解析为具体类型）
 type AuthSettings struct {
     JWTSecret         string
     AccessTTL         time.Duration
     RefreshTTL        time.Duration
     RefreshTokenBytes int
+    TOTPIssuer        string
+    TOTPEncryptionKey string
+    DevMode           bool
+    // UsingDevSecret 为 true 表示 JWTSecret 回退到了开发默认值
+    UsingDevSecret bool
+    // UsingDevTOTPKey 为 true 表示 TOTP 主密钥回退到了开发默认值
+    UsingDevTOTPKey   bool
+    SigningKeys       []auth.KeySpec
+    ActiveKID         string
+    AcceptLegacyHS256 bool
+    LegacyHS256Until  time.Time
+    OIDCProviders     map[string]OIDCProviderSettings
 }
 
 // ToSettings 解析 YAML 中的字符串时长并应用默认值，生成运行时配置
@@ -53,9 +110,68 @@ func (a Auth) ToSettings() AuthSettings {
         } else if s := os.Getenv("QUQUCHAT_JWT_SECRET"); strings.TrimSpace(s) != "" {
             secret = strings.TrimSpace(s)
         } else {
-            const dev = "dev-secret-change-me"
             log.Println("警告: 未配置 JWT_SECRET，使用开发默认值。请设置 JWT_SECRET 环境变量或配置文件！")
-            secret = dev
+            secret = devJWTSecret
+        }
+    }
+    usingDev := secret == devJWTSecret
+
+    issuer := strings.TrimSpace(a.TOTPIssuer)
+    if issuer == "" {
+        issuer = auth.DefaultTOTPIssuer
+    }
+    // TOTPEncryptionKey: 优先配置文件，其次环境变量，最后回退为 JWTSecret
+    totpKey := strings.TrimSpace(a.TOTPEncryptionKey)
+    if totpKey == "" {
+        if s := os.Getenv("QUQUCHAT_TOTP_KEY"); strings.TrimSpace(s) != "" {
+            totpKey = strings.TrimSpace(s)
+        } else {
+            log.Println("警告: 未配置 totp_encryption_key，TOTP 密钥将使用 JWT 密钥加密")
+            totpKey = secret
+        }
+    }
+
+    devMode := a.DevMode
+    if s := strings.TrimSpace(os.Getenv("QUQUCHAT_DEV_MODE")); s == "1" || strings.EqualFold(s, "true") {
+        devMode = true
+    }
+    keys := make([]auth.KeySpec, 0, len(a.SigningKeys))
+    for _, k := range a.SigningKeys {
+        keys = append(keys, auth.KeySpec{
+            KID:            strings.TrimSpace(k.KID),
+            Algorithm:      strings.TrimSpace(k.Algorithm),
+            PrivateKeyFile: strings.TrimSpace(k.PrivateKeyFile),
+            PublicKeyFile:  strings.TrimSpace(k.PublicKeyFile),
+        })
+    }
+
+    acceptLegacy := a.AcceptLegacyHS256
+    var legacyUntil time.Time
+    if s := strings.TrimSpace(a.LegacyHS256Until); s != "" && acceptLegacy {
+        if t, err := time.Parse(time.RFC3339, s); err == nil {
+            legacyUntil = t
+        } else {
+            log.Printf("警告: legacy_hs256_until 格式错误（需 RFC3339），已停止接受 HS256 旧令牌: %v", err)
+            acceptLegacy = false
+        }
+    }
+
+    providers := make(map[string]OIDCProviderSettings, len(a.OIDCProviders))
+    for name, p := range a.OIDCProviders {
+        name = strings.TrimSpace(name)
+        if name == "" || strings.TrimSpace(p.Issuer) == "" || strings.TrimSpace(p.ClientID) == "" {
+            log.Printf("警告: OIDC 提供方 %q 缺少 issuer 或 client_id，已忽略", name)
+            continue
+        }
+        providers[name] = OIDCProviderSettings{
+            OIDC: oidc.Config{
+                Issuer:       strings.TrimSpace(p.Issuer),
+                ClientID:     strings.TrimSpace(p.ClientID),
+                ClientSecret: strings.TrimSpace(p.ClientSecret),
+                RedirectURL:  strings.TrimSpace(p.RedirectURL),
+                Scopes:       p.Scopes,
+            },
+            AutoProvision: p.AutoProvision == nil || *p.AutoProvision,
         }
     }
 
@@ -64,5 +180,15 @@ func (a Auth) ToSettings() AuthSettings {
         AccessTTL:         access,
         RefreshTTL:        refresh,
         RefreshTokenBytes: bytes,
+        TOTPIssuer:        issuer,
+        TOTPEncryptionKey: totpKey,
+        DevMode:           devMode,
+        UsingDevSecret:    usingDev,
+        UsingDevTOTPKey:   totpKey == devJWTSecret,
+        SigningKeys:       keys,
+        ActiveKID:         strings.TrimSpace(a.ActiveKID),
+        AcceptLegacyHS256: acceptLegacy,
+        LegacyHS256Until:  legacyUntil,
+        OIDCProviders:     providers,
     }
 }
\ No newline at end of file
@@ -6,7 +6,20 @@ import (
 )
 
 type Chat struct {
-	HistoryLimit int `yaml:"history_limit" json:"history_limit"`
+	HistoryLimit int    `yaml:"history_limit" json:"history_limit"`
+	RecallWindow string `yaml:"recall_window" json:"recall_window"`
+}
+
+// RecallWindowDuration 发送者可撤回自己消息的时间窗口
+func (c Chat) RecallWindowDuration() time.Duration {
+	const defaultWindow = 2 * time.Minute
+	if strings.TrimSpace(c.RecallWindow) == "" {
+		return defaultWindow
+	}
+	if d, err := time.ParseDuration(strings.TrimSpace(c.RecallWindow)); err == nil && d > 0 {
+		return d
+	}
+	return defaultWindow
 }
 
 type Task struct {
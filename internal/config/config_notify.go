@@ -0,0 +1,35 @@
+package config
+
+import "strings"
+
+// Notify 验证码与密码重置通知的发送通道
+type Notify struct {
+	// Driver 可选 log（写入日志/文件，仅限本地测试）、smtp，默认 log
+	Driver string `yaml:"driver" json:"driver"`
+	// LogFile 为空时输出到标准日志
+	LogFile string `yaml:"log_file" json:"log_file"`
+	SMTP    SMTP   `yaml:"smtp" json:"smtp"`
+}
+
+type SMTP struct {
+	Host     string `yaml:"host" json:"host"`
+	Port     int    `yaml:"port" json:"port"`
+	Username string `yaml:"username" json:"username"`
+	Password string `yaml:"password" json:"password"`
+	From     string `yaml:"from" json:"from"`
+}
+
+func (n Notify) DriverOrDefault() string {
+	if d := strings.ToLower(strings.TrimSpace(n.Driver)); d != "" {
+		return d
+	}
+	return "log"
+}
+
+// PortOrDefault 默认使用 587（STARTTLS）
+func (s SMTP) PortOrDefault() int {
+	if s.Port > 0 {
+		return s.Port
+	}
+	return 587
+}
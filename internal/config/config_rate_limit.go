@@ -0,0 +1,111 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+// RateLimit 接口限流与登录防爆破配置，依赖 Redis；Redis 不可用时不限流
+type RateLimit struct {
+	Enabled      *bool          `yaml:"enabled" json:"enabled"`
+	Auth         RateLimitRule  `yaml:"auth" json:"auth"`
+	API          RateLimitRule  `yaml:"api" json:"api"`
+	LoginLockout LoginLockout   `yaml:"login_lockout" json:"login_lockout"`
+	WS           WSMessageLimit `yaml:"ws" json:"ws"`
+}
+
+// RateLimitRule 单个路由组的滑动窗口限额，0 表示使用默认值，负数表示不限制该维度
+type RateLimitRule struct {
+	Window  string `yaml:"window" json:"window"`
+	PerIP   int    `yaml:"per_ip" json:"per_ip"`
+	PerUser int    `yaml:"per_user" json:"per_user"`
+}
+
+// LoginLockout 连续登录失败达到阈值后锁定账号，锁定时长按次数指数增长
+type LoginLockout struct {
+	MaxFailures   int    `yaml:"max_failures" json:"max_failures"`
+	FailureWindow string `yaml:"failure_window" json:"failure_window"`
+	BaseDuration  string `yaml:"base_duration" json:"base_duration"`
+	MaxDuration   string `yaml:"max_duration" json:"max_duration"`
+}
+
+// WSMessageLimit 单个 WebSocket 连接的上行消息速率
+type WSMessageLimit struct {
+	MessagesPerSecond int `yaml:"messages_per_second" json:"messages_per_second"`
+	Burst             int `yaml:"burst" json:"burst"`
+}
+
+func parseDurationOr(s string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(strings.TrimSpace(s)); err == nil && d > 0 {
+		return d
+	}
+	return def
+}
+
+func (r RateLimit) EnabledOrDefault() bool {
+	if r.Enabled != nil {
+		return *r.Enabled
+	}
+	return true
+}
+
+// AuthOrDefault 登录/注册等匿名接口默认每 IP 每分钟 20 次
+func (r RateLimit) AuthOrDefault() RateLimitRule {
+	return r.Auth.withDefaults(time.Minute, 20, -1)
+}
+
+// APIOrDefault 已登录接口默认每 IP 每分钟 600 次、每用户每分钟 300 次
+func (r RateLimit) APIOrDefault() RateLimitRule {
+	return r.API.withDefaults(time.Minute, 600, 300)
+}
+
+func (r RateLimitRule) withDefaults(window time.Duration, perIP, perUser int) RateLimitRule {
+	out := r
+	if strings.TrimSpace(out.Window) == "" {
+		out.Window = window.String()
+	}
+	if out.PerIP == 0 {
+		out.PerIP = perIP
+	}
+	if out.PerUser == 0 {
+		out.PerUser = perUser
+	}
+	return out
+}
+
+func (r RateLimitRule) WindowDuration() time.Duration {
+	return parseDurationOr(r.Window, time.Minute)
+}
+
+func (l LoginLockout) MaxFailuresOrDefault() int {
+	if l.MaxFailures > 0 {
+		return l.MaxFailures
+	}
+	return 5
+}
+
+func (l LoginLockout) FailureWindowDuration() time.Duration {
+	return parseDurationOr(l.FailureWindow, 15*time.Minute)
+}
+
+func (l LoginLockout) BaseDurationOrDefault() time.Duration {
+	return parseDurationOr(l.BaseDuration, time.Minute)
+}
+
+func (l LoginLockout) MaxDurationOrDefault() time.Duration {
+	return parseDurationOr(l.MaxDuration, time.Hour)
+}
+
+func (w WSMessageLimit) MessagesPerSecondOrDefault() int {
+	if w.MessagesPerSecond > 0 {
+		return w.MessagesPerSecond
+	}
+	return 20
+}
+
+func (w WSMessageLimit) BurstOrDefault() int {
+	if w.Burst > 0 {
+		return w.Burst
+	}
+	return 2 * w.MessagesPerSecondOrDefault()
+}
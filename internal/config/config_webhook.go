@@ -0,0 +1,62 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+// Webhook 群事件外发回调（outgoing webhook）投递配置
+type Webhook struct {
+	Enabled *bool `yaml:"enabled" json:"enabled"`
+	// RabbitMQURL 为空时复用 task.done_event_rabbitmq_url；仍为空则由进程内 worker 直接投递
+	RabbitMQURL string `yaml:"rabbitmq_url" json:"rabbitmq_url"`
+	QueueName   string `yaml:"queue_name" json:"queue_name"`
+	MaxAttempts int    `yaml:"max_attempts" json:"max_attempts"`
+	Timeout     string `yaml:"timeout" json:"timeout"`
+	BackoffBase string `yaml:"backoff_base" json:"backoff_base"`
+	BackoffMax  string `yaml:"backoff_max" json:"backoff_max"`
+	// AllowPrivateTargets 允许投递到内网/回环地址，默认关闭以防 SSRF
+	AllowPrivateTargets bool `yaml:"allow_private_targets" json:"allow_private_targets"`
+}
+
+func (w Webhook) EnabledOrDefault() bool {
+	if w.Enabled != nil {
+		return *w.Enabled
+	}
+	return true
+}
+
+func (w Webhook) RabbitMQURLOr(fallback string) string {
+	if s := strings.TrimSpace(w.RabbitMQURL); s != "" {
+		return s
+	}
+	return strings.TrimSpace(fallback)
+}
+
+func (w Webhook) QueueNameOrDefault() string {
+	if s := strings.TrimSpace(w.QueueName); s != "" {
+		return s
+	}
+	return "ququchat.webhook.delivery"
+}
+
+// MaxAttemptsOrDefault 默认最多投递 8 次（含首次）
+func (w Webhook) MaxAttemptsOrDefault() int {
+	if w.MaxAttempts > 0 {
+		return w.MaxAttempts
+	}
+	return 8
+}
+
+func (w Webhook) TimeoutOrDefault() time.Duration {
+	return parseDurationOr(w.Timeout, 10*time.Second)
+}
+
+// BackoffBaseOrDefault 第 n 次失败后等待 base*2^(n-1)，不超过 BackoffMax
+func (w Webhook) BackoffBaseOrDefault() time.Duration {
+	return parseDurationOr(w.BackoffBase, 30*time.Second)
+}
+
+func (w Webhook) BackoffMaxOrDefault() time.Duration {
+	return parseDurationOr(w.BackoffMax, time.Hour)
+}
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"ququchat/internal/server/auth"
+)
+
+// apiTokenContextKey Context 中保存 API 令牌主体的键；JWT 登录的请求不设置
+const apiTokenContextKey = "api_token"
+
+// TokenAuth Gin 中间件：同时接受用户访问令牌（JWT）与 API 令牌（qqc_ 前缀）
+// 仅用于开放给机器人/程序访问的路由，需配合 RequireScope 限定权限范围
+func TokenAuth(keys *auth.KeySet, revocations *auth.SessionRevocations, resolver auth.APITokenResolver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenStr, ok := extractBearerFromHeader(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "缺少 Authorization 头"})
+			return
+		}
+		if !injectToken(c, tokenStr, keys, revocations, resolver) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// TokenAuthFromHeaderOrQuery 同 TokenAuth，允许通过 ?token= 传递（WebSocket 握手）
+func TokenAuthFromHeaderOrQuery(keys *auth.KeySet, revocations *auth.SessionRevocations, resolver auth.APITokenResolver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenStr, ok := extractBearerFromHeader(c)
+		if !ok {
+			q := strings.TrimSpace(c.Query("token"))
+			if q == "" {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "缺少访问令牌"})
+				return
+			}
+			tokenStr = q
+		}
+		if !injectToken(c, tokenStr, keys, revocations, resolver) {
+			return
+		}
+		c.Next()
+	}
+}
+
+func injectToken(c *gin.Context, tokenStr string, keys *auth.KeySet, revocations *auth.SessionRevocations, resolver auth.APITokenResolver) bool {
+	if !auth.IsAPIToken(tokenStr) {
+		return injectClaims(c, tokenStr, keys, revocations)
+	}
+	principal, err := resolver.ResolveAPIToken(c.Request.Context(), tokenStr)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "API 令牌无效或已吊销"})
+		return false
+	}
+	c.Set("user_id", principal.UserID)
+	c.Set("username", principal.Username)
+	// 以令牌 ID 作为会话 ID，吊销令牌时可按会话断开其 WebSocket 连接
+	c.Set("session_id", principal.TokenID)
+	c.Set(apiTokenContextKey, principal)
+	return true
+}
+
+// APITokenFromContext 返回当前请求的 API 令牌主体；JWT 登录的请求返回 false
+func APITokenFromContext(c *gin.Context) (*auth.APITokenPrincipal, bool) {
+	v, ok := c.Get(apiTokenContextKey)
+	if !ok {
+		return nil, false
+	}
+	p, ok := v.(*auth.APITokenPrincipal)
+	return p, ok
+}
+
+// RequireScope Gin 中间件：API 令牌须包含指定权限范围；路径或查询参数带房间 ID（group_id/room_id）时同时校验房间限制
+// 不解析请求体：房间 ID 在请求体中的接口须在 handler 内调用 AllowsRoom 自行校验（如 PostMessage）
+// 用户访问令牌不受限制
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, ok := APITokenFromContext(c)
+		if !ok {
+			c.Next()
+			return
+		}
+		if !p.HasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API 令牌缺少权限: " + scope})
+			return
+		}
+		roomID := c.Param("group_id")
+		if roomID == "" {
+			roomID = c.Param("room_id")
+		}
+		if roomID == "" {
+			roomID = c.Query("group_id")
+		}
+		if roomID == "" {
+			roomID = c.Query("room_id")
+		}
+		if roomID != "" && !p.AllowsRoom(roomID) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API 令牌无权访问该房间"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -11,33 +11,15 @@ import (
 	"ququchat/internal/server/auth"
 )
 
-// JWTAuth Gin 中间件：验证 Bearer 令牌，将用户信息注入到 Context
-func JWTAuth(secret string) gin.HandlerFunc {
+// JWTAuth Gin 中间件：验证 Bearer 令牌并拒绝已吊销会话，将用户信息注入到 Context
+func JWTAuth(keys *auth.KeySet, revocations *auth.SessionRevocations) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tokenStr, ok := extractBearerFromHeader(c)
 		if !ok {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "缺少 Authorization 头"})
 			return
 		}
-		if !injectClaims(c, tokenStr, secret) {
-			return
-		}
-		c.Next()
-	}
-}
-
-func JWTAuthFromHeaderOrQuery(secret string) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		tokenStr, ok := extractBearerFromHeader(c)
-		if !ok {
-			q := strings.TrimSpace(c.Query("token"))
-			if q == "" {
-				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "缺少访问令牌"})
-				return
-			}
-			tokenStr = q
-		}
-		if !injectClaims(c, tokenStr, secret) {
+		if !injectClaims(c, tokenStr, keys, revocations) {
 			return
 		}
 		c.Next()
@@ -60,8 +42,8 @@ func extractBearerFromHeader(c *gin.Context) (string, bool) {
 	return tokenStr, true
 }
 
-func injectClaims(c *gin.Context, tokenStr, secret string) bool {
-	claims, err := auth.ParseAndValidate(tokenStr, secret)
+func injectClaims(c *gin.Context, tokenStr string, keys *auth.KeySet, revocations *auth.SessionRevocations) bool {
+	claims, err := auth.ParseAndValidate(tokenStr, keys)
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "访问令牌已过期"})
@@ -70,8 +52,13 @@ func injectClaims(c *gin.Context, tokenStr, secret string) bool {
 		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "访问令牌无效"})
 		return false
 	}
+	if revocations.IsRevoked(c.Request.Context(), claims.UserID, claims.SessionID) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "会话已被注销"})
+		return false
+	}
 	c.Set("user_id", claims.UserID)
 	c.Set("username", claims.Username)
+	c.Set("session_id", claims.SessionID)
 	return true
 }
 
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpsTokenHeader 运维接口携带令牌的请求头
+const OpsTokenHeader = "X-Ops-Token"
+
+// OpsToken Gin 中间件：运维接口须携带与配置一致的 X-Ops-Token；未配置令牌时接口不开放
+func OpsToken(token string) gin.HandlerFunc {
+	token = strings.TrimSpace(token)
+	return func(c *gin.Context) {
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "接口未开放"})
+			return
+		}
+		got := strings.TrimSpace(c.GetHeader(OpsTokenHeader))
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "运维令牌无效"})
+			return
+		}
+		c.Next()
+	}
+}
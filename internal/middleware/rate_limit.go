@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"ququchat/internal/config"
+	cachepkg "ququchat/internal/server/cache"
+)
+
+// RateLimit Gin 中间件：基于 Redis 滑动窗口按路由组限流
+// 按 IP 计数始终生效；按用户计数需在 JWTAuth 之后注册才能取得 user_id。Redis 不可用时放行
+func RateLimit(cache *cachepkg.RedisClient, group string, rule config.RateLimitRule) gin.HandlerFunc {
+	window := rule.WindowDuration()
+	return func(c *gin.Context) {
+		if cache == nil {
+			c.Next()
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Millisecond)
+		defer cancel()
+		if rule.PerIP > 0 {
+			if !allowOrAbort(ctx, c, cache, cachepkg.RateLimitKey(group, "ip", c.ClientIP()), rule.PerIP, window) {
+				return
+			}
+		}
+		if userID := c.GetString("user_id"); rule.PerUser > 0 && userID != "" {
+			if !allowOrAbort(ctx, c, cache, cachepkg.RateLimitKey(group, "user", userID), rule.PerUser, window) {
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+func allowOrAbort(ctx context.Context, c *gin.Context, cache *cachepkg.RedisClient, keyParts []string, limit int, window time.Duration) bool {
+	allowed, retryAfter, err := cache.SlidingWindowAllow(ctx, cache.BuildKey(keyParts...), limit, window)
+	if err != nil || allowed {
+		return true
+	}
+	c.Header("Retry-After", fmt.Sprint(int(math.Ceil(retryAfter.Seconds()))))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "请求过于频繁，请稍后再试"})
+	return false
+}
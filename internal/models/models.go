@@ -31,6 +31,14 @@ const (
 	FriendRequestCanceled FriendRequestStatus = "canceled"
 )
 
+type VerificationPurpose string
+
+const (
+	VerificationPurposeEmail         VerificationPurpose = "verify_email"
+	VerificationPurposePhone         VerificationPurpose = "verify_phone"
+	VerificationPurposePasswordReset VerificationPurpose = "password_reset"
+)
+
 type ContentType string
 
 const (
@@ -44,18 +52,33 @@ const (
 // 使用字符串 UUID 作为主键，可在应用层或数据库默认生成
 // 如 Postgres 可使用: gorm:"type:uuid;default:gen_random_uuid()"
 type User struct {
-	ID                 string    `gorm:"type:char(36);primaryKey" json:"id"`
-	UserCode           int64     `gorm:"autoIncrement;uniqueIndex;not null" json:"user_code"`
-	Username           string    `gorm:"size:64;uniqueIndex;not null" json:"username"`
-	Email              *string   `gorm:"size:255;uniqueIndex" json:"email,omitempty"`
-	Phone              *string   `gorm:"size:32;uniqueIndex" json:"phone,omitempty"`
-	PasswordHash       string    `gorm:"size:255;not null" json:"-"`
-	Status             string    `gorm:"size:16;not null;default:offline" json:"status"`
-	DisplayName        *string   `gorm:"size:64" json:"display_name,omitempty"`
-	AvatarAttachmentID *string   `gorm:"type:char(36)" json:"avatar_attachment_id,omitempty"`
-	Bio                *string   `gorm:"size:1024" json:"bio,omitempty"`
-	CreatedAt          time.Time `gorm:"not null" json:"created_at"`
-	UpdatedAt          time.Time `gorm:"not null" json:"updated_at"`
+	ID                 string  `gorm:"type:char(36);primaryKey" json:"id"`
+	UserCode           int64   `gorm:"autoIncrement;uniqueIndex;not null" json:"user_code"`
+	Username           string  `gorm:"size:64;uniqueIndex;not null" json:"username"`
+	Email              *string `gorm:"size:255;uniqueIndex" json:"email,omitempty"`
+	Phone              *string `gorm:"size:32;uniqueIndex" json:"phone,omitempty"`
+	PasswordHash       string  `gorm:"size:255;not null" json:"-"`
+	Status             string  `gorm:"size:16;not null;default:offline" json:"status"`
+	DisplayName        *string `gorm:"size:64" json:"display_name,omitempty"`
+	AvatarAttachmentID *string `gorm:"type:char(36)" json:"avatar_attachment_id,omitempty"`
+	Bio                *string `gorm:"size:1024" json:"bio,omitempty"`
+	// 机器人账号由 BotOwnerID 对应的用户创建，只能通过 API 令牌访问
+	IsBot      bool    `gorm:"not null;default:false" json:"is_bot"`
+	BotOwnerID *string `gorm:"type:char(36);index" json:"bot_owner_id,omitempty"`
+	// 邮箱/手机号通过验证码确认的时间，为空表示未验证
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	PhoneVerifiedAt *time.Time `json:"phone_verified_at,omitempty"`
+	// 最近一次上线或下线的时间，在线状态以 Redis 中的连接心跳为准
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+	// 最近一次按服务端游标推送 sync 帧的时间，此前加入的房间不再作为新房间返回
+	LastSyncedAt *time.Time `json:"-"`
+	// 注销后待提交清理任务的时间，提交成功后清空；到期仍未清空的由清扫任务重新提交
+	PurgePendingAt *time.Time `gorm:"index" json:"-"`
+	// 用户自定义状态文字与表情
+	StatusText  *string   `gorm:"size:128" json:"status_text,omitempty"`
+	StatusEmoji *string   `gorm:"size:64" json:"status_emoji,omitempty"`
+	CreatedAt   time.Time `gorm:"not null" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"not null" json:"updated_at"`
 }
 
 // 登录会话/令牌
@@ -72,9 +95,168 @@ type AuthSession struct {
 	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
 	IP           *string    `gorm:"size:64" json:"ip,omitempty"`
 	UserAgent    *string    `gorm:"size:256" json:"user_agent,omitempty"`
+	LastActiveAt *time.Time `json:"last_active_at,omitempty"`
+	CreatedAt    time.Time  `gorm:"not null" json:"created_at"`
+}
+
+// 用户二次验证（TOTP）配置，SecretEnc 为加密后的共享密钥
+// EnabledAt 为空表示已登记但尚未确认
+type UserTwoFactor struct {
+	UserID       string     `gorm:"type:char(36);primaryKey" json:"user_id"`
+	User         *User      `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	SecretEnc    string     `gorm:"size:255;not null" json:"-"`
+	EnabledAt    *time.Time `json:"enabled_at,omitempty"`
+	LastUsedStep int64      `gorm:"not null;default:0" json:"-"`
+	CreatedAt    time.Time  `gorm:"not null" json:"created_at"`
+	UpdatedAt    time.Time  `gorm:"not null" json:"updated_at"`
+}
+
+// 二次验证恢复码，仅保存哈希，使用后标记 UsedAt
+type TwoFactorRecoveryCode struct {
+	ID        string     `gorm:"type:char(36);primaryKey" json:"id"`
+	UserID    string     `gorm:"type:char(36);not null;index" json:"user_id"`
+	User      *User      `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	CodeHash  string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `gorm:"not null" json:"created_at"`
+}
+
+// 一次性验证令牌：邮箱/手机验证码与密码重置令牌，仅保存哈希
+// Target 为发送时的邮箱或手机号，确认时需与用户当前联系方式一致
+type VerificationToken struct {
+	ID        string              `gorm:"type:char(36);primaryKey" json:"id"`
+	UserID    string              `gorm:"type:char(36);not null;index:idx_verification_user_purpose,priority:1" json:"user_id"`
+	User      *User               `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	Purpose   VerificationPurpose `gorm:"type:varchar(32);not null;index:idx_verification_user_purpose,priority:2" json:"purpose"`
+	Target    string              `gorm:"size:255;not null" json:"target"`
+	TokenHash string              `gorm:"size:64;not null;index" json:"-"`
+	Attempts  int                 `gorm:"not null;default:0" json:"-"`
+	ExpiresAt time.Time           `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time          `json:"used_at,omitempty"`
+	CreatedAt time.Time           `gorm:"not null" json:"created_at"`
+}
+
+// API 令牌：供机器人或用户本人以程序方式访问，仅保存哈希
+// Scopes 为空格分隔的权限范围；RoomIDs 为空格分隔的房间白名单，为空表示不限房间
+type APIToken struct {
+	ID         string     `gorm:"type:char(36);primaryKey" json:"id"`
+	UserID     string     `gorm:"type:char(36);not null;index" json:"user_id"`
+	User       *User      `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	CreatedBy  string     `gorm:"type:char(36);not null;index" json:"created_by"`
+	Name       string     `gorm:"size:64;not null" json:"name"`
+	Prefix     string     `gorm:"size:16;not null" json:"prefix"`
+	TokenHash  string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	Scopes     string     `gorm:"size:255;not null" json:"scopes"`
+	RoomIDs    string     `gorm:"type:text" json:"room_ids"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `gorm:"not null" json:"created_at"`
+}
+
+// 群外发回调（outgoing webhook），EventTypes 为空格分隔的订阅事件类型
+type RoomWebhook struct {
+	ID         string    `gorm:"type:char(36);primaryKey" json:"id"`
+	RoomID     string    `gorm:"type:char(36);not null;index" json:"room_id"`
+	Room       *Room     `gorm:"foreignKey:RoomID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	CreatedBy  string    `gorm:"type:char(36);not null" json:"created_by"`
+	URL        string    `gorm:"size:2048;not null" json:"url"`
+	Secret     string    `gorm:"size:128;not null" json:"-"`
+	EventTypes string    `gorm:"size:255;not null" json:"event_types"`
+	Active     bool      `gorm:"not null;default:true" json:"active"`
+	CreatedAt  time.Time `gorm:"not null" json:"created_at"`
+	UpdatedAt  time.Time `gorm:"not null" json:"updated_at"`
+}
+
+// 群接入回调（incoming webhook）：外部系统凭令牌向群发送消息，BotUserID 为消息发送者（创建时生成的机器人账号）
+type RoomIncomingWebhook struct {
+	ID         string     `gorm:"type:char(36);primaryKey" json:"id"`
+	RoomID     string     `gorm:"type:char(36);not null;index" json:"room_id"`
+	Room       *Room      `gorm:"foreignKey:RoomID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	CreatedBy  string     `gorm:"type:char(36);not null" json:"created_by"`
+	BotUserID  string     `gorm:"type:char(36);not null" json:"bot_user_id"`
+	Name       string     `gorm:"size:64;not null" json:"name"`
+	Prefix     string     `gorm:"size:16;not null" json:"prefix"`
+	TokenHash  string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	Active     bool       `gorm:"not null;default:true" json:"active"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `gorm:"not null" json:"created_at"`
+	UpdatedAt  time.Time  `gorm:"not null" json:"updated_at"`
+}
+
+// 回调投递 outbox：事件落库后再投递，NextAttemptAt 到期且未完成的记录由清扫任务重新入队
+type WebhookDelivery struct {
+	ID             string       `gorm:"type:char(36);primaryKey" json:"id"`
+	WebhookID      string       `gorm:"type:char(36);not null;index" json:"webhook_id"`
+	Webhook        *RoomWebhook `gorm:"foreignKey:WebhookID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	RoomID         string       `gorm:"type:char(36);not null;index" json:"room_id"`
+	EventType      string       `gorm:"size:64;not null" json:"event_type"`
+	EventID        string       `gorm:"type:char(36);not null;index" json:"event_id"`
+	Payload        string       `gorm:"type:text;not null" json:"payload"`
+	Status         string       `gorm:"size:32;not null;default:pending;index:idx_webhook_delivery_due,priority:1" json:"status"`
+	Attempts       int          `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt  time.Time    `gorm:"not null;index:idx_webhook_delivery_due,priority:2" json:"next_attempt_at"`
+	LastStatusCode int          `gorm:"not null;default:0" json:"last_status_code"`
+	LastError      string       `gorm:"size:512" json:"last_error,omitempty"`
+	DeliveredAt    *time.Time   `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time    `gorm:"not null;index" json:"created_at"`
+	UpdatedAt      time.Time    `gorm:"not null" json:"updated_at"`
+}
+
+const (
+	WebhookDeliveryStatusPending   = "pending"
+	WebhookDeliveryStatusRetrying  = "retrying"
+	WebhookDeliveryStatusSucceeded = "succeeded"
+	WebhookDeliveryStatusFailed    = "failed"
+)
+
+// 单次投递尝试记录
+type WebhookDeliveryAttempt struct {
+	ID         string    `gorm:"type:char(36);primaryKey" json:"id"`
+	DeliveryID string    `gorm:"type:char(36);not null;index" json:"delivery_id"`
+	AttemptNo  int       `gorm:"not null" json:"attempt_no"`
+	StatusCode int       `gorm:"not null;default:0" json:"status_code"`
+	Error      string    `gorm:"size:512" json:"error,omitempty"`
+	DurationMs int64     `gorm:"not null;default:0" json:"duration_ms"`
+	CreatedAt  time.Time `gorm:"not null" json:"created_at"`
+}
+
+// 外部身份（OIDC）与本地用户的关联，(Provider, Subject) 唯一确定一个外部账号
+// Provider 为配置中的提供方名称，Subject 为 ID Token 的 sub
+type UserIdentity struct {
+	ID          string     `gorm:"type:char(36);primaryKey" json:"id"`
+	UserID      string     `gorm:"type:char(36);not null;index" json:"user_id"`
+	User        *User      `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	Provider    string     `gorm:"size:64;not null;uniqueIndex:uidx_identity_provider_subject,priority:1" json:"provider"`
+	Subject     string     `gorm:"size:255;not null;uniqueIndex:uidx_identity_provider_subject,priority:2" json:"subject"`
+	Email       *string    `gorm:"size:255" json:"email,omitempty"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+	CreatedAt   time.Time  `gorm:"not null" json:"created_at"`
+}
+
+// 个人数据导出记录，由任务服务异步打包为 ZIP 写入对象存储，ObjectKey 在成功后填充
+type DataExport struct {
+	ID           string     `gorm:"type:char(36);primaryKey" json:"id"`
+	UserID       string     `gorm:"type:char(36);not null;index" json:"user_id"`
+	User         *User      `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	TaskID       string     `gorm:"type:char(36)" json:"task_id,omitempty"`
+	Status       string     `gorm:"size:32;not null;default:pending;index" json:"status"`
+	ObjectKey    string     `gorm:"size:512" json:"-"`
+	SizeBytes    int64      `gorm:"not null;default:0" json:"size_bytes"`
+	ErrorMessage string     `gorm:"size:512" json:"error_message,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty"`
 	CreatedAt    time.Time  `gorm:"not null" json:"created_at"`
+	UpdatedAt    time.Time  `gorm:"not null" json:"updated_at"`
 }
 
+const (
+	DataExportStatusPending   = "pending"
+	DataExportStatusRunning   = "running"
+	DataExportStatusSucceeded = "succeeded"
+	DataExportStatusFailed    = "failed"
+)
+
 // 好友请求
 // 使用三列唯一索引 (from, to, status) 以兼容多数据库
 // 若使用 Postgres，可在迁移中改为部分唯一索引 (status='pending')
@@ -113,14 +295,16 @@ type Block struct {
 
 // 房间，采用软删除；群聊/单聊通过 RoomType 区分
 type Room struct {
-	ID          string         `gorm:"type:char(36);primaryKey" json:"id"`
-	RoomType    RoomType       `gorm:"type:varchar(16);not null;index" json:"room_type"`
-	Name        string         `gorm:"size:128;not null" json:"name"`
-	OwnerUserID string         `gorm:"type:char(36);not null;index" json:"owner_user_id"`
-	OwnerUser   *User          `gorm:"foreignKey:OwnerUserID;constraint:OnDelete:CASCADE" json:"-"`
-	DeletedAt   gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
-	CreatedAt   time.Time      `gorm:"not null" json:"created_at"`
-	UpdatedAt   time.Time      `gorm:"not null" json:"updated_at"`
+	ID          string   `gorm:"type:char(36);primaryKey" json:"id"`
+	RoomType    RoomType `gorm:"type:varchar(16);not null;index" json:"room_type"`
+	Name        string   `gorm:"size:128;not null" json:"name"`
+	OwnerUserID string   `gorm:"type:char(36);not null;index" json:"owner_user_id"`
+	OwnerUser   *User    `gorm:"foreignKey:OwnerUserID;constraint:OnDelete:CASCADE" json:"-"`
+	// MuteAll 全员禁言，开启后仅群主和管理员可发言
+	MuteAll   bool           `gorm:"not null;default:false" json:"mute_all"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+	CreatedAt time.Time      `gorm:"not null" json:"created_at"`
+	UpdatedAt time.Time      `gorm:"not null" json:"updated_at"`
 }
 
 // 房间成员，复合主键 (room_id, user_id)
@@ -135,17 +319,24 @@ type RoomMember struct {
 	InviteBy       *string    `gorm:"type:char(36)" json:"invite_by,omitempty"`
 	MuteUntil      *time.Time `json:"mute_until,omitempty"`
 	NicknameInRoom *string    `gorm:"size:64" json:"nickname_in_room,omitempty"`
+	// 会话列表中的个人设置：免打扰 / 置顶 / 归档
+	NotifyMuted bool       `gorm:"not null;default:false" json:"notify_muted"`
+	PinnedAt    *time.Time `json:"pinned_at,omitempty"`
+	Archived    bool       `gorm:"not null;default:false" json:"archived"`
 }
 
 // 消息，采用软删除；Payload 使用 GORM datatypes.JSON
 // 复合唯一索引：(room_id, sequence_id) 保证房间内消息序号唯一且单调递增
 // 辅助索引：(room_id, created_at) 用于基于时间的时间轴查询
+// 复合唯一索引：(room_id, sender_id, client_msg_id) 用于客户端重发去重
 type Message struct {
-	ID               string         `gorm:"type:char(36);primaryKey" json:"id"`
-	RoomID           string         `gorm:"type:char(36);not null;uniqueIndex:uidx_room_seq,priority:1;index:idx_room_created_at,priority:1" json:"room_id"`
-	Room             *Room          `gorm:"foreignKey:RoomID;constraint:OnDelete:CASCADE" json:"-"`
-	SenderID         *string        `gorm:"type:char(36);index" json:"sender_id,omitempty"`
-	Sender           *User          `gorm:"foreignKey:SenderID;constraint:OnDelete:SET NULL" json:"-"`
+	ID       string  `gorm:"type:char(36);primaryKey" json:"id"`
+	RoomID   string  `gorm:"type:char(36);not null;uniqueIndex:uidx_room_seq,priority:1;index:idx_room_created_at,priority:1;uniqueIndex:uidx_msg_client_id,priority:1" json:"room_id"`
+	Room     *Room   `gorm:"foreignKey:RoomID;constraint:OnDelete:CASCADE" json:"-"`
+	SenderID *string `gorm:"type:char(36);index;uniqueIndex:uidx_msg_client_id,priority:2" json:"sender_id,omitempty"`
+	Sender   *User   `gorm:"foreignKey:SenderID;constraint:OnDelete:SET NULL" json:"-"`
+	// ClientMsgID 客户端生成的消息 ID，同一发送者在同一房间内唯一，用于重发去重；为空表示未提供
+	ClientMsgID      *string        `gorm:"size:64;uniqueIndex:uidx_msg_client_id,priority:3" json:"client_msg_id,omitempty"`
 	ContentType      ContentType    `gorm:"type:varchar(16);not null" json:"content_type"`
 	ContentText      *string        `gorm:"type:text" json:"content_text,omitempty"`
 	PayloadJSON      datatypes.JSON `gorm:"type:json" json:"payload_json,omitempty"`
@@ -154,10 +345,12 @@ type Message struct {
 	ParentMessage    *Message       `gorm:"foreignKey:ParentMessageID;references:ID;constraint:OnDelete:SET NULL" json:"-"`
 	ParentSequenceID *int64         `gorm:"index" json:"parent_sequence_id,omitempty"`
 	// SequenceID 房间内单调递增的序号，从1开始
-	SequenceID int64          `gorm:"not null;uniqueIndex:uidx_room_seq,priority:2,sort:desc" json:"sequence_id"`
-	CreatedAt  time.Time      `gorm:"not null;index:idx_room_created_at,priority:2,sort:desc" json:"created_at"`
-	UpdatedAt  *time.Time     `json:"updated_at,omitempty"`
-	DeletedAt  gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+	SequenceID int64      `gorm:"not null;uniqueIndex:uidx_room_seq,priority:2,sort:desc" json:"sequence_id"`
+	CreatedAt  time.Time  `gorm:"not null;index:idx_room_created_at,priority:2,sort:desc" json:"created_at"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+	// EditedAt 发送者最后一次编辑内容的时间，未编辑为空
+	EditedAt  *time.Time     `json:"edited_at,omitempty"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
 }
 
 // 送达/已读回执，复合主键 (message_id, user_id)
@@ -180,6 +373,27 @@ type MessageReaction struct {
 	CreatedAt time.Time `gorm:"not null" json:"created_at"`
 }
 
+// 房间置顶消息，(room_id, message_id) 唯一
+type PinnedMessage struct {
+	ID        string    `gorm:"type:char(36);primaryKey" json:"id"`
+	RoomID    string    `gorm:"type:char(36);not null;uniqueIndex:uidx_pin_room_msg,priority:1" json:"room_id"`
+	MessageID string    `gorm:"type:char(36);not null;uniqueIndex:uidx_pin_room_msg,priority:2" json:"message_id"`
+	PinnedBy  string    `gorm:"type:char(36);not null" json:"pinned_by"`
+	Room      *Room     `gorm:"foreignKey:RoomID;constraint:OnDelete:CASCADE" json:"-"`
+	Message   *Message  `gorm:"foreignKey:MessageID;constraint:OnDelete:CASCADE" json:"-"`
+	CreatedAt time.Time `gorm:"not null" json:"created_at"`
+}
+
+// 用户收藏消息，(user_id, message_id) 唯一
+type StarredMessage struct {
+	ID        string    `gorm:"type:char(36);primaryKey" json:"id"`
+	UserID    string    `gorm:"type:char(36);not null;uniqueIndex:uidx_star_user_msg,priority:1;index:idx_star_user_time,priority:1" json:"user_id"`
+	MessageID string    `gorm:"type:char(36);not null;uniqueIndex:uidx_star_user_msg,priority:2" json:"message_id"`
+	User      *User     `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+	Message   *Message  `gorm:"foreignKey:MessageID;constraint:OnDelete:CASCADE" json:"-"`
+	CreatedAt time.Time `gorm:"not null;index:idx_star_user_time,priority:2" json:"created_at"`
+}
+
 // 附件元数据
 type Attachment struct {
 	ID                string     `gorm:"type:char(36);primaryKey" json:"id"`
@@ -247,6 +461,7 @@ type ChatSegment struct {
 	RawTextHash   string    `gorm:"size:64;not null;index" json:"raw_text_hash"`
 	QdrantPointID string    `gorm:"size:128;not null;uniqueIndex" json:"qdrant_point_id"`
 	SummaryReady  bool      `gorm:"not null;default:false;index" json:"summary_ready"`
+	Stale         bool      `gorm:"not null;default:false;index" json:"stale"`
 	CreatedAt     time.Time `gorm:"not null;index" json:"created_at"`
 	UpdatedAt     time.Time `gorm:"not null;index" json:"updated_at"`
 }
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// APITokenPrefix API 令牌前缀，用于与 JWT 区分并便于密钥扫描工具识别
+const APITokenPrefix = "qqc_"
+
+// API 令牌权限范围
+const (
+	ScopeMessagesRead  = "messages:read"
+	ScopeMessagesWrite = "messages:write"
+	ScopeEventsRead    = "events:read"
+)
+
+var validScopes = map[string]bool{
+	ScopeMessagesRead:  true,
+	ScopeMessagesWrite: true,
+	ScopeEventsRead:    true,
+}
+
+// NormalizeScopes 去重排序并校验权限范围
+func NormalizeScopes(scopes []string) ([]string, error) {
+	seen := make(map[string]bool, len(scopes))
+	out := make([]string, 0, len(scopes))
+	for _, s := range scopes {
+		s = strings.TrimSpace(s)
+		if s == "" || seen[s] {
+			continue
+		}
+		if !validScopes[s] {
+			return nil, fmt.Errorf("unknown scope: %s", s)
+		}
+		seen[s] = true
+		out = append(out, s)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// IsAPIToken 判断 Bearer 令牌是否为 API 令牌
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
+
+// GenerateAPIToken 生成 API 令牌，返回明文（仅展示一次）与用于列表展示的前缀
+func GenerateAPIToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	raw := APITokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return raw, raw[:len(APITokenPrefix)+6], nil
+}
+
+// APITokenPrincipal API 令牌认证后的主体；RoomIDs 为空表示不限房间
+type APITokenPrincipal struct {
+	TokenID  string
+	UserID   string
+	Username string
+	Scopes   []string
+	RoomIDs  []string
+}
+
+func (p *APITokenPrincipal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *APITokenPrincipal) AllowsRoom(roomID string) bool {
+	if len(p.RoomIDs) == 0 {
+		return true
+	}
+	for _, id := range p.RoomIDs {
+		if id == roomID {
+			return true
+		}
+	}
+	return false
+}
+
+// APITokenResolver 校验 API 令牌明文并返回主体，令牌无效、过期或已吊销时返回错误
+type APITokenResolver interface {
+	ResolveAPIToken(ctx context.Context, raw string) (*APITokenPrincipal, error)
+}
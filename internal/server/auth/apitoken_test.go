@@ -0,0 +1,31 @@
+package auth
+
+import "testing"
+
+func TestNormalizeScopes(t *testing.T) {
+	got, err := NormalizeScopes([]string{" messages:write", "events:read", "messages:write", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != ScopeEventsRead || got[1] != ScopeMessagesWrite {
+		t.Fatalf("unexpected scopes: %v", got)
+	}
+	if _, err := NormalizeScopes([]string{"admin:*"}); err == nil {
+		t.Fatal("unknown scope should be rejected")
+	}
+}
+
+func TestAPITokenPrincipalRooms(t *testing.T) {
+	open := &APITokenPrincipal{Scopes: []string{ScopeMessagesRead}}
+	if !open.AllowsRoom("r1") || !open.HasScope(ScopeMessagesRead) || open.HasScope(ScopeMessagesWrite) {
+		t.Fatal("unrestricted principal checks failed")
+	}
+	limited := &APITokenPrincipal{RoomIDs: []string{"r1"}}
+	if !limited.AllowsRoom("r1") || limited.AllowsRoom("r2") {
+		t.Fatal("room restriction not enforced")
+	}
+	raw, prefix, err := GenerateAPIToken()
+	if err != nil || !IsAPIToken(raw) || len(prefix) >= len(raw) || raw[:len(prefix)] != prefix {
+		t.Fatalf("unexpected token %q prefix %q err %v", raw, prefix, err)
+	}
+}
@@ -6,4 +6,6 @@ const (
     DefaultAccessTTL        = 15 * time.Minute
     DefaultRefreshTTL       = 30 * 24 * time.Hour
     DefaultRefreshTokenBytes = 32
+    DefaultChallengeTTL      = 5 * time.Minute
+    DefaultTOTPIssuer        = "QuQuChat"
 )
\ No newline at end of file
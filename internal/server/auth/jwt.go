@@ -12,37 +12,77 @@ import (
 type Claims struct {
 	UserID   string `json:"user_id"`
 	Username string `json:"username"`
+	// SessionID 对应 AuthSession.ID，用于按设备吊销访问令牌
+	SessionID string `json:"sid,omitempty"`
+	// Purpose 非空表示专用令牌（如二次验证挑战），不可作为访问令牌使用
+	Purpose string `json:"purpose,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// PurposeTwoFactorChallenge 登录二次验证挑战令牌
+const PurposeTwoFactorChallenge = "2fa_challenge"
+
+// 专用令牌的 aud，访问令牌不携带 aud，带 aud 的令牌一律不能作为访问令牌
+const (
+	audienceTwoFactorChallenge = "ququchat:2fa_challenge"
+	audienceOIDCState          = "ququchat:oidc_state"
+)
+
 // 移除密钥加载回退逻辑，统一在配置层处理
 
 // SignAccessToken 生成访问令牌
-func SignAccessToken(userID, username string, ttl time.Duration, secret string) (string, time.Time, error) {
+func SignAccessToken(keys *KeySet, userID, username, sessionID string, ttl time.Duration) (string, time.Time, error) {
+	exp := time.Now().Add(ttl)
+	claims := Claims{
+		UserID:    userID,
+		Username:  username,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID,
+			ExpiresAt: jwt.NewNumericDate(exp),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+	s, err := keys.Sign(claims)
+	return s, exp, err
+}
+
+// ParseAndValidate 解析并校验访问令牌，按 kid 选择验签公钥
+func ParseAndValidate(tokenStr string, keys *KeySet) (*Claims, error) {
+	token, err := keys.parse(tokenStr, &Claims{})
+	if err != nil {
+		return nil, err
+	}
+	if claims, ok := token.Claims.(*Claims); ok && token.Valid && claims.Purpose == "" && len(claims.Audience) == 0 {
+		return claims, nil
+	}
+	return nil, jwt.ErrTokenInvalidClaims
+}
+
+// SignChallengeToken 生成短时效的二次验证挑战令牌：密码校验通过但尚未完成 TOTP 校验
+func SignChallengeToken(keys *KeySet, userID string, ttl time.Duration) (string, time.Time, error) {
 	exp := time.Now().Add(ttl)
 	claims := Claims{
-		UserID:   userID,
-		Username: username,
+		UserID:  userID,
+		Purpose: PurposeTwoFactorChallenge,
 		RegisteredClaims: jwt.RegisteredClaims{
 			Subject:   userID,
+			Audience:  jwt.ClaimStrings{audienceTwoFactorChallenge},
 			ExpiresAt: jwt.NewNumericDate(exp),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	s, err := token.SignedString([]byte(secret))
+	s, err := keys.Sign(claims)
 	return s, exp, err
 }
 
-// ParseAndValidate 解析并校验访问令牌
-func ParseAndValidate(tokenStr, secret string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
-	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+// ParseChallengeToken 解析并校验二次验证挑战令牌
+func ParseChallengeToken(tokenStr string, keys *KeySet) (*Claims, error) {
+	token, err := keys.parse(tokenStr, &Claims{}, jwt.WithAudience(audienceTwoFactorChallenge))
 	if err != nil {
 		return nil, err
 	}
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+	if claims, ok := token.Claims.(*Claims); ok && token.Valid && claims.Purpose == PurposeTwoFactorChallenge {
 		return claims, nil
 	}
 	return nil, jwt.ErrTokenInvalidClaims
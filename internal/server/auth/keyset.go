@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// 支持的非对称签名算法
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// KeySpec 单个签名密钥的配置：私钥用于签发，仅配置公钥的密钥只用于验签（轮换下线中的旧密钥）
+type KeySpec struct {
+	KID            string
+	Algorithm      string
+	PrivateKeyFile string
+	PublicKeyFile  string
+}
+
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// LegacyHS256 HS256 共享密钥的使用方式
+type LegacyHS256 struct {
+	Secret string
+	// Accept 已切换到非对称密钥后仍接受不带 kid 的 HS256 旧令牌
+	Accept bool
+	// Until 非零时，超过该时间不再接受旧令牌
+	Until time.Time
+}
+
+// KeySet 管理签名密钥：当前密钥签发令牌并写入 kid 头，所有已配置的公钥都可验签以支持轮换
+// 未配置非对称密钥时回退到 HS256 共享密钥；切换到非对称密钥后，不带 kid 的旧令牌仅在显式开启过渡时接受
+type KeySet struct {
+	active       *signingKey
+	keys         map[string]*signingKey
+	legacySecret []byte
+	acceptLegacy bool
+	legacyUntil  time.Time
+}
+
+// NewKeySet 加载密钥文件；activeKID 为空时使用第一个带私钥的密钥
+func NewKeySet(specs []KeySpec, activeKID string, legacy LegacyHS256) (*KeySet, error) {
+	ks := &KeySet{
+		keys:         make(map[string]*signingKey, len(specs)),
+		acceptLegacy: legacy.Accept,
+		legacyUntil:  legacy.Until,
+	}
+	if legacy.Secret != "" {
+		ks.legacySecret = []byte(legacy.Secret)
+	}
+	for _, spec := range specs {
+		key, err := loadSigningKey(spec)
+		if err != nil {
+			return nil, fmt.Errorf("加载签名密钥 %s 失败: %w", spec.KID, err)
+		}
+		if _, dup := ks.keys[key.kid]; dup {
+			return nil, fmt.Errorf("签名密钥 kid 重复: %s", key.kid)
+		}
+		ks.keys[key.kid] = key
+		if ks.active == nil && key.private != nil && (activeKID == "" || activeKID == key.kid) {
+			ks.active = key
+		}
+	}
+	if activeKID != "" && ks.active == nil {
+		return nil, fmt.Errorf("active_kid %s 未配置私钥", activeKID)
+	}
+	if ks.active == nil && ks.legacySecret == nil {
+		return nil, errors.New("未配置任何可用于签发令牌的密钥")
+	}
+	return ks, nil
+}
+
+// UsesSharedSecret HS256 共享密钥是否参与签发或验签
+func (ks *KeySet) UsesSharedSecret() bool {
+	return ks.active == nil || ks.acceptLegacy
+}
+
+// legacyAccepted 不带 kid 的 HS256 令牌当前是否可用
+func (ks *KeySet) legacyAccepted(now time.Time) bool {
+	if ks.legacySecret == nil {
+		return false
+	}
+	if ks.active == nil {
+		return true
+	}
+	return ks.acceptLegacy && (ks.legacyUntil.IsZero() || now.Before(ks.legacyUntil))
+}
+
+func loadSigningKey(spec KeySpec) (*signingKey, error) {
+	kid := strings.TrimSpace(spec.KID)
+	if kid == "" {
+		return nil, errors.New("缺少 kid")
+	}
+	var privPEM, pubPEM []byte
+	var err error
+	if f := strings.TrimSpace(spec.PrivateKeyFile); f != "" {
+		if privPEM, err = os.ReadFile(f); err != nil {
+			return nil, err
+		}
+	}
+	if f := strings.TrimSpace(spec.PublicKeyFile); f != "" {
+		if pubPEM, err = os.ReadFile(f); err != nil {
+			return nil, err
+		}
+	}
+	if privPEM == nil && pubPEM == nil {
+		return nil, errors.New("缺少私钥或公钥文件")
+	}
+	return parseSigningKey(kid, strings.TrimSpace(spec.Algorithm), privPEM, pubPEM)
+}
+
+func parseSigningKey(kid, alg string, privPEM, pubPEM []byte) (*signingKey, error) {
+	key := &signingKey{kid: kid}
+	switch alg {
+	case AlgRS256:
+		key.method = jwt.SigningMethodRS256
+		if privPEM != nil {
+			priv, err := jwt.ParseRSAPrivateKeyFromPEM(privPEM)
+			if err != nil {
+				return nil, err
+			}
+			key.private, key.public = priv, &priv.PublicKey
+		} else {
+			pub, err := jwt.ParseRSAPublicKeyFromPEM(pubPEM)
+			if err != nil {
+				return nil, err
+			}
+			key.public = pub
+		}
+	case AlgEdDSA:
+		key.method = jwt.SigningMethodEdDSA
+		if privPEM != nil {
+			priv, err := jwt.ParseEdPrivateKeyFromPEM(privPEM)
+			if err != nil {
+				return nil, err
+			}
+			signer, ok := priv.(ed25519.PrivateKey)
+			if !ok {
+				return nil, errors.New("仅支持 Ed25519 私钥")
+			}
+			key.private, key.public = signer, signer.Public()
+		} else {
+			pub, err := jwt.ParseEdPublicKeyFromPEM(pubPEM)
+			if err != nil {
+				return nil, err
+			}
+			key.public = pub
+		}
+	default:
+		return nil, fmt.Errorf("不支持的签名算法: %s", alg)
+	}
+	return key, nil
+}
+
+// Sign 使用当前密钥签发令牌
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if ks.active == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(ks.legacySecret)
+	}
+	token := jwt.NewWithClaims(ks.active.method, claims)
+	token.Header["kid"] = ks.active.kid
+	return token.SignedString(ks.active.private)
+}
+
+// parse 根据 kid 头选择验签密钥，并要求令牌算法与密钥算法一致
+func (ks *KeySet) parse(tokenStr string, claims jwt.Claims, opts ...jwt.ParserOption) (*jwt.Token, error) {
+	opts = append(opts, jwt.WithValidMethods([]string{AlgRS256, AlgEdDSA, jwt.SigningMethodHS256.Alg()}))
+	return jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			if !ks.legacyAccepted(time.Now()) || token.Method.Alg() != jwt.SigningMethodHS256.Alg() {
+				return nil, jwt.ErrTokenUnverifiable
+			}
+			return ks.legacySecret, nil
+		}
+		key, ok := ks.keys[kid]
+		if !ok || token.Method.Alg() != key.method.Alg() {
+			return nil, jwt.ErrTokenUnverifiable
+		}
+		return key.public, nil
+	}, opts...)
+}
+
+// JWK 公钥的 JSON Web Key 表示（RFC 7517 / RFC 8037）
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS 返回全部可验签公钥，供其他服务校验本服务签发的令牌；共享密钥不会公开
+func (ks *KeySet) JWKS() []JWK {
+	out := make([]JWK, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			out = append(out, JWK{
+				Kty: "RSA",
+				Kid: key.kid,
+				Use: "sig",
+				Alg: AlgRS256,
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			out = append(out, JWK{
+				Kty: "OKP",
+				Kid: key.kid,
+				Use: "sig",
+				Alg: AlgEdDSA,
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Kid < out[j].Kid })
+	return out
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func mustPKCS8PEM(t *testing.T, key interface{}) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal private key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func newTestKeySet(t *testing.T, active string) *KeySet {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate rsa: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate ed25519: %v", err)
+	}
+	rsaSK, err := parseSigningKey("rsa-1", AlgRS256, mustPKCS8PEM(t, rsaKey), nil)
+	if err != nil {
+		t.Fatalf("parse rsa: %v", err)
+	}
+	edSK, err := parseSigningKey("ed-1", AlgEdDSA, mustPKCS8PEM(t, edKey), nil)
+	if err != nil {
+		t.Fatalf("parse ed25519: %v", err)
+	}
+	ks := &KeySet{
+		keys:         map[string]*signingKey{rsaSK.kid: rsaSK, edSK.kid: edSK},
+		legacySecret: []byte("legacy-secret"),
+	}
+	ks.active = ks.keys[active]
+	return ks
+}
+
+func TestKeySet_RotationKeepsOldTokensValid(t *testing.T) {
+	ks := newTestKeySet(t, "rsa-1")
+	oldToken, _, err := SignAccessToken(ks, "u1", "alice", "s1", time.Minute)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	ks.active = ks.keys["ed-1"]
+	newToken, _, err := SignAccessToken(ks, "u1", "alice", "s1", time.Minute)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	for _, tok := range []string{oldToken, newToken} {
+		claims, err := ParseAndValidate(tok, ks)
+		if err != nil || claims.UserID != "u1" || claims.SessionID != "s1" {
+			t.Fatalf("parse rotated token: claims=%+v err=%v", claims, err)
+		}
+	}
+	if len(ks.JWKS()) != 2 {
+		t.Fatalf("expected both public keys in JWKS")
+	}
+}
+
+func TestKeySet_RejectsAlgorithmMismatchAndUnknownKid(t *testing.T) {
+	ks := newTestKeySet(t, "rsa-1")
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{UserID: "u1"})
+	forged.Header["kid"] = "rsa-1"
+	s, _ := forged.SignedString([]byte("legacy-secret"))
+	if _, err := ParseAndValidate(s, ks); err == nil {
+		t.Fatalf("expected HS256 token with RSA kid to be rejected")
+	}
+	unknown := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{UserID: "u1"})
+	unknown.Header["kid"] = "missing"
+	s, _ = unknown.SignedString([]byte("legacy-secret"))
+	if _, err := ParseAndValidate(s, ks); err == nil {
+		t.Fatalf("expected unknown kid to be rejected")
+	}
+}
+
+func TestKeySet_LegacyTokenWithoutKid(t *testing.T) {
+	legacy := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		UserID:           "u1",
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))},
+	})
+	s, _ := legacy.SignedString([]byte("legacy-secret"))
+	cases := []struct {
+		name   string
+		active string
+		accept bool
+		until  time.Time
+		ok     bool
+	}{
+		{name: "hs256 active", active: "", ok: true},
+		{name: "asymmetric, legacy off", active: "ed-1", ok: false},
+		{name: "asymmetric, legacy on", active: "ed-1", accept: true, ok: true},
+		{name: "asymmetric, legacy before expiry", active: "ed-1", accept: true, until: time.Now().Add(time.Hour), ok: true},
+		{name: "asymmetric, legacy expired", active: "ed-1", accept: true, until: time.Now().Add(-time.Hour), ok: false},
+	}
+	for _, tc := range cases {
+		ks := newTestKeySet(t, tc.active)
+		ks.acceptLegacy, ks.legacyUntil = tc.accept, tc.until
+		if _, err := ParseAndValidate(s, ks); (err == nil) != tc.ok {
+			t.Fatalf("%s: ok=%v err=%v", tc.name, tc.ok, err)
+		}
+	}
+}
+
+func TestKeySet_UsesSharedSecret(t *testing.T) {
+	if !newTestKeySet(t, "").UsesSharedSecret() {
+		t.Fatalf("HS256 signer must require the shared secret")
+	}
+	ks := newTestKeySet(t, "ed-1")
+	if ks.UsesSharedSecret() {
+		t.Fatalf("asymmetric signer without legacy acceptance must not require the shared secret")
+	}
+	ks.acceptLegacy = true
+	if !ks.UsesSharedSecret() {
+		t.Fatalf("legacy acceptance must require the shared secret")
+	}
+}
+
+func TestKeySet_PurposeTokensRejectedAsAccessTokens(t *testing.T) {
+	for _, active := range []string{"", "ed-1"} {
+		ks := newTestKeySet(t, active)
+		challenge, _, _ := SignChallengeToken(ks, "u1", time.Minute)
+		if _, err := ParseAndValidate(challenge, ks); err == nil {
+			t.Fatalf("challenge token must not be accepted as access token")
+		}
+		if _, err := ParseChallengeToken(challenge, ks); err != nil {
+			t.Fatalf("parse challenge: %v", err)
+		}
+		state, _ := SignOIDCState(ks, "p", "st", "n", "v", time.Minute)
+		if _, err := ParseAndValidate(state, ks); err == nil {
+			t.Fatalf("oidc state must not be accepted as access token")
+		}
+		if _, err := ParseChallengeToken(state, ks); err == nil {
+			t.Fatalf("oidc state must not be accepted as challenge token")
+		}
+		if _, err := ParseOIDCState(state, ks); err != nil {
+			t.Fatalf("parse oidc state: %v", err)
+		}
+		access, _, _ := SignAccessToken(ks, "u1", "alice", "s1", time.Minute)
+		if _, err := ParseChallengeToken(access, ks); err == nil {
+			t.Fatalf("access token must not be accepted as challenge token")
+		}
+		if _, err := ParseOIDCState(access, ks); err == nil {
+			t.Fatalf("access token must not be accepted as oidc state")
+		}
+	}
+}
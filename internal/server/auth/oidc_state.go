@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// PurposeOIDCState OIDC 登录状态令牌，通过 HttpOnly Cookie 在授权跳转与回调之间传递
+const PurposeOIDCState = "oidc_state"
+
+// DefaultOIDCStateTTL 用户在 IdP 完成登录的最长时间
+const DefaultOIDCStateTTL = 10 * time.Minute
+
+// OIDCStateClaims 授权请求的 state、nonce 与 PKCE code_verifier，绑定到发起登录的浏览器
+type OIDCStateClaims struct {
+	Provider     string `json:"provider"`
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+	Purpose      string `json:"purpose"`
+	jwt.RegisteredClaims
+}
+
+// SignOIDCState 签发 OIDC 登录状态令牌
+func SignOIDCState(keys *KeySet, provider, state, nonce, codeVerifier string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := OIDCStateClaims{
+		Provider:     provider,
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		Purpose:      PurposeOIDCState,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{audienceOIDCState},
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+	return keys.Sign(claims)
+}
+
+// ParseOIDCState 解析并校验 OIDC 登录状态令牌
+func ParseOIDCState(tokenStr string, keys *KeySet) (*OIDCStateClaims, error) {
+	token, err := keys.parse(tokenStr, &OIDCStateClaims{}, jwt.WithAudience(audienceOIDCState))
+	if err != nil {
+		return nil, err
+	}
+	if claims, ok := token.Claims.(*OIDCStateClaims); ok && token.Valid && claims.Purpose == PurposeOIDCState {
+		return claims, nil
+	}
+	return nil, jwt.ErrTokenInvalidClaims
+}
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	cachepkg "ququchat/internal/server/cache"
+)
+
+// SessionRevocations 维护每个用户已吊销会话 ID 的 Redis 集合，供访问令牌校验使用
+// 集合过期时间随每次吊销刷新为访问令牌 TTL，届时集合内会话签发的访问令牌均已过期
+type SessionRevocations struct {
+	cache *cachepkg.RedisClient
+	ttl   time.Duration
+}
+
+func NewSessionRevocations(cache *cachepkg.RedisClient, accessTTL time.Duration) *SessionRevocations {
+	if accessTTL <= 0 {
+		accessTTL = DefaultAccessTTL
+	}
+	return &SessionRevocations{cache: cache, ttl: accessTTL}
+}
+
+// Revoke 将会话加入吊销集合；Redis 不可用时仅依赖刷新令牌失效
+func (r *SessionRevocations) Revoke(ctx context.Context, userID string, sessionIDs ...string) error {
+	if r == nil || r.cache == nil || strings.TrimSpace(userID) == "" || len(sessionIDs) == 0 {
+		return nil
+	}
+	key := r.cache.BuildKey(cachepkg.RevokedSessionsKey(userID)...)
+	if err := r.cache.SAdd(ctx, key, sessionIDs...); err != nil {
+		return err
+	}
+	return r.cache.Expire(ctx, key, r.ttl)
+}
+
+// IsRevoked 判断会话是否已被吊销；查询失败时放行，避免 Redis 故障导致全站不可用
+func (r *SessionRevocations) IsRevoked(ctx context.Context, userID, sessionID string) bool {
+	if r == nil || r.cache == nil || sessionID == "" {
+		return false
+	}
+	key := r.cache.BuildKey(cachepkg.RevokedSessionsKey(userID)...)
+	revoked, err := r.cache.SIsMember(ctx, key, sessionID)
+	return err == nil && revoked
+}
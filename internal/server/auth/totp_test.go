@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// RFC 6238 附录 B 的 SHA1 测试向量（取后 6 位）
+func TestTOTPCode_RFC6238Vectors(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	cases := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tc := range cases {
+		got, err := TOTPCode(secret, tc.unix/TOTPPeriod)
+		if err != nil {
+			t.Fatalf("TOTPCode(%d) error: %v", tc.unix, err)
+		}
+		if got != tc.want {
+			t.Fatalf("TOTPCode(%d) = %s, want %s", tc.unix, got, tc.want)
+		}
+	}
+}
+
+func TestValidateTOTP_AllowsOneStepSkew(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("generate secret: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	prev, _ := TOTPCode(secret, now.Unix()/TOTPPeriod-1)
+	if step, ok := ValidateTOTP(secret, prev, now); !ok || step != now.Unix()/TOTPPeriod-1 {
+		t.Fatalf("expected previous step code to be accepted")
+	}
+	old, _ := TOTPCode(secret, now.Unix()/TOTPPeriod-3)
+	if _, ok := ValidateTOTP(secret, old, now); ok {
+		t.Fatalf("expected stale code to be rejected")
+	}
+}
+
+func TestEncryptSecret_RoundTrip(t *testing.T) {
+	key := DeriveEncryptionKey("test-key")
+	enc, err := EncryptSecret(key, "JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	plain, err := DecryptSecret(key, enc)
+	if err != nil || plain != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("decrypt = %q, %v", plain, err)
+	}
+	if _, err := DecryptSecret(DeriveEncryptionKey("other"), enc); err == nil {
+		t.Fatalf("expected decrypt with wrong key to fail")
+	}
+}
@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// 验证码与密码重置令牌的有效期与限制
+const (
+	VerificationCodeTTL        = 15 * time.Minute
+	VerificationMaxAttempts    = 5
+	VerificationResendInterval = time.Minute
+	PasswordResetTTL           = 30 * time.Minute
+)
+
+// GenerateVerificationCode 生成 6 位数字验证码
+func GenerateVerificationCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// HashVerificationToken 验证码与重置令牌仅保存 SHA-256 哈希
+func HashVerificationToken(token string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(token)))
+	return hex.EncodeToString(sum[:])
+}
@@ -13,6 +13,11 @@ const (
 	GroupMemberIDsTTL         = 2 * time.Minute
 	DirectRoomTTL             = 24 * time.Hour
 	FriendIDsTTL              = 15 * time.Minute
+	ConversationListTTL       = 1 * time.Minute
+	// WSPresenceTTL 连接心跳有效期，节点宕机后其连接在该时间内自动失效
+	WSPresenceTTL = 2 * time.Minute
+	// WSNodeAliveTTL 节点存活标记有效期，过期后其他节点将其从路由集合中清除
+	WSNodeAliveTTL = 30 * time.Second
 )
 
 func FriendshipKey(userA string, userB string) []string {
@@ -45,6 +50,30 @@ func FriendIDsKey(userID string) []string {
 	return []string{"friend_ids", strings.TrimSpace(userID)}
 }
 
+func ConversationListKey(userID string) []string {
+	return []string{"conversation_list", strings.TrimSpace(userID)}
+}
+
+func RevokedSessionsKey(userID string) []string {
+	return []string{"auth", "revoked_sessions", strings.TrimSpace(userID)}
+}
+
+func RateLimitKey(group string, dimension string, id string) []string {
+	return []string{"rate_limit", strings.TrimSpace(group), dimension, strings.TrimSpace(id)}
+}
+
+func LoginFailuresKey(username string) []string {
+	return []string{"auth", "login_failures", strings.ToLower(strings.TrimSpace(username))}
+}
+
+func LoginLockKey(username string) []string {
+	return []string{"auth", "login_lock", strings.ToLower(strings.TrimSpace(username))}
+}
+
+func LoginLockLevelKey(username string) []string {
+	return []string{"auth", "login_lock_level", strings.ToLower(strings.TrimSpace(username))}
+}
+
 func WSUserNodesKey(userID string) []string {
 	return []string{"ws", "user_nodes", strings.TrimSpace(userID)}
 }
@@ -57,10 +86,31 @@ func WSUserConnsKey(userID string) []string {
 	return []string{"ws", "user_conns", strings.TrimSpace(userID)}
 }
 
+// WSConnHubKey 连接所在节点，同时作为连接心跳，由心跳续期
 func WSConnHubKey(connID string) []string {
 	return []string{"ws", "conn_hub", strings.TrimSpace(connID)}
 }
 
-func WSNodeChannel(keyPrefix, nodeID string) string {
-	return keyPrefix + ":ws:hub:" + strings.TrimSpace(nodeID)
+// WSNodesKey 已注册的 WebSocket 节点集合
+func WSNodesKey() []string {
+	return []string{"ws", "nodes"}
+}
+
+// WSNodeAliveKey 节点存活标记，由节点定期续期
+func WSNodeAliveKey(nodeID string) []string {
+	return []string{"ws", "node_alive", strings.TrimSpace(nodeID)}
+}
+
+// WSNodeStreamKey 发往指定节点的消息流
+func WSNodeStreamKey(nodeID string) []string {
+	return []string{"ws", "node_stream", strings.TrimSpace(nodeID)}
+}
+
+// WSNodeUsersKey/WSNodeRoomsKey 节点写入过的 user_nodes/room_nodes 反向索引，节点失效时据此清理
+func WSNodeUsersKey(nodeID string) []string {
+	return []string{"ws", "node_users", strings.TrimSpace(nodeID)}
+}
+
+func WSNodeRoomsKey(nodeID string) []string {
+	return []string{"ws", "node_rooms", strings.TrimSpace(nodeID)}
 }
@@ -156,3 +156,27 @@ func (c *RedisClient) Publish(ctx context.Context, channel string, payload strin
 func (c *RedisClient) Subscribe(ctx context.Context, channel string) *redis.PubSub {
 	return c.raw.Subscribe(ctx, channel)
 }
+
+func (c *RedisClient) SIsMember(ctx context.Context, key string, member string) (bool, error) {
+	return c.raw.SIsMember(ctx, key, member).Result()
+}
+
+func (c *RedisClient) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return c.raw.Expire(ctx, key, ttl).Err()
+}
+
+// Incr 计数自增，首次创建时设置过期时间
+func (c *RedisClient) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	n, err := c.raw.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if n == 1 && ttl > 0 {
+		_ = c.raw.Expire(ctx, key, ttl).Err()
+	}
+	return n, nil
+}
+
+func (c *RedisClient) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return c.raw.TTL(ctx, key).Result()
+}
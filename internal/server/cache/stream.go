@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// XAdd 追加一条流消息，并按 minAge 近似裁剪更早的消息（需要 Redis 6.2+）
+func (c *RedisClient) XAdd(ctx context.Context, stream string, values map[string]interface{}, minAge time.Duration) (string, error) {
+	args := &redis.XAddArgs{
+		Stream: stream,
+		Values: values,
+	}
+	if minAge > 0 {
+		args.MinID = fmt.Sprintf("%d-0", time.Now().Add(-minAge).UnixMilli())
+		args.Approx = true
+	}
+	return c.raw.XAdd(ctx, args).Result()
+}
+
+// XGroupEnsure 创建消费组（流不存在时一并创建），消费组已存在时忽略
+func (c *RedisClient) XGroupEnsure(ctx context.Context, stream, group, start string) error {
+	err := c.raw.XGroupCreateMkStream(ctx, stream, group, start).Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// XReadGroup 以消费组方式读取单个流；id 为 ">" 读取新消息，为 "0" 读取本消费者已投递未确认的消息
+func (c *RedisClient) XReadGroup(ctx context.Context, stream, group, consumer, id string, count int64, block time.Duration) ([]redis.XMessage, error) {
+	args := &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{stream, id},
+		Count:    count,
+		Block:    block,
+	}
+	if block <= 0 {
+		args.Block = -1
+	}
+	res, err := c.raw.XReadGroup(ctx, args).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, nil
+	}
+	return res[0].Messages, nil
+}
+
+func (c *RedisClient) XAck(ctx context.Context, stream, group string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return c.raw.XAck(ctx, stream, group, ids...).Err()
+}
+
+// IsNoGroupErr 流或消费组已被删除（如键过期）
+func IsNoGroupErr(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "NOGROUP")
+}
+
+func (c *RedisClient) Persist(ctx context.Context, key string) error {
+	return c.raw.Persist(ctx, key).Err()
+}
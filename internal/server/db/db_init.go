@@ -83,6 +83,18 @@ func Migrate(db *gorm.DB) error {
 		&models.Message{},
 		&models.MessageReceipt{},
 		&models.MessageReaction{},
+		&models.PinnedMessage{},
+		&models.StarredMessage{},
+		&models.UserTwoFactor{},
+		&models.TwoFactorRecoveryCode{},
+		&models.UserIdentity{},
+		&models.DataExport{},
+		&models.VerificationToken{},
+		&models.APIToken{},
+		&models.RoomWebhook{},
+		&models.WebhookDelivery{},
+		&models.WebhookDeliveryAttempt{},
+		&models.RoomIncomingWebhook{},
 		&models.Attachment{},
 		&models.TaskJob{},
 		&models.TaskDeadLetter{},
@@ -0,0 +1,44 @@
+package db
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+const (
+	mysqlMessageFulltextIndex = "ft_messages_content_text"
+	postgresMessageTrgmIndex  = "idx_messages_content_trgm"
+)
+
+// EnsureSearchIndexes 为消息全文检索创建数据库相关的索引
+// MySQL 使用 ngram 分词的 FULLTEXT 索引；Postgres 使用 pg_trgm 索引支持 ILIKE 子串匹配
+func EnsureSearchIndexes(db *gorm.DB, driver string) error {
+	switch driver {
+	case "mysql":
+		var count int64
+		if err := db.Raw(
+			"SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = 'messages' AND index_name = ?",
+			mysqlMessageFulltextIndex,
+		).Scan(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		return db.Exec(fmt.Sprintf("ALTER TABLE messages ADD FULLTEXT INDEX %s (content_text) WITH PARSER ngram", mysqlMessageFulltextIndex)).Error
+	case "postgres":
+		stmts := []string{
+			"CREATE EXTENSION IF NOT EXISTS pg_trgm",
+			fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON messages USING gin (content_text gin_trgm_ops)", postgresMessageTrgmIndex),
+		}
+		for _, stmt := range stmts {
+			if err := db.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported driver: %s", driver)
+	}
+}
@@ -0,0 +1,57 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogNotifier 将通知写入日志或文件（每行一条 JSON），用于本地开发与测试时读取验证码
+type LogNotifier struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewLogNotifier(path string) (*LogNotifier, error) {
+	path = strings.TrimSpace(path)
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		_ = f.Close()
+	}
+	return &LogNotifier{path: path}, nil
+}
+
+type logRecord struct {
+	Time    time.Time `json:"time"`
+	Channel string    `json:"channel"`
+	To      string    `json:"to"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+}
+
+func (n *LogNotifier) Send(ctx context.Context, msg Message) error {
+	if n.path == "" {
+		log.Printf("notify channel=%s to=%s subject=%q body=%q", msg.Channel, msg.To, msg.Subject, msg.Body)
+		return nil
+	}
+	line, err := json.Marshal(logRecord{Time: time.Now(), Channel: msg.Channel, To: msg.To, Subject: msg.Subject, Body: msg.Body})
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	f, err := os.OpenFile(n.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
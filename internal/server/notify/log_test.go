@@ -0,0 +1,38 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogNotifierAppendsJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.log")
+	n, err := NewLogNotifier(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, to := range []string{"a@example.com", "b@example.com"} {
+		if err := n.Send(context.Background(), Message{Channel: ChannelEmail, To: to, Subject: "s", Body: "code 123456"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(lines))
+	}
+	var rec logRecord
+	if err := json.Unmarshal([]byte(lines[1]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.To != "b@example.com" || rec.Body != "code 123456" || rec.Channel != ChannelEmail {
+		t.Fatalf("unexpected record: %+v", rec)
+	}
+}
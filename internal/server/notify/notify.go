@@ -0,0 +1,43 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"ququchat/internal/config"
+)
+
+// 通知通道
+const (
+	ChannelEmail = "email"
+	ChannelPhone = "phone"
+)
+
+// ErrUnsupportedChannel 当前通知实现不支持该通道（如 SMTP 无法发送短信）
+var ErrUnsupportedChannel = errors.New("notify: unsupported channel")
+
+// Message 一条待发送的通知，To 为邮箱地址或手机号
+type Message struct {
+	Channel string
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier 通知发送接口，可按部署环境替换实现
+type Notifier interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New 按配置创建通知实现
+func New(cfg config.Notify) (Notifier, error) {
+	switch cfg.DriverOrDefault() {
+	case "log":
+		return NewLogNotifier(cfg.LogFile)
+	case "smtp":
+		return NewSMTPNotifier(cfg.SMTP)
+	default:
+		return nil, fmt.Errorf("不支持的通知 driver: %s", cfg.Driver)
+	}
+}
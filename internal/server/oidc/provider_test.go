@@ -0,0 +1,145 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockIdP 最小化的 OIDC 提供方：发现文档、JWKS、授权码换令牌（校验 PKCE）
+type mockIdP struct {
+	t         *testing.T
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	clientID  string
+	challenge string
+	nonce     string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIdP{t: t, key: key, clientID: "ququchat"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "idp-1",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.PostForm.Get("code") != "auth-code" || CodeChallengeS256(r.PostForm.Get("code_verifier")) != m.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "idp-access",
+			"token_type":   "Bearer",
+			"id_token":     m.idToken(m.nonce, m.server.URL, m.clientID),
+		})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockIdP) idToken(nonce, issuer, audience string) string {
+	now := time.Now()
+	claims := IDTokenClaims{
+		Nonce:             nonce,
+		Email:             "alice@example.com",
+		EmailVerified:     true,
+		PreferredUsername: "alice",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   "sub-123",
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "idp-1"
+	s, err := token.SignedString(m.key)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return s
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	idp := newMockIdP(t)
+	p := NewProvider(Config{Issuer: idp.server.URL, ClientID: idp.clientID, RedirectURL: "http://app/callback"})
+	ctx := context.Background()
+
+	verifier, _ := RandomString(32)
+	authURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("state") != "state-1" || q.Get("client_id") != idp.clientID {
+		t.Fatalf("unexpected authorization url: %s", authURL)
+	}
+	idp.challenge, idp.nonce = q.Get("code_challenge"), q.Get("nonce")
+
+	if _, err := p.Exchange(ctx, "auth-code", "wrong-verifier"); err == nil {
+		t.Fatal("exchange with wrong code_verifier should fail")
+	}
+	tok, err := p.Exchange(ctx, "auth-code", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := p.VerifyIDToken(ctx, tok.IDToken, "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "sub-123" || claims.Email != "alice@example.com" || claims.PreferredUsername != "alice" {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+	if _, err := p.VerifyIDToken(ctx, tok.IDToken, "other-nonce"); err == nil {
+		t.Fatal("nonce mismatch should fail")
+	}
+}
+
+func TestVerifyIDTokenRejectsWrongIssuerOrAudience(t *testing.T) {
+	idp := newMockIdP(t)
+	p := NewProvider(Config{Issuer: idp.server.URL, ClientID: idp.clientID})
+	ctx := context.Background()
+
+	if _, err := p.VerifyIDToken(ctx, idp.idToken("n", "https://evil.example", idp.clientID), "n"); err == nil {
+		t.Fatal("wrong issuer should fail")
+	}
+	if _, err := p.VerifyIDToken(ctx, idp.idToken("n", idp.server.URL, "other-client"), "n"); err == nil {
+		t.Fatal("wrong audience should fail")
+	}
+	if _, err := p.VerifyIDToken(ctx, idp.idToken("n", idp.server.URL, idp.clientID), "n"); err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+}
@@ -38,6 +38,9 @@ var ErrRAGMemorySequenceRangeInvalid = errors.New("rag memory sequence range is
 
 const summaryCountMax = 1000
 const agentRecentMessageLimit = 12
+
+// 注入智能体上下文的置顶消息条数上限
+const agentPinnedMessageLimit = 3
 const agentMaxSteps = 10
 const ragSegmentGapSeconds = 300
 const ragMaxCharsPerSegment = 2000
@@ -192,6 +195,12 @@ func (s *MainService) SubmitCommand(req SubmitCommandRequest) (string, error) {
 		if recentErr != nil {
 			return "", recentErr
 		}
+		pinnedMessages, pinnedErr := s.loadAgentPinnedMessages(req.RoomID, agentPinnedMessageLimit)
+		if pinnedErr != nil {
+			return "", pinnedErr
+		}
+		// 置顶消息追加在末尾：各节点截取最近消息时保留尾部，置顶内容始终可见
+		recentMessages = append(recentMessages, pinnedMessages...)
 		t, err = s.producer.SubmitAgent(tasksvc.SubmitAgentRequest{
 			RequestID:      requestID,
 			Priority:       priority,
@@ -257,6 +266,62 @@ func (s *MainService) SubmitCommand(req SubmitCommandRequest) (string, error) {
 	return t.ID, nil
 }
 
+// SubmitDataExport 提交个人数据导出任务，同一导出记录重复提交时返回已有任务
+func (s *MainService) SubmitDataExport(userID string, exportID string) (string, error) {
+	if s == nil || s.producer == nil {
+		return "", ErrServiceNotInitialized
+	}
+	t, err := s.producer.SubmitDataExport(tasksvc.SubmitDataExportRequest{
+		RequestID: "data_export:" + strings.TrimSpace(exportID),
+		Priority:  tasksvc.PriorityLow,
+		ExportID:  exportID,
+		UserID:    userID,
+	})
+	if err != nil {
+		return "", err
+	}
+	return t.ID, nil
+}
+
+// SubmitAccountPurge 提交注销账号后的清理任务；claimedAt 区分每次提交，入队失败后可重新提交
+func (s *MainService) SubmitAccountPurge(userID string, claimedAt time.Time) (string, error) {
+	if s == nil || s.producer == nil {
+		return "", ErrServiceNotInitialized
+	}
+	t, err := s.producer.SubmitAccountPurge(tasksvc.SubmitAccountPurgeRequest{
+		RequestID: fmt.Sprintf("account_purge:%s:%d", strings.TrimSpace(userID), claimedAt.UnixNano()),
+		Priority:  tasksvc.PriorityLow,
+		UserID:    userID,
+	})
+	if err != nil {
+		return "", err
+	}
+	return t.ID, nil
+}
+
+// SubmitRAGRefresh 提交房间的 RAG 增量索引任务，同时重建被编辑或撤回标记为过期的分段；结果不回写到聊天
+func (s *MainService) SubmitRAGRefresh(roomID string) (string, error) {
+	if s == nil || s.producer == nil {
+		return "", ErrServiceNotInitialized
+	}
+	if strings.TrimSpace(roomID) == "" {
+		return "", ErrRAGRoomRequired
+	}
+	t, err := s.producer.SubmitRAG(tasksvc.SubmitRAGRequest{
+		RequestID:          "rag_refresh:" + strings.TrimSpace(roomID) + ":" + strconv.FormatInt(time.Now().UnixNano(), 10),
+		Priority:           tasksvc.PriorityLow,
+		RoomID:             strings.TrimSpace(roomID),
+		SegmentGapSeconds:  ragSegmentGapSeconds,
+		MaxCharsPerSegment: ragMaxCharsPerSegment,
+		MaxMessagesPerSeg:  ragMaxMessagesPerSeg,
+		OverlapMessages:    ragOverlapMessages,
+	})
+	if err != nil {
+		return "", err
+	}
+	return t.ID, nil
+}
+
 func (s *MainService) ensureAgentUserAllowed(userID string) error {
 	if s == nil || s.db == nil {
 		return ErrServiceNotInitialized
@@ -435,6 +500,43 @@ func (s *MainService) loadAgentRecentMessages(roomID string, limit int) ([]strin
 	return lines, nil
 }
 
+// loadAgentPinnedMessages 加载房间置顶消息，作为智能体的高优先级上下文
+func (s *MainService) loadAgentPinnedMessages(roomID string, limit int) ([]string, error) {
+	if s == nil || s.db == nil {
+		return nil, ErrServiceNotInitialized
+	}
+	var raw []models.Message
+	if err := s.db.
+		Joins("JOIN pinned_messages p ON p.message_id = messages.id").
+		Where("p.room_id = ? AND messages.content_type = ?", roomID, models.ContentTypeText).
+		Order("p.created_at desc").
+		Limit(limit).
+		Find(&raw).Error; err != nil {
+		return nil, err
+	}
+	senderNames, err := s.loadSenderNames(raw)
+	if err != nil {
+		return nil, err
+	}
+	lines := make([]string, 0, len(raw))
+	for _, m := range raw {
+		if m.ContentText == nil || strings.TrimSpace(*m.ContentText) == "" {
+			continue
+		}
+		sender := "系统"
+		if m.SenderID != nil && strings.TrimSpace(*m.SenderID) != "" {
+			senderID := strings.TrimSpace(*m.SenderID)
+			if name, ok := senderNames[senderID]; ok && strings.TrimSpace(name) != "" {
+				sender = strings.TrimSpace(name)
+			} else {
+				sender = senderID
+			}
+		}
+		lines = append(lines, fmt.Sprintf("[置顶][%s] %s", sender, strings.TrimSpace(*m.ContentText)))
+	}
+	return lines, nil
+}
+
 func (s *MainService) buildSummaryPrompt(roomID string, count int) (string, error) {
 	if s == nil || s.db == nil {
 		return "", ErrServiceNotInitialized
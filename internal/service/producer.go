@@ -316,6 +316,62 @@ func (p *Producer) SubmitRAGAddMemory(req tasksvc.SubmitRAGAddMemoryRequest) (*t
 	return doneTask.Clone(), nil
 }
 
+func (p *Producer) SubmitDataExport(req tasksvc.SubmitDataExportRequest) (*tasksvc.Task, error) {
+	now := time.Now()
+	t := &tasksvc.Task{
+		ID:        uuid.NewString(),
+		RequestID: strings.TrimSpace(req.RequestID),
+		Type:      tasksvc.TypeDataExport,
+		Priority:  req.Priority,
+		Status:    tasksvc.StatusPending,
+		Payload: tasksvc.Payload{
+			DataExport: &tasksvc.DataExportPayload{
+				ExportID: strings.TrimSpace(req.ExportID),
+				UserID:   strings.TrimSpace(req.UserID),
+			},
+		},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if t.Payload.DataExport.UserID == "" {
+		return nil, tasksvc.ErrInvalidAccountUserID
+	}
+	if t.Payload.DataExport.ExportID == "" {
+		return nil, tasksvc.ErrInvalidDataExportID
+	}
+	doneTask, err := p.createAndEnqueue(t)
+	if err != nil {
+		return nil, err
+	}
+	return doneTask.Clone(), nil
+}
+
+func (p *Producer) SubmitAccountPurge(req tasksvc.SubmitAccountPurgeRequest) (*tasksvc.Task, error) {
+	now := time.Now()
+	t := &tasksvc.Task{
+		ID:        uuid.NewString(),
+		RequestID: strings.TrimSpace(req.RequestID),
+		Type:      tasksvc.TypeAccountPurge,
+		Priority:  req.Priority,
+		Status:    tasksvc.StatusPending,
+		Payload: tasksvc.Payload{
+			AccountPurge: &tasksvc.AccountPurgePayload{
+				UserID: strings.TrimSpace(req.UserID),
+			},
+		},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if t.Payload.AccountPurge.UserID == "" {
+		return nil, tasksvc.ErrInvalidAccountUserID
+	}
+	doneTask, err := p.createAndEnqueue(t)
+	if err != nil {
+		return nil, err
+	}
+	return doneTask.Clone(), nil
+}
+
 func (p *Producer) createAndEnqueue(t *tasksvc.Task) (*tasksvc.Task, error) {
 	if p == nil || p.store == nil || t == nil {
 		return nil, errors.New("producer not initialized")
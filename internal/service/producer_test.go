@@ -64,3 +64,36 @@ func TestCreateAndEnqueue_DuplicateRequestIDReturnsExistingTask(t *testing.T) {
 		t.Fatalf("expected queue push count 1, got %d", q.pushCount)
 	}
 }
+
+func TestSubmitDataExport_ValidatesAndEnqueues(t *testing.T) {
+	q := &testQueue{}
+	p := &Producer{
+		store:                 tasksvc.NewMemoryStore(),
+		highQueue:             q,
+		normalQueue:           q,
+		lowQueue:              q,
+		inputRetryMaxAttempts: 1,
+		inputRetryDelay:       time.Millisecond,
+	}
+	if _, err := p.SubmitDataExport(tasksvc.SubmitDataExportRequest{ExportID: "export-1"}); err != tasksvc.ErrInvalidAccountUserID {
+		t.Fatalf("expected ErrInvalidAccountUserID, got %v", err)
+	}
+	if _, err := p.SubmitDataExport(tasksvc.SubmitDataExportRequest{UserID: "user-1"}); err != tasksvc.ErrInvalidDataExportID {
+		t.Fatalf("expected ErrInvalidDataExportID, got %v", err)
+	}
+	task, err := p.SubmitDataExport(tasksvc.SubmitDataExportRequest{
+		RequestID: "data_export:export-1",
+		Priority:  tasksvc.PriorityLow,
+		ExportID:  " export-1 ",
+		UserID:    "user-1",
+	})
+	if err != nil {
+		t.Fatalf("submit data export failed: %v", err)
+	}
+	if task.Type != tasksvc.TypeDataExport || task.Payload.DataExport == nil || task.Payload.DataExport.ExportID != "export-1" {
+		t.Fatalf("unexpected task: %+v", task)
+	}
+	if q.pushCount != 1 {
+		t.Fatalf("expected 1 push, got %d", q.pushCount)
+	}
+}
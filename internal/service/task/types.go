@@ -15,6 +15,10 @@ const (
 	TypeRAG       Type = "rag"
 	TypeRAGSearch Type = "rag_search"
 	TypeRAGAddMem Type = "rag_add_memory"
+	// TypeDataExport 打包导出用户个人数据
+	TypeDataExport Type = "data_export"
+	// TypeAccountPurge 注销账号后清理附件与向量索引
+	TypeAccountPurge Type = "account_purge"
 )
 
 type Priority int
@@ -80,6 +84,15 @@ type RAGAddMemoryPayload struct {
 	OverlapMessages    int
 }
 
+type DataExportPayload struct {
+	ExportID string
+	UserID   string
+}
+
+type AccountPurgePayload struct {
+	UserID string
+}
+
 type Payload struct {
 	FakeLLM   *FakeLLMPayload
 	LLM       *LLMPayload
@@ -88,6 +101,9 @@ type Payload struct {
 	RAG       *RAGPayload
 	RAGSearch *RAGSearchPayload
 	RAGAddMem *RAGAddMemoryPayload
+	// 账号数据
+	DataExport   *DataExportPayload
+	AccountPurge *AccountPurgePayload
 }
 
 type Result struct {
@@ -145,6 +161,14 @@ func (t *Task) Clone() *Task {
 		payloadCopy := *t.Payload.RAGAddMem
 		next.Payload.RAGAddMem = &payloadCopy
 	}
+	if t.Payload.DataExport != nil {
+		payloadCopy := *t.Payload.DataExport
+		next.Payload.DataExport = &payloadCopy
+	}
+	if t.Payload.AccountPurge != nil {
+		payloadCopy := *t.Payload.AccountPurge
+		next.Payload.AccountPurge = &payloadCopy
+	}
 	if t.Result.Text != nil {
 		textCopy := *t.Result.Text
 		next.Result.Text = &textCopy
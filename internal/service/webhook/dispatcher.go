@@ -0,0 +1,436 @@
+package webhooksvc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"ququchat/internal/models"
+)
+
+const (
+	hookCacheTTL      = 30 * time.Second
+	hookCacheMaxRooms = 10000
+	sweepInterval     = 5 * time.Second
+	sweepBatch        = 100
+	localQueueSize    = 1024
+	maxErrorLen       = 512
+	// RabbitMQ 断开后的重连退避
+	mqReconnectBase = time.Second
+	mqReconnectMax  = time.Minute
+)
+
+var activeStatuses = []string{models.WebhookDeliveryStatusPending, models.WebhookDeliveryStatusRetrying}
+
+type Options struct {
+	DB *gorm.DB
+	// RabbitMQURL 为空时使用进程内队列，仍通过数据库 outbox 保证重启后继续投递
+	RabbitMQURL         string
+	QueueName           string
+	MaxAttempts         int
+	Timeout             time.Duration
+	BackoffBase         time.Duration
+	BackoffMax          time.Duration
+	AllowPrivateTargets bool
+	Workers             int
+}
+
+type hookRef struct {
+	id     string
+	events map[string]bool
+}
+
+type cachedHooks struct {
+	hooks     []hookRef
+	expiresAt time.Time
+}
+
+// Dispatcher 群事件回调投递器：事件写入 outbox 后经队列投递，失败按指数退避由清扫任务重新入队
+// nil Dispatcher 的方法均为空操作
+type Dispatcher struct {
+	db     *gorm.DB
+	opts   Options
+	client *http.Client
+	// lease 投递进行中的租约，超时未完成的记录由清扫任务重新入队
+	lease time.Duration
+
+	mu      sync.Mutex
+	hooks   map[string]cachedHooks
+	publish func(context.Context, deliveryJob) error
+
+	startOnce sync.Once
+	localOnce sync.Once
+	// localPublish 进程内队列，MQ 不可用期间临时使用
+	localPublish func(context.Context, deliveryJob) error
+}
+
+func NewDispatcher(opts Options) *Dispatcher {
+	if opts.DB == nil {
+		return nil
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 8
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.BackoffBase <= 0 {
+		opts.BackoffBase = 30 * time.Second
+	}
+	if opts.BackoffMax < opts.BackoffBase {
+		opts.BackoffMax = opts.BackoffBase
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 8
+	}
+	lease := 2 * time.Minute
+	if lease < 3*opts.Timeout {
+		lease = 3 * opts.Timeout
+	}
+	return &Dispatcher{
+		db:     opts.DB,
+		opts:   opts,
+		client: newHTTPClient(opts.Timeout, opts.AllowPrivateTargets),
+		lease:  lease,
+		hooks:  make(map[string]cachedHooks),
+	}
+}
+
+// AllowPrivateTargets 是否允许回调内网地址，供注册时校验 URL
+func (d *Dispatcher) AllowPrivateTargets() bool {
+	return d != nil && d.opts.AllowPrivateTargets
+}
+
+// Start 连接 RabbitMQ 并启动消费与清扫；MQ 不可用时临时退化为进程内 worker，并按退避重连，连上后切回 MQ
+func (d *Dispatcher) Start(ctx context.Context) {
+	if d == nil {
+		return
+	}
+	d.startOnce.Do(func() {
+		d.useLocal(ctx)
+		if url := strings.TrimSpace(d.opts.RabbitMQURL); url != "" {
+			go d.runMQ(ctx, url)
+		}
+		go d.sweepLoop(ctx)
+	})
+}
+
+// runMQ 维持 RabbitMQ 连接：连接或消费中断时切换到进程内投递，退避后重连
+func (d *Dispatcher) runMQ(ctx context.Context, url string) {
+	failures := 0
+	for {
+		q, err := newRabbitMQQueue(url, d.opts.QueueName, d.opts.Workers)
+		if err == nil {
+			if failures > 0 {
+				log.Printf("[webhook-dispatcher] rabbitmq reconnected, switch back from in-process delivery")
+			}
+			failures = 0
+			d.setPublisher(q.publish)
+			err = q.consume(ctx, d.handleJob)
+			if err == nil {
+				return
+			}
+		}
+		failures++
+		d.useLocal(ctx)
+		delay := Backoff(failures, mqReconnectBase, mqReconnectMax)
+		log.Printf("[webhook-dispatcher] rabbitmq unavailable, fallback to in-process delivery, retry in %s err=%v", delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// useLocal 切换到进程内队列；worker 只启动一次，切回 MQ 后继续处理已入队的任务
+func (d *Dispatcher) useLocal(ctx context.Context) {
+	d.localOnce.Do(func() {
+		jobs := make(chan deliveryJob, localQueueSize)
+		for i := 0; i < d.opts.Workers; i++ {
+			go func() {
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case job := <-jobs:
+						d.handleJob(ctx, job)
+					}
+				}
+			}()
+		}
+		d.localPublish = func(_ context.Context, job deliveryJob) error {
+			select {
+			case jobs <- job:
+				return nil
+			default:
+				return errors.New("webhook local queue is full")
+			}
+		}
+	})
+	d.setPublisher(d.localPublish)
+}
+
+func (d *Dispatcher) setPublisher(fn func(context.Context, deliveryJob) error) {
+	d.mu.Lock()
+	d.publish = fn
+	d.mu.Unlock()
+}
+
+func (d *Dispatcher) enqueueJob(ctx context.Context, job deliveryJob) error {
+	d.mu.Lock()
+	fn := d.publish
+	d.mu.Unlock()
+	if fn == nil {
+		return errors.New("webhook dispatcher is not started")
+	}
+	return fn(ctx, job)
+}
+
+// Invalidate 清除房间的回调缓存，注册或删除回调后调用；其他节点在缓存过期后生效
+func (d *Dispatcher) Invalidate(roomID string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	delete(d.hooks, roomID)
+	d.mu.Unlock()
+}
+
+func (d *Dispatcher) roomHooks(roomID string) ([]hookRef, error) {
+	now := time.Now()
+	d.mu.Lock()
+	entry, ok := d.hooks[roomID]
+	d.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.hooks, nil
+	}
+	var rows []models.RoomWebhook
+	if err := d.db.Select("id", "event_types").Where("room_id = ? AND active = ?", roomID, true).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	hooks := make([]hookRef, 0, len(rows))
+	for _, r := range rows {
+		ref := hookRef{id: r.ID, events: make(map[string]bool)}
+		for _, t := range strings.Fields(r.EventTypes) {
+			ref.events[t] = true
+		}
+		hooks = append(hooks, ref)
+	}
+	d.mu.Lock()
+	if len(d.hooks) >= hookCacheMaxRooms {
+		d.hooks = make(map[string]cachedHooks)
+	}
+	d.hooks[roomID] = cachedHooks{hooks: hooks, expiresAt: now.Add(hookCacheTTL)}
+	d.mu.Unlock()
+	return hooks, nil
+}
+
+// Enqueue 为订阅了该事件的每个回调写入一条 outbox 记录并入队；入队失败的记录由清扫任务补发
+func (d *Dispatcher) Enqueue(roomID, eventType string, data interface{}) {
+	if d == nil || roomID == "" {
+		return
+	}
+	hooks, err := d.roomHooks(roomID)
+	if err != nil {
+		log.Printf("[webhook-dispatcher] load webhooks failed room=%s err=%v", roomID, err)
+		return
+	}
+	var targets []string
+	for _, h := range hooks {
+		if h.events[eventType] {
+			targets = append(targets, h.id)
+		}
+	}
+	if len(targets) == 0 {
+		return
+	}
+	now := time.Now()
+	env := Envelope{ID: uuid.NewString(), Type: eventType, RoomID: roomID, OccurredAt: now.UTC(), Data: data}
+	body, err := json.Marshal(env)
+	if err != nil {
+		log.Printf("[webhook-dispatcher] marshal event failed room=%s type=%s err=%v", roomID, eventType, err)
+		return
+	}
+	rows := make([]models.WebhookDelivery, 0, len(targets))
+	for _, id := range targets {
+		rows = append(rows, models.WebhookDelivery{
+			ID:            uuid.NewString(),
+			WebhookID:     id,
+			RoomID:        roomID,
+			EventType:     eventType,
+			EventID:       env.ID,
+			Payload:       string(body),
+			Status:        models.WebhookDeliveryStatusPending,
+			NextAttemptAt: now.Add(d.lease),
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		})
+	}
+	if err := d.db.Create(&rows).Error; err != nil {
+		log.Printf("[webhook-dispatcher] save outbox failed room=%s type=%s err=%v", roomID, eventType, err)
+		return
+	}
+	var unpublished []string
+	for _, r := range rows {
+		if err := d.enqueueJob(context.Background(), deliveryJob{DeliveryID: r.ID}); err != nil {
+			unpublished = append(unpublished, r.ID)
+		}
+	}
+	if len(unpublished) > 0 {
+		d.db.Model(&models.WebhookDelivery{}).Where("id IN ?", unpublished).Update("next_attempt_at", now)
+	}
+}
+
+func (d *Dispatcher) sweepLoop(ctx context.Context) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.sweep(ctx)
+		}
+	}
+}
+
+// sweep 认领到期的待投递记录（首次入队失败、退避到期或租约超时）并重新入队
+// 认领通过条件更新 next_attempt_at 实现，多节点同时清扫时只有一个节点成功
+func (d *Dispatcher) sweep(ctx context.Context) {
+	now := time.Now()
+	var due []models.WebhookDelivery
+	if err := d.db.Select("id", "attempts").
+		Where("status IN ? AND next_attempt_at <= ?", activeStatuses, now).
+		Order("next_attempt_at ASC").Limit(sweepBatch).Find(&due).Error; err != nil {
+		log.Printf("[webhook-dispatcher] sweep query failed err=%v", err)
+		return
+	}
+	for _, r := range due {
+		res := d.db.Model(&models.WebhookDelivery{}).
+			Where("id = ? AND status IN ? AND next_attempt_at <= ?", r.ID, activeStatuses, now).
+			Update("next_attempt_at", now.Add(d.lease))
+		if res.Error != nil || res.RowsAffected != 1 {
+			continue
+		}
+		if err := d.enqueueJob(ctx, deliveryJob{DeliveryID: r.ID, Attempt: r.Attempts}); err != nil {
+			log.Printf("[webhook-dispatcher] requeue failed delivery=%s err=%v", r.ID, err)
+		}
+	}
+}
+
+// handleJob 执行一次投递并记录结果；通过 attempts 乐观锁保证同一次尝试只会被一个消费者执行
+func (d *Dispatcher) handleJob(ctx context.Context, job deliveryJob) {
+	var dlv models.WebhookDelivery
+	if err := d.db.Where("id = ?", job.DeliveryID).First(&dlv).Error; err != nil {
+		return
+	}
+	if dlv.Attempts != job.Attempt ||
+		(dlv.Status != models.WebhookDeliveryStatusPending && dlv.Status != models.WebhookDeliveryStatusRetrying) {
+		return
+	}
+	var hook models.RoomWebhook
+	if err := d.db.Where("id = ?", dlv.WebhookID).First(&hook).Error; err != nil || !hook.Active {
+		d.db.Model(&models.WebhookDelivery{}).Where("id = ?", dlv.ID).Updates(map[string]interface{}{
+			"status":     models.WebhookDeliveryStatusFailed,
+			"last_error": "webhook removed or disabled",
+			"updated_at": time.Now(),
+		})
+		return
+	}
+
+	attemptNo := dlv.Attempts + 1
+	start := time.Now()
+	res := d.db.Model(&models.WebhookDelivery{}).
+		Where("id = ? AND attempts = ? AND status IN ?", dlv.ID, dlv.Attempts, activeStatuses).
+		Updates(map[string]interface{}{"attempts": attemptNo, "next_attempt_at": start.Add(d.lease), "updated_at": start})
+	if res.Error != nil || res.RowsAffected != 1 {
+		return
+	}
+
+	statusCode, postErr := d.post(ctx, &hook, &dlv)
+	finished := time.Now()
+	errText := ""
+	if postErr != nil {
+		errText = truncate(postErr.Error(), maxErrorLen)
+	}
+	attempt := models.WebhookDeliveryAttempt{
+		ID:         uuid.NewString(),
+		DeliveryID: dlv.ID,
+		AttemptNo:  attemptNo,
+		StatusCode: statusCode,
+		Error:      errText,
+		DurationMs: finished.Sub(start).Milliseconds(),
+		CreatedAt:  finished,
+	}
+	if err := d.db.Create(&attempt).Error; err != nil {
+		log.Printf("[webhook-dispatcher] save attempt failed delivery=%s err=%v", dlv.ID, err)
+	}
+
+	updates := map[string]interface{}{
+		"last_status_code": statusCode,
+		"last_error":       errText,
+		"updated_at":       finished,
+	}
+	switch {
+	case postErr == nil:
+		updates["status"] = models.WebhookDeliveryStatusSucceeded
+		updates["delivered_at"] = finished
+	case attemptNo >= d.opts.MaxAttempts:
+		updates["status"] = models.WebhookDeliveryStatusFailed
+		log.Printf("[webhook-dispatcher] delivery gave up delivery=%s webhook=%s attempts=%d err=%s", dlv.ID, hook.ID, attemptNo, errText)
+	default:
+		updates["status"] = models.WebhookDeliveryStatusRetrying
+		updates["next_attempt_at"] = finished.Add(Backoff(attemptNo, d.opts.BackoffBase, d.opts.BackoffMax))
+	}
+	if err := d.db.Model(&models.WebhookDelivery{}).Where("id = ? AND attempts = ?", dlv.ID, attemptNo).Updates(updates).Error; err != nil {
+		log.Printf("[webhook-dispatcher] update delivery failed delivery=%s err=%v", dlv.ID, err)
+	}
+}
+
+// post 发送签名请求，2xx 视为成功；不跟随重定向
+func (d *Dispatcher) post(ctx context.Context, hook *models.RoomWebhook, dlv *models.WebhookDelivery) (int, error) {
+	body := []byte(dlv.Payload)
+	ts := time.Now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "QuQuChat-Webhook/1.0")
+	req.Header.Set(HeaderEvent, dlv.EventType)
+	req.Header.Set(HeaderDelivery, dlv.ID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(HeaderSignature, Sign(hook.Secret, ts, body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,160 @@
+package webhooksvc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 可订阅的群事件类型
+const (
+	EventMessageCreated = "message.created"
+	EventMemberJoined   = "member.joined"
+	EventMemberLeft     = "member.left"
+	EventAgentTaskDone  = "agent.task_done"
+)
+
+var validEventTypes = map[string]bool{
+	EventMessageCreated: true,
+	EventMemberJoined:   true,
+	EventMemberLeft:     true,
+	EventAgentTaskDone:  true,
+}
+
+// 投递请求头
+const (
+	HeaderEvent     = "X-QuQuChat-Event"
+	HeaderDelivery  = "X-QuQuChat-Delivery"
+	HeaderTimestamp = "X-QuQuChat-Timestamp"
+	HeaderSignature = "X-QuQuChat-Signature"
+)
+
+// SecretPrefix 回调签名密钥前缀；IncomingTokenPrefix 接入回调令牌前缀
+const (
+	SecretPrefix        = "whsec_"
+	IncomingTokenPrefix = "qqh_"
+)
+
+// Envelope 回调请求体
+type Envelope struct {
+	ID         string      `json:"id"`
+	Type       string      `json:"type"`
+	RoomID     string      `json:"room_id"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Data       interface{} `json:"data"`
+}
+
+// NormalizeEventTypes 去重排序并校验事件类型，至少需要一个
+func NormalizeEventTypes(types []string) ([]string, error) {
+	seen := make(map[string]bool, len(types))
+	out := make([]string, 0, len(types))
+	for _, t := range types {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		if !validEventTypes[t] {
+			return nil, fmt.Errorf("unknown event type: %s", t)
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	if len(out) == 0 {
+		return nil, errors.New("event types are empty")
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// GenerateSecret 生成签名密钥，仅在注册时返回一次
+func GenerateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return SecretPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// GenerateIncomingToken 生成接入回调令牌，返回明文（仅展示一次）与用于列表展示的前缀
+func GenerateIncomingToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	raw := IncomingTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return raw, raw[:len(IncomingTokenPrefix)+6], nil
+}
+
+// Sign 计算签名：sha256=hex(HMAC-SHA256(secret, timestamp + "." + body))
+// 接收方应校验时间戳在允许窗口内以防重放
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify 校验签名，供接收方或测试使用
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// Backoff 第 attempt 次失败后的等待时长：base*2^(attempt-1)，不超过 max
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	d := float64(base) * math.Pow(2, float64(attempt-1))
+	if d > float64(max) {
+		return max
+	}
+	return time.Duration(d)
+}
+
+var ErrBlockedTarget = errors.New("webhook target address is not allowed")
+
+// ValidateURL 校验回调地址：仅允许 http/https；字面 IP 在禁止内网时直接拒绝，域名在建连时再校验解析结果
+func ValidateURL(raw string, allowPrivate bool) error {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("webhook url must be http or https")
+	}
+	host := u.Hostname()
+	if host == "" {
+		return errors.New("webhook url host is empty")
+	}
+	if u.User != nil {
+		return errors.New("webhook url must not contain credentials")
+	}
+	if allowPrivate {
+		return nil
+	}
+	if strings.EqualFold(host, "localhost") {
+		return ErrBlockedTarget
+	}
+	if ip := net.ParseIP(host); ip != nil && isBlockedIP(ip) {
+		return ErrBlockedTarget
+	}
+	return nil
+}
+
+func isBlockedIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast()
+}
@@ -0,0 +1,85 @@
+package webhooksvc
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"id":"evt","type":"message.created"}`)
+	sig := Sign("whsec_test", 1700000000, body)
+	if sig[:7] != "sha256=" {
+		t.Fatalf("unexpected signature format: %s", sig)
+	}
+	if !Verify("whsec_test", 1700000000, body, sig) {
+		t.Fatal("signature should verify")
+	}
+	if Verify("whsec_test", 1700000001, body, sig) {
+		t.Fatal("signature must bind timestamp")
+	}
+	if Verify("whsec_other", 1700000000, body, sig) {
+		t.Fatal("signature must bind secret")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base, max := 30*time.Second, 5*time.Minute
+	cases := map[int]time.Duration{0: base, 1: base, 2: time.Minute, 3: 2 * time.Minute, 4: 4 * time.Minute, 5: max, 20: max}
+	for attempt, want := range cases {
+		if got := Backoff(attempt, base, max); got != want {
+			t.Errorf("Backoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+}
+
+func TestNormalizeEventTypes(t *testing.T) {
+	got, err := NormalizeEventTypes([]string{"member.left", " message.created ", "member.left"})
+	if err != nil || len(got) != 2 || got[0] != EventMemberLeft || got[1] != EventMessageCreated {
+		t.Fatalf("unexpected result %v err=%v", got, err)
+	}
+	if _, err := NormalizeEventTypes([]string{"room.deleted"}); err == nil {
+		t.Fatal("unknown event type should be rejected")
+	}
+	if _, err := NormalizeEventTypes(nil); err == nil {
+		t.Fatal("empty event types should be rejected")
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	for _, raw := range []string{"https://ci.example.com/hook", "http://203.0.113.10:8080/x"} {
+		if err := ValidateURL(raw, false); err != nil {
+			t.Errorf("ValidateURL(%q) unexpected err %v", raw, err)
+		}
+	}
+	for _, raw := range []string{"ftp://example.com", "http://127.0.0.1/x", "http://10.0.0.8/x", "http://[::1]/x", "http://localhost/x", "https://u:p@example.com/"} {
+		if err := ValidateURL(raw, false); err == nil {
+			t.Errorf("ValidateURL(%q) should fail", raw)
+		}
+	}
+	if err := ValidateURL("http://127.0.0.1/x", true); err != nil {
+		t.Errorf("private target should be allowed when enabled: %v", err)
+	}
+}
+
+func TestHTTPClientBlocksPrivateTargets(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	_, err := newHTTPClient(time.Second, false).Post(srv.URL, "application/json", nil)
+	if err == nil || !errors.Is(err, ErrBlockedTarget) {
+		t.Fatalf("expected blocked target error, got %v", err)
+	}
+	resp, err := newHTTPClient(time.Second, true).Post(srv.URL, "application/json", nil)
+	if err != nil {
+		t.Fatalf("allowed client failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+}
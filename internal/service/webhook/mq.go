@@ -0,0 +1,136 @@
+package webhooksvc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// deliveryJob 队列消息：Attempt 为发布时记录的已尝试次数，消费时与数据库比对以丢弃重复或过期的消息
+type deliveryJob struct {
+	DeliveryID string `json:"delivery_id"`
+	Attempt    int    `json:"attempt"`
+}
+
+type rabbitMQQueue struct {
+	queueName   string
+	consumerTag string
+	prefetch    int
+	conn        *amqp.Connection
+	pubCh       *amqp.Channel
+	consCh      *amqp.Channel
+	pubMu       sync.Mutex
+}
+
+func newRabbitMQQueue(url, queueName string, prefetch int) (*rabbitMQQueue, error) {
+	url = strings.TrimSpace(url)
+	queueName = strings.TrimSpace(queueName)
+	if url == "" || queueName == "" {
+		return nil, errors.New("webhook rabbitmq url or queue is empty")
+	}
+	if prefetch <= 0 {
+		prefetch = 1
+	}
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+	pubCh, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if _, err := pubCh.QueueDeclare(queueName, true, false, false, false, nil); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	consCh, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if err := consCh.Qos(prefetch, 0, false); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return &rabbitMQQueue{
+		queueName:   queueName,
+		consumerTag: "ququchat.webhook.consumer." + uuid.NewString(),
+		prefetch:    prefetch,
+		conn:        conn,
+		pubCh:       pubCh,
+		consCh:      consCh,
+	}, nil
+}
+
+func (q *rabbitMQQueue) publish(ctx context.Context, job deliveryJob) error {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	pubCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	q.pubMu.Lock()
+	defer q.pubMu.Unlock()
+	return q.pubCh.PublishWithContext(pubCtx, "", q.queueName, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    job.DeliveryID,
+		Timestamp:    time.Now(),
+		Body:         body,
+	})
+}
+
+// consume 以 prefetch 个 goroutine 并发处理，投递结果已落库，处理完成后总是确认消息
+// 连接断开时返回错误，由调用方切换到进程内投递并重连
+func (q *rabbitMQQueue) consume(ctx context.Context, handle func(context.Context, deliveryJob)) error {
+	deliveries, err := q.consCh.Consume(q.queueName, q.consumerTag, false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < q.prefetch; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range deliveries {
+				var job deliveryJob
+				if err := json.Unmarshal(msg.Body, &job); err != nil || job.DeliveryID == "" {
+					log.Printf("[webhook-dispatcher] drop invalid message message_id=%s err=%v", strings.TrimSpace(msg.MessageId), err)
+					_ = msg.Ack(false)
+					continue
+				}
+				handle(ctx, job)
+				_ = msg.Ack(false)
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-ctx.Done():
+		_ = q.consCh.Cancel(q.consumerTag, false)
+		<-done
+		q.close()
+		return nil
+	case <-done:
+		q.close()
+		return errors.New("webhook rabbitmq delivery channel closed")
+	}
+}
+
+func (q *rabbitMQQueue) close() {
+	_ = q.pubCh.Close()
+	_ = q.consCh.Close()
+	_ = q.conn.Close()
+}
@@ -0,0 +1,28 @@
+package tasksvc
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrInvalidAccountUserID = errors.New("invalid account user id")
+var ErrInvalidDataExportID = errors.New("invalid data export id")
+
+type SubmitDataExportRequest struct {
+	RequestID string
+	Priority  Priority
+	ExportID  string
+	UserID    string
+}
+
+type SubmitAccountPurgeRequest struct {
+	RequestID string
+	Priority  Priority
+	UserID    string
+}
+
+// AccountHandler 执行账号数据导出与注销清理
+type AccountHandler interface {
+	ExecuteDataExport(ctx context.Context, payload *DataExportPayload) (Result, error)
+	ExecuteAccountPurge(ctx context.Context, payload *AccountPurgePayload) (Result, error)
+}
@@ -0,0 +1,494 @@
+package accounthandler
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"ququchat/internal/models"
+	"ququchat/internal/server/storage"
+	tasksvc "ququchat/internal/taskservice/task"
+)
+
+const defaultLinkTTL = 24 * time.Hour
+const defaultRetention = 7 * 24 * time.Hour
+const exportBatchSize = 500
+const purgeBatchSize = 100
+
+// AttachmentDeleter 删除附件对象、缩略图与记录，由 file.Service 实现
+type AttachmentDeleter interface {
+	DeleteAttachment(userID string, attachmentID string) error
+}
+
+type Handler struct {
+	db          *gorm.DB
+	storage     storage.ObjectStorage
+	bucket      string
+	attachments AttachmentDeleter
+	vectorStore tasksvc.VectorStore
+	linkTTL     time.Duration
+	retention   time.Duration
+}
+
+type Options struct {
+	DB          *gorm.DB
+	Storage     storage.ObjectStorage
+	Bucket      string
+	Attachments AttachmentDeleter
+	VectorStore tasksvc.VectorStore
+	// LinkTTL 结果中预签名下载链接的有效期
+	LinkTTL time.Duration
+	// Retention 导出文件保留时长，过期后不再提供下载
+	Retention time.Duration
+}
+
+func New(opts Options) *Handler {
+	linkTTL := opts.LinkTTL
+	if linkTTL <= 0 {
+		linkTTL = defaultLinkTTL
+	}
+	retention := opts.Retention
+	if retention <= 0 {
+		retention = defaultRetention
+	}
+	return &Handler{
+		db:          opts.DB,
+		storage:     opts.Storage,
+		bucket:      strings.TrimSpace(opts.Bucket),
+		attachments: opts.Attachments,
+		vectorStore: opts.VectorStore,
+		linkTTL:     linkTTL,
+		retention:   retention,
+	}
+}
+
+// ExportObjectKey 导出文件在对象存储中的路径
+func ExportObjectKey(userID string, exportID string) string {
+	return fmt.Sprintf("exports/%s/%s.zip", userID, exportID)
+}
+
+func (h *Handler) ExecuteDataExport(ctx context.Context, payload *tasksvc.DataExportPayload) (tasksvc.Result, error) {
+	if h == nil || h.db == nil {
+		return tasksvc.Result{}, errors.New("account handler db is not initialized")
+	}
+	if payload == nil || strings.TrimSpace(payload.UserID) == "" {
+		return tasksvc.Result{}, tasksvc.ErrInvalidAccountUserID
+	}
+	if strings.TrimSpace(payload.ExportID) == "" {
+		return tasksvc.Result{}, tasksvc.ErrInvalidDataExportID
+	}
+	if h.storage == nil || h.bucket == "" {
+		return tasksvc.Result{}, errors.New("account handler object storage is not configured")
+	}
+	userID := strings.TrimSpace(payload.UserID)
+	exportID := strings.TrimSpace(payload.ExportID)
+
+	var export models.DataExport
+	if err := h.db.Where("id = ? AND user_id = ?", exportID, userID).First(&export).Error; err != nil {
+		return tasksvc.Result{}, fmt.Errorf("load data export: %w", err)
+	}
+	if export.Status == models.DataExportStatusSucceeded {
+		return h.exportResult(ctx, &export)
+	}
+	h.db.Model(&export).Updates(map[string]interface{}{
+		"status":     models.DataExportStatusRunning,
+		"updated_at": time.Now(),
+	})
+
+	key, size, err := h.buildExport(ctx, userID, exportID)
+	if err != nil {
+		msg := err.Error()
+		if len(msg) > 512 {
+			msg = msg[:512]
+		}
+		h.db.Model(&export).Updates(map[string]interface{}{
+			"status":        models.DataExportStatusFailed,
+			"error_message": msg,
+			"updated_at":    time.Now(),
+		})
+		return tasksvc.Result{}, err
+	}
+	now := time.Now()
+	expiresAt := now.Add(h.retention)
+	if err := h.db.Model(&export).Updates(map[string]interface{}{
+		"status":        models.DataExportStatusSucceeded,
+		"object_key":    key,
+		"size_bytes":    size,
+		"error_message": "",
+		"expires_at":    &expiresAt,
+		"completed_at":  &now,
+		"updated_at":    now,
+	}).Error; err != nil {
+		return tasksvc.Result{}, fmt.Errorf("update data export: %w", err)
+	}
+	export.ObjectKey = key
+	export.SizeBytes = size
+	return h.exportResult(ctx, &export)
+}
+
+func (h *Handler) exportResult(ctx context.Context, export *models.DataExport) (tasksvc.Result, error) {
+	url, err := h.storage.PresignGetObject(ctx, h.bucket, export.ObjectKey, h.linkTTL)
+	if err != nil {
+		return tasksvc.Result{}, fmt.Errorf("presign data export: %w", err)
+	}
+	return tasksvc.Result{Payload: map[string]interface{}{
+		"export_id":    export.ID,
+		"object_key":   export.ObjectKey,
+		"size_bytes":   export.SizeBytes,
+		"download_url": url,
+	}}, nil
+}
+
+// buildExport 先写入临时文件再上传，避免大体积导出占用内存
+func (h *Handler) buildExport(ctx context.Context, userID string, exportID string) (string, int64, error) {
+	tmp, err := os.CreateTemp("", "ququchat-export-*.zip")
+	if err != nil {
+		return "", 0, fmt.Errorf("create temp file: %w", err)
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+
+	zw := zip.NewWriter(tmp)
+	if err := h.writeProfile(zw, userID); err != nil {
+		return "", 0, err
+	}
+	if err := h.writeFriends(zw, userID); err != nil {
+		return "", 0, err
+	}
+	if err := h.writeMessages(zw, userID); err != nil {
+		return "", 0, err
+	}
+	if err := h.writeAttachments(ctx, zw, userID); err != nil {
+		return "", 0, err
+	}
+	if err := zw.Close(); err != nil {
+		return "", 0, fmt.Errorf("close zip: %w", err)
+	}
+
+	size, err := tmp.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", 0, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+	key := ExportObjectKey(userID, exportID)
+	contentType := "application/zip"
+	if err := h.storage.PutObject(ctx, h.bucket, key, tmp, size, &contentType); err != nil {
+		return "", 0, fmt.Errorf("upload data export: %w", err)
+	}
+	return key, size, nil
+}
+
+func writeJSON(zw *zip.Writer, name string, v interface{}) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func (h *Handler) writeProfile(zw *zip.Writer, userID string) error {
+	var user models.User
+	if err := h.db.Where("id = ?", userID).First(&user).Error; err != nil {
+		return fmt.Errorf("load user: %w", err)
+	}
+	var identities []models.UserIdentity
+	if err := h.db.Where("user_id = ?", userID).Find(&identities).Error; err != nil {
+		return fmt.Errorf("load identities: %w", err)
+	}
+	return writeJSON(zw, "profile.json", map[string]interface{}{
+		"user":       user,
+		"identities": identities,
+	})
+}
+
+type exportFriend struct {
+	UserID      string    `json:"user_id"`
+	Username    string    `json:"username"`
+	DisplayName *string   `json:"display_name,omitempty"`
+	Since       time.Time `json:"since"`
+}
+
+func (h *Handler) writeFriends(zw *zip.Writer, userID string) error {
+	var friendships []models.Friendship
+	if err := h.db.Where("user_id_a = ? OR user_id_b = ?", userID, userID).Order("created_at asc").Find(&friendships).Error; err != nil {
+		return fmt.Errorf("load friendships: %w", err)
+	}
+	ids := make([]string, 0, len(friendships))
+	for _, f := range friendships {
+		if f.UserIDA == userID {
+			ids = append(ids, f.UserIDB)
+		} else {
+			ids = append(ids, f.UserIDA)
+		}
+	}
+	users := make(map[string]models.User, len(ids))
+	if len(ids) > 0 {
+		var list []models.User
+		if err := h.db.Select("id", "username", "display_name").Where("id IN ?", ids).Find(&list).Error; err != nil {
+			return fmt.Errorf("load friends: %w", err)
+		}
+		for _, u := range list {
+			users[u.ID] = u
+		}
+	}
+	out := make([]exportFriend, 0, len(friendships))
+	for i, f := range friendships {
+		item := exportFriend{UserID: ids[i], Since: f.CreatedAt}
+		if u, ok := users[ids[i]]; ok {
+			item.Username = u.Username
+			item.DisplayName = u.DisplayName
+		}
+		out = append(out, item)
+	}
+	return writeJSON(zw, "friends.json", out)
+}
+
+type exportMessage struct {
+	ID              string          `json:"id"`
+	RoomID          string          `json:"room_id"`
+	SequenceID      int64           `json:"sequence_id"`
+	ContentType     string          `json:"content_type"`
+	ContentText     *string         `json:"content_text,omitempty"`
+	Payload         json.RawMessage `json:"payload,omitempty"`
+	AttachmentID    *string         `json:"attachment_id,omitempty"`
+	ParentMessageID *string         `json:"parent_message_id,omitempty"`
+	CreatedAt       time.Time       `json:"created_at"`
+	EditedAt        *time.Time      `json:"edited_at,omitempty"`
+}
+
+// writeMessages 按批读取并逐条写出 JSON 数组，消息量大时不整体加载
+func (h *Handler) writeMessages(zw *zip.Writer, userID string) error {
+	w, err := zw.Create("messages.json")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "[\n"); err != nil {
+		return err
+	}
+	first := true
+	var batch []models.Message
+	res := h.db.Where("sender_id = ?", userID).Order("created_at asc").FindInBatches(&batch, exportBatchSize, func(tx *gorm.DB, _ int) error {
+		for _, m := range batch {
+			item := exportMessage{
+				ID:              m.ID,
+				RoomID:          m.RoomID,
+				SequenceID:      m.SequenceID,
+				ContentType:     string(m.ContentType),
+				ContentText:     m.ContentText,
+				AttachmentID:    m.AttachmentID,
+				ParentMessageID: m.ParentMessageID,
+				CreatedAt:       m.CreatedAt,
+				EditedAt:        m.EditedAt,
+			}
+			if len(m.PayloadJSON) > 0 {
+				item.Payload = json.RawMessage(m.PayloadJSON)
+			}
+			b, err := json.Marshal(item)
+			if err != nil {
+				return err
+			}
+			if !first {
+				if _, err := io.WriteString(w, ",\n"); err != nil {
+					return err
+				}
+			}
+			first = false
+			if _, err := w.Write(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if res.Error != nil {
+		return fmt.Errorf("export messages: %w", res.Error)
+	}
+	_, err = io.WriteString(w, "\n]\n")
+	return err
+}
+
+// writeAttachments 写出附件元数据与原文件；已过期或读取失败的附件仅保留元数据
+func (h *Handler) writeAttachments(ctx context.Context, zw *zip.Writer, userID string) error {
+	var attachments []models.Attachment
+	if err := h.db.Where("uploader_user_id = ?", userID).Order("created_at asc").Find(&attachments).Error; err != nil {
+		return fmt.Errorf("load attachments: %w", err)
+	}
+	type exportAttachment struct {
+		models.Attachment
+		File string `json:"file,omitempty"`
+	}
+	out := make([]exportAttachment, 0, len(attachments))
+	now := time.Now()
+	for _, a := range attachments {
+		item := exportAttachment{Attachment: a}
+		if a.StorageKey != nil && strings.TrimSpace(*a.StorageKey) != "" && (a.ExpiresAt == nil || now.Before(*a.ExpiresAt)) {
+			name := "attachments/" + a.ID + "_" + attachmentFileName(a)
+			if err := h.copyObject(ctx, zw, name, strings.TrimSpace(*a.StorageKey)); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				log.Printf("data export skip attachment id=%s err=%v", a.ID, err)
+			} else {
+				item.File = name
+			}
+		}
+		out = append(out, item)
+	}
+	return writeJSON(zw, "attachments.json", out)
+}
+
+func (h *Handler) copyObject(ctx context.Context, zw *zip.Writer, name string, key string) error {
+	body, err := h.storage.GetObject(ctx, h.bucket, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, body)
+	return err
+}
+
+func attachmentFileName(a models.Attachment) string {
+	name := ""
+	if a.FileName != nil {
+		name = path.Base(strings.ReplaceAll(strings.TrimSpace(*a.FileName), "\\", "/"))
+	}
+	if name == "" || name == "." || name == "/" {
+		name = "file"
+	}
+	return name
+}
+
+// ExecuteAccountPurge 注销后的异步清理：匿名化消息发送者、使包含该用户消息的分段失效并删除其向量点、删除其上传的附件
+// 可重复执行
+func (h *Handler) ExecuteAccountPurge(ctx context.Context, payload *tasksvc.AccountPurgePayload) (tasksvc.Result, error) {
+	if h == nil || h.db == nil {
+		return tasksvc.Result{}, errors.New("account handler db is not initialized")
+	}
+	if payload == nil || strings.TrimSpace(payload.UserID) == "" {
+		return tasksvc.Result{}, tasksvc.ErrInvalidAccountUserID
+	}
+	userID := strings.TrimSpace(payload.UserID)
+
+	// 分段依据消息发送者定位，须在匿名化之前查出；标记过期与匿名化在同一事务中完成，
+	// 过期分段在检索时被过滤，并由下次索引任务按匿名化后的内容重建，向量点删除失败不影响匿名化
+	var segments []models.ChatSegment
+	if err := h.db.Select("id", "qdrant_point_id").
+		Where("EXISTS (SELECT 1 FROM messages m WHERE m.room_id = chat_segments.room_id AND m.sender_id = ? AND m.sequence_id BETWEEN chat_segments.start_seq AND chat_segments.end_seq)", userID).
+		Find(&segments).Error; err != nil {
+		return tasksvc.Result{}, fmt.Errorf("load chat segments: %w", err)
+	}
+	segmentIDs := make([]string, 0, len(segments))
+	pointIDs := make([]string, 0, len(segments))
+	for _, s := range segments {
+		segmentIDs = append(segmentIDs, s.ID)
+		if strings.TrimSpace(s.QdrantPointID) != "" {
+			pointIDs = append(pointIDs, s.QdrantPointID)
+		}
+	}
+	var anonymized int64
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		for i := 0; i < len(segmentIDs); i += purgeBatchSize {
+			end := i + purgeBatchSize
+			if end > len(segmentIDs) {
+				end = len(segmentIDs)
+			}
+			if err := tx.Model(&models.ChatSegment{}).Where("id IN ?", segmentIDs[i:end]).Update("stale", true).Error; err != nil {
+				return fmt.Errorf("mark chat segments stale: %w", err)
+			}
+		}
+		res := tx.Unscoped().Model(&models.Message{}).Where("sender_id = ?", userID).Update("sender_id", nil)
+		if res.Error != nil {
+			return fmt.Errorf("anonymize messages: %w", res.Error)
+		}
+		anonymized = res.RowsAffected
+		return nil
+	})
+	if err != nil {
+		return tasksvc.Result{}, err
+	}
+	if err := h.purgeSegmentPoints(ctx, pointIDs); err != nil {
+		return tasksvc.Result{}, err
+	}
+	attachments, err := h.purgeAttachments(ctx, userID)
+	if err != nil {
+		return tasksvc.Result{}, err
+	}
+	var exportKeys []string
+	h.db.Model(&models.DataExport{}).Where("user_id = ? AND object_key <> ''", userID).Pluck("object_key", &exportKeys)
+	for _, key := range exportKeys {
+		if h.storage != nil && h.bucket != "" {
+			_ = h.storage.RemoveObject(ctx, h.bucket, key)
+		}
+	}
+	h.db.Where("user_id = ?", userID).Delete(&models.DataExport{})
+
+	return tasksvc.Result{Payload: map[string]interface{}{
+		"user_id":             userID,
+		"segments_purged":     len(segmentIDs),
+		"messages_anonymized": anonymized,
+		"attachments_deleted": attachments,
+	}}, nil
+}
+
+// purgeSegmentPoints 删除分段的向量点；未配置向量存储时没有可清理的向量
+func (h *Handler) purgeSegmentPoints(ctx context.Context, pointIDs []string) error {
+	if h.vectorStore == nil {
+		return nil
+	}
+	for i := 0; i < len(pointIDs); i += purgeBatchSize {
+		end := i + purgeBatchSize
+		if end > len(pointIDs) {
+			end = len(pointIDs)
+		}
+		if err := h.vectorStore.DeletePoints(ctx, pointIDs[i:end]); err != nil {
+			return fmt.Errorf("delete vector points: %w", err)
+		}
+	}
+	return nil
+}
+
+func (h *Handler) purgeAttachments(ctx context.Context, userID string) (int, error) {
+	// 未配置对象存储时没有可清理的附件对象
+	if h.attachments == nil {
+		return 0, nil
+	}
+	var ids []string
+	if err := h.db.Model(&models.Attachment{}).Where("uploader_user_id = ?", userID).Pluck("id", &ids).Error; err != nil {
+		return 0, fmt.Errorf("load attachments: %w", err)
+	}
+	deleted := 0
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+		if err := h.attachments.DeleteAttachment(userID, id); err != nil {
+			// 缩略图随原图一并删除，此时记录已不存在
+			var count int64
+			if h.db.Model(&models.Attachment{}).Where("id = ?", id).Count(&count).Error == nil && count == 0 {
+				continue
+			}
+			return deleted, fmt.Errorf("delete attachment %s: %w", id, err)
+		}
+		deleted++
+	}
+	return deleted, nil
+}
@@ -0,0 +1,72 @@
+package accounthandler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"ququchat/internal/models"
+	tasksvc "ququchat/internal/taskservice/task"
+)
+
+func TestExecuteAccountPurge_WithoutStores(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("sql db: %v", err)
+	}
+	// 内存库每个连接独立，固定单连接
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&models.Message{}, &models.ChatSegment{}, &models.Attachment{}, &models.DataExport{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	now := time.Now()
+	sender, other := "u1", "u2"
+	for i, s := range []*string{&sender, &other, &other} {
+		if err := db.Create(&models.Message{ID: fmt.Sprintf("m%d", i+1), RoomID: "r1", SenderID: s, ContentType: models.ContentTypeText, SequenceID: int64(i + 1), CreatedAt: now}).Error; err != nil {
+			t.Fatalf("create message: %v", err)
+		}
+	}
+	for _, seg := range []models.ChatSegment{
+		{ID: "s1", RoomID: "r1", SegmentID: "s1", StartSeq: 1, EndSeq: 2, StartAt: now, EndAt: now, QdrantPointID: "p1", CreatedAt: now, UpdatedAt: now},
+		{ID: "s2", RoomID: "r1", SegmentID: "s2", StartSeq: 3, EndSeq: 3, StartAt: now, EndAt: now, QdrantPointID: "p2", CreatedAt: now, UpdatedAt: now},
+	} {
+		if err := db.Create(&seg).Error; err != nil {
+			t.Fatalf("create segment: %v", err)
+		}
+	}
+
+	res, err := New(Options{DB: db}).ExecuteAccountPurge(context.Background(), &tasksvc.AccountPurgePayload{UserID: sender})
+	if err != nil {
+		t.Fatalf("ExecuteAccountPurge: %v", err)
+	}
+	if res.Payload["messages_anonymized"] != int64(1) || res.Payload["segments_purged"] != 1 {
+		t.Fatalf("unexpected result: %v", res.Payload)
+	}
+	var remaining int64
+	db.Model(&models.Message{}).Where("sender_id = ?", sender).Count(&remaining)
+	if remaining != 0 {
+		t.Fatalf("messages still attributed to the deleted user: %d", remaining)
+	}
+	var stale []string
+	db.Model(&models.ChatSegment{}).Where("stale = ?", true).Pluck("id", &stale)
+	if len(stale) != 1 || stale[0] != "s1" {
+		t.Fatalf("stale segments = %v, want [s1]", stale)
+	}
+
+	// 再次执行时不再有需要处理的消息与分段
+	res, err = New(Options{DB: db}).ExecuteAccountPurge(context.Background(), &tasksvc.AccountPurgePayload{UserID: sender})
+	if err != nil {
+		t.Fatalf("second ExecuteAccountPurge: %v", err)
+	}
+	if res.Payload["messages_anonymized"] != int64(0) || res.Payload["segments_purged"] != 0 {
+		t.Fatalf("unexpected second result: %v", res.Payload)
+	}
+}
@@ -19,6 +19,7 @@ type Executor interface {
 type ExecutorOptions struct {
 	LLMClient        LLMClient
 	RAGHandler       RAGHandler
+	AccountHandler   AccountHandler
 	AIGCClient       AIGCClient
 	MCPMultiClient   *mcpclient.MultiClient
 	ProgressReporter AgentProgressReporter
@@ -27,6 +28,7 @@ type ExecutorOptions struct {
 type DefaultExecutor struct {
 	llmClient        LLMClient
 	ragHandler       RAGHandler
+	accountHandler   AccountHandler
 	aigcClient       AIGCClient
 	mcpMultiClient   *mcpclient.MultiClient
 	progressReporter AgentProgressReporter
@@ -36,6 +38,7 @@ func NewDefaultExecutor(opts ExecutorOptions) *DefaultExecutor {
 	return &DefaultExecutor{
 		llmClient:      opts.LLMClient,
 		ragHandler:     opts.RAGHandler,
+		accountHandler: opts.AccountHandler,
 		aigcClient:     opts.AIGCClient,
 		mcpMultiClient: opts.MCPMultiClient,
 		progressReporter: opts.ProgressReporter,
@@ -112,6 +115,22 @@ func (e *DefaultExecutor) Execute(ctx context.Context, t *Task) (Result, error)
 			return Result{}, errors.New("rag handler is not configured")
 		}
 		return e.ragHandler.ExecuteRAGAddMemory(ctx, t.Payload.RAGAddMem)
+	case TypeDataExport:
+		if t.Payload.DataExport == nil {
+			return Result{}, errors.New("missing data export payload")
+		}
+		if e.accountHandler == nil {
+			return Result{}, errors.New("account handler is not configured")
+		}
+		return e.accountHandler.ExecuteDataExport(ctx, t.Payload.DataExport)
+	case TypeAccountPurge:
+		if t.Payload.AccountPurge == nil {
+			return Result{}, errors.New("missing account purge payload")
+		}
+		if e.accountHandler == nil {
+			return Result{}, errors.New("account handler is not configured")
+		}
+		return e.accountHandler.ExecuteAccountPurge(ctx, t.Payload.AccountPurge)
 	default:
 		return Result{}, ErrUnsupportedTask
 	}
@@ -67,6 +67,7 @@ type EmbeddingProvider interface {
 
 type VectorStore interface {
 	UpsertPoints(ctx context.Context, points []VectorPoint) error
+	DeletePoints(ctx context.Context, pointIDs []string) error
 	SearchRaw(ctx context.Context, roomID string, vector []float32, topK int) ([]VectorSearchHit, error)
 	SearchSummary(ctx context.Context, roomID string, vector []float32, topK int) ([]VectorSearchHit, error)
 }
@@ -126,6 +126,10 @@ func (h *Handler) ExecuteRAG(ctx context.Context, payload *tasksvc.RAGPayload) (
 		overlapMessages = 3
 	}
 	stopPhrases := h.stopPhrasesSet()
+	refreshedSegments, err := h.refreshStaleSegments(ctx, roomID, stopPhrases)
+	if err != nil {
+		return tasksvc.Result{}, err
+	}
 	var latest models.Message
 	if err := h.db.Where("room_id = ?", roomID).Order("sequence_id desc").Take(&latest).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -134,8 +138,9 @@ func (h *Handler) ExecuteRAG(ctx context.Context, payload *tasksvc.RAGPayload) (
 				Text:  &final,
 				Final: &final,
 				Payload: map[string]interface{}{
-					"room_id": roomID,
-					"status":  "empty",
+					"room_id":            roomID,
+					"refreshed_segments": refreshedSegments,
+					"status":             "empty",
 				},
 			}, nil
 		}
@@ -159,11 +164,12 @@ func (h *Handler) ExecuteRAG(ctx context.Context, payload *tasksvc.RAGPayload) (
 			Text:  &final,
 			Final: &final,
 			Payload: map[string]interface{}{
-				"room_id":          roomID,
-				"latest_seq":       latest.SequenceID,
-				"last_indexed_seq": lastIndexedSeq,
-				"indexed_segments": 0,
-				"status":           "up_to_date",
+				"room_id":            roomID,
+				"latest_seq":         latest.SequenceID,
+				"last_indexed_seq":   lastIndexedSeq,
+				"indexed_segments":   0,
+				"refreshed_segments": refreshedSegments,
+				"status":             "up_to_date",
 			},
 		}, nil
 	}
@@ -295,6 +301,7 @@ func (h *Handler) ExecuteRAG(ctx context.Context, payload *tasksvc.RAGPayload) (
 				"indexed_segments":          0,
 				"skipped_segments":          skippedByRange,
 				"skipped_too_long_messages": skippedTooLongMessages,
+				"refreshed_segments":        refreshedSegments,
 				"status":                    "no_valid_messages",
 			},
 		}, nil
@@ -353,6 +360,7 @@ func (h *Handler) ExecuteRAG(ctx context.Context, payload *tasksvc.RAGPayload) (
 			"indexed_segments":          len(taskSegments),
 			"skipped_segments":          skippedByRange,
 			"skipped_too_long_messages": skippedTooLongMessages,
+			"refreshed_segments":        refreshedSegments,
 			"overlap_messages":          overlapMessages,
 			"segment_gap_seconds":       gapSeconds,
 		},
@@ -429,12 +437,28 @@ func (h *Handler) ExecuteRAGSearch(ctx context.Context, payload *tasksvc.RAGSear
 	rawTextBySegmentID := make(map[string]string, len(segmentIDs))
 	if h.db != nil && len(segmentIDs) > 0 {
 		var segments []models.ChatSegment
-		if err := h.db.Select("segment_id", "raw_text").Where("segment_id IN ?", segmentIDs).Find(&segments).Error; err != nil {
+		if err := h.db.Select("segment_id", "raw_text", "stale").Where("segment_id IN ?", segmentIDs).Find(&segments).Error; err != nil {
 			return tasksvc.Result{}, err
 		}
+		staleSegmentIDs := make(map[string]struct{})
 		for _, seg := range segments {
+			if seg.Stale {
+				staleSegmentIDs[seg.SegmentID] = struct{}{}
+				continue
+			}
 			rawTextBySegmentID[seg.SegmentID] = seg.RawText
 		}
+		// 分段中的消息已被编辑或撤回，旧内容不再参与召回
+		if len(staleSegmentIDs) > 0 {
+			freshHits := hits[:0]
+			for _, hit := range hits {
+				if _, stale := staleSegmentIDs[strings.TrimSpace(fmt.Sprint(hit.Payload["segment_id"]))]; stale {
+					continue
+				}
+				freshHits = append(freshHits, hit)
+			}
+			hits = freshHits
+		}
 	}
 	resolvedTexts := make([]string, len(hits))
 	for i, hit := range hits {
@@ -813,6 +837,104 @@ func (h *Handler) loadRAGSenderNames(roomID string, messages []models.Message) (
 	return names, nil
 }
 
+// refreshStaleSegments 按当前消息内容重建因编辑或撤回被标记为过期的分段，分段内消息已全部撤回时连同向量点一并删除
+func (h *Handler) refreshStaleSegments(ctx context.Context, roomID string, stopPhrases map[string]struct{}) (int, error) {
+	var staleSegments []models.ChatSegment
+	if err := h.db.Where("room_id = ? AND stale = ?", roomID, true).Order("start_seq asc").Find(&staleSegments).Error; err != nil {
+		return 0, err
+	}
+	if len(staleSegments) == 0 {
+		return 0, nil
+	}
+	refreshed := make([]models.ChatSegment, 0, len(staleSegments))
+	emptyIDs := make([]string, 0)
+	emptyPointIDs := make([]string, 0)
+	for _, seg := range staleSegments {
+		var messages []models.Message
+		if err := h.db.Where("room_id = ? AND sequence_id BETWEEN ? AND ?", roomID, seg.StartSeq, seg.EndSeq).
+			Where("content_type IN ?", []models.ContentType{
+				models.ContentTypeText,
+				models.ContentTypeImage,
+				models.ContentTypeFile,
+			}).
+			Order("sequence_id asc").
+			Find(&messages).Error; err != nil {
+			return 0, err
+		}
+		senderNames, err := h.loadRAGSenderNames(roomID, messages)
+		if err != nil {
+			return 0, err
+		}
+		lines := make([]string, 0, len(messages))
+		for _, msg := range messages {
+			if formatted, ok := formatRAGMessageLine(msg, stopPhrases, senderNames); ok {
+				lines = append(lines, formatted)
+			}
+		}
+		rawText := strings.TrimSpace(strings.Join(lines, "\n"))
+		if rawText == "" {
+			emptyIDs = append(emptyIDs, seg.ID)
+			if strings.TrimSpace(seg.QdrantPointID) != "" {
+				emptyPointIDs = append(emptyPointIDs, seg.QdrantPointID)
+			}
+			continue
+		}
+		sum := sha256.Sum256([]byte(rawText))
+		seg.RawText = rawText
+		seg.RawTextHash = hex.EncodeToString(sum[:])
+		seg.MessageCount = len(lines)
+		refreshed = append(refreshed, seg)
+	}
+	if len(emptyPointIDs) > 0 {
+		if h.vectorStore == nil {
+			return 0, errors.New("vector store is not configured")
+		}
+		if err := h.vectorStore.DeletePoints(ctx, emptyPointIDs); err != nil {
+			return 0, err
+		}
+	}
+	if len(emptyIDs) > 0 {
+		if err := h.db.Where("id IN ?", emptyIDs).Delete(&models.ChatSegment{}).Error; err != nil {
+			return 0, err
+		}
+	}
+	if len(refreshed) == 0 {
+		return len(emptyIDs), nil
+	}
+	taskSegments := make([]tasksvc.RAGSegment, 0, len(refreshed))
+	for _, seg := range refreshed {
+		taskSegments = append(taskSegments, tasksvc.RAGSegment{
+			SegmentID:    seg.SegmentID,
+			PointID:      seg.QdrantPointID,
+			RoomID:       seg.RoomID,
+			StartSeq:     seg.StartSeq,
+			EndSeq:       seg.EndSeq,
+			StartUnixSec: seg.StartAt.Unix(),
+			EndUnixSec:   seg.EndAt.Unix(),
+			MessageCount: seg.MessageCount,
+			RawText:      seg.RawText,
+			TextPreview:  previewText(seg.RawText, 80),
+		})
+	}
+	// 向量更新失败时保持过期标记，由下次索引任务重试
+	if err := h.upsertRAGPoints(ctx, taskSegments); err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	for _, seg := range refreshed {
+		if err := h.db.Model(&models.ChatSegment{}).Where("id = ?", seg.ID).Updates(map[string]interface{}{
+			"raw_text":      seg.RawText,
+			"raw_text_hash": seg.RawTextHash,
+			"message_count": seg.MessageCount,
+			"stale":         false,
+			"updated_at":    now,
+		}).Error; err != nil {
+			return 0, err
+		}
+	}
+	return len(refreshed) + len(emptyIDs), nil
+}
+
 func (h *Handler) filterExistingSegmentsBySeqRange(roomID string, segments []models.ChatSegment) ([]models.ChatSegment, int, error) {
 	if h == nil || h.db == nil || len(segments) == 0 {
 		return segments, 0, nil
@@ -70,6 +70,7 @@ type RuntimeOptions struct {
 	RAGRerankTimeout                 time.Duration
 	RAGRerankRecallTopN              int
 	RAGHandler                       RAGHandler
+	AccountHandler                   AccountHandler
 	MCPMultiClient                   *mcpclient.MultiClient
 	AgentProgressReporter            AgentProgressReporter
 	OnFinish                         func(ctx context.Context, doneTask *Task)
@@ -241,6 +242,7 @@ func NewRuntime(opts RuntimeOptions) *Runtime {
 	exec := NewDefaultExecutor(ExecutorOptions{
 		LLMClient:        llmClient,
 		RAGHandler:       opts.RAGHandler,
+		AccountHandler:   opts.AccountHandler,
 		AIGCClient:       aigcClient,
 		MCPMultiClient:   mcpMultiClient,
 		ProgressReporter: opts.AgentProgressReporter,
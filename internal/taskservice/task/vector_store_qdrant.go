@@ -133,6 +133,43 @@ func (s *QdrantVectorStore) UpsertPoints(ctx context.Context, points []VectorPoi
 	return nil
 }
 
+func (s *QdrantVectorStore) DeletePoints(ctx context.Context, pointIDs []string) error {
+	if s == nil {
+		return errors.New("qdrant vector store is nil")
+	}
+	if len(pointIDs) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"points": pointIDs,
+	})
+	if err != nil {
+		return err
+	}
+	url := s.baseURL + "/collections/" + s.collection + "/points/delete?wait=true"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.apiKey != "" {
+		req.Header.Set("api-key", s.apiKey)
+	}
+	resp, err := s.httpCli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("qdrant delete failed: status=%d body=%s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
+
 func (s *QdrantVectorStore) SearchRaw(ctx context.Context, roomID string, vector []float32, topK int) ([]VectorSearchHit, error) {
 	return s.searchByNamedVector(ctx, roomID, "raw", vector, topK, false)
 }